@@ -14,23 +14,29 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/agent"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 )
 
 const (
-	instanceResultSuffix = "-test-results.json"
-	testResultSuffix     = "-result.json"
-	bucketTestsDir       = "Tests"
+	testResultSuffix   = "-result.json"
+	purchaseOptionSpot = "spot"
+	// The spot interruption notice is issued two minutes before the interruption
+	spotInterruptionPollingPeriod = 5 * time.Second
 )
 
 // The agent runs all the tests in the test suite, populates the result json files, and uploads them to the
@@ -48,29 +54,71 @@ func main() {
 	timeout := os.Args[7]
 	bucketRootDir := os.Args[8]
 	region := os.Args[9]
+	purchaseOption := os.Args[10]
+	options, err := parseAgentOptions(os.Args[11:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	isLocal := options.backend == config.BackendLocal
+	concurrency := options.concurrency
 
-	// Warm-up time for 2 purposes:
-	// 1. During booting, the CPU load is not stable, so wait for some time before starting all tests
-	// 2. Ensure the instance is not terminated before being added to the auto scaling group
-	time.Sleep(1 * time.Minute)
+	// The tests run in process groups of their own, which the termination of the agent doesn't reach
+	terminated := make(chan os.Signal, 1)
+	signal.Notify(terminated, syscall.SIGTERM)
+	go func() {
+		<-terminated
+		agent.KillTests()
+		os.Exit(1)
+	}()
 
-	sess, err := newAgentSession(region)
-	if err != nil {
-		agent.TerminateInstance()
+	var svc *resources.Resources
+	var instance resources.Instance
+	if isLocal {
+		// The CLI may be interrupted and resumed while the tests keep running, as on an instance
+		signal.Ignore(os.Interrupt)
+		svc = resources.NewLocal(options.localDir, options.instanceId)
+		if instance, err = svc.CreateInstance(instanceType, vCpus, memory, osVersion, architecture); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		// Warm-up time for 2 purposes:
+		// 1. During booting, the CPU load is not stable, so wait for some time before starting all tests
+		// 2. Ensure the instance is not terminated before being added to the auto scaling group
+		time.Sleep(1 * time.Minute)
+
+		sess, err := newAgentSession(region)
+		if err != nil {
+			agent.TerminateInstance()
+		}
+		svc = resources.New(sess)
+
+		instance, err = svc.CreateInstance(instanceType, vCpus, memory, osVersion, architecture)
+		if err != nil {
+			agent.TerminateInstance()
+		}
 	}
-	svc := resources.New(sess)
 
-	instance, err := svc.CreateInstance(instanceType, vCpus, memory, osVersion, architecture)
-	if err != nil {
-		agent.TerminateInstance()
+	if options.storeEndpoint != "" {
+		svc.Store = resources.NewEndpointStore(options.storeEndpoint, region)
 	}
 
 	agentFixture, err := createAgentFixture(instance, bucketName, timeout, bucketRootDir)
 	if err != nil {
+		if isLocal {
+			log.Fatal(err)
+		}
 		agent.TerminateInstance()
 	}
+	agentFixture.IsLocal = isLocal
+	agentFixture.LogStreamer = agent.NewLogStreamer(svc.Store, agentFixture)
 
+	// The instance result is uploaded by one goroutine at a time, be it a worker which finished a test or a timeout
+	var instanceMutex sync.Mutex
 	done := make(chan bool, 1)
+	if isLocal {
+		go agent.SampleLocalMetrics(svc, instance, agent.SamplingPeriod, done)
+	}
+	go agentFixture.LogStreamer.Stream(resources.HeartbeatPeriod, done)
 	go func() {
 		select {
 		case <-done:
@@ -80,48 +128,147 @@ func main() {
 			fmt.Printf("💀 Timeout! One or more tests were not executed\n")
 			fmt.Printf("======================================================================================================\n")
 
+			instanceMutex.Lock()
 			instance.IsTimeout = true
-			err = marshalAndUploadToBucketTestsDir(sess, instance, agentFixture.InstanceResultFilename, agentFixture)
-			agent.Fatal(sess, agentFixture, err)
+			err := marshalAndUploadToBucketTestsDir(svc, instance, agentFixture.InstanceResultFilename, agentFixture)
+			agent.Fatal(svc, agentFixture, err)
 		}
 	}()
 
+	if purchaseOption == purchaseOptionSpot {
+		go func() {
+			ticker := time.NewTicker(spotInterruptionPollingPeriod)
+			for {
+				select {
+				case <-done:
+					ticker.Stop()
+					return
+				case <-ticker.C:
+					if !svc.IsSpotInterruptionNoticed() {
+						continue
+					}
+					ticker.Stop()
+					fmt.Printf("\n======================================================================================================\n")
+					fmt.Printf("⚡ Spot interruption! One or more tests were not executed\n")
+					fmt.Printf("======================================================================================================\n")
+
+					instanceMutex.Lock()
+					instance.IsInterrupted = true
+					err := marshalAndUploadToBucketTestsDir(svc, instance, agentFixture.InstanceResultFilename, agentFixture)
+					agent.Fatal(svc, agentFixture, err)
+				}
+			}
+		}()
+	}
+
 	// Upload first, in case that timeout occurs before getting any result
-	if err := marshalAndUploadToBucketTestsDir(sess, instance, agentFixture.InstanceResultFilename, agentFixture); err != nil {
-		agent.Fatal(sess, agentFixture, err)
+	instanceMutex.Lock()
+	if err := marshalAndUploadToBucketTestsDir(svc, instance, agentFixture.InstanceResultFilename, agentFixture); err != nil {
+		agent.Fatal(svc, agentFixture, err)
 	}
+	instanceMutex.Unlock()
 
-	testFileList, err := agent.GetTestFileList(agentFixture.ScriptPath)
+	suite, err := agent.GetSuite(agentFixture.ScriptPath)
 	if err != nil {
-		agent.Fatal(sess, agentFixture, err)
+		agent.Fatal(svc, agentFixture, err)
 	}
 
-	for _, testFile := range testFileList {
-		testResult := agent.PopulateResult(testFile, agentFixture, outputStream, errStream)
-		instance.Results = append(instance.Results, testResult)
-		testResultFilename := testFile + testResultSuffix
+	if concurrency == 0 {
+		concurrency = suite.Concurrency
+	}
+	setupResult, isSetupPassed := agent.RunHook(suite.Setup, outputStream, errStream)
+	instanceMutex.Lock()
+	instance.Setup = setupResult
+	if isSetupPassed && concurrency > 1 {
+		instance.Concurrency = concurrency
+	}
+	instanceMutex.Unlock()
+	if !isSetupPassed {
+		fmt.Printf("\n======================================================================================================\n")
+		fmt.Printf("💥 Setup failed! No test was executed\n")
+		fmt.Printf("======================================================================================================\n")
+	} else {
+		// The results are kept in the order the tests are declared, whatever the order the tests finish in
+		testResults := make([]*resources.Result, len(suite.Tests))
+		agent.RunTests(suite.Tests, concurrency, agentFixture, outputStream, errStream, func(i int, testResult resources.Result) {
+			test := suite.Tests[i]
+			testResultFilename := test.File + testResultSuffix
+			if err := uploadTestOutput(svc, testResult, test.File, agentFixture); err != nil {
+				log.Println(err)
+			}
 
-		if err := marshalAndUploadToBucketTestsDir(sess, testResult, testResultFilename, agentFixture); err != nil {
-			// Failing on one test result shouldn't terminate the whole program
-			log.Println(err)
-			continue
-		}
+			instanceMutex.Lock()
+			defer instanceMutex.Unlock()
+			testResults[i] = &testResult
+			instance.Results = make([]resources.Result, 0, len(testResults))
+			for _, result := range testResults {
+				if result != nil {
+					instance.Results = append(instance.Results, *result)
+				}
+			}
+			if err := marshalAndUploadToBucketTestsDir(svc, testResult, testResultFilename, agentFixture); err != nil {
+				// Failing on one test result shouldn't terminate the whole program
+				log.Println(err)
+				return
+			}
 
-		if err := marshalAndUploadToBucketTestsDir(sess, instance, agentFixture.InstanceResultFilename, agentFixture); err != nil {
+			if err := marshalAndUploadToBucketTestsDir(svc, instance, agentFixture.InstanceResultFilename, agentFixture); err != nil {
+				log.Println(err)
+			}
+		})
+	}
+	teardown, _ := agent.RunHook(suite.Teardown, outputStream, errStream)
+	instanceMutex.Lock()
+	defer instanceMutex.Unlock()
+	instance.Teardown = teardown
+	if instance.Setup != nil || instance.Teardown != nil {
+		if err := marshalAndUploadToBucketTestsDir(svc, instance, agentFixture.InstanceResultFilename, agentFixture); err != nil {
 			log.Println(err)
 		}
 	}
 
-	remoteFinalInstanceResultFilename := agentFixture.BucketDir + "/" + filepath.Base(agentFixture.InstanceResultFilename)
-	if err := svc.UploadToBucket(agentFixture.BucketName, agentFixture.InstanceResultFilename, remoteFinalInstanceResultFilename); err != nil {
-		agent.Fatal(sess, agentFixture, err)
-	}
-
-	done <- true
 	fmt.Printf("\n======================================================================================================\n")
 	fmt.Printf("🎉 All test files finish execution\n")
 	fmt.Printf("======================================================================================================\n")
-	agent.Fatal(sess, agentFixture, nil)
+	// The whole log is streamed before the result, once collecting which the CLI stops following the log
+	if err := agentFixture.LogStreamer.Flush(); err != nil {
+		log.Println(err)
+	}
+	if err := resources.PutFile(svc.Store, agentFixture.BucketName, agentFixture.InstanceResultFilename, agentFixture.Layout.Result()); err != nil {
+		agent.Fatal(svc, agentFixture, err)
+	}
+
+	close(done)
+	agent.Fatal(svc, agentFixture, nil)
+}
+
+// agentOptions are the optional settings of the agent, passed as flags after its positional arguments.
+type agentOptions struct {
+	backend string
+	// localDir and instanceId are the directory backing the resources of the local backend and the ID of the instance
+	// the agent plays on it.
+	localDir      string
+	instanceId    string
+	storeEndpoint string
+	// concurrency overrides that of the manifest of the test suite, unless it is 0.
+	concurrency int
+}
+
+// parseAgentOptions parses the flags of the optional settings of the agent.
+func parseAgentOptions(args []string) (options agentOptions, err error) {
+	flagSet := flag.NewFlagSet("agent", flag.ContinueOnError)
+	flagSet.StringVar(&options.backend, "backend", config.BackendEc2, "backend on which the agent runs")
+	flagSet.StringVar(&options.localDir, "local-dir", "", "directory backing the resources of the local backend")
+	flagSet.StringVar(&options.instanceId, "instance-id", "", "ID of the instance the agent plays on the local backend")
+	flagSet.StringVar(&options.storeEndpoint, "store-endpoint", "", "endpoint of the S3-compatible service storing the bucket")
+	flagSet.IntVar(&options.concurrency, "concurrency", 0, "max number of test files executed at once")
+	if err := flagSet.Parse(args); err != nil {
+		return options, err
+	}
+	if flagSet.NArg() > 0 {
+		return options, fmt.Errorf("unexpected arguments: %v", flagSet.Args())
+	}
+	return options, nil
 }
 
 // newAgentSession returns a session with region config.
@@ -150,24 +297,35 @@ func createAgentFixture(instance resources.Instance, bucketName string, timeout
 		return agentFixture, err
 	}
 
-	agentFixture.BucketDir = bucketRootDir + "/" + instance.InstanceType + "/" + instance.InstanceId
-	agentFixture.InstanceResultFilename = agentFixture.ScriptPath + "/" + instance.InstanceId + instanceResultSuffix
+	agentFixture.Layout = resources.BucketLayout{RootDir: bucketRootDir}.Instance(instance.InstanceType, instance.InstanceId)
+	agentFixture.InstanceResultFilename = agentFixture.ScriptPath + "/" + resources.InstanceResultFilename(instance.InstanceId)
 	agentFixture.LogFilename = agentFixture.ScriptPath + "/" + instance.InstanceType + ".log"
 
 	return agentFixture, nil
 }
 
+// uploadTestOutput uploads the output files of the test file to the keys referenced by its result, if it was captured.
+func uploadTestOutput(svc *resources.Resources, testResult resources.Result, testFile string, agentFixture agent.AgentFixture) error {
+	for stream, key := range map[string]string{config.StreamStdout: testResult.StdoutKey, config.StreamStderr: testResult.StderrKey} {
+		if key == "" {
+			continue
+		}
+		if err := resources.PutFile(svc.Store, agentFixture.BucketName, agent.OutputFilename(testFile, stream), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // marshalAndUploadToBucketTestsDir marshals an object to json string, writes it to a file, and uploads the
 // file to the bucket tests directory.
-func marshalAndUploadToBucketTestsDir(sess *session.Session, v interface{}, filename string, agentFixture agent.AgentFixture) error {
-	svc := resources.New(sess)
-
+func marshalAndUploadToBucketTestsDir(svc *resources.Resources, v interface{}, filename string, agentFixture agent.AgentFixture) error {
 	if err := cmdutil.MarshalToFile(v, filename); err != nil {
 		return err
 	}
 
-	remoteFilename := agentFixture.BucketDir + "/" + bucketTestsDir + "/" + filepath.Base(filename)
-	if err := svc.UploadToBucket(agentFixture.BucketName, filename, remoteFilename); err != nil {
+	remoteFilename := agentFixture.Layout.TestsObject(filepath.Base(filename))
+	if err := resources.PutFile(svc.Store, agentFixture.BucketName, filename, remoteFilename); err != nil {
 		return err
 	}
 
@@ -18,6 +18,7 @@ import (
 	"testing"
 
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/agent"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
@@ -40,7 +41,7 @@ func TestCreateAgentFixtureSuccess(t *testing.T) {
 	expected := agent.AgentFixture{
 		BucketName:             "qualifier-bucket-12345",
 		Timeout:                3600,
-		BucketDir:              "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a",
+		Layout:                 resources.InstanceLayout{Dir: "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a", InstanceId: "i-0df3ef636ba12ee2a"},
 		ScriptPath:             cwd,
 		InstanceResultFilename: cwd + "/i-0df3ef636ba12ee2a-test-results.json",
 		LogFilename:            cwd + "/m4.large.log",
@@ -55,3 +56,25 @@ func TestCreateAgentFixtureInvalidTimeoutFailure(t *testing.T) {
 	_, err := createAgentFixture(instance, "qualifier-bucket-12345", "TIMEOUT", "Instance-Qualifier-Run-12345")
 	h.Assert(t, err != nil, "Failed to return error when timeout is invalid")
 }
+
+func TestParseAgentOptionsSuccess(t *testing.T) {
+	actual, err := parseAgentOptions(nil)
+	h.Ok(t, err)
+	h.Equals(t, agentOptions{backend: config.BackendEc2}, actual)
+
+	actual, err = parseAgentOptions([]string{"--backend=local", "--local-dir=/tmp/local", "--instance-id=i-0df3ef636ba12ee2a", "--store-endpoint=http://localhost:9000", "--concurrency=4"})
+	h.Ok(t, err)
+	expected := agentOptions{
+		backend:       config.BackendLocal,
+		localDir:      "/tmp/local",
+		instanceId:    "i-0df3ef636ba12ee2a",
+		storeEndpoint: "http://localhost:9000",
+		concurrency:   4,
+	}
+	h.Equals(t, expected, actual)
+}
+
+func TestParseAgentOptionsPositionalFailure(t *testing.T) {
+	_, err := parseAgentOptions([]string{"--concurrency=4", "local"})
+	h.Assert(t, err != nil, "Failed to return error when an option is positional")
+}
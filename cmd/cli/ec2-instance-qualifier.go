@@ -16,6 +16,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -23,118 +24,526 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/agent"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/data"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/setup"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/template"
 )
 
-// Enums indicating what resources need to be deleted
-const (
-	deleteNothing = iota
-	deleteCfnStack
-	deleteAll           // delete bucket and CloudFormation stack
-	testFixtureFileName = "test-fixture.json"
-)
-
 func main() {
-	deleteState := deleteNothing
 	inputStream := os.Stdin
 	outputStream := os.Stdout
+	reportStream := os.Stdout
 	rand.Seed(time.Now().UnixNano())
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case config.ListRunsCommand:
+			listRuns(os.Args[2:], reportStream)
+			return
+		case config.CleanupCommand:
+			cleanup(os.Args[2:], inputStream, reportStream)
+			return
+		case config.LogsCommand:
+			logs(os.Args[2:], reportStream)
+			return
+		}
+	}
+
 	userConfig, err := config.ParseCliArgs(outputStream)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The report options of the current invocation apply even when resuming a run. Machine-readable reports get
+	// stdout to themselves
+	reportOptions := data.ReportOptions{Format: userConfig.Output, PriceType: userConfig.PriceType}
+	if reportOptions.Format != config.OutputTable {
+		outputStream = os.Stderr
+	}
+	require := userConfig.Require
+	follow := userConfig.Follow
 
-	sess, err := newSession(userConfig)
+	isLocal := userConfig.Backend == config.BackendLocal
+	regions, err := newRegionalRuns(userConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	svc := resources.New(sess)
+	// Load prices before the run so that a malformed price file doesn't surface only after the tests
+	if userConfig.PriceFile != "" {
+		if reportOptions.PriceSource, err = loadPrices(regions, userConfig.PriceFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 
+	// Validate the manifest of the test suite before the run so that a mistake doesn't surface only on the instances
+	if userConfig.Bucket == "" {
+		if _, err := agent.GetSuite(userConfig.TestSuiteName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// What an interrupted run leaves behind depends on the state of each of its regions when the interruption arrives.
+	// Main only holds the mutex while it swaps the active region or records its state, never through a prompt or a
+	// wait, so the termination cleans up right away and the run exits in the middle of its current step
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigs
-		terminate(sess, fmt.Errorf("interrupted"), deleteState)
+		log.Println("Interrupted! Deleting the resources of the run. Interrupt again to quit right away, leaving them to the cleanup subcommand")
+		go func() {
+			<-sigs
+			os.Exit(config.ExitCodeError)
+		}()
+		regions.mutex.Lock()
+		terminate(regions, fmt.Errorf("interrupted"))
 	}()
 
-	if userConfig.Bucket == "" {
-		// For a new run, before tests begin on all instances, if the CLI is interrupted, all resources should be
-		// deleted because it could be interpreted as "I don't want this run to be continued any more"
-		deleteState = deleteAll
+	if userConfig.Bucket == "" && isLocal {
+		regions.mutex.Lock()
+		svc := regions.runs[0].svc
+
+		runId := cmdutil.GetRandomString()
+		fmt.Fprintf(outputStream, "Test Run ID: %s\n", runId)
+
+		if err := createBucket(regions, runId, outputStream); err != nil {
+			terminate(regions, err)
+		}
+		if err := prepareForLocalRun(svc, userConfig, runId); err != nil {
+			terminate(regions, err)
+		}
+		if err := regions.setState(resources.RunStateUploadedSuite); err != nil {
+			terminate(regions, err)
+		}
+		if err := regions.setState(resources.RunStateStackCreating); err != nil {
+			terminate(regions, err)
+		}
+		if err := svc.CreateLocalStack(resources.LocalInstances(strings.Split(userConfig.InstanceTypes, ","), config.GetTestFixture().Replicas), outputStream); err != nil {
+			terminate(regions, err)
+		}
+		if err := regions.setState(resources.RunStateTestsRunning); err != nil {
+			terminate(regions, err)
+		}
+
+		regions.mutex.Unlock()
+
+		log.Println("The execution of test suite has been kicked off on all local agents. You may quit now and later run the CLI again with the bucket name flag to get the result")
+	} else if userConfig.Bucket == "" {
+		var buckets []string
+		for i, run := range regions.runs {
+			regions.mutex.Lock()
+			regions.activate(i)
+			regions.mutex.Unlock()
+			if regions.isMultiRegion() {
+				fmt.Fprintf(outputStream, "Region: %s\n", run.region)
+			}
+			if err := startRun(regions, userConfig, inputStream, outputStream); err != nil {
+				regions.mutex.Lock()
+				terminate(regions, err)
+			}
+			regions.mutex.Lock()
+			buckets = append(buckets, config.GetTestFixture().BucketName)
+			regions.mutex.Unlock()
+		}
+
+		if regions.isMultiRegion() {
+			log.Printf("The execution of test suite has been kicked off on all instances of all regions. You may quit now and later run the CLI again with --regions=%s --bucket=%s to get the result\n", userConfig.Regions, strings.Join(buckets, ","))
+		} else {
+			log.Println("The execution of test suite has been kicked off on all instances. You may quit now and later run the CLI again with the bucket name flag to get the result")
+		}
+	} else {
+		buckets := strings.Split(userConfig.Bucket, ",")
+		resumedConfig := userConfig
+		for i, run := range regions.runs {
+			regions.mutex.Lock()
+			regions.activate(i)
+			runConfig := userConfig
+			runConfig.Bucket = strings.TrimSpace(buckets[i])
+			if resumedConfig, err = prepareForResumedRun(run.svc, runConfig); err != nil {
+				terminate(regions, err)
+			}
+			testFixture := config.GetTestFixture()
+			if regions.isMultiRegion() && testFixture.Region != "" && testFixture.Region != run.region {
+				terminate(regions, fmt.Errorf("bucket %s belongs to the run in region %s, not %s", runConfig.Bucket, testFixture.Region, run.region))
+			}
+
+			status, err := run.svc.GetRunStatus(runConfig.Bucket)
+			if err != nil {
+				terminate(regions, err)
+			}
+			if !status.State.IsResumable() {
+				// Its instances may be running the tests already, so resuming never deletes the run: its state isn't
+				// recorded, for the termination to leave it alone
+				terminate(regions, fmt.Errorf("run %s stopped before its tests began (state %s), so it cannot be resumed. Delete its resources with the %s subcommand", testFixture.RunId, status.State, config.CleanupCommand))
+			}
+			regions.runs[i].status = status
+			log.Printf("Resuming run %s from state %s\n", testFixture.RunId, status.State)
+			regions.mutex.Unlock()
+		}
+		userConfig = resumedConfig
+	}
+
+	regions.mutex.Lock()
+	if reportOptions.PriceSource == nil && userConfig.PriceFile != "" {
+		// The price file of a resumed run is best-effort since it may not exist on this machine
+		if priceSource, err := loadPrices(regions, userConfig.PriceFile); err == nil {
+			reportOptions.PriceSource = priceSource
+		} else {
+			log.Printf("Skipping costs since the price file of the run cannot be loaded: %v\n", err)
+		}
+	}
 
-		vpcId, subnetId, err := svc.GetVpcAndSubnetIds(userConfig.VpcId, userConfig.SubnetId, inputStream, outputStream)
+	// The requirement of the current invocation overrides that of a resumed run
+	if require == "" {
+		require = userConfig.Require
+	}
+	if require != "" {
+		requirement, err := config.ParseRequirement(require)
 		if err != nil {
-			log.Fatal(err)
+			terminate(regions, err)
 		}
+		reportOptions.Requirement = &requirement
+	}
+	regions.mutex.Unlock()
 
-		runId := cmdutil.GetRandomString()
-		fmt.Printf("Test Run ID: %s\n", runId)
+	// The runs of the regions are polled one after the other, while their tests keep running concurrently
+	var regionResults []data.RegionResults
+	for i := range regions.runs {
+		regions.mutex.Lock()
+		regions.activate(i)
+		testFixture := config.GetTestFixture()
+		regions.mutex.Unlock()
+		log.Printf("Executing Instance-Qualifier run with the following configuration: %s\n: ", testFixture.String())
 
-		if err := svc.CreateBucket(runId, outputStream); err != nil {
-			terminate(sess, err)
+		results, err := collectResults(regions, follow, outputStream)
+		if err != nil {
+			regions.mutex.Lock()
+			terminate(regions, err)
 		}
+		regionResults = append(regionResults, results)
+	}
+
+	regions.mutex.Lock()
+	summary, err := data.OutputReport(regionResults, reportOptions, reportStream)
+	if err != nil {
+		terminate(regions, err)
+	}
+	for i := range regions.runs {
+		regions.activate(i)
+		if regions.runs[i].status.State.IsReported() {
+			continue
+		}
+		regions.runs[i].status.MissingInstanceTypes = regionResults[i].MissingInstanceTypes
+		if err := regions.setState(resources.RunStateReported); err != nil {
+			terminate(regions, err)
+		}
+	}
+	fmt.Fprintln(outputStream, "User configuration and CloudFormation template are stored in the root directory of the bucket. You may check them if you want")
+
+	// After outputting the final table, stack is no longer needed, but bucket should be kept for any deep dive
+	terminate(regions, nil)
+	regions.mutex.Unlock()
+	fmt.Fprintln(outputStream, "The process of cleaning up stack resources has started. You can quit now")
+	for i, run := range regions.runs {
+		regions.mutex.Lock()
+		regions.activate(i)
+		regions.mutex.Unlock()
+		if err := run.svc.WaitUntilCfnStackDeleteComplete(); err != nil {
+			regions.mutex.Lock()
+			terminate(regions, err)
+		}
+	}
+
+	fmt.Fprintln(outputStream, "Completed!")
+	os.Exit(summary.ExitCode)
+}
 
-		cfnTemplate, err := prepareForNewRun(sess, userConfig, runId, subnetId, inputStream, outputStream)
+// regionalRun is the run in one region, each region of a multi-region run having its own bucket and stack.
+type regionalRun struct {
+	region      string
+	svc         *resources.Resources
+	priceSource pricing.Source
+	testFixture config.TestFixture
+	status      resources.RunStatus
+}
+
+// regionalRuns are the runs of every region of the CLI invocation, of which only one is active at a time: the test
+// fixture of the config package, which the resources and the data act upon, is that of the active run.
+type regionalRuns struct {
+	runs   []regionalRun
+	active int
+	// mutex is held while the active run is swapped, its test fixture is written or its state is recorded, so that an
+	// interruption terminates the runs from the state in effect when it arrives. It is never held through a prompt or
+	// a wait, which the interruption cuts short
+	mutex sync.Mutex
+}
+
+// newRegionalRuns returns the run of each region of a multi-region run, or the single run of the region otherwise.
+func newRegionalRuns(userConfig config.UserConfig) (*regionalRuns, error) {
+	regionList := []string{userConfig.Region}
+	if userConfig.Regions != "" {
+		regionList = config.SplitRegions(userConfig.Regions)
+	}
+	regions := &regionalRuns{}
+	for _, region := range regionList {
+		userConfig.Region = region
+		svc, sessionRegion, err := newResources(userConfig)
 		if err != nil {
-			terminate(sess, err)
+			return nil, err
 		}
+		regions.runs = append(regions.runs, regionalRun{region: sessionRegion, svc: svc})
+	}
+	return regions, nil
+}
 
-		if err := svc.CreateCfnStack(cfnTemplate, vpcId, subnetId, outputStream); err != nil {
-			terminate(sess, err)
+// activate saves the test fixture of the active run, and makes the run at index i the active one. The mutex must be
+// held.
+func (r *regionalRuns) activate(i int) {
+	r.runs[r.active].testFixture = config.GetTestFixture()
+	r.active = i
+	config.SetTestFixture(r.runs[i].testFixture)
+}
+
+// setState moves the active run to the state, and persists its status in its bucket so that the run is resumed and
+// cleaned up from it. The mutex must be held.
+func (r *regionalRuns) setState(state resources.RunState) error {
+	run := &r.runs[r.active]
+	run.status.State = state
+	return run.svc.PutRunStatus(run.status)
+}
+
+// enterState moves the active run to the state like setState, holding the mutex, for the steps of a run to record
+// their progress in between their prompts and waits.
+func (r *regionalRuns) enterState(state resources.RunState) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.setState(state)
+}
+
+// isMultiRegion checks whether the runs span more than one region.
+func (r *regionalRuns) isMultiRegion() bool {
+	return len(r.runs) > 1
+}
+
+// label returns the region of the run as labeled in the report, which is empty for a single-region run.
+func (r *regionalRuns) label(run regionalRun) string {
+	if !r.isMultiRegion() {
+		return ""
+	}
+	return run.region
+}
+
+// loadPrices loads the prices of the price file in the region of each run, and returns the prices of the report.
+// The prices of a multi-region report are looked up by region.
+func loadPrices(regions *regionalRuns, priceFile string) (pricing.Source, error) {
+	regionalPrices := data.RegionalPrices{}
+	for i := range regions.runs {
+		run := &regions.runs[i]
+		priceSource, err := pricing.NewFileSource(priceFile, run.region)
+		if err != nil {
+			return nil, err
 		}
+		run.priceSource = priceSource
+		regionalPrices[run.region] = priceSource
+	}
+	if !regions.isMultiRegion() {
+		return regions.runs[0].priceSource, nil
+	}
+	return regionalPrices, nil
+}
+
+// startRun creates the resources of a new run in the region of the active run, and kicks off the execution of the
+// test suite on its instances.
+func startRun(regions *regionalRuns, userConfig config.UserConfig, inputStream *os.File, outputStream *os.File) error {
+	run := regions.runs[regions.active]
+	userConfig.Region = run.region
+	vpcId, subnetId, err := run.svc.GetVpcAndSubnetIds(userConfig.VpcId, userConfig.SubnetId, inputStream, outputStream)
+	if err != nil {
+		return err
+	}
+
+	runId := cmdutil.GetRandomString()
+	fmt.Fprintf(outputStream, "Test Run ID: %s\n", runId)
 
-		// After the tests begin, if the CLI is interrupted, we think the user may resume the session later to grab
-		// the results, so nothing should be deleted
-		deleteState = deleteNothing
-		log.Println("The execution of test suite has been kicked off on all instances. You may quit now and later run the CLI again with the bucket name flag to get the result")
+	// The bucket name is written to the test fixture, and the bucket is deleted with it
+	regions.mutex.Lock()
+	err = createBucket(regions, runId, outputStream)
+	regions.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cfnTemplate, err := prepareForNewRun(regions, userConfig, runId, subnetId, inputStream, outputStream)
+	if err != nil {
+		return err
+	}
+	if err := regions.enterState(resources.RunStateUploadedSuite); err != nil {
+		return err
+	}
+
+	if err := regions.enterState(resources.RunStateStackCreating); err != nil {
+		return err
+	}
+	if err := run.svc.CreateCfnStack(cfnTemplate, vpcId, subnetId, outputStream); err != nil {
+		return err
+	}
+	return regions.enterState(resources.RunStateTestsRunning)
+}
+
+// createBucket creates the bucket of the active run. The run is in RunStateCreatedBucket as soon as the creation
+// starts, so that a bucket created halfway is deleted too. The mutex must be held.
+func createBucket(regions *regionalRuns, runId string, outputStream *os.File) error {
+	run := &regions.runs[regions.active]
+	run.status.State = resources.RunStateCreatedBucket
+	if err := run.svc.CreateBucket(runId, outputStream); err != nil {
+		return err
+	}
+	return regions.setState(resources.RunStateCreatedBucket)
+}
+
+// collectResults polls for the results of the active run, following the logs of its instances if requested, and
+// collects its metrics, skipping the phases the run has completed before it was resumed.
+func collectResults(regions *regionalRuns, follow bool, outputStream *os.File) (data.RegionResults, error) {
+	run := regions.runs[regions.active]
+	state := run.status.State
+	if state.IsPolled() {
+		if err := data.FetchFinalResult(run.svc); err != nil {
+			return data.RegionResults{}, err
+		}
 	} else {
-		userConfig, err = prepareForResumedRun(sess, userConfig)
-		if err != nil {
-			terminate(sess, err)
+		if err := data.PollForResults(run.svc, follow, outputStream); err != nil {
+			return data.RegionResults{}, err
+		}
+		if err := regions.enterState(resources.RunStateCollectingMetrics); err != nil {
+			return data.RegionResults{}, err
 		}
 	}
+	if state.IsReported() {
+		return data.RestoreResults(run.svc, regions.label(run), run.status.MissingInstanceTypes)
+	}
+	return data.CollectResults(run.svc, regions.label(run))
+}
 
-	testFixture := config.GetTestFixture()
-	log.Printf("Executing Instance-Qualifier run with the following configuration: %s\n: ", testFixture.String())
+// listRuns outputs every instance-qualifier run in the region, so that any of them can be resumed.
+func listRuns(args []string, outputStream *os.File) {
+	userConfig, err := config.ParseSubcommandArgs(config.ListRunsCommand, args, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sess, err := newSession(userConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if err := data.PollForResults(sess); err != nil {
-		terminate(sess, err)
+	runs, err := resources.New(sess).ListRuns(*sess.Config.Region)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := data.OutputRuns(runs, userConfig.Output, outputStream); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	instances, err := svc.GetInstancesInCfnStack()
+// cleanup outputs the plan of the instance-qualifier resources left behind in the region and deletes them once the
+// user confirms, or as the policy says.
+func cleanup(args []string, inputStream *os.File, outputStream *os.File) {
+	userConfig, cleanupConfig, err := config.ParseCleanupArgs(args, os.Stderr)
 	if err != nil {
-		terminate(sess, err)
+		log.Fatal(err)
 	}
+	sess, err := newSession(userConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	svc := resources.New(sess)
 
-	cwResults, err := svc.GetCloudWatchData(instances, testFixture)
+	orphans, err := svc.FindOrphanedResources(*sess.Config.Region, cleanupConfig.OlderThan, time.Now())
 	if err != nil {
-		terminate(sess, err)
+		log.Fatal(err)
+	}
+	if err := data.OutputCleanupPlan(orphans, userConfig.Output, outputStream); err != nil {
+		log.Fatal(err)
+	}
+	if cleanupConfig.DryRun || len(orphans) == 0 {
+		return
 	}
 
-	if err := data.OutputAsTable(sess, outputStream, cwResults.MetricDataResults); err != nil {
-		terminate(sess, err)
+	// Machine-readable plans get stdout to themselves
+	promptStream := outputStream
+	if userConfig.Output != config.OutputTable {
+		promptStream = os.Stderr
 	}
-	fmt.Println("User configuration and CloudFormation template are stored in the root directory of the bucket. You may check them if you want")
-	// After outputting the final table, stack is no longer needed, but bucket should be kept for any deep dive
-	deleteState = deleteCfnStack
+	confirmed, err := config.Decide(config.DecisionDeleteOrphanedResources, "Do you want to delete these resources?", inputStream, promptStream)
+	if err != nil {
+		log.Println(err)
+		os.Exit(exitCode(err))
+	}
+	if !confirmed {
+		return
+	}
+	if err := svc.DeleteOrphanedResources(orphans, cleanupConfig.ArchiveBucket); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Fprintln(promptStream, "The process of cleaning up resources has started. You can quit now")
+}
 
-	terminate(sess, nil, deleteState)
-	fmt.Println("The process of cleaning up stack resources has started. You can quit now")
-	if err := svc.WaitUntilCfnStackDeleteComplete(); err != nil {
-		terminate(sess, err)
+// logs prints the output of a test file on an instance type from the bucket of a run, with a header per instance if
+// the instance type has replicas.
+func logs(args []string, outputStream *os.File) {
+	userConfig, logsConfig, err := config.ParseLogsArgs(args, os.Stderr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	svc, _, err := newResources(userConfig)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	fmt.Println("Completed!")
+	outputs, err := svc.GetTestOutputs(userConfig.Bucket, logsConfig.InstanceType, logsConfig.TestFile, logsConfig.Stream, logsConfig.InstanceId)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, output := range outputs {
+		if len(outputs) > 1 {
+			fmt.Fprintf(outputStream, "==> %s <==\n", output.InstanceId)
+		}
+		if _, err := outputStream.Write(output.Content); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// newResources returns the resources of the backend of the run, and the region used to price it. Local runs don't
+// have any session. The buckets are in the result store of the backend, unless a store endpoint is provided.
+func newResources(userConfig config.UserConfig) (svc *resources.Resources, region string, err error) {
+	if userConfig.Backend == config.BackendLocal {
+		localDir, err := filepath.Abs(config.LocalDir)
+		if err != nil {
+			return nil, "", err
+		}
+		svc = resources.NewLocal(localDir, "")
+		region = userConfig.Region
+	} else {
+		sess, err := newSession(userConfig)
+		if err != nil {
+			return nil, "", err
+		}
+		svc = resources.New(sess)
+		region = *sess.Config.Region
+	}
+	if userConfig.StoreEndpoint != "" {
+		svc.Store = resources.NewEndpointStore(userConfig.StoreEndpoint, region)
+	}
+	return svc, region, nil
 }
 
 // newSession returns a session with user provided config.
@@ -157,22 +566,35 @@ func newSession(userConfig config.UserConfig) (*session.Session, error) {
 	return sess, fmt.Errorf("cannot start test run without a region; refer to Configuration for more information")
 }
 
-// prepareForNewRun does the preparation work for a new instance-qualifier run, including populating TestFixture,
-// finding supported instance types, uploading the user configuration file, uploading the compressed test
-// suite, and uploading the final CloudFormation template.
-func prepareForNewRun(sess *session.Session, userConfig config.UserConfig, runId string, subnetId string, inputStream *os.File, outputStream *os.File) (cfnTemplate string, err error) {
-	svc := resources.New(sess)
-
+// prepareForNewRun does the preparation work for the active run, which is a new instance-qualifier run, including
+// resolving the instance type filters, populating TestFixture, finding supported instance types, uploading the user
+// configuration file, uploading the compressed test suite, and uploading the final CloudFormation template.
+func prepareForNewRun(regions *regionalRuns, userConfig config.UserConfig, runId string, subnetId string, inputStream *os.File, outputStream *os.File) (cfnTemplate string, err error) {
+	run := regions.runs[regions.active]
+	svc, prices := run.svc, run.priceSource
+	if !userConfig.InstanceTypeFilters.IsEmpty() {
+		instanceTypes, err := svc.ResolveInstanceTypes(userConfig.InstanceTypes, userConfig.InstanceTypeFilters, prices, userConfig.PriceType)
+		if err != nil {
+			return "", err
+		}
+		// The resolved list is persisted with the user config, so the run is resumed with the same instance types
+		userConfig.InstanceTypes = strings.Join(instanceTypes, ",")
+		config.SetInstanceTypes(userConfig.InstanceTypes)
+		fmt.Fprintf(outputStream, "Instance Types Resolved: %s\n", userConfig.InstanceTypes)
+	}
 	amiId, err := svc.GetAmiId(userConfig.AmiId, inputStream, outputStream)
 	if err != nil {
 		return "", err
 	}
-	if err := config.PopulateTestFixture(userConfig, runId, amiId); err != nil {
+	regions.mutex.Lock()
+	err = config.PopulateTestFixture(userConfig, runId, amiId)
+	testFixture := config.GetTestFixture()
+	regions.mutex.Unlock()
+	if err != nil {
 		return "", err
 	}
-	testFixture := config.GetTestFixture()
 
-	availabilityZone, instanceTypes, err := svc.FindBestAvailabilityZone(userConfig.InstanceTypes, subnetId)
+	placement, instanceTypes, err := svc.PlaceInstanceTypes(userConfig.InstanceTypes, subnetId)
 	if err != nil {
 		return "", err
 	}
@@ -180,55 +602,81 @@ func prepareForNewRun(sess *session.Session, userConfig config.UserConfig, runId
 	if err != nil {
 		return "", err
 	}
+	for i := range instances {
+		instances[i].AvailabilityZone = placement[instances[i].InstanceType]
+	}
 
-	if err := config.WriteUserConfig(testFixture.UserConfigFilename); err != nil {
+	if err := uploadUserConfigAndTestSuite(svc, testFixture); err != nil {
 		return "", err
 	}
-	if err := uploadAndRemoveFile(sess, testFixture.BucketName, testFixture.UserConfigFilename, testFixture.UserConfigFilename); err != nil {
+	cfnTemplate, err = template.GenerateCfnTemplate(instances, userConfig.InstanceTypes, inputStream, outputStream)
+	if err != nil {
 		return "", err
 	}
 
-	if err := setup.SetTestSuite(); err != nil {
+	// persist test fixture, including the decisions made so far
+	if err := uploadTestFixture(svc); err != nil {
 		return "", err
 	}
-	if err := uploadAndRemoveFile(sess, testFixture.BucketName, testFixture.CompressedTestSuiteName, filepath.Base(testFixture.CompressedTestSuiteName)); err != nil {
+	if err := ioutil.WriteFile(testFixture.CfnTemplateFilename, []byte(cfnTemplate), 0644); err != nil {
 		return "", err
 	}
-	// persist test fixture
-	tfByte, err := json.Marshal(testFixture)
-	if err != nil {
+	if err := uploadAndRemoveFile(svc, testFixture.BucketName, testFixture.CfnTemplateFilename, testFixture.CfnTemplateFilename); err != nil {
 		return "", err
 	}
-	tfReader := bytes.NewReader(tfByte)
-	if err := svc.UploadToS3(testFixture.BucketName, tfReader, testFixtureFileName); err != nil {
-		return "", err
+
+	return cfnTemplate, nil
+}
+
+// prepareForLocalRun does the preparation work for a new local run, which is that of a new run without anything
+// related to EC2: no AMI, Availability Zone nor CloudFormation template.
+func prepareForLocalRun(svc *resources.Resources, userConfig config.UserConfig, runId string) error {
+	if userConfig.CustomScriptPath != "" {
+		log.Printf("Skipping the custom script %s since it provisions instances, not the local machine\n", userConfig.CustomScriptPath)
+	}
+	if err := config.PopulateTestFixture(userConfig, runId, ""); err != nil {
+		return err
+	}
+	if err := uploadUserConfigAndTestSuite(svc, config.GetTestFixture()); err != nil {
+		return err
 	}
+	return uploadTestFixture(svc)
+}
 
-	cfnTemplate, err = template.GenerateCfnTemplate(instances, userConfig.InstanceTypes, availabilityZone, inputStream, outputStream)
-	if err != nil {
-		return "", err
+// uploadUserConfigAndTestSuite uploads the user configuration file and the compressed test suite to the bucket.
+func uploadUserConfigAndTestSuite(svc *resources.Resources, testFixture config.TestFixture) error {
+	if err := config.WriteUserConfig(testFixture.UserConfigFilename); err != nil {
+		return err
 	}
-	if err := ioutil.WriteFile(testFixture.CfnTemplateFilename, []byte(cfnTemplate), 0644); err != nil {
-		return "", err
+	if err := uploadAndRemoveFile(svc, testFixture.BucketName, testFixture.UserConfigFilename, testFixture.UserConfigFilename); err != nil {
+		return err
 	}
-	if err := uploadAndRemoveFile(sess, testFixture.BucketName, testFixture.CfnTemplateFilename, testFixture.CfnTemplateFilename); err != nil {
-		return "", err
+
+	if err := setup.SetTestSuite(); err != nil {
+		return err
 	}
+	return uploadAndRemoveFile(svc, testFixture.BucketName, testFixture.CompressedTestSuiteName, resources.TestSuiteKey(testFixture.CompressedTestSuiteName))
+}
 
-	return cfnTemplate, nil
+// uploadTestFixture persists the test fixture in the bucket, so that the run can be resumed.
+func uploadTestFixture(svc *resources.Resources) error {
+	testFixture := config.GetTestFixture()
+	tfByte, err := json.Marshal(testFixture)
+	if err != nil {
+		return err
+	}
+	return svc.Store.Put(testFixture.BucketName, resources.TestFixtureKey, bytes.NewReader(tfByte))
 }
 
 // prepareForResumedRun populates TestFixture, finds the types of all instances running in the stack, and populates
 // UserConfig struct with the configuration in the previous session.
-func prepareForResumedRun(sess *session.Session, userConfig config.UserConfig) (config.UserConfig, error) {
-	svc := resources.New(sess)
-
+func prepareForResumedRun(svc *resources.Resources, userConfig config.UserConfig) (config.UserConfig, error) {
 	runId := resources.RemoveBucketNamePrefix(userConfig.Bucket)
 	log.Printf("Test Run ID: %s\n", runId)
 	log.Printf("Bucket Used: %s\n", userConfig.Bucket)
 
 	// rehydrate test fixture
-	tfByte, err := svc.DownloadFromS3(userConfig.Bucket, testFixtureFileName)
+	tfByte, err := svc.Store.Get(userConfig.Bucket, resources.TestFixtureKey)
 	if err != nil {
 		return userConfig, err
 	}
@@ -241,7 +689,7 @@ func prepareForResumedRun(sess *session.Session, userConfig config.UserConfig) (
 
 	testFixture := config.GetTestFixture()
 
-	if err := svc.DownloadFromBucket(testFixture.BucketName, testFixture.UserConfigFilename, testFixture.UserConfigFilename); err != nil {
+	if err := resources.GetFile(svc.Store, testFixture.BucketName, testFixture.UserConfigFilename, testFixture.UserConfigFilename); err != nil {
 		return userConfig, err
 	}
 
@@ -257,10 +705,8 @@ func prepareForResumedRun(sess *session.Session, userConfig config.UserConfig) (
 	return userConfig, nil
 }
 
-func uploadAndRemoveFile(sess *session.Session, bucketName string, localPath string, remotePath string) error {
-	svc := resources.New(sess)
-
-	if err := svc.UploadToBucket(bucketName, localPath, remotePath); err != nil {
+func uploadAndRemoveFile(svc *resources.Resources, bucketName string, localPath string, remotePath string) error {
+	if err := resources.PutFile(svc.Store, bucketName, localPath, remotePath); err != nil {
 		return err
 	}
 
@@ -271,27 +717,45 @@ func uploadAndRemoveFile(sess *session.Session, bucketName string, localPath str
 	return nil
 }
 
-// terminate outputs error, deletes resources and exits conditionally.
-func terminate(sess *session.Session, err error, deleteState ...int) {
-	svc := resources.New(sess)
-
-	state := deleteAll
-	if len(deleteState) > 0 {
-		state = deleteState[0]
-	}
-
+// terminate outputs error, deletes the resources of every run as its state dictates (see resources.RunState.Cleanup)
+// and exits if there is an error. The mutex of the regions must be held.
+func terminate(regions *regionalRuns, err error) {
 	if err != nil {
 		log.Println(err)
 	}
 
-	if state != deleteNothing {
-		if state == deleteAll {
-			svc.DeleteBucket()
+	for i, run := range regions.runs {
+		regions.activate(i)
+		deleteBucket, deleteStack := run.status.State.Cleanup()
+		if deleteBucket {
+			run.svc.DeleteBucket()
+		}
+		if deleteStack {
+			if err := run.svc.DeleteCfnStack(); err != nil {
+				log.Println(err)
+				continue
+			}
+		}
+		if deleteBucket {
+			// Nothing is left to delete, nor any bucket to persist the state in
+			regions.runs[i].status.State = resources.RunStateCleanedUp
+		} else if deleteStack {
+			if err := regions.setState(resources.RunStateCleanedUp); err != nil {
+				log.Println(err)
+			}
 		}
-		svc.DeleteCfnStack()
 	}
 
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the exit code of the CLI when it fails with the error.
+func exitCode(err error) int {
+	var decisionErr config.DecisionRequiredError
+	if errors.As(err, &decisionErr) {
+		return config.ExitCodeDecisionRequired
 	}
+	return config.ExitCodeError
 }
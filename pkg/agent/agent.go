@@ -21,9 +21,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/setup"
 )
@@ -35,6 +36,14 @@ const (
 	resultFail             = "fail"
 )
 
+// testGroups are the process groups of the test files being executed. They are killed along with the agent, which
+// their processes don't belong to.
+var testGroups = struct {
+	sync.Mutex
+	pgids  map[int]bool
+	killed bool
+}{pgids: make(map[int]bool)}
+
 // GetTestFileList returns the list of test files in the test suite.
 func GetTestFileList(scriptPath string) (testFileList []string, err error) {
 	files, err := ioutil.ReadDir(scriptPath)
@@ -50,12 +59,73 @@ func GetTestFileList(scriptPath string) (testFileList []string, err error) {
 	return testFileList, nil
 }
 
-// PopulateResult takes a test file, executes it, then persists the pass/fail result and execution time
-func PopulateResult(filename string, agentFixture AgentFixture, outputStream *os.File, errStream *os.File) (testResult resources.Result) {
+// PopulateResult takes a test, executes it until it passes or runs out of retries, then persists the pass/fail result,
+// the execution time across all attempts and each attempt. The output of the test is captured to its output files,
+// whose keys in the bucket are referenced by the result, then copied to the output streams.
+func PopulateResult(test Test, agentFixture AgentFixture, outputStream *os.File, errStream *os.File) (testResult resources.Result) {
+	filename := test.File
 	testResult.Label = filepath.Base(filename)
 	testResult.Metrics = make([]resources.Metric, 0)
+	testResult.Tags = test.Tags
 
-	success, execTime := execute(filename, agentFixture.ScriptPath, outputStream, errStream)
+	output, err := createTestOutput(filename, test.MaxOutputSize)
+	isCaptured := err == nil
+	if !isCaptured {
+		// The output of the test still shows in the log of the instance
+		log.Println(err)
+	} else {
+		testResult.StdoutKey = agentFixture.Layout.TestOutput(filename, config.StreamStdout)
+		testResult.StderrKey = agentFixture.Layout.TestOutput(filename, config.StreamStderr)
+	}
+
+	var success bool
+	var firstStart time.Time
+	var totalExecTime float64
+	for i := 0; i <= test.Retries && !success; i++ {
+		if i > 0 {
+			fmt.Fprintf(outputStream, "🔁 Retrying %s (%d/%d)\n", filename, i, test.Retries)
+		}
+		before, ok := RunHook(test.Before, outputStream, errStream)
+		if before != nil {
+			testResult.Before = before
+		}
+		if ok {
+			testStdout, testStderr, release := outputStream, errStream, func() {}
+			if isCaptured {
+				if testStdout, testStderr, release, err = output.startAttempt(i); err != nil {
+					log.Println(err)
+					testStdout, testStderr, release = outputStream, errStream, func() {}
+				}
+			}
+			attempt, start, execTime := execute(test, outputStream, testStdout, testStderr)
+			release()
+			if firstStart.IsZero() {
+				firstStart = start
+			}
+			totalExecTime = start.Sub(firstStart).Seconds() + execTime
+			testResult.Attempts = append(testResult.Attempts, attempt)
+			success = !attempt.IsTimeout && test.isExpectedExitCode(attempt.ExitCode)
+			if execTime < waitUntilFileExistTime {
+				time.Sleep(waitUntilFileExistTime * time.Second)
+			}
+		} else {
+			fmt.Fprintf(outputStream, "⏭  %s was not executed since its before hook failed\n", filename)
+		}
+		if after, _ := RunHook(test.After, outputStream, errStream); after != nil {
+			testResult.After = after
+		}
+	}
+	if isCaptured {
+		if err := output.close(outputStream, errStream); err != nil {
+			log.Println(err)
+		}
+	}
+	if firstStart.IsZero() {
+		// No attempt was executed
+		firstStart = time.Now()
+	}
+	testResult.StartTime = firstStart.UTC().Format(time.RFC3339)
+	testResult.EndTime = firstStart.Add(time.Duration(totalExecTime * float64(time.Second))).UTC().Format(time.RFC3339)
 	if success {
 		testResult.Status = resultSuccess
 		fmt.Fprintf(outputStream, "\n------------------------------------------------------------------------------------------------------\n")
@@ -68,10 +138,61 @@ func PopulateResult(filename string, agentFixture AgentFixture, outputStream *os
 		fmt.Fprintf(outputStream, "------------------------------------------------------------------------------------------------------\n\n")
 	}
 
-	testResult.ExecutionTime = fmt.Sprintf("%.3f", execTime)
+	testResult.ExecutionTime = fmt.Sprintf("%.3f", totalExecTime)
 	return testResult
 }
 
+// RunTests executes the tests with up to concurrency of them at once, in the order they are declared, and passes the
+// result of each test to onResult along with the index of the test as soon as the test finishes. The calls to
+// onResult are serialized, so that it can update and upload the instance result. The tests are executed one at a time
+// if concurrency is less than 2.
+func RunTests(tests []Test, concurrency int, agentFixture AgentFixture, outputStream *os.File, errStream *os.File, onResult func(i int, testResult resources.Result)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	indexes := make(chan int)
+	for worker := 0; worker < concurrency && worker < len(tests); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if agentFixture.LogStreamer != nil {
+					agentFixture.LogStreamer.StartTest(tests[i].File)
+				}
+				testResult := PopulateResult(tests[i], agentFixture, outputStream, errStream)
+				if agentFixture.LogStreamer != nil {
+					agentFixture.LogStreamer.FinishTest(tests[i].File)
+				}
+				mutex.Lock()
+				onResult(i, testResult)
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i := range tests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// RunHook executes the hook, if any, then returns its result and whether it passed. There is no result if there is no
+// hook, which passes.
+func RunHook(hook *Hook, outputStream *os.File, errStream *os.File) (*resources.HookResult, bool) {
+	if hook == nil {
+		return nil, true
+	}
+	attempt, _, _ := execute(Test{File: hook.File, Timeout: hook.Timeout}, outputStream, outputStream, errStream)
+	hookResult := &resources.HookResult{Label: filepath.Base(hook.File), Status: resultSuccess, Attempt: attempt}
+	if attempt.IsTimeout || attempt.ExitCode != 0 {
+		hookResult.Status = resultFail
+		fmt.Fprintf(outputStream, "❌ %s failed with exit code %d\n", hook.File, attempt.ExitCode)
+	}
+	return hookResult, hookResult.Status == resultSuccess
+}
+
 // TerminateInstance terminates the instance.
 func TerminateInstance() {
 	cmd := exec.Command("shutdown", "-h", "now")
@@ -80,17 +201,29 @@ func TerminateInstance() {
 	}
 }
 
-// Fatal logs the fatal error, uploads the log to the bucket, then terminates the instance.
-func Fatal(sess *session.Session, agentFixture AgentFixture, err error) {
-	svc := resources.New(sess)
-
+// Fatal logs the fatal error, flushes the log streamer if any, uploads the log to the bucket, then terminates the
+// instance. A local agent exits instead.
+func Fatal(svc *resources.Resources, agentFixture AgentFixture, err error) {
 	if err != nil {
 		log.Println(err)
 	}
-	remoteLogFilename := agentFixture.BucketDir + "/" + filepath.Base(agentFixture.LogFilename)
-	if err := svc.UploadToBucket(agentFixture.BucketName, agentFixture.LogFilename, remoteLogFilename); err != nil {
+	if agentFixture.LogStreamer != nil {
+		if err := agentFixture.LogStreamer.Flush(); err != nil {
+			log.Println(err)
+		}
+	}
+	remoteLogFilename := agentFixture.Layout.Object(filepath.Base(agentFixture.LogFilename))
+	if err := resources.PutFile(svc.Store, agentFixture.BucketName, agentFixture.LogFilename, remoteLogFilename); err != nil {
 		log.Println(err)
 	}
+	if agentFixture.IsLocal {
+		// Unlike those of an instance, the tests of a local agent would outlive it
+		KillTests()
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	TerminateInstance()
 }
 
@@ -102,27 +235,117 @@ func isValidTestFile(file os.FileInfo) bool {
 	return true
 }
 
-// execute executes the test file, then returns the test result and execution time
-func execute(filename string, scriptPath string, outputStream *os.File, errStream *os.File) (success bool, execTime float64) {
+// execute executes the test file once, or a hook as a test without retries, with its output written to testStdout and
+// testStderr, then returns the attempt, start time and execution time. The test file runs in a process group of its
+// own, which is killed as a whole if the test times out.
+func execute(test Test, outputStream *os.File, testStdout *os.File, testStderr *os.File) (attempt resources.Attempt, start time.Time, execTime float64) {
+	filename := test.File
 	fmt.Fprintf(outputStream, "\n======================================================================================================\n")
 	fmt.Fprintf(outputStream, "🥑 Starting %s\n", filename)
 	fmt.Fprintf(outputStream, "======================================================================================================\n")
 
+	// Only the files at the root of the test suite are made executable when it is extracted
+	if info, err := os.Stat(filename); err == nil && info.Mode()&0100 == 0 {
+		if err := os.Chmod(filename, info.Mode()|0100); err != nil {
+			log.Println(err)
+		}
+	}
+
 	// Run the test file
 	cmd := exec.Command(filename)
-	cmd.Stdout = outputStream
-	cmd.Stderr = errStream
-	start := time.Now()
-	err := cmd.Run()
+	cmd.Dir = test.WorkingDir
+	if len(test.Env) > 0 {
+		cmd.Env = os.Environ()
+		for name, value := range test.Env {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+	cmd.Stdout = testStdout
+	cmd.Stderr = testStderr
+	setProcessGroup(cmd)
+	start = time.Now()
+	err := cmd.Start()
+	if err == nil {
+		trackTestGroup(cmd.Process.Pid)
+		err = wait(cmd, test.Timeout, &attempt)
+		untrackTestGroup(cmd.Process.Pid)
+	}
 	execTime = time.Since(start).Seconds()
-	if err != nil {
-		success = false
-	} else {
-		success = true
+	attempt.ExitCode = exitCode(err)
+	attempt.StartTime = start.UTC().Format(time.RFC3339)
+	attempt.ExecutionTime = fmt.Sprintf("%.3f", execTime)
+	if attempt.IsTimeout {
+		fmt.Fprintf(outputStream, "⏰ %s timed out after %d seconds\n", filename, test.Timeout)
 	}
-	if execTime < waitUntilFileExistTime {
-		time.Sleep(waitUntilFileExistTime * time.Second)
+
+	return attempt, start, execTime
+}
+
+// wait waits for the started test file to exit, and kills its process group once the timeout in seconds elapses if
+// there is one.
+func wait(cmd *exec.Cmd, timeout int, attempt *resources.Attempt) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	if timeout <= 0 {
+		return <-exited
 	}
 
-	return success, execTime
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case err := <-exited:
+		return err
+	case <-timer.C:
+		attempt.IsTimeout = true
+		if err := killProcessGroup(cmd.Process.Pid); err != nil {
+			log.Println(err)
+		}
+		return <-exited
+	}
+}
+
+// KillTests kills the process group of every test file being executed, and of every test file started from then on,
+// for the tests not to outlive the agent when it is stopped.
+func KillTests() {
+	testGroups.Lock()
+	defer testGroups.Unlock()
+	testGroups.killed = true
+	for pgid := range testGroups.pgids {
+		if err := killProcessGroup(pgid); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// trackTestGroup records the process group of a started test file, which is killed right away if the tests are.
+func trackTestGroup(pgid int) {
+	testGroups.Lock()
+	defer testGroups.Unlock()
+	if testGroups.killed {
+		if err := killProcessGroup(pgid); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	testGroups.pgids[pgid] = true
+}
+
+// untrackTestGroup forgets the process group of a test file which exited.
+func untrackTestGroup(pgid int) {
+	testGroups.Lock()
+	defer testGroups.Unlock()
+	delete(testGroups.pgids, pgid)
+}
+
+// exitCode returns the exit code of the test file given the error of its execution.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
 }
@@ -16,8 +16,13 @@ package agent
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
 
@@ -51,3 +56,53 @@ func TestGetTestFileListNonExistentDirFailure(t *testing.T) {
 	_, err := GetTestFileList("non-existent-dir")
 	h.Assert(t, err != nil, "Failed to return error when the directory doesn't exist")
 }
+
+func TestKillTests(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"child-test.sh": "#!/bin/sh\nsleep 60 &\necho $! > child.pid\nwait\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+	defer func() {
+		testGroups.Lock()
+		testGroups.killed = false
+		testGroups.Unlock()
+	}()
+
+	executed := make(chan resources.Attempt, 1)
+	go func() {
+		attempt, _, _ := execute(Test{File: dir + "/child-test.sh", WorkingDir: dir}, stream, stream, stream)
+		executed <- attempt
+	}()
+	var childPid int
+	for i := 0; i < 50 && childPid == 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "child.pid"))
+		childPid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	h.Assert(t, childPid != 0, "The test file didn't start its child")
+
+	KillTests()
+	select {
+	case attempt := <-executed:
+		h.Assert(t, attempt.ExitCode != 0, "The killed test file passed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("The test file is still running after its process group is killed")
+	}
+	// The child is gone, or a zombie left to be reaped, once the signal is delivered
+	childKilled := false
+	for i := 0; i < 50 && !childKilled; i++ {
+		stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(childPid) + "/stat")
+		childKilled = err != nil || strings.Contains(string(stat), ") Z ")
+		if !childKilled {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	h.Assert(t, childKilled, "The child of the test file is still running")
+
+	// Test files started from then on are killed right away
+	start := time.Now()
+	attempt, _, _ := execute(Test{File: dir + "/child-test.sh", WorkingDir: dir}, stream, stream, stream)
+	h.Assert(t, attempt.ExitCode != 0 && time.Since(start) < 5*time.Second, "The test file started after the tests were killed ran")
+}
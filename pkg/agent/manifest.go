@@ -0,0 +1,270 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/setup"
+)
+
+const (
+	// ManifestFilename is the name of the optional manifest at the root of the test suite.
+	ManifestFilename = "manifest.json"
+	maxExitCode      = 255
+)
+
+// Suite declares the tests of a test suite in the order they are executed, and the scripts executed around them. It
+// is read from the manifest of the test suite, if any.
+type Suite struct {
+	// Setup is executed before all tests; the tests aren't executed if it fails.
+	Setup *Hook `json:"setup,omitempty"`
+	// Teardown is executed after all tests, even if the setup failed.
+	Teardown *Hook `json:"teardown,omitempty"`
+	// Tests are every test file at the root of the test suite, in the order of its name, if none is declared.
+	Tests []Test `json:"tests,omitempty"`
+	// MaxOutputSize is the max bytes of the output of each test on each stream, for tests which don't declare theirs.
+	MaxOutputSize int `json:"max-output-size,omitempty"`
+	// Concurrency is the max number of tests executed at once, in the order they are declared. The tests are executed
+	// one at a time if it is 0.
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Hook declares a script executed by the agent around the tests, e.g. to install the dependencies of the tests.
+type Hook struct {
+	// File is the path of the script, relative to the test suite.
+	File string `json:"file"`
+	// Timeout is the max seconds of the script, after which its process group is killed and it fails.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Test declares how a test file is executed.
+type Test struct {
+	// File is the path of the test file, relative to the test suite.
+	File string `json:"file"`
+	// Timeout is the max seconds of each attempt of the test, after which its process group is killed. The test is
+	// only bounded by the timeout of the test suite if it is 0.
+	Timeout int `json:"timeout,omitempty"`
+	// Retries is the number of times the test is executed again while it fails.
+	Retries int `json:"retries,omitempty"`
+	// ExpectedExitCodes are the exit codes with which the test passes, 0 if none.
+	ExpectedExitCodes []int             `json:"expected-exit-codes,omitempty"`
+	Env               map[string]string `json:"env,omitempty"`
+	// WorkingDir is the working directory of the test, relative to the test suite. It is the test suite if empty.
+	WorkingDir string   `json:"working-dir,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// Before is executed before each attempt of the test, which fails without being executed if it fails.
+	Before *Hook `json:"before,omitempty"`
+	// After is executed after each attempt of the test, even if Before failed.
+	After *Hook `json:"after,omitempty"`
+	// MaxOutputSize is the max bytes of the output of the test on each stream across all attempts. Only the end of a
+	// larger output is kept, since that is where a failure usually shows, and the output doesn't take more than twice
+	// that size on disk while the test runs. The output is kept whole if it is 0.
+	MaxOutputSize int `json:"max-output-size,omitempty"`
+}
+
+// GetSuite returns the test suite. If the test suite has a manifest, the tests are those it declares in its order;
+// otherwise, every test file of the test suite is executed once in the order of its name.
+func GetSuite(scriptPath string) (suite Suite, err error) {
+	manifestPath := filepath.Join(scriptPath, ManifestFilename)
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		if suite, err = readManifest(manifestPath); err != nil {
+			return suite, err
+		}
+		if err := suite.validate(scriptPath); err != nil {
+			return suite, fmt.Errorf("invalid %s: %v", ManifestFilename, err)
+		}
+	}
+
+	suite.Setup = suite.Setup.resolve(scriptPath)
+	suite.Teardown = suite.Teardown.resolve(scriptPath)
+	if len(suite.Tests) == 0 {
+		testFileList, err := GetTestFileList(scriptPath)
+		if err != nil {
+			return suite, err
+		}
+		for _, testFile := range testFileList {
+			if !suite.isHookFile(testFile) {
+				suite.Tests = append(suite.Tests, Test{File: testFile, MaxOutputSize: suite.MaxOutputSize})
+			}
+		}
+		return suite, nil
+	}
+
+	for i, test := range suite.Tests {
+		test.File = resolve(scriptPath, test.File)
+		if test.WorkingDir != "" {
+			test.WorkingDir = filepath.Join(scriptPath, test.WorkingDir)
+		}
+		test.Before = test.Before.resolve(scriptPath)
+		test.After = test.After.resolve(scriptPath)
+		if test.MaxOutputSize == 0 {
+			test.MaxOutputSize = suite.MaxOutputSize
+		}
+		suite.Tests[i] = test
+	}
+	return suite, nil
+}
+
+// readManifest parses a manifest, rejecting unknown fields so that a misspelled field isn't silently ignored.
+func readManifest(manifestPath string) (suite Suite, err error) {
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return suite, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&suite); err != nil {
+		return suite, fmt.Errorf("failed to parse %s: %v", ManifestFilename, err)
+	}
+	return suite, nil
+}
+
+// validate checks that each test and hook is a file of the test suite, and that each test has a name of its own,
+// since the result of a test is stored under the name of its file.
+func (s Suite) validate(scriptPath string) error {
+	if err := s.Setup.validate(scriptPath, "setup"); err != nil {
+		return err
+	}
+	if err := s.Teardown.validate(scriptPath, "teardown"); err != nil {
+		return err
+	}
+	if s.MaxOutputSize < 0 {
+		return fmt.Errorf("max-output-size must be non-negative")
+	}
+	if s.Concurrency < 0 {
+		return fmt.Errorf("concurrency must be non-negative")
+	}
+	names := make(map[string]bool)
+	for i, test := range s.Tests {
+		if err := validateFile(scriptPath, test.File); err != nil {
+			return fmt.Errorf("test %d: %v", i+1, err)
+		}
+		name := filepath.Base(test.File)
+		if names[name] {
+			return fmt.Errorf("test %d: another test file is named %s", i+1, name)
+		}
+		names[name] = true
+
+		if test.Timeout < 0 {
+			return fmt.Errorf("test %s: timeout must be non-negative", test.File)
+		}
+		if test.MaxOutputSize < 0 {
+			return fmt.Errorf("test %s: max-output-size must be non-negative", test.File)
+		}
+		if test.Retries < 0 {
+			return fmt.Errorf("test %s: retries must be non-negative", test.File)
+		}
+		for _, exitCode := range test.ExpectedExitCodes {
+			if exitCode < 0 || exitCode > maxExitCode {
+				return fmt.Errorf("test %s: expected exit code %d must be between 0 and %d", test.File, exitCode, maxExitCode)
+			}
+		}
+		if test.WorkingDir != "" {
+			if !isInTestSuite(test.WorkingDir) {
+				return fmt.Errorf("test %s: working directory %q must be a relative path within the test suite", test.File, test.WorkingDir)
+			}
+			if info, err := os.Stat(filepath.Join(scriptPath, test.WorkingDir)); err != nil || !info.IsDir() {
+				return fmt.Errorf("test %s: working directory %s doesn't exist", test.File, test.WorkingDir)
+			}
+		}
+		if err := test.Before.validate(scriptPath, "before hook of test "+test.File); err != nil {
+			return err
+		}
+		if err := test.After.validate(scriptPath, "after hook of test "+test.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isHookFile checks whether the resolved file is the setup or the teardown of the suite, which aren't test files.
+func (s Suite) isHookFile(file string) bool {
+	return (s.Setup != nil && s.Setup.File == file) || (s.Teardown != nil && s.Teardown.File == file)
+}
+
+// validate checks that the hook, if any, is a file of the test suite with a non-negative timeout.
+func (h *Hook) validate(scriptPath string, name string) error {
+	if h == nil {
+		return nil
+	}
+	if err := validateFile(scriptPath, h.File); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("%s: timeout must be non-negative", name)
+	}
+	return nil
+}
+
+// resolve returns the hook, if any, with the path of its file within the test suite.
+func (h *Hook) resolve(scriptPath string) *Hook {
+	if h == nil {
+		return nil
+	}
+	return &Hook{File: resolve(scriptPath, h.File), Timeout: h.Timeout}
+}
+
+// validateFile checks that a file declared by the manifest is a file of the test suite, other than the files of the
+// instance-qualifier.
+func validateFile(scriptPath string, file string) error {
+	if !isInTestSuite(file) {
+		return fmt.Errorf("file %q must be a relative path within the test suite", file)
+	}
+	name := filepath.Base(file)
+	if setup.IsInstanceQualifierScript(name) || name == ManifestFilename {
+		return fmt.Errorf("%s is not a script of the test suite", file)
+	}
+	info, err := os.Stat(filepath.Join(scriptPath, file))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", file)
+	}
+	return nil
+}
+
+// resolve returns the path of a file declared by the manifest within the test suite.
+func resolve(scriptPath string, file string) string {
+	return scriptPath + "/" + filepath.ToSlash(filepath.Clean(file))
+}
+
+// isExpectedExitCode checks whether the test passes with the exit code.
+func (t Test) isExpectedExitCode(exitCode int) bool {
+	if len(t.ExpectedExitCodes) == 0 {
+		return exitCode == 0
+	}
+	for _, expected := range t.ExpectedExitCodes {
+		if exitCode == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// isInTestSuite checks whether a path is relative and doesn't escape the test suite.
+func isInTestSuite(path string) bool {
+	if path == "" || filepath.IsAbs(path) {
+		return false
+	}
+	cleaned := filepath.Clean(path)
+	return cleaned != ".." && !strings.HasPrefix(cleaned, ".."+string(filepath.Separator))
+}
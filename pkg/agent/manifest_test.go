@@ -0,0 +1,268 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+// newTestSuite creates a test suite with the files mapped to their content, and returns its directory.
+func newTestSuite(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "test-suite")
+	h.Ok(t, err)
+	for filename, content := range files {
+		path := filepath.Join(dir, filename)
+		h.Ok(t, os.MkdirAll(filepath.Dir(path), 0755))
+		h.Ok(t, ioutil.WriteFile(path, []byte(content), 0755))
+	}
+	return dir
+}
+
+func devNull(t *testing.T) *os.File {
+	file, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	h.Ok(t, err)
+	return file
+}
+
+// Tests
+
+func TestGetSuiteManifestSuccess(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"mem-test.sh":     "",
+		"cpu/cpu-test.sh": "",
+		"not-declared.sh": "",
+		ManifestFilename: `{"tests": [
+			{"file": "mem-test.sh", "timeout": 60, "retries": 2, "expected-exit-codes": [0, 3], "env": {"SIZE": "1G"}, "tags": ["memory"]},
+			{"file": "cpu/cpu-test.sh", "working-dir": "cpu"}
+		]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	suite, err := GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, []Test{
+		{File: dir + "/mem-test.sh", Timeout: 60, Retries: 2, ExpectedExitCodes: []int{0, 3}, Env: map[string]string{"SIZE": "1G"}, Tags: []string{"memory"}},
+		{File: dir + "/cpu/cpu-test.sh", WorkingDir: filepath.Join(dir, "cpu")},
+	}, suite.Tests)
+}
+
+func TestGetSuiteNoManifestSuccess(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{"b-test.sh": "", "a-test.sh": "", "a-test.sh-result.json": ""})
+	defer os.RemoveAll(dir)
+
+	suite, err := GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, Suite{Tests: []Test{{File: dir + "/a-test.sh"}, {File: dir + "/b-test.sh"}}}, suite)
+}
+
+func TestGetSuiteHooksSuccess(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"setup.sh":         "",
+		"teardown.sh":      "",
+		"cpu-test.sh":      "",
+		"hooks/warm-up.sh": "",
+		ManifestFilename:   `{"setup": {"file": "setup.sh", "timeout": 300}, "teardown": {"file": "teardown.sh"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	// Without any test declared, the hooks aren't test files
+	suite, err := GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, Suite{
+		Setup:    &Hook{File: dir + "/setup.sh", Timeout: 300},
+		Teardown: &Hook{File: dir + "/teardown.sh"},
+		Tests:    []Test{{File: dir + "/cpu-test.sh"}},
+	}, suite)
+
+	h.Ok(t, ioutil.WriteFile(filepath.Join(dir, ManifestFilename), []byte(`{"tests": [{"file": "cpu-test.sh", "before": {"file": "hooks/warm-up.sh", "timeout": 30}}]}`), 0644))
+	suite, err = GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, &Hook{File: dir + "/hooks/warm-up.sh", Timeout: 30}, suite.Tests[0].Before)
+}
+
+func TestGetSuiteMaxOutputSize(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"a-test.sh":      "",
+		"b-test.sh":      "",
+		ManifestFilename: `{"max-output-size": 1024, "tests": [{"file": "a-test.sh"}, {"file": "b-test.sh", "max-output-size": 64}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	suite, err := GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, 1024, suite.Tests[0].MaxOutputSize)
+	h.Equals(t, 64, suite.Tests[1].MaxOutputSize)
+
+	// Without any test declared, every test file takes the max output size of the suite
+	h.Ok(t, ioutil.WriteFile(filepath.Join(dir, ManifestFilename), []byte(`{"max-output-size": 1024}`), 0644))
+	suite, err = GetSuite(dir)
+	h.Ok(t, err)
+	h.Equals(t, []Test{{File: dir + "/a-test.sh", MaxOutputSize: 1024}, {File: dir + "/b-test.sh", MaxOutputSize: 1024}}, suite.Tests)
+}
+
+func TestGetSuiteInvalidManifestFailure(t *testing.T) {
+	for _, manifest := range []string{
+		`{"setup": {"file": "../setup.sh"}}`,
+		`{"teardown": {"file": "test.sh", "timeout": -1}}`,
+		`{"tests": [{"file": "test.sh", "after": {"file": "missing.sh"}}]}`,
+		`{"tests": [{"file": "test.sh", "retry": 2}]}`,
+		`{"tests": [{"file": "../test.sh"}]}`,
+		`{"tests": [{"file": "missing.sh"}]}`,
+		`{"tests": [{"file": "test.sh"}, {"file": "dir/test.sh"}]}`,
+		`{"tests": [{"file": "agent"}]}`,
+		`{"tests": [{"file": "test.sh", "timeout": -1}]}`,
+		`{"tests": [{"file": "test.sh", "retries": -1}]}`,
+		`{"tests": [{"file": "test.sh", "expected-exit-codes": [256]}]}`,
+		`{"tests": [{"file": "test.sh", "working-dir": "missing"}]}`,
+		`{"tests": [{"file": "test.sh", "max-output-size": -1}]}`,
+		`{"max-output-size": -1}`,
+		`{"concurrency": -1}`,
+	} {
+		dir := newTestSuite(t, map[string]string{"test.sh": "", "dir/test.sh": "", "agent": "", ManifestFilename: manifest})
+		_, err := GetSuite(dir)
+		os.RemoveAll(dir)
+		h.Assert(t, err != nil, "Failed to return error for the manifest %s", manifest)
+	}
+}
+
+func TestPopulateResultRetries(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		// Fails on the first attempt and exits with 3 on the second one
+		"flaky-test.sh": "#!/bin/sh\nif [ -f attempted ]; then exit 3; fi\ntouch attempted\nexit 1\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	test := Test{File: dir + "/flaky-test.sh", Retries: 2, ExpectedExitCodes: []int{3}, WorkingDir: dir, Tags: []string{"flaky"}}
+	testResult := PopulateResult(test, AgentFixture{}, stream, stream)
+	h.Equals(t, resultSuccess, testResult.Status)
+	h.Equals(t, []string{"flaky"}, testResult.Tags)
+	h.Equals(t, 2, len(testResult.Attempts))
+	h.Equals(t, 1, testResult.Attempts[0].ExitCode)
+	h.Equals(t, 3, testResult.Attempts[1].ExitCode)
+}
+
+func TestPopulateResultTimeout(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"slow-test.sh": "#!/bin/sh\nsleep 30 &\nsleep 30\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	start := time.Now()
+	testResult := PopulateResult(Test{File: dir + "/slow-test.sh", Timeout: 1}, AgentFixture{}, stream, stream)
+	h.Assert(t, time.Since(start) < 10*time.Second, "Failed to kill the test once it timed out")
+	h.Equals(t, resultFail, testResult.Status)
+	h.Equals(t, 1, len(testResult.Attempts))
+	h.Assert(t, testResult.Attempts[0].IsTimeout, "Failed to record that the test timed out")
+	h.Equals(t, -1, testResult.Attempts[0].ExitCode)
+}
+
+func TestPopulateResultBeforeHookFailure(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		"test.sh":   "#!/bin/sh\ntouch executed\n",
+		"before.sh": "#!/bin/sh\nexit 2\n",
+		"after.sh":  "#!/bin/sh\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	test := Test{File: dir + "/test.sh", WorkingDir: dir, Before: &Hook{File: dir + "/before.sh"}, After: &Hook{File: dir + "/after.sh"}}
+	testResult := PopulateResult(test, AgentFixture{}, stream, stream)
+	h.Equals(t, resultFail, testResult.Status)
+	h.Equals(t, 0, len(testResult.Attempts))
+	h.Equals(t, "before.sh", testResult.Before.Label)
+	h.Equals(t, resultFail, testResult.Before.Status)
+	h.Equals(t, 2, testResult.Before.ExitCode)
+	h.Equals(t, resultSuccess, testResult.After.Status)
+	_, err := os.Stat(filepath.Join(dir, "executed"))
+	h.Assert(t, os.IsNotExist(err), "Executed the test although its before hook failed")
+}
+
+func TestRunTestsConcurrency(t *testing.T) {
+	// Each test waits until the other one started, so that they only pass if they are executed at once
+	dir := newTestSuite(t, map[string]string{
+		"a-test.sh": "#!/bin/sh\ntouch a-started\nfor i in $(seq 50); do [ -f b-started ] && exit 0; sleep 0.1; done\nexit 1\n",
+		"b-test.sh": "#!/bin/sh\ntouch b-started\nfor i in $(seq 50); do [ -f a-started ] && exit 3; sleep 0.1; done\nexit 1\n",
+		"c-test.sh": "#!/bin/sh\nexit 2\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	tests := []Test{
+		{File: dir + "/a-test.sh", WorkingDir: dir},
+		{File: dir + "/b-test.sh", WorkingDir: dir, ExpectedExitCodes: []int{3}},
+		{File: dir + "/c-test.sh", WorkingDir: dir},
+	}
+	testResults := make([]resources.Result, len(tests))
+	calls := 0
+	RunTests(tests, 2, AgentFixture{}, stream, stream, func(i int, testResult resources.Result) {
+		// The calls are serialized, so the counter isn't raced
+		calls++
+		testResults[i] = testResult
+	})
+	h.Equals(t, 3, calls)
+	h.Equals(t, "a-test.sh", testResults[0].Label)
+	h.Equals(t, resultSuccess, testResults[0].Status)
+	h.Equals(t, "b-test.sh", testResults[1].Label)
+	h.Equals(t, resultSuccess, testResults[1].Status)
+	h.Equals(t, 3, testResults[1].Attempts[0].ExitCode)
+	h.Equals(t, "c-test.sh", testResults[2].Label)
+	h.Equals(t, 2, testResults[2].Attempts[0].ExitCode)
+}
+
+func TestRunTestsOneAtATime(t *testing.T) {
+	// The second test fails if the first one is still running
+	dir := newTestSuite(t, map[string]string{
+		"a-test.sh": "#!/bin/sh\ntouch running\nsleep 1\nrm running\n",
+		"b-test.sh": "#!/bin/sh\n[ ! -f running ]\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	var order []string
+	RunTests([]Test{{File: dir + "/a-test.sh", WorkingDir: dir}, {File: dir + "/b-test.sh", WorkingDir: dir}}, 0, AgentFixture{}, stream, stream, func(i int, testResult resources.Result) {
+		h.Equals(t, resultSuccess, testResult.Status)
+		order = append(order, testResult.Label)
+	})
+	h.Equals(t, []string{"a-test.sh", "b-test.sh"}, order)
+}
+
+func TestRunHook(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{"setup.sh": "#!/bin/sh\nsleep 30\n"})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	hookResult, ok := RunHook(nil, stream, stream)
+	h.Assert(t, hookResult == nil && ok, "A missing hook doesn't pass")
+	hookResult, ok = RunHook(&Hook{File: dir + "/setup.sh", Timeout: 1}, stream, stream)
+	h.Assert(t, !ok, "A hook which timed out passes")
+	h.Assert(t, hookResult.IsTimeout, "Failed to record that the hook timed out")
+	h.Equals(t, resultFail, hookResult.Status)
+}
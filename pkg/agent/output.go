@@ -0,0 +1,230 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+// OutputFilename returns the path of the file capturing the output of the test file on the stream, next to the test
+// file.
+func OutputFilename(testFile string, stream string) string {
+	return filepath.Join(filepath.Dir(testFile), resources.TestOutputFilename(testFile, stream))
+}
+
+// outputGracePeriod is how long the agent keeps copying the output of a test file once it exits, for the processes it
+// left behind to close their output.
+const outputGracePeriod = 1 * time.Second
+
+// testOutput holds the files capturing the output of a test across all its attempts.
+type testOutput struct {
+	stdout *tailFile
+	stderr *tailFile
+}
+
+// createTestOutput creates the empty output files of the test file, replacing those of any previous execution. Only
+// the last maxSize bytes of each output are kept, unless maxSize is 0.
+func createTestOutput(testFile string, maxSize int) (output testOutput, err error) {
+	if output.stdout, err = createTailFile(OutputFilename(testFile, config.StreamStdout), maxSize); err != nil {
+		return output, err
+	}
+	if output.stderr, err = createTailFile(OutputFilename(testFile, config.StreamStderr), maxSize); err != nil {
+		output.stdout.file.Close()
+		return output, err
+	}
+	return output, nil
+}
+
+// startAttempt separates the output of an attempt from that of the previous ones, and returns the files the attempt
+// writes its output to along with the function to call once it exits.
+func (o testOutput) startAttempt(attempt int) (stdout *os.File, stderr *os.File, release func(), err error) {
+	if attempt > 0 {
+		fmt.Fprintf(o.stdout, "\n----- attempt %d -----\n", attempt+1)
+		fmt.Fprintf(o.stderr, "\n----- attempt %d -----\n", attempt+1)
+	}
+	stdout, releaseStdout, err := o.stdout.pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stderr, releaseStderr, err := o.stderr.pipe()
+	if err != nil {
+		releaseStdout()
+		return nil, nil, nil, err
+	}
+	return stdout, stderr, func() {
+		releaseStdout()
+		releaseStderr()
+	}, nil
+}
+
+// close closes the output files, truncated to their max size, then copies them to the output streams of the agent, so
+// that the log of the instance still shows the output of every test.
+func (o testOutput) close(outputStream io.Writer, errStream io.Writer) error {
+	for _, file := range []struct {
+		*tailFile
+		stream io.Writer
+	}{{o.stdout, outputStream}, {o.stderr, errStream}} {
+		if err := file.close(); err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(file.file.Name())
+		if err != nil {
+			return err
+		}
+		if _, err := file.stream.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tailFile is an output file which keeps the last maxSize bytes written to it, so that a chatty test doesn't fill the
+// disk of the instance: it never grows beyond twice maxSize while the output is captured, and is truncated to maxSize
+// once closed, after a line telling how many bytes were truncated. The file is kept whole if maxSize is 0.
+type tailFile struct {
+	file      *os.File
+	maxSize   int64
+	size      int64
+	truncated int64
+}
+
+// createTailFile creates an empty tail file.
+func createTailFile(filename string, maxSize int) (*tailFile, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &tailFile{file: file, maxSize: int64(maxSize)}, nil
+}
+
+// Write appends p to the file, first dropping the oldest bytes beyond the max size.
+func (f *tailFile) Write(p []byte) (int, error) {
+	n := len(p)
+	if f.maxSize > 0 {
+		if int64(len(p)) > f.maxSize {
+			f.truncated += int64(len(p)) - f.maxSize
+			p = p[int64(len(p))-f.maxSize:]
+		}
+		if f.size+int64(len(p)) > 2*f.maxSize {
+			if err := f.keepLast(f.maxSize - int64(len(p))); err != nil {
+				return 0, err
+			}
+		}
+	}
+	written, err := f.file.Write(p)
+	f.size += int64(written)
+	if err != nil {
+		return written, err
+	}
+	return n, nil
+}
+
+// keepLast rewrites the file with its last bytes only.
+func (f *tailFile) keepLast(size int64) error {
+	tail := make([]byte, size)
+	if _, err := f.file.ReadAt(tail, f.size-size); err != nil && err != io.EOF {
+		return err
+	}
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.file.WriteAt(tail, 0); err != nil {
+		return err
+	}
+	if _, err := f.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	f.truncated += f.size - size
+	f.size = size
+	return nil
+}
+
+// close truncates the file to its max size, then closes it.
+func (f *tailFile) close() error {
+	if f.maxSize > 0 && f.size > f.maxSize {
+		if err := f.keepLast(f.maxSize); err != nil {
+			f.file.Close()
+			return err
+		}
+	}
+	if f.truncated > 0 {
+		tail := make([]byte, f.size)
+		if _, err := f.file.ReadAt(tail, 0); err != nil && err != io.EOF {
+			f.file.Close()
+			return err
+		}
+		header := fmt.Sprintf("[... %d bytes truncated ...]\n", f.truncated)
+		if _, err := f.file.WriteAt(append([]byte(header), tail...), 0); err != nil {
+			f.file.Close()
+			return err
+		}
+	}
+	return f.file.Close()
+}
+
+// pipe returns the write end of a pipe whose output is copied to the file, and the function to call once the test
+// writing to it exits. The copy goes on until every process of the test closes the pipe, or until the grace period
+// elapses, after which the output of the processes the test left behind is discarded rather than breaking their pipe.
+func (f *tailFile) pipe() (*os.File, func(), error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	dst := &detachableWriter{writer: f}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(dst, reader)
+		reader.Close()
+		close(copied)
+	}()
+	return writer, func() {
+		writer.Close()
+		select {
+		case <-copied:
+		case <-time.After(outputGracePeriod):
+		}
+		dst.detach()
+	}, nil
+}
+
+// detachableWriter writes to its writer until it is detached, after which it discards what it is written.
+type detachableWriter struct {
+	mutex    sync.Mutex
+	writer   io.Writer
+	detached bool
+}
+
+func (w *detachableWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.detached {
+		return len(p), nil
+	}
+	return w.writer.Write(p)
+}
+
+func (w *detachableWriter) detach() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.detached = true
+}
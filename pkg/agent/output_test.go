@@ -0,0 +1,112 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestPopulateResultOutput(t *testing.T) {
+	dir := newTestSuite(t, map[string]string{
+		// Fails on the first attempt and passes on the second one
+		"flaky-test.sh": "#!/bin/sh\necho out\necho err >&2\nif [ -f attempted ]; then exit 0; fi\ntouch attempted\nexit 1\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+	agentFixture := AgentFixture{Layout: resources.BucketLayout{RootDir: "Instance-Qualifier-Run-123"}.Instance("m4.large", "i-123")}
+
+	testResult := PopulateResult(Test{File: dir + "/flaky-test.sh", Retries: 1, WorkingDir: dir}, agentFixture, stream, stream)
+	h.Equals(t, resultSuccess, testResult.Status)
+	h.Equals(t, "Instance-Qualifier-Run-123/m4.large/i-123/Tests/flaky-test.sh-stdout.log", testResult.StdoutKey)
+	h.Equals(t, "Instance-Qualifier-Run-123/m4.large/i-123/Tests/flaky-test.sh-stderr.log", testResult.StderrKey)
+
+	stdout, err := ioutil.ReadFile(filepath.Join(dir, "flaky-test.sh-stdout.log"))
+	h.Ok(t, err)
+	h.Equals(t, "out\n\n----- attempt 2 -----\nout\n", string(stdout))
+	stderr, err := ioutil.ReadFile(OutputFilename(dir+"/flaky-test.sh", config.StreamStderr))
+	h.Ok(t, err)
+	h.Equals(t, "err\n\n----- attempt 2 -----\nerr\n", string(stderr))
+}
+
+func TestPopulateResultOutputMaxSize(t *testing.T) {
+	// Writes 100000 bytes, then keeps running
+	dir := newTestSuite(t, map[string]string{
+		"chatty-test.sh": "#!/bin/sh\nhead -c 100000 /dev/zero | tr '\\0' x\ntouch written\nsleep 2\n",
+	})
+	defer os.RemoveAll(dir)
+	stream := devNull(t)
+	defer stream.Close()
+
+	done := make(chan resources.Result, 1)
+	go func() {
+		done <- PopulateResult(Test{File: dir + "/chatty-test.sh", WorkingDir: dir, MaxOutputSize: 1024}, AgentFixture{}, stream, stream)
+	}()
+	filename := OutputFilename(dir+"/chatty-test.sh", config.StreamStdout)
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(filepath.Join(dir, "written")); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	info, err := os.Stat(filename)
+	h.Ok(t, err)
+	h.Assert(t, info.Size() <= 2*1024, "The output of the running test takes %d bytes beyond twice its max size", info.Size())
+
+	h.Equals(t, resultSuccess, (<-done).Status)
+	content, err := ioutil.ReadFile(filename)
+	h.Ok(t, err)
+	h.Equals(t, "[... 98976 bytes truncated ...]\n"+strings.Repeat("x", 1024), string(content))
+}
+
+func TestTailFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-output")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "test.sh-stdout.log")
+
+	for _, testCase := range []struct {
+		maxSize  int
+		writes   []string
+		expected string
+	}{
+		{0, []string{"0123456789"}, "0123456789"},
+		{10, []string{"0123456789"}, "0123456789"},
+		{4, []string{"0123456789"}, "[... 6 bytes truncated ...]\n6789"},
+		{4, []string{"012", "345", "678", "9"}, "[... 6 bytes truncated ...]\n6789"},
+	} {
+		file, err := createTailFile(filename, testCase.maxSize)
+		h.Ok(t, err)
+		for _, write := range testCase.writes {
+			_, err := file.Write([]byte(write))
+			h.Ok(t, err)
+			h.Assert(t, testCase.maxSize == 0 || file.size <= int64(2*testCase.maxSize), "The file grew beyond twice its max size")
+		}
+		h.Ok(t, file.close())
+		content, err := ioutil.ReadFile(filename)
+		h.Ok(t, err)
+		h.Equals(t, testCase.expected, string(content))
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+const (
+	procStatPath = "/proc/stat"
+	// SamplingPeriod is the period at which a local agent samples the metrics, in place of the CloudWatch agent.
+	SamplingPeriod = 1 * time.Second
+)
+
+// cpuTimes are the times spent by all CPUs, in units of USER_HZ, as in the "cpu" line of /proc/stat.
+type cpuTimes struct {
+	idle  float64
+	total float64
+}
+
+// SampleLocalMetrics samples the metrics of the local machine from /proc each period and publishes them as the
+// metrics of the instance, until done is closed. A metric which can't be sampled is skipped.
+func SampleLocalMetrics(svc *resources.Resources, instance resources.Instance, period time.Duration, done <-chan bool) {
+	previous, err := readCpuTimes()
+	if err != nil {
+		log.Println(err)
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			values := make(map[string]float64)
+			if current, err := readCpuTimes(); err == nil {
+				if current.total > previous.total {
+					values[metrics.CpuUsageActive] = 100 * (1 - (current.idle-previous.idle)/(current.total-previous.total))
+				}
+				previous = current
+			} else {
+				log.Println(err)
+			}
+			if running, err := readProcessesRunning(); err == nil {
+				values[metrics.ProcessesRunning] = running
+			} else {
+				log.Println(err)
+			}
+			if meminfo, err := resources.ReadMeminfo(); err == nil {
+				for name, value := range memoryMetrics(meminfo) {
+					values[name] = value
+				}
+			} else {
+				log.Println(err)
+			}
+			if err := svc.PutLocalMetricData(instance, values, now); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func readCpuTimes() (cpuTimes, error) {
+	file, err := os.Open(procStatPath)
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer file.Close()
+	return parseCpuTimes(file)
+}
+
+func readProcessesRunning() (float64, error) {
+	file, err := os.Open(procStatPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return parseProcessesRunning(file)
+}
+
+// parseCpuTimes parses the "cpu" line of /proc/stat. As for cpu_usage_active of the CloudWatch agent, the time
+// waiting for I/O is active; guest times are already included in the user times.
+func parseCpuTimes(reader io.Reader) (times cpuTimes, err error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal
+		for i, field := range fields[1:9] {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return times, err
+			}
+			times.total += value
+			if i == 3 {
+				times.idle += value
+			}
+		}
+		return times, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return times, err
+	}
+	return times, fmt.Errorf("no cpu line in %s", procStatPath)
+}
+
+// parseProcessesRunning parses the "procs_running" line of /proc/stat.
+func parseProcessesRunning(reader io.Reader) (float64, error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "procs_running" {
+			return strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no procs_running line in %s", procStatPath)
+}
+
+// memoryMetrics computes the memory metrics from the fields of /proc/meminfo, as the CloudWatch agent does.
+func memoryMetrics(meminfo map[string]float64) map[string]float64 {
+	values := make(map[string]float64)
+	if memTotal := meminfo["MemTotal"]; memTotal > 0 {
+		values[metrics.MemUsedPercent] = 100 * (memTotal - meminfo["MemAvailable"]) / memTotal
+	}
+	values[metrics.SwapUsedPercent] = 0
+	if swapTotal := meminfo["SwapTotal"]; swapTotal > 0 {
+		values[metrics.SwapUsedPercent] = 100 * (swapTotal - meminfo["SwapFree"]) / swapTotal
+	}
+	return values
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+const procStat = `cpu  100 20 30 800 40 5 5 0 0 0
+cpu0 50 10 15 400 20 2 3 0 0 0
+intr 12345
+ctxt 67890
+procs_running 3
+procs_blocked 0
+`
+
+// Tests
+
+func TestParseCpuTimesSuccess(t *testing.T) {
+	times, err := parseCpuTimes(strings.NewReader(procStat))
+	h.Ok(t, err)
+	h.Equals(t, cpuTimes{idle: 800, total: 1000}, times)
+}
+
+func TestParseCpuTimesNoCpuLineFailure(t *testing.T) {
+	_, err := parseCpuTimes(strings.NewReader("procs_running 3\n"))
+	h.Assert(t, err != nil, "Failed to return error when there is no cpu line")
+}
+
+func TestParseProcessesRunningSuccess(t *testing.T) {
+	running, err := parseProcessesRunning(strings.NewReader(procStat))
+	h.Ok(t, err)
+	h.Equals(t, 3.0, running)
+}
+
+func TestMemoryMetricsSuccess(t *testing.T) {
+	values := memoryMetrics(map[string]float64{"MemTotal": 1000, "MemAvailable": 250, "SwapTotal": 200, "SwapFree": 150})
+	h.Equals(t, map[string]float64{metrics.MemUsedPercent: 75, metrics.SwapUsedPercent: 25}, values)
+}
+
+func TestMemoryMetricsWithoutSwapSuccess(t *testing.T) {
+	values := memoryMetrics(map[string]float64{"MemTotal": 1000, "MemAvailable": 500})
+	h.Equals(t, map[string]float64{metrics.MemUsedPercent: 50, metrics.SwapUsedPercent: 0}, values)
+}
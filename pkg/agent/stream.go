@@ -0,0 +1,135 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+// LogStreamer streams the log of the instance to the bucket while the agent runs: each flush uploads what was appended
+// to the log since the previous flush as a chunk of its own, then the heartbeat of the agent.
+type LogStreamer struct {
+	store        resources.ResultStore
+	agentFixture AgentFixture
+	start        time.Time
+
+	mutex   sync.Mutex
+	offset  int64
+	chunks  int
+	running map[string]time.Time
+}
+
+// NewLogStreamer returns the log streamer of the agent, which starts now.
+func NewLogStreamer(store resources.ResultStore, agentFixture AgentFixture) *LogStreamer {
+	return &LogStreamer{store: store, agentFixture: agentFixture, start: time.Now(), running: make(map[string]time.Time)}
+}
+
+// StartTest records that the test file is running, until FinishTest.
+func (s *LogStreamer) StartTest(testFile string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.running[filepath.Base(testFile)] = time.Now()
+}
+
+// FinishTest records that the test file isn't running anymore.
+func (s *LogStreamer) FinishTest(testFile string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.running, filepath.Base(testFile))
+}
+
+// Stream flushes the log each period until done is closed. It runs apart from the tests, so the heartbeat goes on
+// while a test hangs: it tells the CLI that the agent is alive, not that its tests progress.
+func (s *LogStreamer) Stream(period time.Duration, done <-chan bool) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Flush uploads what was appended to the log since the previous flush, if anything, then the heartbeat. A chunk which
+// fails to upload is uploaded again by the next flush.
+func (s *LogStreamer) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	chunk, err := s.readChunk()
+	if err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		if err := s.store.Put(s.agentFixture.BucketName, s.agentFixture.Layout.LogChunk(s.chunks), bytes.NewReader(chunk)); err != nil {
+			return err
+		}
+		s.offset += int64(len(chunk))
+		s.chunks++
+	}
+
+	heartbeat, err := json.Marshal(s.heartbeat(time.Now()))
+	if err != nil {
+		return err
+	}
+	return s.store.Put(s.agentFixture.BucketName, s.agentFixture.Layout.Heartbeat(), bytes.NewReader(heartbeat))
+}
+
+// readChunk returns what was appended to the log since the previous chunk.
+func (s *LogStreamer) readChunk() ([]byte, error) {
+	file, err := os.Open(s.agentFixture.LogFilename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(s.offset, 0); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(file)
+}
+
+// heartbeat returns the heartbeat of the agent at the time, with the running test files in the order of their name.
+func (s *LogStreamer) heartbeat(now time.Time) resources.Heartbeat {
+	heartbeat := resources.Heartbeat{
+		UpdatedAt:   now.UTC().Format(time.RFC3339),
+		ElapsedTime: fmt.Sprintf("%.3f", now.Sub(s.start).Seconds()),
+		LogChunks:   s.chunks,
+	}
+	for label, start := range s.running {
+		heartbeat.RunningTests = append(heartbeat.RunningTests, resources.RunningTest{Label: label, ElapsedTime: fmt.Sprintf("%.3f", now.Sub(start).Seconds())})
+	}
+	sort.Slice(heartbeat.RunningTests, func(i, j int) bool {
+		return heartbeat.RunningTests[i].Label < heartbeat.RunningTests[j].Label
+	})
+	return heartbeat
+}
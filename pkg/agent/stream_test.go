@@ -0,0 +1,71 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package agent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestLogStreamerFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-streamer")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	store := resources.LocalStore{Dir: filepath.Join(dir, "buckets")}
+	h.Ok(t, store.CreateBucket("qualifier-bucket"))
+	layout := resources.BucketLayout{RootDir: "Instance-Qualifier-Run-123"}.Instance("m4.large", "i-123")
+	agentFixture := AgentFixture{BucketName: "qualifier-bucket", Layout: layout, LogFilename: filepath.Join(dir, "m4.large.log")}
+	s := NewLogStreamer(store, agentFixture)
+
+	// Nothing is logged yet
+	h.Ok(t, s.Flush())
+	exists, err := store.Exists("qualifier-bucket", layout.LogChunk(0))
+	h.Ok(t, err)
+	h.Assert(t, !exists, "Uploaded a chunk while nothing was logged")
+
+	logFile, err := os.Create(agentFixture.LogFilename)
+	h.Ok(t, err)
+	defer logFile.Close()
+	_, err = logFile.WriteString("first line\n")
+	h.Ok(t, err)
+	s.StartTest(dir + "/cpu-test.sh")
+	h.Ok(t, s.Flush())
+	_, err = logFile.WriteString("second line\n")
+	h.Ok(t, err)
+	h.Ok(t, s.Flush())
+
+	for i, expected := range []string{"first line\n", "second line\n"} {
+		chunk, err := store.Get("qualifier-bucket", layout.LogChunk(i))
+		h.Ok(t, err)
+		h.Equals(t, expected, string(chunk))
+	}
+	data, err := store.Get("qualifier-bucket", layout.Heartbeat())
+	h.Ok(t, err)
+	var heartbeat resources.Heartbeat
+	h.Ok(t, json.Unmarshal(data, &heartbeat))
+	h.Equals(t, 2, heartbeat.LogChunks)
+	h.Equals(t, 1, len(heartbeat.RunningTests))
+	h.Equals(t, "cpu-test.sh", heartbeat.RunningTests[0].Label)
+
+	s.FinishTest(dir + "/cpu-test.sh")
+	h.Equals(t, 0, len(s.heartbeat(s.start).RunningTests))
+}
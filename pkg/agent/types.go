@@ -13,12 +13,18 @@
 
 package agent
 
+import "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+
 // AgentFixture contains constant information for the agent in the entire run.
 type AgentFixture struct {
 	BucketName             string
 	Timeout                int
-	BucketDir              string
+	Layout                 resources.InstanceLayout
 	ScriptPath             string
 	InstanceResultFilename string
 	LogFilename            string
+	// IsLocal is true if the agent runs as a child process of the CLI instead of on an instance.
+	IsLocal bool
+	// LogStreamer streams the log to the bucket while the agent runs, if set. It is flushed one last time by Fatal.
+	LogStreamer *LogStreamer
 }
@@ -43,6 +43,8 @@ const (
 
 var charset = []rune(charsetString)
 
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
 // GetRandomString returns a string of length 15 consisting of lower-case characters and numbers.
 func GetRandomString() string {
 	res := make([]rune, randStringLen)
@@ -68,6 +70,48 @@ func Compress(filename string, compressedName string) error {
 	return nil
 }
 
+// Decompress extracts a gzipped archive (.tar.gz) created by Compress into a folder.
+func Decompress(compressedName string, dest string) error {
+	file, err := os.Open(compressedName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dest, header.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("%s is outside of %s", header.Name, dest)
+		}
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractFile(tarReader, path, os.FileMode(header.Mode)); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("%s successfully decompressed\n", filepath.Base(compressedName))
+
+	return nil
+}
+
 // BoolPrompt generates a bool prompt in the output stream. It will only return the answer when getting
 // "y" or "N", otherwise each time an invalid input is given, the prompt will be outputted again.
 func BoolPrompt(prompt string, inputStream *os.File, outputStream *os.File) (bool, error) {
@@ -125,6 +169,46 @@ func RenderTable(data [][]string, header []string, outputStream *os.File) {
 	table.Render()
 }
 
+// Sparkline renders the values as a sparkline scaled between their min and max. If there are more values than
+// maxWidth, consecutive values are merged into their max so that peaks remain visible.
+func Sparkline(values []float64, maxWidth int) string {
+	if len(values) == 0 || maxWidth <= 0 {
+		return ""
+	}
+	if len(values) > maxWidth {
+		merged := make([]float64, maxWidth)
+		for i := range merged {
+			bucket := values[i*len(values)/maxWidth : (i+1)*len(values)/maxWidth]
+			merged[i] = bucket[0]
+			for _, value := range bucket[1:] {
+				if value > merged[i] {
+					merged[i] = value
+				}
+			}
+		}
+		values = merged
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+	var sparkline strings.Builder
+	for _, value := range values {
+		tick := 0
+		if max > min {
+			tick = int((value - min) / (max - min) * float64(len(sparkTicks)-1))
+		}
+		sparkline.WriteRune(sparkTicks[tick])
+	}
+	return sparkline.String()
+}
+
 // MarshalToFile marshals an object to a json string and writes it to a file.
 func MarshalToFile(v interface{}, filename string) error {
 	jsonData, err := json.MarshalIndent(v, "", "    ")
@@ -227,3 +311,18 @@ func gzipFile(src string, dest string) error {
 
 	return nil
 }
+
+// extractFile writes the current file of the archive to path.
+func extractFile(reader io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	writer, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
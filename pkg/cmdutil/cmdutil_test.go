@@ -98,6 +98,34 @@ func TestCompressNonExistentDestFilePathFailure(t *testing.T) {
 	h.Assert(t, err != nil, "Failed to return error when dest file path doesn't exist")
 }
 
+func TestDecompressSuccess(t *testing.T) {
+	compressedFolder := filepath.Base(compressTestFolder) + ".tar.gz"
+	defer os.Remove(compressedFolder)
+	h.Ok(t, cmdutil.Compress(compressTestFolder, compressedFolder))
+	dest, err := ioutil.TempDir("", "decompress")
+	h.Ok(t, err)
+	defer os.RemoveAll(dest)
+
+	h.Ok(t, cmdutil.Decompress(compressedFolder, dest))
+	expected := []string{"Folder", "Folder/0", "Folder/0/0", "Folder/0/1", "Folder/1", "Folder/2"}
+	actual := make([]string, 0)
+	err = filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dest {
+			return err
+		}
+		relativePath, err := filepath.Rel(dest, path)
+		actual = append(actual, relativePath)
+		return err
+	})
+	h.Ok(t, err)
+	h.Equals(t, expected, actual)
+}
+
+func TestDecompressNonExistentFileFailure(t *testing.T) {
+	err := cmdutil.Decompress("non-existent-file.tar.gz", "dest")
+	h.Assert(t, err != nil, "Failed to return error when the archive doesn't exist")
+}
+
 func TestBoolPromptSuccess(t *testing.T) {
 	// Prepare input
 	inputStream, err := prepareInput("invalid_answer\ny\n")
@@ -161,3 +189,10 @@ func TestOptionPromptEOFFailure(t *testing.T) {
 	_, err := cmdutil.OptionPrompt("PROMPT", 3, inputStream, outputStream)
 	h.Assert(t, err != nil, "Failed to return error when there is no input")
 }
+
+func TestSparkline(t *testing.T) {
+	h.Equals(t, "▁▂▃▄▅▆▇█", cmdutil.Sparkline([]float64{0, 1, 2, 3, 4, 5, 6, 7}, 60))
+	h.Equals(t, "▁▁▁", cmdutil.Sparkline([]float64{5, 5, 5}, 60))
+	h.Equals(t, "▁█", cmdutil.Sparkline([]float64{0, 1, 0, 100}, 2))
+	h.Equals(t, "", cmdutil.Sparkline(nil, 60))
+}
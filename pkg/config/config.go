@@ -20,10 +20,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
 	homedir "github.com/mitchellh/go-homedir"
 	"gopkg.in/ini.v1"
 )
@@ -37,12 +43,77 @@ const (
 	cfnTemplateFilePrefix = "qualifier-cfn-template-"
 	binName               = "ec2-instance-qualifier"
 	defaultTimeout        = 3600
+	defaultReplicas       = 1
+	defaultPassRate       = 1.0
+	defaultCleanupAge     = 24 * time.Hour
 	defaultProfile        = "default"
 	awsConfigFile         = "~/.aws/config"
 	awsRegionEnvVar       = "AWS_REGION"
 	defaultRegionEnvVar   = "AWS_DEFAULT_REGION"
 )
 
+// Subcommands of the CLI, given as its first argument.
+const (
+	ListRunsCommand = "list-runs"
+	CleanupCommand  = "cleanup"
+	LogsCommand     = "logs"
+)
+
+// Streams of the output of a test file, each captured by the agent to a file of its own.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Formats in which the final report can be output.
+const (
+	OutputTable    = "table"
+	OutputJson     = "json"
+	OutputCsv      = "csv"
+	OutputMarkdown = "markdown"
+	OutputJunitXml = "junit-xml"
+)
+
+// Exit codes of the CLI. Unless a requirement is provided, a run which gets its results exits with the code of the
+// worst outcome among its instance types.
+const (
+	ExitCodeAllPassed         = 0
+	ExitCodeError             = 1
+	ExitCodeThresholdsFailed  = 2
+	ExitCodeDecisionRequired  = 3
+	ExitCodeTestsFailed       = 4
+	ExitCodeTimeout           = 5
+	ExitCodeNoResults         = 6
+	ExitCodeRequirementNotMet = 7
+	ExitCodeSetupFailed       = 8
+)
+
+// Backends on which the test suite is executed. The local backend runs the agent of each instance type as a child
+// process of the CLI, which is only meant to iterate on a test suite since all of them share the local machine.
+const (
+	BackendEc2   = "ec2"
+	BackendLocal = "local"
+)
+
+// LocalDir is the directory, relative to the working directory, where the local backend keeps its buckets, agents
+// and metrics.
+const LocalDir = "instance-qualifier-local"
+
+// The only platform the local backend runs on, which is the one the agent is built for.
+const (
+	localHostOs   = "linux"
+	localHostArch = "amd64"
+)
+
+// Purchase options of the instances launched for a run.
+const (
+	PurchaseOptionOnDemand = "on-demand"
+	PurchaseOptionSpot     = "spot"
+)
+
+// OutputFormats are the supported formats of the final report.
+var OutputFormats = []string{OutputTable, OutputJson, OutputCsv, OutputMarkdown, OutputJunitXml}
+
 // PopulateTestFixture populates the test fixture which contains constant information for the entire run.
 func PopulateTestFixture(userConfig UserConfig, runId string, amiId ...string) (err error) {
 	testFixture.RunId = runId
@@ -60,6 +131,18 @@ func PopulateTestFixture(userConfig UserConfig, runId string, amiId ...string) (
 	testFixture.CpuThreshold = userConfig.CpuThreshold
 	testFixture.MemThreshold = userConfig.MemThreshold
 	testFixture.Timeout = userConfig.Timeout
+	testFixture.ThresholdRules = userConfig.ThresholdRules
+	testFixture.Metrics = splitMetrics(userConfig.Metrics)
+	testFixture.MetricThresholds = userConfig.MetricThresholds
+	testFixture.Statistic = userConfig.Statistic
+	testFixture.MetricStatistics = userConfig.MetricStatistics
+	testFixture.PurchaseOption = userConfig.PurchaseOption
+	testFixture.Replicas = userConfig.Replicas
+	testFixture.PassRate = userConfig.PassRate
+	testFixture.Backend = userConfig.Backend
+	testFixture.StoreEndpoint = userConfig.StoreEndpoint
+	testFixture.Region = userConfig.Region
+	testFixture.Concurrency = userConfig.Concurrency
 	testFixture.StartTime = time.Now().Format(time.RFC3339)
 
 	return nil
@@ -84,6 +167,17 @@ func GetUserConfig() UserConfig {
 	return userConfig
 }
 
+// SetInstanceTypes sets instanceTypes of userConfig, which is how the instance types resolved from the filters are
+// persisted with the run.
+func SetInstanceTypes(instanceTypes string) {
+	userConfig.InstanceTypes = instanceTypes
+}
+
+// SetTestFixture sets testFixture, which is how the regional runs of a multi-region run take turns.
+func SetTestFixture(tf TestFixture) {
+	testFixture = tf
+}
+
 // SetTestFixtureBucketName sets bucketName of testFixture.
 func SetTestFixtureBucketName(bucketName string) {
 	testFixture.BucketName = bucketName
@@ -99,21 +193,57 @@ run the input on all designated types, test against multiple metrics, and output
 in a user friendly format`, binName, binName)
 		examples := fmt.Sprintf(`./%s --instance-types=m4.large,c5.large,m4.xlarge --test-suite=path/to/test-folder --cpu-threshold=30 --mem-threshold=30 --vpc=vpc-294b9542 --subnet=subnet-4879bf23 --timeout=2400
 ./%s --instance-types=m4.xlarge,c1.large,c5.large --test-suite=path/to/test-folder --cpu-threshold=30 --mem-threshold=30 --profile=default
-./%s --bucket=qualifier-Bucket-123456789abcdef`, binName, binName, binName)
+./%s --bucket=qualifier-Bucket-123456789abcdef
+./%s --instance-types=m4.large --test-suite=path/to/test-folder --cpu-threshold=30 --mem-threshold=30 --backend=local
+./%s --instance-types=m5.large,c5.large --test-suite=path/to/test-folder --cpu-threshold=30 --mem-threshold=30 --regions=us-east-1,eu-west-1
+./%s %s --region=us-east-2
+./%s %s --region=us-east-2 --older-than=48h --archive-bucket=my-results-bucket
+./%s %s --bucket=qualifier-Bucket-123456789abcdef --instance-type=m4.large --test=cpu-test.sh --stream=stderr`, binName, binName, binName, binName, binName, binName, ListRunsCommand, binName, CleanupCommand, binName, LogsCommand)
 		fmt.Fprintf(outputStream,
 			longUsage+"\n\n"+
 				"Usage:\n"+
-				"  "+binName+" [flags]\n\n"+
+				"  "+binName+" [flags]\n"+
+				"  "+binName+" "+ListRunsCommand+" [--region] [--profile] [--output]\n"+
+				"  "+binName+" "+CleanupCommand+" [--region] [--profile] [--output] [--older-than] [--dry-run] [--archive-bucket]\n"+
+				"  "+binName+" "+LogsCommand+" --bucket --instance-type --test [--stream] [--instance-id] [--region] [--profile] [--backend] [--store-endpoint]\n\n"+
 				"Examples:\n"+examples+"\n\n"+
 				"Flags:\n",
 		)
 		flag.PrintDefaults()
 	}
 
-	flag.StringVar(&userConfig.InstanceTypes, "instance-types", "", "[REQUIRED] comma-separated list of instance-types to test")
+	flag.StringVar(&userConfig.InstanceTypes, "instance-types", "", "[REQUIRED] comma-separated list of instance-types to test. Not required with instance type filters, which otherwise narrow down the list")
 	flag.StringVar(&userConfig.TestSuiteName, "test-suite", "", "[REQUIRED] folder containing test files to execute")
 	flag.IntVar(&userConfig.CpuThreshold, "cpu-threshold", 0, "[REQUIRED] % cpu utilization that should not be exceeded measured by cpu_usage_active. ex: 30 means instances using 30% or less CPU SUCCEED")
 	flag.IntVar(&userConfig.MemThreshold, "mem-threshold", 0, "[REQUIRED] % of memory used that should not be exceeded measured by mem_used_percent. ex: 30 means instances using 30% or less MEM SUCCEED")
+	flag.StringVar(&userConfig.Metrics, "metrics", "", fmt.Sprintf("[OPTIONAL] comma-separated list of metrics to benchmark. Thresholds of metrics other than cpu_usage_active and mem_used_percent are set in the config file. Default is %s. Supported metrics are %s", strings.Join(metrics.DefaultMetrics, ","), strings.Join(metrics.Names(), ",")))
+	flag.StringVar(&userConfig.Statistic, "statistic", "", fmt.Sprintf("[OPTIONAL] statistic of each metric over the execution window of a test file that is compared against the thresholds. Statistics of individual metrics are set in the config file. Default is %s. Supported statistics are %s", metrics.DefaultStatistic, strings.Join(metrics.Statistics, ",")))
+	flag.StringVar(&userConfig.Output, "output", OutputTable, fmt.Sprintf("[OPTIONAL] format of the final report. Supported formats are %s. With any format other than %s, all other output is written to stderr", strings.Join(OutputFormats, ","), OutputTable))
+	flag.StringVar(&userConfig.PurchaseOption, "purchase-option", PurchaseOptionOnDemand, fmt.Sprintf("[OPTIONAL] purchase option of the instances, either %s or %s. Instance types whose spot instances are interrupted are reported as INTERRUPTED", PurchaseOptionOnDemand, PurchaseOptionSpot))
+	flag.IntVar(&userConfig.Replicas, "replicas", defaultReplicas, "[OPTIONAL] number of instances launched per instance type, each running the whole test suite. With more than one replica, the results of each instance type are aggregated across its replicas")
+	flag.Float64Var(&userConfig.PassRate, "pass-rate", defaultPassRate, "[OPTIONAL] fraction of the replicas of an instance type that must stay within the thresholds for it to SUCCEED. Interrupted replicas are not counted, and replicas without results count as failed")
+	flag.StringVar(&userConfig.PriceFile, "price-file", "", "[OPTIONAL] path to a JSON file mapping instance types to their hourly on-demand and spot prices in USD, or a cached Pricing API export. When provided, the cost of each instance type is reported and the cheapest passing instance type is recommended")
+	flag.StringVar(&userConfig.PriceType, "price-type", pricing.OnDemand, fmt.Sprintf("[OPTIONAL] price used to compute costs and rank instance types. Either %s or %s", pricing.OnDemand, pricing.Spot))
+	flag.IntVar(&userConfig.Concurrency, "concurrency", 0, "[OPTIONAL] max number of test files executed at once on each instance, overriding the concurrency of the manifest of the test suite. The test files are executed one at a time by default")
+	flag.BoolVar(&userConfig.Follow, "follow", false, "[OPTIONAL] set to true to stream the output of the tests while they run")
+	flag.BoolVar(&userConfig.NonInteractive, "non-interactive", false, fmt.Sprintf("[OPTIONAL] set to true to never prompt. Decisions without a policy fail the run with exit code %d", ExitCodeDecisionRequired))
+	flag.StringVar(&userConfig.OnInvalidAmi, decisionPolicies[DecisionInvalidAmi].flag, "", policyUsage(DecisionInvalidAmi, "the AMI doesn't exist"))
+	flag.StringVar(&userConfig.OnInvalidNetwork, decisionPolicies[DecisionInvalidNetwork].flag, "", policyUsage(DecisionInvalidNetwork, "the VPC or subnet doesn't exist"))
+	flag.StringVar(&userConfig.OnUnsupportedInstanceTypes, decisionPolicies[DecisionUnsupportedInstanceTypes].flag, "", policyUsage(DecisionUnsupportedInstanceTypes, "some instance types are not supported by the AMI or not offered in the region or subnet"))
+	flag.StringVar(&userConfig.Require, "require", "", fmt.Sprintf("[OPTIONAL] requirement on the instance types which pass that determines the exit status instead of the worst outcome, e.g. \"at least one of m5.large,c5.large passes\". The CLI exits with %d if it is met and %d otherwise", ExitCodeAllPassed, ExitCodeRequirementNotMet))
+	flag.StringVar(&userConfig.Backend, "backend", BackendEc2, fmt.Sprintf("[OPTIONAL] where the test suite is executed, either %s or %s. With %s, the agent of each instance type runs as a child process on this machine, metrics are sampled from /proc and buckets are directories of ./%s; no AWS resource is created", BackendEc2, BackendLocal, BackendLocal, LocalDir))
+	flag.StringVar(&userConfig.StoreEndpoint, "store-endpoint", "", "[OPTIONAL] endpoint of an S3-compatible service storing the buckets instead of S3 (or the directories of the local backend), e.g. http://localhost:9000. It must be reachable from the instances, and provided again to resume the run")
+	flag.IntVar(&userConfig.VCpusMin, "vcpus-min", 0, "[OPTIONAL] instance type filter: min number of vCPUs")
+	flag.IntVar(&userConfig.VCpusMax, "vcpus-max", 0, "[OPTIONAL] instance type filter: max number of vCPUs")
+	flag.Float64Var(&userConfig.MemoryMin, "memory-min", 0, "[OPTIONAL] instance type filter: min memory in GiB")
+	flag.Float64Var(&userConfig.MemoryMax, "memory-max", 0, "[OPTIONAL] instance type filter: max memory in GiB")
+	flag.StringVar(&userConfig.Architecture, "architecture", "", "[OPTIONAL] instance type filter: processor architecture, e.g. x86_64 or arm64")
+	flag.StringVar(&userConfig.Families, "families", "", "[OPTIONAL] instance type filter: comma-separated list of shell patterns of the instance families to include, e.g. m5*,c5")
+	flag.StringVar(&userConfig.ExcludeFamilies, "exclude-families", "", "[OPTIONAL] instance type filter: comma-separated list of shell patterns of the instance families to exclude, e.g. t2,*n")
+	flag.StringVar(&userConfig.Burstable, "burstable", "", fmt.Sprintf("[OPTIONAL] instance type filter: %s to only include burstable instance types, %s to exclude them", burstableOnly, burstableExclude))
+	flag.StringVar(&userConfig.NetworkPerformance, "network-performance", "", "[OPTIONAL] instance type filter: comma-separated list of network performances as described by EC2, e.g. \"Up to 10 Gigabit,10 Gigabit\"")
+	flag.BoolVar(&userConfig.CurrentGeneration, "current-generation", false, "[OPTIONAL] instance type filter: set to true to only include current-generation instance types")
+	flag.Float64Var(&userConfig.MaxPrice, "max-price", 0, "[OPTIONAL] instance type filter: max hourly price in USD of the price type, which requires a price file. Instance types without a price are excluded")
 	flag.StringVar(&userConfig.ConfigFilePath, "config-file", "", "[OPTIONAL] path to config file for cli input parameters in JSON")
 	flag.StringVar(&userConfig.CustomScriptPath, "custom-script", "", "[OPTIONAL] path to Bash script to be executed on instance-types BEFORE agent runs test-suite and monitoring")
 	flag.StringVar(&userConfig.VpcId, "vpc", "", "[OPTIONAL] vpc id")
@@ -123,7 +253,8 @@ in a user friendly format`, binName, binName)
 	flag.BoolVar(&userConfig.Persist, "persist", false, "[OPTIONAL] set to true if you'd like the tool to keep the CloudFormation stack after the run. Default is deleting the stack")
 	flag.StringVar(&userConfig.Profile, "profile", "", "[OPTIONAL] AWS CLI Profile to use for credentials and config")
 	flag.StringVar(&userConfig.Region, "region", "", "[OPTIONAL] AWS Region to use for API requests")
-	flag.StringVar(&userConfig.Bucket, "bucket", "", "[OPTIONAL] the name of the Bucket created in the last run. When provided with this flag, the CLI won't create new resources, but try to grab test results from the Bucket. If you provide this flag, you don't need to specify any required flags")
+	flag.StringVar(&userConfig.Regions, "regions", "", "[OPTIONAL] comma-separated list of AWS Regions to run in instead of --region, e.g. us-east-1,eu-west-1. Each region gets its own bucket and stack, and the results are merged into a single report with a region column")
+	flag.StringVar(&userConfig.Bucket, "bucket", "", "[OPTIONAL] the name of the Bucket created in the last run. When provided with this flag, the CLI won't create new resources, but try to grab test results from the Bucket. If you provide this flag, you don't need to specify any required flags. A multi-region run is resumed with the comma-separated list of its buckets, in the order of --regions")
 
 	// Apply config with precedence: cli args, env vars, config file
 	flag.Parse()
@@ -138,16 +269,25 @@ in a user friendly format`, binName, binName)
 		}
 	}
 
-	if userConfig.Region == "" {
-		errorMsg := "Failed to determine region from the following sources: \n"
-		errorMsg = errorMsg + "\t - --region flag\n"
-		if userConfig.Profile != "" {
-			errorMsg = errorMsg + fmt.Sprintf("\t - profile region in %s\n", awsConfigFile)
+	if userConfig.Backend != BackendEc2 && userConfig.Backend != BackendLocal {
+		return userConfig, fmt.Errorf("backend must be either %s or %s", BackendEc2, BackendLocal)
+	}
+	if userConfig.Backend == BackendLocal {
+		if err := validateLocalHost(runtime.GOOS, runtime.GOARCH); err != nil {
+			return userConfig, err
 		}
-		errorMsg = errorMsg + fmt.Sprintf("\t - %s environment variable\n", awsRegionEnvVar)
-		errorMsg = errorMsg + fmt.Sprintf("\t - default profile region in %s\n", awsConfigFile)
-		errorMsg = errorMsg + fmt.Sprintf("\t - %s environment variable\n", defaultRegionEnvVar)
-		return userConfig, fmt.Errorf(errorMsg)
+	}
+	if userConfig.StoreEndpoint != "" {
+		if endpoint, err := url.Parse(userConfig.StoreEndpoint); err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return userConfig, fmt.Errorf("store endpoint must be a URL such as http://localhost:9000: %s", userConfig.StoreEndpoint)
+		}
+	}
+	if err := validateRegions(userConfig); err != nil {
+		return userConfig, err
+	}
+	// Local runs don't call any AWS API
+	if userConfig.Region == "" && userConfig.Regions == "" && userConfig.Backend != BackendLocal {
+		return userConfig, regionError()
 	}
 
 	// preserve this data if config file defines "config-file" as nil
@@ -157,26 +297,153 @@ in a user friendly format`, binName, binName)
 
 	// Validation
 	if userConfig.Bucket == "" {
-		if userConfig.InstanceTypes == "" {
-			return userConfig, errors.New("you must provide a comma-separated list of instance-types")
+		if userConfig.InstanceTypes == "" && userConfig.InstanceTypeFilters.IsEmpty() {
+			return userConfig, errors.New("you must provide a comma-separated list of instance-types or instance type filters")
 		}
-		if userConfig.CpuThreshold <= 0 {
-			return userConfig, errors.New("you must provide a cpu-threshold greater than 0")
+		if err := validateInstanceTypeFilters(userConfig); err != nil {
+			return userConfig, err
 		}
-		if userConfig.MemThreshold <= 0 {
-			return userConfig, errors.New("you must provide a mem-threshold greater than 0")
+		if err := validateMetrics(userConfig); err != nil {
+			return userConfig, err
 		}
 		if userConfig.TestSuiteName == "" {
 			return userConfig, errors.New("you must provide a folder containing test files to execute")
 		}
+		if err := validateThresholdRules(userConfig.ThresholdRules); err != nil {
+			return userConfig, err
+		}
+		if userConfig.PurchaseOption != PurchaseOptionOnDemand && userConfig.PurchaseOption != PurchaseOptionSpot {
+			return userConfig, fmt.Errorf("purchase option must be either %s or %s", PurchaseOptionOnDemand, PurchaseOptionSpot)
+		}
+		if userConfig.Replicas < 1 {
+			return userConfig, errors.New("you must provide a number of replicas greater than 0")
+		}
+		if userConfig.PassRate <= 0 || userConfig.PassRate > 1 {
+			return userConfig, errors.New("you must provide a pass rate greater than 0 and at most 1")
+		}
+		if userConfig.Concurrency < 0 {
+			return userConfig, errors.New("you must provide a concurrency of at least 0")
+		}
+		if err := validatePolicies(userConfig); err != nil {
+			return userConfig, err
+		}
 	}
 	if userConfig.Timeout <= 0 {
 		return userConfig, errors.New("you must provide a timeout greater than 0")
 	}
+	if err := validateOutput(userConfig.Output); err != nil {
+		return userConfig, err
+	}
+	if userConfig.Require != "" {
+		if _, err := ParseRequirement(userConfig.Require); err != nil {
+			return userConfig, err
+		}
+	}
+	if err := validatePricing(userConfig); err != nil {
+		return userConfig, err
+	}
 	log.Printf("Starting Instance-Qualifier with User Config: %s\n", userConfig.String())
 	return userConfig, nil
 }
 
+// ParseSubcommandArgs parses the arguments of a subcommand, which only take the AWS Profile and Region to use, and the
+// output format.
+func ParseSubcommandArgs(command string, args []string, outputStream *os.File) (UserConfig, error) {
+	flagSet := newSubcommandFlagSet(command, outputStream)
+	if err := flagSet.Parse(args); err != nil {
+		return userConfig, err
+	}
+	return userConfig, validateSubcommandArgs(command)
+}
+
+// ParseCleanupArgs parses the arguments of the cleanup subcommand, which take the options of the cleanup on top of
+// the arguments of any subcommand.
+func ParseCleanupArgs(args []string, outputStream *os.File) (UserConfig, CleanupConfig, error) {
+	var cleanupConfig CleanupConfig
+	flagSet := newSubcommandFlagSet(CleanupCommand, outputStream)
+	flagSet.DurationVar(&cleanupConfig.OlderThan, "older-than", defaultCleanupAge, "[OPTIONAL] minimum age of the resources to delete, e.g. 12h. Resources of runs whose stack failed are deleted regardless of their age. 0 deletes every resource, including those of in-flight runs")
+	flagSet.BoolVar(&cleanupConfig.DryRun, "dry-run", false, "[OPTIONAL] set to true to only output the resources that would be deleted")
+	flagSet.StringVar(&cleanupConfig.ArchiveBucket, "archive-bucket", "", "[OPTIONAL] name of a bucket to which the final results of each run are copied before its bucket is deleted")
+	flagSet.BoolVar(&userConfig.NonInteractive, "non-interactive", false, fmt.Sprintf("[OPTIONAL] set to true to never prompt. Without a policy, the deletion fails with exit code %d", ExitCodeDecisionRequired))
+	flagSet.StringVar(&userConfig.OnOrphanedResources, decisionPolicies[DecisionDeleteOrphanedResources].flag, "", policyUsage(DecisionDeleteOrphanedResources, "resources are to be deleted"))
+	if err := flagSet.Parse(args); err != nil {
+		return userConfig, cleanupConfig, err
+	}
+
+	if err := validateSubcommandArgs(CleanupCommand); err != nil {
+		return userConfig, cleanupConfig, err
+	}
+	if cleanupConfig.OlderThan < 0 {
+		return userConfig, cleanupConfig, errors.New("you must provide a minimum age of at least 0")
+	}
+	if err := validatePolicy(DecisionDeleteOrphanedResources, userConfig); err != nil {
+		return userConfig, cleanupConfig, err
+	}
+	return userConfig, cleanupConfig, nil
+}
+
+// ParseLogsArgs parses the arguments of the logs subcommand, which prints the output of a test file of a run from its
+// bucket. Unlike other subcommands, it has no output format since it prints the output as is.
+func ParseLogsArgs(args []string, outputStream *os.File) (UserConfig, LogsConfig, error) {
+	var logsConfig LogsConfig
+	flagSet := flag.NewFlagSet(binName+" "+LogsCommand, flag.ContinueOnError)
+	flagSet.SetOutput(outputStream)
+	flagSet.StringVar(&userConfig.Profile, "profile", "", "[OPTIONAL] AWS CLI Profile to use for credentials and config")
+	flagSet.StringVar(&userConfig.Region, "region", "", "[OPTIONAL] AWS Region to use for API requests")
+	flagSet.StringVar(&userConfig.Bucket, "bucket", "", "[REQUIRED] the name of the bucket of the run")
+	flagSet.StringVar(&userConfig.Backend, "backend", BackendEc2, fmt.Sprintf("[OPTIONAL] backend of the run, either %s or %s", BackendEc2, BackendLocal))
+	flagSet.StringVar(&userConfig.StoreEndpoint, "store-endpoint", "", "[OPTIONAL] endpoint of the S3-compatible service storing the bucket of the run, if any")
+	flagSet.StringVar(&logsConfig.InstanceType, "instance-type", "", "[REQUIRED] instance type whose output is printed")
+	flagSet.StringVar(&logsConfig.TestFile, "test", "", "[REQUIRED] name of the test file whose output is printed")
+	flagSet.StringVar(&logsConfig.Stream, "stream", StreamStdout, fmt.Sprintf("[OPTIONAL] output stream of the test file, either %s or %s", StreamStdout, StreamStderr))
+	flagSet.StringVar(&logsConfig.InstanceId, "instance-id", "", "[OPTIONAL] ID of the instance whose output is printed. The output of every replica of the instance type is printed if it is empty")
+	if err := flagSet.Parse(args); err != nil {
+		return userConfig, logsConfig, err
+	}
+
+	if userConfig.Bucket == "" || logsConfig.InstanceType == "" || logsConfig.TestFile == "" {
+		return userConfig, logsConfig, errors.New("you must provide the bucket, instance type and test file whose output is printed")
+	}
+	if logsConfig.Stream != StreamStdout && logsConfig.Stream != StreamStderr {
+		return userConfig, logsConfig, fmt.Errorf("stream must be either %s or %s", StreamStdout, StreamStderr)
+	}
+	if userConfig.Backend != BackendEc2 && userConfig.Backend != BackendLocal {
+		return userConfig, logsConfig, fmt.Errorf("backend must be either %s or %s", BackendEc2, BackendLocal)
+	}
+	if userConfig.Backend == BackendLocal {
+		if err := validateLocalHost(runtime.GOOS, runtime.GOARCH); err != nil {
+			return userConfig, logsConfig, err
+		}
+	}
+	setUserConfigRegion()
+	if userConfig.Region == "" && userConfig.Backend != BackendLocal {
+		return userConfig, logsConfig, regionError()
+	}
+	return userConfig, logsConfig, nil
+}
+
+// newSubcommandFlagSet returns the flag set of a subcommand, with the flags shared by all subcommands.
+func newSubcommandFlagSet(command string, outputStream *os.File) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(binName+" "+command, flag.ContinueOnError)
+	flagSet.SetOutput(outputStream)
+	flagSet.StringVar(&userConfig.Profile, "profile", "", "[OPTIONAL] AWS CLI Profile to use for credentials and config")
+	flagSet.StringVar(&userConfig.Region, "region", "", "[OPTIONAL] AWS Region to use for API requests")
+	flagSet.StringVar(&userConfig.Output, "output", OutputTable, fmt.Sprintf("[OPTIONAL] output format. Supported formats are %s,%s,%s", OutputTable, OutputJson, OutputCsv))
+	return flagSet
+}
+
+// validateSubcommandArgs determines the region and checks the output format of a subcommand.
+func validateSubcommandArgs(command string) error {
+	setUserConfigRegion()
+	if userConfig.Region == "" {
+		return regionError()
+	}
+	if userConfig.Output != OutputTable && userConfig.Output != OutputJson && userConfig.Output != OutputCsv {
+		return fmt.Errorf("output format %s is not supported by %s; supported formats are %s,%s,%s", userConfig.Output, command, OutputTable, OutputJson, OutputCsv)
+	}
+	return nil
+}
+
 // WriteUserConfig writes user config to config file.
 func WriteUserConfig(filename string) error {
 	configJson, err := json.MarshalIndent(userConfig, "", "\t")
@@ -205,6 +472,194 @@ func ReadUserConfig(filename string) (UserConfig, error) {
 	return result, nil
 }
 
+// validateMetrics checks that all selected metrics are supported and have a threshold, and that all statistics are
+// supported.
+func validateMetrics(userConfig UserConfig) error {
+	definitions, err := metrics.Select(splitMetrics(userConfig.Metrics))
+	if err != nil {
+		return err
+	}
+	for _, definition := range definitions {
+		if userConfig.Backend == BackendLocal && !isLocalMetric(definition.Name) {
+			return fmt.Errorf("%s is not supported by the %s backend; supported metrics are %s", definition.Name, BackendLocal, strings.Join(metrics.LocalMetrics, ","))
+		}
+		switch definition.Name {
+		case metrics.CpuUsageActive:
+			if userConfig.CpuThreshold <= 0 {
+				return errors.New("you must provide a cpu-threshold greater than 0")
+			}
+		case metrics.MemUsedPercent:
+			if userConfig.MemThreshold <= 0 {
+				return errors.New("you must provide a mem-threshold greater than 0")
+			}
+		default:
+			if _, ok := userConfig.MetricThresholds[definition.Name]; !ok {
+				return fmt.Errorf("you must provide a threshold for %s in metric-thresholds", definition.Name)
+			}
+		}
+	}
+	for name, metricThreshold := range userConfig.MetricThresholds {
+		if _, ok := metrics.Get(name); !ok {
+			return fmt.Errorf("metric %s in metric-thresholds is not supported", name)
+		}
+		// A threshold of 0 is taken for a missing one when the thresholds are resolved
+		if metricThreshold.Threshold <= 0 {
+			return fmt.Errorf("you must provide a threshold greater than 0 for %s in metric-thresholds", name)
+		}
+		if metricThreshold.Direction != "" && metricThreshold.Direction != metrics.Below && metricThreshold.Direction != metrics.Above {
+			return fmt.Errorf("direction of %s must be either %s or %s", name, metrics.Below, metrics.Above)
+		}
+	}
+	if userConfig.Statistic != "" {
+		if err := metrics.ValidateStatistic(userConfig.Statistic); err != nil {
+			return err
+		}
+	}
+	for name, statistic := range userConfig.MetricStatistics {
+		if _, ok := metrics.Get(name); !ok {
+			return fmt.Errorf("metric %s in metric-statistics is not supported", name)
+		}
+		if err := metrics.ValidateStatistic(statistic); err != nil {
+			return fmt.Errorf("invalid statistic of %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// SplitRegions splits a comma-separated list of regions.
+func SplitRegions(regions string) (regionList []string) {
+	for _, region := range strings.Split(regions, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regionList = append(regionList, region)
+		}
+	}
+	return regionList
+}
+
+// validateRegions checks that the regions of a multi-region run are distinct, that none of the flags bound to a
+// single region is set, and that a resumed run provides one bucket per region.
+func validateRegions(userConfig UserConfig) error {
+	if userConfig.Regions == "" {
+		if strings.Contains(userConfig.Bucket, ",") {
+			return errors.New("you must provide the regions of the buckets of a multi-region run with --regions")
+		}
+		return nil
+	}
+	if userConfig.Backend == BackendLocal {
+		return errors.New("regions are not supported by the local backend, which runs on this machine")
+	}
+	if userConfig.VpcId != "" || userConfig.SubnetId != "" {
+		return errors.New("vpc and subnet cannot be provided with regions since they belong to a single region")
+	}
+	regionList := SplitRegions(userConfig.Regions)
+	if len(regionList) == 0 {
+		return fmt.Errorf("invalid regions: %s", userConfig.Regions)
+	}
+	seen := make(map[string]bool)
+	for _, region := range regionList {
+		if seen[region] {
+			return fmt.Errorf("region %s is provided more than once", region)
+		}
+		seen[region] = true
+	}
+	if userConfig.Bucket != "" && len(strings.Split(userConfig.Bucket, ",")) != len(regionList) {
+		return fmt.Errorf("you must provide one bucket per region, in the order of the regions: %s", userConfig.Regions)
+	}
+	return nil
+}
+
+// regionError returns the error listing the sources the region is determined from.
+func regionError() error {
+	errorMsg := "Failed to determine region from the following sources: \n"
+	errorMsg = errorMsg + "\t - --region flag\n"
+	if userConfig.Profile != "" {
+		errorMsg = errorMsg + fmt.Sprintf("\t - profile region in %s\n", awsConfigFile)
+	}
+	errorMsg = errorMsg + fmt.Sprintf("\t - %s environment variable\n", awsRegionEnvVar)
+	errorMsg = errorMsg + fmt.Sprintf("\t - default profile region in %s\n", awsConfigFile)
+	errorMsg = errorMsg + fmt.Sprintf("\t - %s environment variable\n", defaultRegionEnvVar)
+	return fmt.Errorf(errorMsg)
+}
+
+// validateOutput checks that the format of the final report is supported.
+func validateOutput(output string) error {
+	for _, format := range OutputFormats {
+		if output == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("output format %s is not supported; supported formats are %v", output, OutputFormats)
+}
+
+// validatePricing checks that the price file exists if provided and the price type is supported.
+func validatePricing(userConfig UserConfig) error {
+	if userConfig.PriceType != pricing.OnDemand && userConfig.PriceType != pricing.Spot {
+		return fmt.Errorf("price type must be either %s or %s", pricing.OnDemand, pricing.Spot)
+	}
+	if userConfig.PriceFile != "" {
+		if _, err := os.Stat(userConfig.PriceFile); err != nil {
+			return fmt.Errorf("cannot read price file: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateLocalHost checks that the local backend can run on the platform of the host: the agent it executes is built
+// for Linux/amd64, and samples its metrics from /proc.
+func validateLocalHost(goos string, goarch string) error {
+	if goos != localHostOs || goarch != localHostArch {
+		return fmt.Errorf("the %s backend is only supported on %s/%s, not on %s/%s", BackendLocal, localHostOs, localHostArch, goos, goarch)
+	}
+	return nil
+}
+
+// isLocalMetric checks whether the local backend samples the metric.
+func isLocalMetric(name string) bool {
+	for _, localMetric := range metrics.LocalMetrics {
+		if name == localMetric {
+			return true
+		}
+	}
+	return false
+}
+
+// splitMetrics splits the comma-separated list of metrics.
+func splitMetrics(metricList string) (metricNames []string) {
+	for _, name := range strings.Split(metricList, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			metricNames = append(metricNames, name)
+		}
+	}
+	return metricNames
+}
+
+// validateThresholdRules checks that every threshold rule overrides at least one threshold and only contains
+// well-formed patterns.
+func validateThresholdRules(rules []ThresholdRule) error {
+	for i, rule := range rules {
+		if rule.CpuThreshold < 0 || rule.MemThreshold < 0 {
+			return fmt.Errorf("threshold rule %d: thresholds must not be negative", i)
+		}
+		if rule.CpuThreshold == 0 && rule.MemThreshold == 0 && len(rule.Thresholds) == 0 {
+			return fmt.Errorf("threshold rule %d: you must provide a cpu-threshold, mem-threshold or thresholds", i)
+		}
+		for name, threshold := range rule.Thresholds {
+			if _, ok := metrics.Get(name); !ok {
+				return fmt.Errorf("threshold rule %d: metric %s is not supported", i, name)
+			}
+			if threshold <= 0 {
+				return fmt.Errorf("threshold rule %d: threshold of %s must be greater than 0", i, name)
+			}
+		}
+		for _, pattern := range append(strings.Split(rule.InstanceTypes, ","), strings.Split(rule.TestFiles, ",")...) {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return fmt.Errorf("threshold rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
 func getProfileRegion(profileName string) (string, error) {
 	if profileName != defaultProfile {
 		profileName = fmt.Sprintf("profile %s", profileName)
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
@@ -320,6 +321,449 @@ func TestParseCliArgsNonPositiveTimeoutFailure(t *testing.T) {
 	h.Assert(t, err != nil, "Failed to return error when non-positive Timeout provided")
 }
 
+func TestParseCliArgsInvalidThresholdRulesFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--config-file=" + configFilesPath + "/invalid-threshold-rules.config",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when a threshold rule doesn't override any threshold")
+}
+
+func TestParseCliArgsUnsupportedMetricFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--metrics=cpu_usage_active,load_average",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when an unsupported metric is selected")
+}
+
+func TestParseCliArgsUnsupportedOutputFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--output=yaml",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when an unsupported output format is selected")
+}
+
+func TestParseCliArgsUnsupportedPurchaseOptionFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--purchase-option=reserved",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when an unsupported purchase option is selected")
+}
+
+func TestParseCliArgsInvalidReplicasFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--replicas=0",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the number of replicas is not positive")
+}
+
+func TestParseCliArgsInvalidConcurrencyFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--concurrency=-1",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the concurrency is negative")
+}
+
+func TestParseCliArgsInvalidPassRateFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--replicas=3",
+		"--pass-rate=1.5",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the pass rate is greater than 1")
+}
+
+func TestParseCliArgsUnsupportedPolicyFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--non-interactive",
+		"--on-invalid-ami=create-vpc",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when an unsupported policy is selected")
+}
+
+func TestParseCliArgsInvalidRequirementFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--require=m5.large should pass",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the requirement is invalid")
+}
+
+func TestParseCliArgsLocalBackendWithoutRegionSuccess(t *testing.T) {
+	resetFlagsForTest()
+	userConfig = UserConfig{}
+	os.Unsetenv("AWS_REGION")
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--backend=local",
+	}
+	actualUserConfig, err := ParseCliArgs(outputStream)
+	h.Ok(t, err)
+	h.Equals(t, BackendLocal, actualUserConfig.Backend)
+}
+
+func TestParseCliArgsInvalidBackendFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--backend=lambda",
+		"--region=REGION",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the backend is invalid")
+}
+
+func TestValidateLocalHostSuccess(t *testing.T) {
+	h.Ok(t, validateLocalHost("linux", "amd64"))
+}
+
+func TestValidateLocalHostUnsupportedFailure(t *testing.T) {
+	h.Assert(t, validateLocalHost("darwin", "amd64") != nil, "Failed to return error when the OS of the host is unsupported")
+	h.Assert(t, validateLocalHost("linux", "arm64") != nil, "Failed to return error when the architecture of the host is unsupported")
+}
+
+func TestParseCliArgsLocalBackendUnsupportedMetricFailure(t *testing.T) {
+	resetFlagsForTest()
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--metrics=cpu_usage_active,disk_used_percent",
+		"--backend=local",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the local backend doesn't support a metric")
+}
+
+func TestParseCliArgsStoreEndpointSuccess(t *testing.T) {
+	resetFlagsForTest()
+	userConfig = UserConfig{}
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--backend=local",
+		"--store-endpoint=http://localhost:9000",
+	}
+	actualUserConfig, err := ParseCliArgs(outputStream)
+	h.Ok(t, err)
+	h.Equals(t, "http://localhost:9000", actualUserConfig.StoreEndpoint)
+}
+
+func TestParseCliArgsInvalidStoreEndpointFailure(t *testing.T) {
+	resetFlagsForTest()
+	userConfig = UserConfig{}
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--backend=local",
+		"--store-endpoint=localhost:9000",
+	}
+	_, err := ParseCliArgs(outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the store endpoint isn't a URL")
+}
+
+func TestParseCliArgsInstanceTypeFiltersSuccess(t *testing.T) {
+	resetFlagsForTest()
+	userConfig = UserConfig{}
+	os.Args = []string{
+		"cmd",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--vcpus-min=4",
+		"--vcpus-max=8",
+		"--memory-min=16",
+		"--memory-max=32",
+		"--architecture=x86_64",
+		"--exclude-families=t*",
+		"--current-generation=true",
+		"--region=REGION",
+	}
+	actualUserConfig, err := ParseCliArgs(outputStream)
+	h.Ok(t, err)
+	h.Equals(t, "", actualUserConfig.InstanceTypes)
+	expected := InstanceTypeFilters{VCpusMin: 4, VCpusMax: 8, MemoryMin: 16, MemoryMax: 32, Architecture: "x86_64", ExcludeFamilies: "t*", CurrentGeneration: true}
+	h.Equals(t, expected, actualUserConfig.InstanceTypeFilters)
+}
+
+func TestParseCliArgsInvalidInstanceTypeFiltersFailure(t *testing.T) {
+	for _, filterArgs := range [][]string{
+		{"--vcpus-min=8", "--vcpus-max=4"},
+		{"--memory-min=-1"},
+		{"--burstable=maybe"},
+		{"--families=[m5"},
+		{"--max-price=0.3"},
+		{"--vcpus-min=4", "--backend=local"},
+	} {
+		resetFlagsForTest()
+		userConfig = UserConfig{}
+		os.Args = append([]string{
+			"cmd",
+			"--test-suite=TEST_SUITE",
+			"--cpu-threshold=30",
+			"--mem-threshold=30",
+			"--region=REGION",
+		}, filterArgs...)
+		_, err := ParseCliArgs(outputStream)
+		h.Assert(t, err != nil, "Failed to return error when the instance type filters are %v", filterArgs)
+	}
+}
+
+func TestMatchesFamily(t *testing.T) {
+	filters := InstanceTypeFilters{Families: "m5*, c5", ExcludeFamilies: "m5d"}
+	h.Assert(t, filters.MatchesFamily("m5.large"), "Failed to include m5.large")
+	h.Assert(t, filters.MatchesFamily("m5a.large"), "Failed to include m5a.large")
+	h.Assert(t, filters.MatchesFamily("c5.large"), "Failed to include c5.large")
+	h.Assert(t, !filters.MatchesFamily("c5n.large"), "Failed to exclude c5n.large")
+	h.Assert(t, !filters.MatchesFamily("m5d.large"), "Failed to exclude m5d.large")
+}
+
+func TestParseCliArgsRegionsSuccess(t *testing.T) {
+	resetFlagsForTest()
+	userConfig = UserConfig{}
+	os.Args = []string{
+		"cmd",
+		"--instance-types=INSTANCE_TYPES",
+		"--test-suite=TEST_SUITE",
+		"--cpu-threshold=30",
+		"--mem-threshold=30",
+		"--regions=us-east-1, eu-west-1",
+	}
+	actualUserConfig, err := ParseCliArgs(outputStream)
+	h.Ok(t, err)
+	h.Equals(t, []string{"us-east-1", "eu-west-1"}, SplitRegions(actualUserConfig.Regions))
+}
+
+func TestParseCliArgsInvalidRegionsFailure(t *testing.T) {
+	for _, regionArgs := range [][]string{
+		{"--regions=us-east-1,us-east-1"},
+		{"--regions=,"},
+		{"--regions=us-east-1,eu-west-1", "--backend=local"},
+		{"--regions=us-east-1,eu-west-1", "--subnet=SUBNET"},
+		{"--regions=us-east-1,eu-west-1", "--bucket=BUCKET"},
+		{"--region=REGION", "--bucket=BUCKET1,BUCKET2"},
+	} {
+		resetFlagsForTest()
+		userConfig = UserConfig{}
+		os.Args = append([]string{
+			"cmd",
+			"--instance-types=INSTANCE_TYPES",
+			"--test-suite=TEST_SUITE",
+			"--cpu-threshold=30",
+			"--mem-threshold=30",
+		}, regionArgs...)
+		_, err := ParseCliArgs(outputStream)
+		h.Assert(t, err != nil, "Failed to return error when the regions are %v", regionArgs)
+	}
+}
+
+func TestParseSubcommandArgsSuccess(t *testing.T) {
+	userConfig = UserConfig{}
+	actual, err := ParseSubcommandArgs(ListRunsCommand, []string{"--region=REGION", "--output=json"}, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, "REGION", actual.Region)
+	h.Equals(t, OutputJson, actual.Output)
+}
+
+func TestParseSubcommandArgsUnsupportedOutputFailure(t *testing.T) {
+	userConfig = UserConfig{}
+	_, err := ParseSubcommandArgs(ListRunsCommand, []string{"--region=REGION", "--output=junit-xml"}, outputStream)
+	h.Assert(t, err != nil, "Failed to return error when an output format unsupported by the subcommand is selected")
+}
+
+func TestParseCleanupArgsSuccess(t *testing.T) {
+	userConfig = UserConfig{}
+	_, actual, err := ParseCleanupArgs([]string{"--region=REGION", "--older-than=12h", "--dry-run", "--archive-bucket=ARCHIVE"}, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, CleanupConfig{OlderThan: 12 * time.Hour, DryRun: true, ArchiveBucket: "ARCHIVE"}, actual)
+
+	_, actual, err = ParseCleanupArgs([]string{"--region=REGION"}, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, defaultCleanupAge, actual.OlderThan)
+}
+
+func TestParseCleanupArgsNegativeAgeFailure(t *testing.T) {
+	userConfig = UserConfig{}
+	_, _, err := ParseCleanupArgs([]string{"--region=REGION", "--older-than=-1h"}, outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the minimum age is negative")
+}
+
+func TestParseCleanupArgsPolicySuccess(t *testing.T) {
+	userConfig = UserConfig{}
+	actual, _, err := ParseCleanupArgs([]string{"--region=REGION", "--non-interactive", "--on-orphaned-resources=delete"}, outputStream)
+	h.Ok(t, err)
+	h.Assert(t, actual.NonInteractive, "Failed to parse the non-interactive mode")
+	h.Equals(t, PolicyDelete, actual.OnOrphanedResources)
+}
+
+func TestParseCleanupArgsInvalidPolicyFailure(t *testing.T) {
+	userConfig = UserConfig{}
+	_, _, err := ParseCleanupArgs([]string{"--region=REGION", "--on-orphaned-resources=continue"}, outputStream)
+	h.Assert(t, err != nil, "Failed to return error when the policy is invalid")
+}
+
+func TestParseLogsArgsSuccess(t *testing.T) {
+	userConfig = UserConfig{}
+	actual, logsConfig, err := ParseLogsArgs([]string{"--bucket=BUCKET", "--instance-type=m4.large", "--test=cpu-test.sh", "--backend=local"}, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, "BUCKET", actual.Bucket)
+	h.Equals(t, LogsConfig{InstanceType: "m4.large", TestFile: "cpu-test.sh", Stream: StreamStdout}, logsConfig)
+
+	_, logsConfig, err = ParseLogsArgs([]string{"--region=REGION", "--bucket=BUCKET", "--instance-type=m4.large", "--test=cpu-test.sh", "--stream=stderr", "--instance-id=i-123"}, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, LogsConfig{InstanceType: "m4.large", TestFile: "cpu-test.sh", Stream: StreamStderr, InstanceId: "i-123"}, logsConfig)
+}
+
+func TestParseLogsArgsFailure(t *testing.T) {
+	for _, args := range [][]string{
+		{"--region=REGION", "--instance-type=m4.large", "--test=cpu-test.sh"},
+		{"--region=REGION", "--bucket=BUCKET", "--test=cpu-test.sh"},
+		{"--region=REGION", "--bucket=BUCKET", "--instance-type=m4.large"},
+		{"--region=REGION", "--bucket=BUCKET", "--instance-type=m4.large", "--test=cpu-test.sh", "--stream=stdin"},
+		{"--region=REGION", "--bucket=BUCKET", "--instance-type=m4.large", "--test=cpu-test.sh", "--backend=lambda"},
+	} {
+		userConfig = UserConfig{}
+		_, _, err := ParseLogsArgs(args, outputStream)
+		h.Assert(t, err != nil, "Failed to return error for the arguments %v", args)
+	}
+}
+
+func TestValidateMetrics(t *testing.T) {
+	userConfig := UserConfig{
+		MemThreshold: 30,
+		Metrics:      "mem_used_percent,disk_used_percent",
+	}
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when a selected metric has no threshold")
+
+	userConfig.MetricThresholds = map[string]MetricThreshold{"disk_used_percent": {Threshold: 80}}
+	h.Ok(t, validateMetrics(userConfig))
+
+	userConfig.MetricThresholds = map[string]MetricThreshold{"disk_used_percent": {Threshold: 80, Direction: "sideways"}}
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when the direction is invalid")
+
+	userConfig.MetricThresholds = map[string]MetricThreshold{"disk_used_percent": {Direction: "above"}}
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when the threshold is 0")
+}
+
+func TestValidateMetricsStatistics(t *testing.T) {
+	userConfig := UserConfig{
+		CpuThreshold: 30,
+		MemThreshold: 30,
+		Statistic:    "p95",
+	}
+	h.Ok(t, validateMetrics(userConfig))
+
+	userConfig.MetricStatistics = map[string]string{"cpu_usage_active": "Average"}
+	h.Ok(t, validateMetrics(userConfig))
+
+	userConfig.Statistic = "p42"
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when the statistic is not supported")
+
+	userConfig.Statistic = ""
+	userConfig.MetricStatistics = map[string]string{"cpu_usage_active": "Median"}
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when the statistic of a metric is not supported")
+
+	userConfig.MetricStatistics = map[string]string{"load_average": "Average"}
+	h.Assert(t, validateMetrics(userConfig) != nil, "Failed to return error when the metric of a statistic is not supported")
+}
+
+func TestMetricStatistic(t *testing.T) {
+	testFixture := TestFixture{}
+	h.Equals(t, "Maximum", testFixture.MetricStatistic("cpu_usage_active"))
+
+	testFixture.Statistic = "p90"
+	testFixture.MetricStatistics = map[string]string{"mem_used_percent": "Average"}
+	h.Equals(t, "p90", testFixture.MetricStatistic("cpu_usage_active"))
+	h.Equals(t, "Average", testFixture.MetricStatistic("mem_used_percent"))
+}
+
+func TestValidateThresholdRules(t *testing.T) {
+	h.Ok(t, validateThresholdRules([]ThresholdRule{{InstanceTypes: "t3.*,m5.large", CpuThreshold: 80}}))
+	h.Assert(t, validateThresholdRules([]ThresholdRule{{InstanceTypes: "[t3", CpuThreshold: 80}}) != nil, "Failed to return error when a pattern is malformed")
+	h.Assert(t, validateThresholdRules([]ThresholdRule{{MemThreshold: -1, CpuThreshold: 80}}) != nil, "Failed to return error when a threshold is negative")
+	h.Assert(t, validateThresholdRules([]ThresholdRule{{Thresholds: map[string]float64{"disk_used_percent": 0}}}) != nil, "Failed to return error when a threshold is 0")
+}
+
 func TestWriteUserConfigSuccess(t *testing.T) {
 	actualConfigFile := "actual.config"
 	defer os.Remove(actualConfigFile)
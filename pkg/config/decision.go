@@ -0,0 +1,159 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+)
+
+// Decisions which are prompted to the user unless resolved by a policy.
+const (
+	DecisionInvalidAmi               = "invalid-ami"
+	DecisionInvalidNetwork           = "invalid-network"
+	DecisionSubnet                   = "subnet"
+	DecisionUnsupportedInstanceTypes = "unsupported-instance-types"
+	DecisionDeleteOrphanedResources  = "delete-orphaned-resources"
+)
+
+// Policies resolving the decisions. Each decision is resolved either by proceeding as the policy says, or by failing.
+const (
+	PolicyDefaultAmi = "default-ami"
+	PolicyCreateVpc  = "create-vpc"
+	PolicyContinue   = "continue"
+	PolicyDelete     = "delete"
+	PolicyFail       = "fail"
+)
+
+// Sources of the decisions.
+const (
+	DecisionSourcePrompt = "prompt"
+	DecisionSourcePolicy = "policy"
+)
+
+// decisionPolicy describes the flag of the policy resolving a decision and the policy which proceeds.
+type decisionPolicy struct {
+	flag    string
+	proceed string
+	policy  func(UserConfig) string
+}
+
+var decisionPolicies = map[string]decisionPolicy{
+	DecisionInvalidAmi: {
+		flag:    "on-invalid-ami",
+		proceed: PolicyDefaultAmi,
+		policy:  func(userConfig UserConfig) string { return userConfig.OnInvalidAmi },
+	},
+	DecisionInvalidNetwork: {
+		flag:    "on-invalid-network",
+		proceed: PolicyCreateVpc,
+		policy:  func(userConfig UserConfig) string { return userConfig.OnInvalidNetwork },
+	},
+	DecisionUnsupportedInstanceTypes: {
+		flag:    "on-unsupported-instance-types",
+		proceed: PolicyContinue,
+		policy:  func(userConfig UserConfig) string { return userConfig.OnUnsupportedInstanceTypes },
+	},
+	DecisionDeleteOrphanedResources: {
+		flag:    "on-orphaned-resources",
+		proceed: PolicyDelete,
+		policy:  func(userConfig UserConfig) string { return userConfig.OnOrphanedResources },
+	},
+}
+
+// DecisionRequiredError is returned when a decision cannot be prompted in non-interactive mode and no policy
+// resolves it.
+type DecisionRequiredError struct {
+	Decision string
+	Flag     string
+}
+
+func (e DecisionRequiredError) Error() string {
+	return fmt.Sprintf("the %s decision must be made by the user in non-interactive mode; provide --%s", e.Decision, e.Flag)
+}
+
+// Decide resolves a yes/no decision: with its policy if provided, otherwise by prompting the user. In non-interactive
+// mode, a decision without a policy returns a DecisionRequiredError. The decision made is recorded in the test
+// fixture.
+func Decide(decision string, prompt string, inputStream *os.File, outputStream *os.File) (bool, error) {
+	policy := decisionPolicies[decision]
+	if value := policy.policy(userConfig); value != "" {
+		proceed := value == policy.proceed
+		log.Printf("%s\nResolved with --%s=%s\n", prompt, policy.flag, value)
+		RecordDecision(decision, value, DecisionSourcePolicy)
+		return proceed, nil
+	}
+	if userConfig.NonInteractive {
+		return false, DecisionRequiredError{Decision: decision, Flag: policy.flag}
+	}
+
+	answer, err := cmdutil.BoolPrompt(prompt, inputStream, outputStream)
+	if err != nil {
+		return false, err
+	}
+	outcome := PolicyFail
+	if answer {
+		outcome = policy.proceed
+	}
+	RecordDecision(decision, outcome, DecisionSourcePrompt)
+	return answer, nil
+}
+
+// Choose resolves a decision between options by prompting the user, and returns the index of the chosen option. In
+// non-interactive mode, it returns a DecisionRequiredError pointing to the flag which avoids the decision. The
+// outcome of the option chosen is recorded in the test fixture.
+func Choose(decision string, flag string, prompt string, outcomes []string, inputStream *os.File, outputStream *os.File) (int, error) {
+	if userConfig.NonInteractive {
+		return 0, DecisionRequiredError{Decision: decision, Flag: flag}
+	}
+	option, err := cmdutil.OptionPrompt(prompt, len(outcomes), inputStream, outputStream)
+	if err != nil {
+		return 0, err
+	}
+	RecordDecision(decision, outcomes[option], DecisionSourcePrompt)
+	return option, nil
+}
+
+// RecordDecision records a decision made during the run in the test fixture.
+func RecordDecision(decision string, outcome string, source string) {
+	testFixture.Decisions = append(testFixture.Decisions, Decision{Name: decision, Outcome: outcome, Source: source})
+}
+
+// validatePolicies checks that the policy of each decision of the run is supported.
+func validatePolicies(userConfig UserConfig) error {
+	for _, decision := range []string{DecisionInvalidAmi, DecisionInvalidNetwork, DecisionUnsupportedInstanceTypes} {
+		if err := validatePolicy(decision, userConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePolicy checks that the policy of the decision is supported.
+func validatePolicy(decision string, userConfig UserConfig) error {
+	policy := decisionPolicies[decision]
+	if value := policy.policy(userConfig); value != "" && value != policy.proceed && value != PolicyFail {
+		return fmt.Errorf("--%s must be either %s or %s", policy.flag, policy.proceed, PolicyFail)
+	}
+	return nil
+}
+
+// policyUsage returns the usage of the flag of the policy resolving a decision.
+func policyUsage(decision string, description string) string {
+	policy := decisionPolicies[decision]
+	return fmt.Sprintf("[OPTIONAL] policy applied without prompting when %s, either %s or %s", description, policy.proceed, PolicyFail)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+func prepareInput(input string) (*os.File, error) {
+	tempFile, err := ioutil.TempFile("", "temp-file")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tempFile.WriteString(input); err != nil {
+		return nil, err
+	}
+	if _, err := tempFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return tempFile, nil
+}
+
+// Tests
+
+func TestDecidePolicy(t *testing.T) {
+	testFixture.Decisions = nil
+	userConfig = UserConfig{NonInteractive: true, OnInvalidAmi: PolicyDefaultAmi, OnInvalidNetwork: PolicyFail}
+
+	answer, err := Decide(DecisionInvalidAmi, "PROMPT", os.Stdin, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, true, answer)
+
+	answer, err = Decide(DecisionInvalidNetwork, "PROMPT", os.Stdin, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, false, answer)
+
+	expected := []Decision{
+		{Name: DecisionInvalidAmi, Outcome: PolicyDefaultAmi, Source: DecisionSourcePolicy},
+		{Name: DecisionInvalidNetwork, Outcome: PolicyFail, Source: DecisionSourcePolicy},
+	}
+	h.Equals(t, expected, testFixture.Decisions)
+}
+
+func TestDecideNonInteractiveFailure(t *testing.T) {
+	testFixture.Decisions = nil
+	userConfig = UserConfig{NonInteractive: true}
+
+	_, err := Decide(DecisionUnsupportedInstanceTypes, "PROMPT", os.Stdin, outputStream)
+	h.Equals(t, DecisionRequiredError{Decision: DecisionUnsupportedInstanceTypes, Flag: "on-unsupported-instance-types"}, err)
+	_, err = Choose(DecisionSubnet, "subnet", "PROMPT", []string{"subnet-1"}, os.Stdin, outputStream)
+	h.Equals(t, DecisionRequiredError{Decision: DecisionSubnet, Flag: "subnet"}, err)
+	h.Equals(t, 0, len(testFixture.Decisions))
+}
+
+func TestDecidePrompt(t *testing.T) {
+	testFixture.Decisions = nil
+	userConfig = UserConfig{}
+	inputStream, err := prepareInput("y\n")
+	h.Ok(t, err)
+	defer os.Remove(inputStream.Name())
+	answer, err := Decide(DecisionUnsupportedInstanceTypes, "PROMPT", inputStream, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, true, answer)
+
+	inputStream, err = prepareInput("1\n")
+	h.Ok(t, err)
+	defer os.Remove(inputStream.Name())
+	option, err := Choose(DecisionSubnet, "subnet", "PROMPT", []string{"subnet-1", "subnet-2"}, inputStream, outputStream)
+	h.Ok(t, err)
+	h.Equals(t, 1, option)
+
+	expected := []Decision{
+		{Name: DecisionUnsupportedInstanceTypes, Outcome: PolicyContinue, Source: DecisionSourcePrompt},
+		{Name: DecisionSubnet, Outcome: "subnet-2", Source: DecisionSourcePrompt},
+	}
+	h.Equals(t, expected, testFixture.Decisions)
+}
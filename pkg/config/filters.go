@@ -0,0 +1,161 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	burstableOnly    = "true"
+	burstableExclude = "false"
+)
+
+// InstanceTypeFilters select instance types by their attributes. They are expanded into the list of instance types of
+// a new run, or narrow down the list provided by the user. A zero value doesn't filter anything.
+type InstanceTypeFilters struct {
+	VCpusMin int `json:"vcpus-min,omitempty"`
+	VCpusMax int `json:"vcpus-max,omitempty"`
+	// MemoryMin and MemoryMax are in GiB.
+	MemoryMin    float64 `json:"memory-min,omitempty"`
+	MemoryMax    float64 `json:"memory-max,omitempty"`
+	Architecture string  `json:"architecture,omitempty"`
+	// Families and ExcludeFamilies are comma-separated lists of shell patterns matching instance families (e.g. "m5*,c5").
+	Families        string `json:"families,omitempty"`
+	ExcludeFamilies string `json:"exclude-families,omitempty"`
+	// Burstable is "true" to only select burstable instance types, "false" to exclude them, and empty for both.
+	Burstable string `json:"burstable,omitempty"`
+	// NetworkPerformance is a comma-separated list of network performances as described by EC2 (e.g. "Up to 10 Gigabit").
+	NetworkPerformance string `json:"network-performance,omitempty"`
+	CurrentGeneration  bool   `json:"current-generation,omitempty"`
+	// MaxPrice is the max hourly price in USD of the price type, which requires a price file.
+	MaxPrice float64 `json:"max-price,omitempty"`
+}
+
+// IsEmpty checks whether no filter is set.
+func (f InstanceTypeFilters) IsEmpty() bool {
+	return f == InstanceTypeFilters{}
+}
+
+// MatchesFamily checks whether an instance type is of the families selected and not of the families excluded.
+func (f InstanceTypeFilters) MatchesFamily(instanceType string) bool {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	if f.Families != "" && !matchesFamily(f.Families, family) {
+		return false
+	}
+	return f.ExcludeFamilies == "" || !matchesFamily(f.ExcludeFamilies, family)
+}
+
+// MatchesBurstable checks whether an instance type is selected given whether it is burstable.
+func (f InstanceTypeFilters) MatchesBurstable(isBurstable bool) bool {
+	return f.Burstable == "" || (f.Burstable == burstableOnly) == isBurstable
+}
+
+// MatchesNetworkPerformance checks whether an instance type is selected given its network performance.
+func (f InstanceTypeFilters) MatchesNetworkPerformance(networkPerformance string) bool {
+	if f.NetworkPerformance == "" {
+		return true
+	}
+	for _, selected := range strings.Split(f.NetworkPerformance, ",") {
+		if strings.EqualFold(strings.TrimSpace(selected), networkPerformance) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge sets empty filters to those of reqFilters. The filters of reqFilters only fill those left unset by the config of
+// the run, so they can widen the set of filters but never reset one: an explicit false or 0 can't be told apart from an
+// unset filter.
+func (f *InstanceTypeFilters) merge(reqFilters InstanceTypeFilters) {
+	if f.VCpusMin == 0 {
+		f.VCpusMin = reqFilters.VCpusMin
+	}
+	if f.VCpusMax == 0 {
+		f.VCpusMax = reqFilters.VCpusMax
+	}
+	if f.MemoryMin == 0 {
+		f.MemoryMin = reqFilters.MemoryMin
+	}
+	if f.MemoryMax == 0 {
+		f.MemoryMax = reqFilters.MemoryMax
+	}
+	if f.Architecture == "" {
+		f.Architecture = reqFilters.Architecture
+	}
+	if f.Families == "" {
+		f.Families = reqFilters.Families
+	}
+	if f.ExcludeFamilies == "" {
+		f.ExcludeFamilies = reqFilters.ExcludeFamilies
+	}
+	if f.Burstable == "" {
+		f.Burstable = reqFilters.Burstable
+	}
+	if f.NetworkPerformance == "" {
+		f.NetworkPerformance = reqFilters.NetworkPerformance
+	}
+	if !f.CurrentGeneration {
+		f.CurrentGeneration = reqFilters.CurrentGeneration
+	}
+	if f.MaxPrice == 0 {
+		f.MaxPrice = reqFilters.MaxPrice
+	}
+}
+
+// matchesFamily checks whether a family matches any pattern of a comma-separated list.
+func matchesFamily(patterns string, family string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), family); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validateInstanceTypeFilters checks that the ranges are well-formed, the patterns are valid and the filters can be
+// applied by the backend.
+func validateInstanceTypeFilters(userConfig UserConfig) error {
+	filters := userConfig.InstanceTypeFilters
+	if filters.IsEmpty() {
+		return nil
+	}
+	if userConfig.Backend == BackendLocal {
+		return errors.New("instance type filters are not supported by the local backend, which doesn't describe instance types")
+	}
+	if filters.VCpusMin < 0 || filters.VCpusMax < 0 || filters.MemoryMin < 0 || filters.MemoryMax < 0 || filters.MaxPrice < 0 {
+		return errors.New("instance type filters must not be negative")
+	}
+	if filters.VCpusMax > 0 && filters.VCpusMin > filters.VCpusMax {
+		return fmt.Errorf("vcpus-min %d is greater than vcpus-max %d", filters.VCpusMin, filters.VCpusMax)
+	}
+	if filters.MemoryMax > 0 && filters.MemoryMin > filters.MemoryMax {
+		return fmt.Errorf("memory-min %g is greater than memory-max %g", filters.MemoryMin, filters.MemoryMax)
+	}
+	if filters.Burstable != "" && filters.Burstable != burstableOnly && filters.Burstable != burstableExclude {
+		return fmt.Errorf("burstable must be either %s or %s", burstableOnly, burstableExclude)
+	}
+	for _, pattern := range append(strings.Split(filters.Families, ","), strings.Split(filters.ExcludeFamilies, ",")...) {
+		if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+			return fmt.Errorf("invalid family pattern %q", pattern)
+		}
+	}
+	if filters.MaxPrice > 0 && userConfig.PriceFile == "" {
+		return errors.New("max-price requires a price file")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	quantifiedRequirementRegex = regexp.MustCompile(`^(all|any|at least (one|\d+)) of (.+) pass(es)?$`)
+	singleRequirementRegex     = regexp.MustCompile(`^(\S+) pass(es)?$`)
+)
+
+// Requirement is a condition on the instance types which pass that determines the exit status of a run. It is
+// parsed from expressions such as "at least one of m5.large,c5.large passes", "all of m5.*,c5.large pass",
+// "any of t3.* passes" or "m5.large passes".
+type Requirement struct {
+	Expression string
+	// Patterns are shell patterns of the instance types the requirement is about
+	Patterns []string
+	// MinPassing is the number of matching instance types which must pass; 0 means all of them must pass
+	MinPassing int
+}
+
+// ParseRequirement parses a requirement expression.
+func ParseRequirement(expression string) (Requirement, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(expression)), " ")
+	requirement := Requirement{Expression: expression}
+
+	var patterns string
+	if match := quantifiedRequirementRegex.FindStringSubmatch(normalized); match != nil {
+		patterns = match[3]
+		switch {
+		case match[1] == "all":
+			requirement.MinPassing = 0
+		case match[1] == "any" || match[2] == "one":
+			requirement.MinPassing = 1
+		default:
+			minPassing, err := strconv.Atoi(match[2])
+			if err != nil || minPassing < 1 {
+				return requirement, fmt.Errorf("invalid requirement %q: the number of instance types must be at least 1", expression)
+			}
+			requirement.MinPassing = minPassing
+		}
+	} else if match := singleRequirementRegex.FindStringSubmatch(normalized); match != nil {
+		patterns = match[1]
+	} else {
+		return requirement, fmt.Errorf("invalid requirement %q: expected e.g. \"at least one of m5.large,c5.large passes\" or \"all of m5.* pass\"", expression)
+	}
+
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return requirement, fmt.Errorf("invalid requirement %q: invalid instance type pattern %q", expression, pattern)
+		}
+		requirement.Patterns = append(requirement.Patterns, pattern)
+	}
+	return requirement, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestParseRequirementSuccess(t *testing.T) {
+	requirement, err := ParseRequirement("At least one of m5.large, c5.large passes")
+	h.Ok(t, err)
+	h.Equals(t, Requirement{Expression: "At least one of m5.large, c5.large passes", Patterns: []string{"m5.large", "c5.large"}, MinPassing: 1}, requirement)
+
+	requirement, err = ParseRequirement("at least 2 of m5.*,c5.* pass")
+	h.Ok(t, err)
+	h.Equals(t, []string{"m5.*", "c5.*"}, requirement.Patterns)
+	h.Equals(t, 2, requirement.MinPassing)
+
+	requirement, err = ParseRequirement("all of m5.* pass")
+	h.Ok(t, err)
+	h.Equals(t, 0, requirement.MinPassing)
+
+	requirement, err = ParseRequirement("m5.large passes")
+	h.Ok(t, err)
+	h.Equals(t, []string{"m5.large"}, requirement.Patterns)
+	h.Equals(t, 0, requirement.MinPassing)
+}
+
+func TestParseRequirementFailure(t *testing.T) {
+	for _, expression := range []string{"m5.large", "at least 0 of m5.large passes", "most of m5.large pass", "all of m5.[ pass"} {
+		_, err := ParseRequirement(expression)
+		h.Assert(t, err != nil, "Failed to return error for the invalid requirement %q", expression)
+	}
+}
@@ -13,42 +13,129 @@
 
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+)
 
 // UserConfig contains configuration provided by the user, which remains unchanged throughout the entire run.
 type UserConfig struct {
-	InstanceTypes    string `json:"instance-types"`
-	TestSuiteName    string `json:"test-suite"`
-	CpuThreshold     int    `json:"cpu-threshold"`
-	MemThreshold     int    `json:"mem-threshold"`
-	VpcId            string `json:"vpc"`
-	SubnetId         string `json:"subnet"`
-	AmiId            string `json:"ami"`
-	Timeout          int    `json:"timeout"`
-	Persist          bool   `json:"persist"`
-	Profile          string `json:"profile"`
-	Region           string `json:"region"`
-	Bucket           string `json:"bucket"`
-	CustomScriptPath string `json:"custom-script"`
-	ConfigFilePath   string `json:"config-file"`
+	InstanceTypes              string                     `json:"instance-types"`
+	TestSuiteName              string                     `json:"test-suite"`
+	CpuThreshold               int                        `json:"cpu-threshold"`
+	MemThreshold               int                        `json:"mem-threshold"`
+	VpcId                      string                     `json:"vpc"`
+	SubnetId                   string                     `json:"subnet"`
+	AmiId                      string                     `json:"ami"`
+	Timeout                    int                        `json:"timeout"`
+	Persist                    bool                       `json:"persist"`
+	Profile                    string                     `json:"profile"`
+	Region                     string                     `json:"region"`
+	Bucket                     string                     `json:"bucket"`
+	CustomScriptPath           string                     `json:"custom-script"`
+	ConfigFilePath             string                     `json:"config-file"`
+	ThresholdRules             []ThresholdRule            `json:"threshold-rules,omitempty"`
+	Metrics                    string                     `json:"metrics,omitempty"`
+	MetricThresholds           map[string]MetricThreshold `json:"metric-thresholds,omitempty"`
+	Statistic                  string                     `json:"statistic,omitempty"`
+	MetricStatistics           map[string]string          `json:"metric-statistics,omitempty"`
+	Output                     string                     `json:"output,omitempty"`
+	PriceFile                  string                     `json:"price-file,omitempty"`
+	PriceType                  string                     `json:"price-type,omitempty"`
+	PurchaseOption             string                     `json:"purchase-option,omitempty"`
+	Replicas                   int                        `json:"replicas,omitempty"`
+	PassRate                   float64                    `json:"pass-rate,omitempty"`
+	NonInteractive             bool                       `json:"non-interactive,omitempty"`
+	OnInvalidAmi               string                     `json:"on-invalid-ami,omitempty"`
+	OnInvalidNetwork           string                     `json:"on-invalid-network,omitempty"`
+	OnUnsupportedInstanceTypes string                     `json:"on-unsupported-instance-types,omitempty"`
+	Require                    string                     `json:"require,omitempty"`
+	Backend                    string                     `json:"backend,omitempty"`
+	StoreEndpoint              string                     `json:"store-endpoint,omitempty"`
+	Regions                    string                     `json:"regions,omitempty"`
+	Concurrency                int                        `json:"concurrency,omitempty"`
+	// Follow only applies to the current invocation, so it isn't persisted with the run.
+	Follow bool `json:"-"`
+	// OnOrphanedResources only applies to the cleanup subcommand, which doesn't persist any run.
+	OnOrphanedResources string `json:"-"`
+	InstanceTypeFilters
+}
+
+// CleanupConfig contains the options of the cleanup subcommand.
+type CleanupConfig struct {
+	OlderThan     time.Duration
+	DryRun        bool
+	ArchiveBucket string
+}
+
+// LogsConfig contains the options of the logs subcommand.
+type LogsConfig struct {
+	InstanceType string
+	TestFile     string
+	Stream       string
+	InstanceId   string
+}
+
+// MetricThreshold contains the global threshold of a selected metric and the direction in which it is compared.
+// cpu_usage_active and mem_used_percent take their thresholds from cpu-threshold and mem-threshold instead.
+type MetricThreshold struct {
+	Threshold float64 `json:"threshold"`
+	Direction string  `json:"direction,omitempty"`
+}
+
+// ThresholdRule overrides the global thresholds for the instance types and test files it matches. InstanceTypes
+// and TestFiles are comma-separated lists of shell patterns (e.g. "t3.*,m5.large"); an empty list matches
+// everything. A threshold of 0 means the rule doesn't override that metric.
+type ThresholdRule struct {
+	Name          string             `json:"name"`
+	InstanceTypes string             `json:"instance-types"`
+	TestFiles     string             `json:"test-files"`
+	CpuThreshold  int                `json:"cpu-threshold"`
+	MemThreshold  int                `json:"mem-threshold"`
+	Thresholds    map[string]float64 `json:"thresholds,omitempty"`
+}
+
+// Decision records how a decision otherwise prompted to the user was made during the run.
+type Decision struct {
+	Name    string `json:"name"`
+	Outcome string `json:"outcome"`
+	Source  string `json:"source"`
 }
 
 // TestFixture contains constant information for the entire run.
 type TestFixture struct {
-	RunId                   string `json:"runId"`
-	TestSuiteName           string `json:"test-suite"`
-	CompressedTestSuiteName string `json:"compressed-test-suite"`
-	BucketName              string `json:"bucket-name"`
-	BucketRootDir           string `json:"bucket-root-dir"`
-	CpuThreshold            int    `json:"cpu-threshold"`
-	MemThreshold            int    `json:"mem-threshold"`
-	Timeout                 int    `json:"timeout"`
-	CfnStackName            string `json:"stack-name"`
-	FinalResultFilename     string `json:"final-results"`
-	UserConfigFilename      string `json:"user-config"`
-	CfnTemplateFilename     string `json:"cfn-template"`
-	AmiId                   string `json:"ami"`
-	StartTime               string `json:"start-time"`
+	RunId                   string                     `json:"runId"`
+	TestSuiteName           string                     `json:"test-suite"`
+	CompressedTestSuiteName string                     `json:"compressed-test-suite"`
+	BucketName              string                     `json:"bucket-name"`
+	BucketRootDir           string                     `json:"bucket-root-dir"`
+	CpuThreshold            int                        `json:"cpu-threshold"`
+	MemThreshold            int                        `json:"mem-threshold"`
+	Timeout                 int                        `json:"timeout"`
+	CfnStackName            string                     `json:"stack-name"`
+	FinalResultFilename     string                     `json:"final-results"`
+	UserConfigFilename      string                     `json:"user-config"`
+	CfnTemplateFilename     string                     `json:"cfn-template"`
+	AmiId                   string                     `json:"ami"`
+	StartTime               string                     `json:"start-time"`
+	ThresholdRules          []ThresholdRule            `json:"threshold-rules,omitempty"`
+	Metrics                 []string                   `json:"metrics,omitempty"`
+	MetricThresholds        map[string]MetricThreshold `json:"metric-thresholds,omitempty"`
+	Statistic               string                     `json:"statistic,omitempty"`
+	MetricStatistics        map[string]string          `json:"metric-statistics,omitempty"`
+	PurchaseOption          string                     `json:"purchase-option,omitempty"`
+	Replicas                int                        `json:"replicas,omitempty"`
+	PassRate                float64                    `json:"pass-rate,omitempty"`
+	Decisions               []Decision                 `json:"decisions,omitempty"`
+	Backend                 string                     `json:"backend,omitempty"`
+	StoreEndpoint           string                     `json:"store-endpoint,omitempty"`
+	Region                  string                     `json:"region,omitempty"`
+	// Concurrency is the max number of test files executed at once by each agent, overriding the manifest of the test
+	// suite. It is 0 if it isn't provided.
+	Concurrency int `json:"concurrency,omitempty"`
 }
 
 var testFixture TestFixture
@@ -70,10 +157,36 @@ func (UserConfig) String() string {
 		Region: %s,
 		Bucket: %s,
 		CustomScriptPath: %s,
-		ConfigFilePath: %s
+		ConfigFilePath: %s,
+		ThresholdRules: %v,
+		Metrics: %s,
+		MetricThresholds: %v,
+		Statistic: %s,
+		MetricStatistics: %v,
+		Output: %s,
+		PriceFile: %s,
+		PriceType: %s,
+		PurchaseOption: %s,
+		Replicas: %d,
+		PassRate: %.2f,
+		NonInteractive: %t,
+		OnInvalidAmi: %s,
+		OnInvalidNetwork: %s,
+		OnUnsupportedInstanceTypes: %s,
+		Require: %s,
+		Backend: %s,
+		StoreEndpoint: %s,
+		Regions: %s,
+		Concurrency: %d,
+		Follow: %t,
+		InstanceTypeFilters: %+v
 `, userConfig.InstanceTypes, userConfig.TestSuiteName, userConfig.CpuThreshold, userConfig.MemThreshold, userConfig.VpcId,
 		userConfig.SubnetId, userConfig.AmiId, userConfig.Timeout, userConfig.Persist, userConfig.Profile, userConfig.Region,
-		userConfig.Bucket, userConfig.CustomScriptPath, userConfig.ConfigFilePath)
+		userConfig.Bucket, userConfig.CustomScriptPath, userConfig.ConfigFilePath, userConfig.ThresholdRules,
+		userConfig.Metrics, userConfig.MetricThresholds, userConfig.Statistic, userConfig.MetricStatistics, userConfig.Output,
+		userConfig.PriceFile, userConfig.PriceType, userConfig.PurchaseOption, userConfig.Replicas, userConfig.PassRate,
+		userConfig.NonInteractive, userConfig.OnInvalidAmi, userConfig.OnInvalidNetwork, userConfig.OnUnsupportedInstanceTypes,
+		userConfig.Require, userConfig.Backend, userConfig.StoreEndpoint, userConfig.Regions, userConfig.Concurrency, userConfig.Follow, userConfig.InstanceTypeFilters)
 }
 
 // SetUserConfig sets empty fields of UserConfig to reqConfig
@@ -121,6 +234,67 @@ func (UserConfig) SetUserConfig(reqConfig UserConfig) {
 	if userConfig.ConfigFilePath == "" {
 		userConfig.ConfigFilePath = reqConfig.ConfigFilePath
 	}
+	if len(userConfig.ThresholdRules) == 0 {
+		userConfig.ThresholdRules = reqConfig.ThresholdRules
+	}
+	if userConfig.Metrics == "" {
+		userConfig.Metrics = reqConfig.Metrics
+	}
+	if len(userConfig.MetricThresholds) == 0 {
+		userConfig.MetricThresholds = reqConfig.MetricThresholds
+	}
+	if userConfig.Statistic == "" {
+		userConfig.Statistic = reqConfig.Statistic
+	}
+	if len(userConfig.MetricStatistics) == 0 {
+		userConfig.MetricStatistics = reqConfig.MetricStatistics
+	}
+	if userConfig.Output == OutputTable && reqConfig.Output != "" {
+		userConfig.Output = reqConfig.Output
+	}
+	if userConfig.PriceFile == "" {
+		userConfig.PriceFile = reqConfig.PriceFile
+	}
+	if userConfig.PriceType == pricing.OnDemand && reqConfig.PriceType != "" {
+		userConfig.PriceType = reqConfig.PriceType
+	}
+	if userConfig.PurchaseOption == PurchaseOptionOnDemand && reqConfig.PurchaseOption != "" {
+		userConfig.PurchaseOption = reqConfig.PurchaseOption
+	}
+	if userConfig.Replicas == defaultReplicas && reqConfig.Replicas > 0 {
+		userConfig.Replicas = reqConfig.Replicas
+	}
+	if userConfig.PassRate == defaultPassRate && reqConfig.PassRate > 0 {
+		userConfig.PassRate = reqConfig.PassRate
+	}
+	if userConfig.NonInteractive != true {
+		userConfig.NonInteractive = reqConfig.NonInteractive
+	}
+	if userConfig.OnInvalidAmi == "" {
+		userConfig.OnInvalidAmi = reqConfig.OnInvalidAmi
+	}
+	if userConfig.OnInvalidNetwork == "" {
+		userConfig.OnInvalidNetwork = reqConfig.OnInvalidNetwork
+	}
+	if userConfig.OnUnsupportedInstanceTypes == "" {
+		userConfig.OnUnsupportedInstanceTypes = reqConfig.OnUnsupportedInstanceTypes
+	}
+	if userConfig.Require == "" {
+		userConfig.Require = reqConfig.Require
+	}
+	if userConfig.Backend == BackendEc2 && reqConfig.Backend != "" {
+		userConfig.Backend = reqConfig.Backend
+	}
+	if userConfig.StoreEndpoint == "" {
+		userConfig.StoreEndpoint = reqConfig.StoreEndpoint
+	}
+	if userConfig.Regions == "" {
+		userConfig.Regions = reqConfig.Regions
+	}
+	if userConfig.Concurrency == 0 {
+		userConfig.Concurrency = reqConfig.Concurrency
+	}
+	userConfig.InstanceTypeFilters.merge(reqConfig.InstanceTypeFilters)
 }
 
 // String returns a pretty string representation of TestFixture
@@ -139,8 +313,52 @@ func (TestFixture) String() string {
 		UserConfigFilename: %s,
 		CfnTemplateFilename: %s,
 		AmiId: %s,
-		StartTime: %s
+		StartTime: %s,
+		ThresholdRules: %v,
+		Metrics: %v,
+		MetricThresholds: %v,
+		Statistic: %s,
+		MetricStatistics: %v,
+		PurchaseOption: %s,
+		Replicas: %d,
+		PassRate: %.2f,
+		Decisions: %v,
+		Backend: %s,
+		StoreEndpoint: %s,
+		Region: %s,
+		Concurrency: %d
 `, testFixture.RunId, testFixture.TestSuiteName, testFixture.CompressedTestSuiteName, testFixture.BucketName, testFixture.BucketRootDir,
 		testFixture.CpuThreshold, testFixture.MemThreshold, testFixture.Timeout, testFixture.CfnStackName, testFixture.FinalResultFilename,
-		testFixture.UserConfigFilename, testFixture.CfnTemplateFilename, testFixture.AmiId, testFixture.StartTime)
+		testFixture.UserConfigFilename, testFixture.CfnTemplateFilename, testFixture.AmiId, testFixture.StartTime, testFixture.ThresholdRules,
+		testFixture.Metrics, testFixture.MetricThresholds, testFixture.Statistic, testFixture.MetricStatistics, testFixture.PurchaseOption,
+		testFixture.Replicas, testFixture.PassRate, testFixture.Decisions, testFixture.Backend, testFixture.StoreEndpoint, testFixture.Region, testFixture.Concurrency)
+}
+
+// MetricStatistic returns the statistic used to aggregate a metric: its own statistic if set, otherwise the global
+// one, otherwise metrics.DefaultStatistic.
+func (t TestFixture) MetricStatistic(metricName string) string {
+	if statistic, ok := t.MetricStatistics[metricName]; ok && statistic != "" {
+		return statistic
+	}
+	if t.Statistic != "" {
+		return t.Statistic
+	}
+	return metrics.DefaultStatistic
+}
+
+// ReplicaCount returns the number of instances launched per instance type, which is 1 for runs started before
+// replicas were supported.
+func (t TestFixture) ReplicaCount() int {
+	if t.Replicas < 1 {
+		return defaultReplicas
+	}
+	return t.Replicas
+}
+
+// RequiredPassRate returns the fraction of the replicas of an instance type that must pass for it to succeed.
+func (t TestFixture) RequiredPassRate() float64 {
+	if t.PassRate <= 0 {
+		return defaultPassRate
+	}
+	return t.PassRate
 }
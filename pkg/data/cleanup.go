@@ -0,0 +1,100 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+const (
+	resourceTypeHeader  = "RESOURCE TYPE"
+	resourceIdHeader    = "ID"
+	stateHeader         = "STATE"
+	creationTimeHeader  = "CREATION TIME"
+	noOrphansMessage    = "No instance-qualifier resource needs to be cleaned up"
+	cleanupPlanTemplate = "%d resources will be deleted\n"
+)
+
+// OutputCleanupPlan outputs the resources to be deleted by the cleanup subcommand in the given format. Only the table,
+// json and csv formats are supported; any other format falls back to a table.
+func OutputCleanupPlan(orphans []resources.OrphanedResource, outputFormat string, outputStream *os.File) error {
+	switch outputFormat {
+	case config.OutputJson:
+		return renderCleanupPlanJson(orphans, outputStream)
+	case config.OutputCsv:
+		return renderCleanupPlanCsv(orphans, outputStream)
+	default:
+		if len(orphans) == 0 {
+			fmt.Fprintln(outputStream, noOrphansMessage)
+			return nil
+		}
+		cmdutil.RenderTable(orphansToRows(orphans), cleanupPlanTableHeader(), outputStream)
+		fmt.Fprintf(outputStream, "\n"+cleanupPlanTemplate, len(orphans))
+		return nil
+	}
+}
+
+// renderCleanupPlanJson outputs the resources to be deleted as a JSON array.
+func renderCleanupPlanJson(orphans []resources.OrphanedResource, outputStream io.Writer) error {
+	if orphans == nil {
+		orphans = []resources.OrphanedResource{}
+	}
+	encoder := json.NewEncoder(outputStream)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(orphans)
+}
+
+// renderCleanupPlanCsv outputs the resources to be deleted as CSV.
+func renderCleanupPlanCsv(orphans []resources.OrphanedResource, outputStream io.Writer) error {
+	writer := csv.NewWriter(outputStream)
+	if err := writer.Write(cleanupPlanTableHeader()); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(orphansToRows(orphans)); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// cleanupPlanTableHeader returns the header of the cleanup plan table.
+func cleanupPlanTableHeader() []string {
+	return []string{resourceTypeHeader, resourceIdHeader, runIdHeader, stateHeader, creationTimeHeader}
+}
+
+// orphansToRows populates and returns the row data of each resource to be deleted, using N/A for unknown details.
+func orphansToRows(orphans []resources.OrphanedResource) (rows [][]string) {
+	for _, orphan := range orphans {
+		row := []string{orphan.Type, orphan.Id, orphan.RunId, orphan.State, orphan.CreationTime.Format(time.RFC3339)}
+		if orphan.RunId == "" {
+			row[2] = notApplicable
+		}
+		if orphan.State == "" {
+			row[3] = notApplicable
+		}
+		if orphan.CreationTime.IsZero() {
+			row[4] = notApplicable
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
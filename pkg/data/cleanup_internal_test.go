@@ -0,0 +1,55 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+var testOrphans = []resources.OrphanedResource{
+	{
+		Type:         resources.ResourceTypeInstance,
+		Id:           "i-0123456789abcdef0",
+		RunId:        "old",
+		State:        "running",
+		CreationTime: time.Date(2020, 9, 1, 10, 0, 0, 0, time.UTC),
+	},
+	{
+		Type:  resources.ResourceTypeBucket,
+		Id:    "qualifier-bucket-old",
+		RunId: "old",
+	},
+}
+
+func TestOrphansToRows(t *testing.T) {
+	expected := [][]string{
+		{"instance", "i-0123456789abcdef0", "old", "running", "2020-09-01T10:00:00Z"},
+		{"bucket", "qualifier-bucket-old", "old", "N/A", "N/A"},
+	}
+	h.Equals(t, expected, orphansToRows(testOrphans))
+}
+
+func TestRenderCleanupPlanCsv(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, renderCleanupPlanCsv(testOrphans, &buf))
+	expected := "RESOURCE TYPE,ID,RUN ID,STATE,CREATION TIME\n" +
+		"instance,i-0123456789abcdef0,old,running,2020-09-01T10:00:00Z\n" +
+		"bucket,qualifier-bucket-old,old,N/A,N/A\n"
+	h.Equals(t, expected, buf.String())
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+)
+
+const (
+	pricePerHourHeaderSuffix = " PRICE ($/hour)"
+	costPerRunHeader         = "COST PER RUN ($)"
+	secondsPerHour           = 3600
+)
+
+// ReportOptions contains the options of the final report, which may differ from those of the run when it is
+// resumed.
+type ReportOptions struct {
+	// Format is one of config.OutputFormats.
+	Format string
+	// PriceSource provides the prices of instance types. Costs are not reported if it is nil.
+	PriceSource pricing.Source
+	// PriceType is the type of price used to compute costs and rank instance types, either pricing.OnDemand or
+	// pricing.Spot.
+	PriceType string
+	// Requirement determines the status of the run instead of the worst outcome of its instance types, if not nil.
+	Requirement *config.Requirement
+}
+
+// recommendation is the cheapest instance type which passes, along with all passing instance types with a known
+// price from the cheapest to the most expensive.
+type recommendation struct {
+	InstanceType string   `json:"instance-type"`
+	PricePerHour string   `json:"price-per-hour"`
+	CostPerRun   string   `json:"cost-per-run"`
+	Ranking      []string `json:"ranking"`
+}
+
+// rankedInstanceType is a passing instance type with a known price.
+type rankedInstanceType struct {
+	instanceType string
+	pricePerHour float64
+	costPerRun   float64
+}
+
+// appendCosts appends the hourly price and the cost of the run of each instance type to the main table, and
+// returns the recommendation, which is nil if no passing instance type has a known price. An instance type passes
+// if its status is SUCCESS and all its tests pass. If the table has a region column, instance types are prefixed by
+// their region, both to look up their prices and in the recommendation.
+func appendCosts(table *reportTable, source pricing.Source, priceType string) *recommendation {
+	instanceTypeIdx := indexOf(table.header, instanceTypeHeader)
+	regionIdx := indexOf(table.header, regionHeader)
+	statusIdx := indexOf(table.header, statusHeader)
+	allTestsPassIdx := indexOf(table.header, allTestsPassHeader)
+	executionTimeIdx := indexOf(table.header, executionTimeHeader)
+	table.header = append(table.header, strings.ToUpper(priceType)+pricePerHourHeaderSuffix, costPerRunHeader)
+
+	var ranked []rankedInstanceType
+	for i, row := range table.rows {
+		instanceType := row[instanceTypeIdx]
+		if regionIdx >= 0 {
+			instanceType = regionalKey(row[regionIdx], instanceType)
+		}
+		price, ok := source.GetPrice(instanceType)
+		pricePerHour := price.ByType(priceType)
+		if !ok || pricePerHour <= 0 {
+			table.rows[i] = append(row, notApplicable, notApplicable)
+			continue
+		}
+		executionTime, err := strconv.ParseFloat(row[executionTimeIdx], 64)
+		if err != nil {
+			table.rows[i] = append(row, fmt.Sprintf("%.4f", pricePerHour), notApplicable)
+			continue
+		}
+		costPerRun := pricePerHour * executionTime / secondsPerHour
+		table.rows[i] = append(row, fmt.Sprintf("%.4f", pricePerHour), fmt.Sprintf("%.4f", costPerRun))
+		if row[statusIdx] == statusSuccess && row[allTestsPassIdx] == strconv.FormatBool(true) {
+			ranked = append(ranked, rankedInstanceType{instanceType: instanceType, pricePerHour: pricePerHour, costPerRun: costPerRun})
+		}
+	}
+	if len(ranked) == 0 {
+		return nil
+	}
+
+	// Rank by hourly price; the cost of the run breaks ties since a faster instance type finishes sooner
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].pricePerHour != ranked[j].pricePerHour {
+			return ranked[i].pricePerHour < ranked[j].pricePerHour
+		}
+		return ranked[i].costPerRun < ranked[j].costPerRun
+	})
+	rec := &recommendation{
+		InstanceType: ranked[0].instanceType,
+		PricePerHour: fmt.Sprintf("%.4f", ranked[0].pricePerHour),
+		CostPerRun:   fmt.Sprintf("%.4f", ranked[0].costPerRun),
+	}
+	for _, instanceType := range ranked {
+		rec.Ranking = append(rec.Ranking, instanceType.instanceType)
+	}
+	return rec
+}
+
+// String returns the recommended line of the report.
+func (r *recommendation) String() string {
+	if r == nil {
+		return "No passing instance type has a known price to recommend"
+	}
+	return fmt.Sprintf("Recommended instance type: %s (%s $/hour, %s $ per run). Passing instance types from cheapest: %s",
+		r.InstanceType, r.PricePerHour, r.CostPerRun, strings.Join(r.Ranking, ", "))
+}
+
+// indexOf returns the index of the string in the slice, or -1 if it isn't found.
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+func costTable() reportTable {
+	return reportTable{
+		header: []string{instanceTypeHeader, statusHeader, allTestsPassHeader, executionTimeHeader},
+		rows: [][]string{
+			{"m4.xlarge", statusSuccess, "true", "1800.00"},
+			{"m4.large", statusSuccess, "true", "3600.00"},
+			{"c5.large", statusFail, "true", "3600.00"},
+			{"m5.large", statusSuccess, "true", "3600.00"},
+			{"a1.large", notApplicable, notApplicable, notApplicable},
+		},
+	}
+}
+
+var testPrices = pricing.StaticSource{
+	"m4.xlarge": {OnDemand: 0.2, Spot: 0.06},
+	"m4.large":  {OnDemand: 0.1, Spot: 0.03},
+	"c5.large":  {OnDemand: 0.085, Spot: 0.02},
+	"a1.large":  {OnDemand: 0.051},
+}
+
+// Tests
+
+func TestAppendCostsOnDemand(t *testing.T) {
+	table := costTable()
+	rec := appendCosts(&table, testPrices, pricing.OnDemand)
+
+	h.Equals(t, []string{instanceTypeHeader, statusHeader, allTestsPassHeader, executionTimeHeader, "ON-DEMAND PRICE ($/hour)", "COST PER RUN ($)"}, table.header)
+	h.Equals(t, []string{"0.2000", "0.1000"}, table.rows[0][4:])
+	h.Equals(t, []string{"0.0850", "0.0850"}, table.rows[2][4:])
+	h.Equals(t, []string{"N/A", "N/A"}, table.rows[3][4:])
+	h.Equals(t, []string{"0.0510", "N/A"}, table.rows[4][4:])
+	h.Equals(t, &recommendation{
+		InstanceType: "m4.large",
+		PricePerHour: "0.1000",
+		CostPerRun:   "0.1000",
+		Ranking:      []string{"m4.large", "m4.xlarge"},
+	}, rec)
+	h.Equals(t, "Recommended instance type: m4.large (0.1000 $/hour, 0.1000 $ per run). Passing instance types from cheapest: m4.large, m4.xlarge", rec.String())
+}
+
+func TestAppendCostsSpot(t *testing.T) {
+	table := costTable()
+	rec := appendCosts(&table, testPrices, pricing.Spot)
+	h.Equals(t, "SPOT PRICE ($/hour)", table.header[4])
+	h.Equals(t, []string{"N/A", "N/A"}, table.rows[4][4:])
+	h.Equals(t, "m4.large", rec.InstanceType)
+}
+
+func TestAppendCostsNoRecommendation(t *testing.T) {
+	table := costTable()
+	rec := appendCosts(&table, pricing.StaticSource{}, pricing.OnDemand)
+	h.Assert(t, rec == nil, "Recommended an instance type without a known price")
+	h.Equals(t, "No passing instance type has a known price to recommend", rec.String())
+}
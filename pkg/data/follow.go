@@ -0,0 +1,108 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+// follower prints the chunks of the logs of the instances of a run as their agents upload them, like `docker compose
+// logs --follow`: each line is prefixed with the instance type, and the instance ID if the instance type has replicas.
+type follower struct {
+	store        resources.ResultStore
+	bucket       string
+	outputStream io.Writer
+	logs         []*followedLog
+}
+
+// followedLog is the log of an instance, of which the chunks before next were printed. The last line of the chunks
+// printed is held back until it is complete.
+type followedLog struct {
+	prefix  string
+	layout  resources.InstanceLayout
+	next    int
+	partial []byte
+}
+
+func newFollower(store resources.ResultStore, bucket string, layout resources.BucketLayout, instances []resources.Instance, outputStream io.Writer) *follower {
+	replicas := make(map[string]int)
+	for _, instance := range instances {
+		replicas[instance.InstanceType]++
+	}
+	labels := make([]string, len(instances))
+	width := 0
+	for i, instance := range instances {
+		labels[i] = instance.InstanceType
+		if replicas[instance.InstanceType] > 1 {
+			labels[i] += "/" + instance.InstanceId
+		}
+		if len(labels[i]) > width {
+			width = len(labels[i])
+		}
+	}
+
+	f := &follower{store: store, bucket: bucket, outputStream: outputStream}
+	for i, instance := range instances {
+		f.logs = append(f.logs, &followedLog{
+			prefix: fmt.Sprintf("%-*s | ", width, labels[i]),
+			layout: layout.Instance(instance.InstanceType, instance.InstanceId),
+		})
+	}
+	return f
+}
+
+// follow prints the chunks uploaded since the previous call, in order, given the keys of the objects of the run. A
+// chunk which fails to download is printed by the next call.
+func (f *follower) follow(uploaded map[string]bool) {
+	for _, l := range f.logs {
+		for uploaded[l.layout.LogChunk(l.next)] {
+			chunk, err := f.store.Get(f.bucket, l.layout.LogChunk(l.next))
+			if err != nil {
+				log.Println(err)
+				break
+			}
+			l.print(f.outputStream, chunk)
+			l.next++
+		}
+	}
+}
+
+// flush prints the last line of every log, which may never be completed.
+func (f *follower) flush() {
+	for _, l := range f.logs {
+		if len(l.partial) > 0 {
+			fmt.Fprintf(f.outputStream, "%s%s\n", l.prefix, l.partial)
+			l.partial = nil
+		}
+	}
+}
+
+// print prints every complete line of the log, with its prefix.
+func (l *followedLog) print(outputStream io.Writer, chunk []byte) {
+	lines := append(l.partial, chunk...)
+	for {
+		i := bytes.IndexByte(lines, '\n')
+		if i < 0 {
+			break
+		}
+		fmt.Fprintf(outputStream, "%s%s\n", l.prefix, lines[:i])
+		lines = lines[i+1:]
+	}
+	l.partial = append([]byte(nil), lines...)
+}
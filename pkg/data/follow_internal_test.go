@@ -0,0 +1,59 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestFollowerFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "follower")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	store := resources.LocalStore{Dir: dir}
+	h.Ok(t, store.CreateBucket("qualifier-bucket"))
+	layout := resources.BucketLayout{RootDir: "Instance-Qualifier-Run-123"}
+	instances := []resources.Instance{
+		{InstanceId: "i-1", InstanceType: "m4.large"},
+		{InstanceId: "i-2", InstanceType: "m4.large"},
+		{InstanceId: "i-3", InstanceType: "c5.xlarge"},
+	}
+	var output bytes.Buffer
+	f := newFollower(store, "qualifier-bucket", layout, instances, &output)
+
+	uploaded := make(map[string]bool)
+	put := func(key string, content string) {
+		h.Ok(t, store.Put("qualifier-bucket", key, bytes.NewReader([]byte(content))))
+		uploaded[key] = true
+	}
+	put(layout.Instance("m4.large", "i-1").LogChunk(0), "first\nsec")
+	put(layout.Instance("c5.xlarge", "i-3").LogChunk(0), "other\n")
+	f.follow(uploaded)
+	h.Equals(t, "m4.large/i-1 | first\nc5.xlarge    | other\n", output.String())
+
+	output.Reset()
+	put(layout.Instance("m4.large", "i-1").LogChunk(1), "ond\nlast")
+	f.follow(uploaded)
+	f.follow(uploaded)
+	f.flush()
+	h.Equals(t, "m4.large/i-1 | second\nm4.large/i-1 | last\n", output.String())
+}
@@ -14,123 +14,278 @@
 package data
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 )
 
 const (
-	finalOutputTableHeader = "INSTANCE TYPE,STATUS,CPU_USAGE_ACTIVE,CPU_THRESHOLD,MEM_USED_PERCENT,MEM_THRESHOLD,ALL TESTS PASS?,TOTAL EXECUTION TIME (sec)"
-	notApplicable          = "N/A"
-	instanceIdRegex        = "i-[0-9a-z]{17}"
+	instanceTypeHeader        = "INSTANCE TYPE"
+	statusHeader              = "STATUS"
+	allTestsPassHeader        = "ALL TESTS PASS?"
+	executionTimeHeader       = "TOTAL EXECUTION TIME (sec)"
+	thresholdRuleHeader       = "THRESHOLD RULE"
+	testFileHeader            = "TEST FILE"
+	testPassHeader            = "TEST PASS?"
+	testExecutionTimeHeader   = "EXECUTION TIME (sec)"
+	replicasHeader            = "REPLICAS"
+	passRateHeader            = "PASS RATE"
+	meanExecutionTimeHeader   = "MEAN EXECUTION TIME (sec)"
+	stddevExecutionTimeHeader = "STDDEV EXECUTION TIME (sec)"
+	minSuffix                 = " MIN"
+	maxSuffix                 = " MAX"
+	metricHeader              = "METRIC"
+	minHeader                 = "MIN"
+	maxHeader                 = "MAX"
+	sparklineHeader           = "TIME SERIES (1 min per point)"
+	sparklineWidth            = 60
+	testTableTitle            = "Metrics of each test file, measured over its own execution window"
+	seriesTableTitle          = "Metrics over time of each instance type"
+	replicaTableTitle         = "Results across the replicas of each instance type"
+	notApplicable             = "N/A"
+	instanceIdRegex           = "i-[0-9a-z]{17}"
 )
 
-// OutputAsTable parses the final result json file and outputs in table format.
-func OutputAsTable(sess *session.Session, outputStream *os.File, results []*cloudwatch.MetricDataResult) error {
-	svc := resources.New(sess)
+// RegionResults are the results of a run, or of one region of a multi-region run.
+type RegionResults struct {
+	// Region is empty for a single-region run.
+	Region               string
+	TestFixture          config.TestFixture
+	FinalResult          []resources.Instance
+	MissingInstanceTypes []string
+	DetailedResultsUrl   string
+}
+
+// CollectResults parses the final result json file of the run of the test fixture, merges the CloudWatch data of
+// each test file, and finds the instance types without results. The CloudWatch data merged by a previous attempt is
+// replaced, so that the metrics can be collected again when the run is resumed.
+func CollectResults(svc *resources.Resources, region string) (RegionResults, error) {
 	testFixture := config.GetTestFixture()
-	finalResult, err := updateResults(results, testFixture)
+	finalResult, err := finalResultToArray(testFixture.FinalResultFilename)
 	if err != nil {
-		return err
+		return RegionResults{}, err
+	}
+	for i := range finalResult {
+		for j := range finalResult[i].Results {
+			finalResult[i].Results[j].Metrics = make([]resources.Metric, 0)
+		}
+		finalResult[i].Series = nil
+	}
+	results, seriesResults, err := svc.GetCloudWatchData(finalResult, testFixture)
+	if err != nil {
+		return RegionResults{}, err
 	}
+	if err := updateResults(results, finalResult, testFixture); err != nil {
+		return RegionResults{}, err
+	}
+	updateSeries(seriesResults, finalResult, testFixture)
 
 	log.Println("Updating local and remote results files after merging CloudWatch data")
 	localPath := resultsDir + "/" + testFixture.FinalResultFilename
-	remotePath := testFixture.BucketRootDir + "/" + testFixture.FinalResultFilename
+	remotePath := resources.BucketLayout{RootDir: testFixture.BucketRootDir}.FinalResult(testFixture.FinalResultFilename)
 	if err := cmdutil.MarshalToFile(finalResult, localPath); err != nil {
 		log.Printf("There was an error saving updated results locally. final result: %v\n", finalResult)
 	}
-	if err := svc.UploadToBucket(testFixture.BucketName, localPath, remotePath); err != nil {
+	if err := resources.PutFile(svc.Store, testFixture.BucketName, localPath, remotePath); err != nil {
 		log.Println("There was an error uploading updated results to S3")
 	}
 
-	var tableData [][]string
+	instances, err := svc.GetInstancesInCfnStack()
+	if err != nil {
+		return RegionResults{}, err
+	}
+	return RegionResults{
+		Region:               region,
+		TestFixture:          testFixture,
+		FinalResult:          finalResult,
+		MissingInstanceTypes: findMissingInstanceTypes(instances, finalResult),
+		DetailedResultsUrl:   svc.Store.Location(testFixture.BucketName, testFixture.BucketRootDir),
+	}, nil
+}
+
+// findMissingInstanceTypes returns the instance types of the instances which have no result on any of their
+// replicas, once each.
+func findMissingInstanceTypes(instances []resources.Instance, finalResult []resources.Instance) (missingInstanceTypes []string) {
+	isFound := make(map[string]bool)
 	for _, instanceResult := range finalResult {
-		row, err := parseInstanceResultToRow(instanceResult)
-		if err != nil {
-			return err
+		isFound[instanceResult.InstanceType] = true
+	}
+	for _, instance := range instances {
+		if !isFound[instance.InstanceType] {
+			missingInstanceTypes = append(missingInstanceTypes, instance.InstanceType)
+			isFound[instance.InstanceType] = true
 		}
-		tableData = append(tableData, row)
 	}
+	return missingInstanceTypes
+}
 
-	instances, err := svc.GetInstancesInCfnStack()
-	if err != nil {
+// FetchFinalResult downloads the final result of the run of the test fixture, which is complete once all instance
+// results are polled, to the local results directory.
+func FetchFinalResult(svc *resources.Resources) error {
+	testFixture := config.GetTestFixture()
+	if err := os.MkdirAll(resultsDir, os.ModePerm); err != nil {
 		return err
 	}
-	for _, instance := range instances {
-		isFound := false
-		for _, instanceResult := range finalResult {
-			if instance.InstanceType == instanceResult.InstanceType {
-				isFound = true
+	localPath := resultsDir + "/" + testFixture.FinalResultFilename
+	remotePath := resources.BucketLayout{RootDir: testFixture.BucketRootDir}.FinalResult(testFixture.FinalResultFilename)
+	return resources.GetFile(svc.Store, testFixture.BucketName, localPath, remotePath)
+}
+
+// RestoreResults parses the final result json file of a run which was already reported, whose CloudWatch data is
+// merged and whose stack may be deleted.
+func RestoreResults(svc *resources.Resources, region string, missingInstanceTypes []string) (RegionResults, error) {
+	testFixture := config.GetTestFixture()
+	finalResult, err := finalResultToArray(testFixture.FinalResultFilename)
+	if err != nil {
+		return RegionResults{}, err
+	}
+	return RegionResults{
+		Region:               region,
+		TestFixture:          testFixture,
+		FinalResult:          finalResult,
+		MissingInstanceTypes: missingInstanceTypes,
+		DetailedResultsUrl:   svc.Store.Location(testFixture.BucketName, testFixture.BucketRootDir),
+	}, nil
+}
+
+// OutputReport outputs the report of the results with the given options, and returns the summary of the run. The
+// results of the regions of a multi-region run are merged into a single report with a region column.
+func OutputReport(regionResults []RegionResults, options ReportOptions, outputStream *os.File) (Summary, error) {
+	if len(regionResults) == 0 {
+		return Summary{}, errors.New("no results to report")
+	}
+	definitions, err := metrics.Select(regionResults[0].TestFixture.Metrics)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var r report
+	if len(regionResults) == 1 {
+		results := regionResults[0]
+		r, err = newReport(results.FinalResult, results.MissingInstanceTypes, definitions, results.TestFixture, options)
+		r.detailedResultsUrl = results.DetailedResultsUrl
+	} else {
+		r, err = newMultiRegionReport(regionResults, definitions, options)
+	}
+	if err != nil {
+		return Summary{}, err
+	}
+	return r.summary, r.render(options.Format, outputStream)
+}
+
+// updateResults updates the final result with the CloudWatch data of each test file and the thresholds resolved
+// for it.
+func updateResults(results []*cloudwatch.MetricDataResult, finalResult []resources.Instance, testFixture config.TestFixture) error {
+	resultIdxByInstanceId := make(map[string]int)
+	for i, instanceResult := range finalResult {
+		resultIdxByInstanceId[instanceResult.InstanceId] = i
+	}
+
+	for _, metricData := range results {
+		if metricData.Values == nil || len(metricData.Values) == 0 {
+			continue
+		}
+		splitLabel := strings.Split(*metricData.Label, " ")
+		var instanceId string
+		for i, tag := range splitLabel {
+			matched, err := regexp.MatchString(instanceIdRegex, tag)
+			if err != nil {
+				log.Println("Could not extract instanceId from MetricDataResult")
+				return err
+			}
+			if matched {
+				instanceId = splitLabel[i]
 				break
 			}
 		}
-		if !isFound {
-			var row []string
-			row = append(row, instance.InstanceType, notApplicable, notApplicable, notApplicable, notApplicable, notApplicable, notApplicable, notApplicable)
-			tableData = append(tableData, row)
+		instanceIdx, ok := resultIdxByInstanceId[instanceId]
+		if !ok || len(splitLabel) < 2 {
+			continue
+		}
+		instanceResult := finalResult[instanceIdx]
+		metricName := splitLabel[len(splitLabel)-1] //name is always last in label
+		resultIdx, err := strconv.Atoi(splitLabel[len(splitLabel)-2])
+		if err != nil || resultIdx < 0 || resultIdx >= len(instanceResult.Results) {
+			log.Printf("Could not extract the test result index from MetricDataResult %s\n", *metricData.Label)
+			continue
 		}
+		definition, ok := metrics.Get(metricName)
+		if !ok {
+			log.Printf("Skipping unsupported metric %s\n", metricName)
+			continue
+		}
+
+		result := &instanceResult.Results[resultIdx]
+		metric := resources.Metric{
+			MetricUsed: metricName,
+			Value:      maxValue(metricData.Values),
+			Statistic:  testFixture.MetricStatistic(metricName),
+			Direction:  resolveDirection(testFixture, definition),
+			Unit:       definition.Unit,
+		}
+		metric.Threshold, metric.Rule = resolveThreshold(testFixture, metricName, instanceResult.InstanceType, result.Label)
+		metric.Breached = metrics.IsBreached(metric.Value, metric.Threshold, metric.Direction)
+		result.Metrics = append(result.Metrics, metric)
 	}
-	cmdutil.RenderTable(tableData, strings.Split(finalOutputTableHeader, ","), outputStream)
-	fmt.Fprintf(outputStream, "\nDetailed test results can be found in s3://%s/%s\n", testFixture.BucketName, testFixture.BucketRootDir)
 	return nil
 }
 
-// updateResults updates the FinalResult of a test fixture with corresponding CloudWatch data
-// then saves updates results file locally and remotely
-func updateResults(results []*cloudwatch.MetricDataResult, testFixture config.TestFixture) ([]resources.Instance, error) {
-	cwMetrics := make(map[string][]resources.Metric)
-	metricThresholds := map[string]int{
-		"cpu_usage_active": testFixture.CpuThreshold,
-		"mem_used_percent": testFixture.MemThreshold,
+// updateSeries updates the final result with the CloudWatch time series of each instance.
+func updateSeries(results []*cloudwatch.MetricDataResult, finalResult []resources.Instance, testFixture config.TestFixture) {
+	resultIdxByInstanceId := make(map[string]int)
+	for i, instanceResult := range finalResult {
+		resultIdxByInstanceId[instanceResult.InstanceId] = i
 	}
+
 	for _, metricData := range results {
-		if metricData.Values != nil {
-			splitLabel := strings.Split(*metricData.Label, " ")
-			var instanceId string
-			for i, tag := range splitLabel {
-				matched, err := regexp.MatchString(instanceIdRegex, tag)
-				if err != nil {
-					log.Println("Could not extract instanceId from MetricDataResult")
-					return nil, err
-				}
-				if matched {
-					instanceId = splitLabel[i]
-					break
-				}
-			}
-			if instanceId != "" {
-				metricName := splitLabel[len(splitLabel)-1] //name is always last in label
-				metricValue := *metricData.Values[0]
-				thresholdValue := metricThresholds[metricName]
-				metric := resources.Metric{
-					MetricUsed: metricName,
-					Value:      metricValue,
-					Threshold:  float64(thresholdValue),
-					Unit:       "Percent", //UserConfig
-				}
-				cwMetrics[instanceId] = append(cwMetrics[instanceId], metric)
+		splitLabel := strings.Split(*metricData.Label, " ")
+		instanceId := regexp.MustCompile(instanceIdRegex).FindString(*metricData.Label)
+		instanceIdx, ok := resultIdxByInstanceId[instanceId]
+		if !ok || len(metricData.Values) == 0 {
+			continue
+		}
+		metricName := splitLabel[len(splitLabel)-1] //name is always last in label
+		definition, ok := metrics.Get(metricName)
+		if !ok {
+			log.Printf("Skipping unsupported metric %s\n", metricName)
+			continue
+		}
+
+		series := resources.MetricSeries{
+			MetricUsed: metricName,
+			Statistic:  testFixture.MetricStatistic(metricName),
+			Unit:       definition.Unit,
+		}
+		for i, value := range metricData.Values {
+			if i >= len(metricData.Timestamps) {
+				break
 			}
+			series.DataPoints = append(series.DataPoints, resources.DataPoint{
+				Timestamp: metricData.Timestamps[i].UTC().Format(time.RFC3339),
+				Value:     *value,
+			})
 		}
+		finalResult[instanceIdx].Series = append(finalResult[instanceIdx].Series, series)
 	}
+}
 
-	finalResult, err := finalResultToArray(testFixture.FinalResultFilename)
-	if err != nil {
-		return finalResult, err
-	}
-	for _, instanceResult := range finalResult {
-		oldRes := instanceResult.Results
-		for i := range oldRes {
-			oldRes[i].Metrics = cwMetrics[instanceResult.InstanceId]
+// maxValue returns the largest of the values.
+func maxValue(values []*float64) float64 {
+	max := *values[0]
+	for _, value := range values[1:] {
+		if *value > max {
+			max = *value
 		}
 	}
-	return finalResult, nil
+	return max
 }
@@ -0,0 +1,37 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+func TestFindMissingInstanceTypes(t *testing.T) {
+	instances := []resources.Instance{
+		{InstanceId: "i-1", InstanceType: "m4.large"},
+		{InstanceId: "i-2", InstanceType: "m4.large"},
+		{InstanceId: "i-3", InstanceType: "c5.large"},
+		{InstanceId: "i-4", InstanceType: "c5.large"},
+		{InstanceId: "i-5", InstanceType: "c5.large"},
+		{InstanceId: "i-6", InstanceType: "t3.micro"},
+	}
+	// Only one replica of m4.large reported, so it isn't missing
+	finalResult := []resources.Instance{{InstanceId: "i-2", InstanceType: "m4.large"}}
+
+	h.Equals(t, []string{"c5.large", "t3.micro"}, findMissingInstanceTypes(instances, finalResult))
+	h.Equals(t, 0, len(findMissingInstanceTypes(instances[:2], finalResult)))
+}
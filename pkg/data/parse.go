@@ -17,17 +17,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 )
 
 const (
-	cpuMetric     = "cpu_usage_active"
-	memMetric     = "mem_used_percent"
-	resultFail    = "fail"
-	statusSuccess = "SUCCESS"
-	statusFail    = "FAIL"
+	resultFail        = "fail"
+	statusSuccess     = "SUCCESS"
+	statusFail        = "FAIL"
+	statusInterrupted = "INTERRUPTED"
+	statusSetupFailed = "SETUP_FAILED"
 )
 
 // finalResultToArray parses the final result json file, populates and returns the instance results array.
@@ -45,57 +51,331 @@ func finalResultToArray(finalResult string) ([]resources.Instance, error) {
 	return v, nil
 }
 
-// parseInstanceResultToRow parses the instance result, populates and returns the row data which is used to
-// generate the final output table.
-func parseInstanceResultToRow(instanceResult resources.Instance) (row []string, err error) {
-	maxCPU := 0.0
-	maxMem := 0.0
-	cpuThreshold := 0.0
-	memThreshold := 0.0
-	totalExecutionTime := 0.0
-	success := true
-	allTestsPass := true
+// instanceSummary is the outcome of the test suite on one instance.
+type instanceSummary struct {
+	worstValues   map[string]float64
+	thresholds    map[string]float64
+	directions    map[string]string
+	success       bool
+	allTestsPass  bool
+	executionTime float64
+	rules         []string
+	isSetupFailed bool
+}
+
+// summarizeInstanceResult aggregates the results of all test files executed on an instance. The value of each metric
+// is its worst across all test files, i.e. its max if it must stay below its threshold and its min if it must stay
+// above it, along with the threshold of the test file where it was reached. The execution time is the wall-clock span
+// of the test files, which may run concurrently, or the sum of their execution times for results recorded without
+// start and end times. An instance whose setup failed executed no test, so it neither succeeds nor passes its tests.
+func summarizeInstanceResult(instanceResult resources.Instance) (summary instanceSummary, err error) {
+	isSetupFailed := isSetupFailed(instanceResult)
+	summary = instanceSummary{
+		worstValues:   make(map[string]float64),
+		thresholds:    make(map[string]float64),
+		directions:    make(map[string]string),
+		success:       !isSetupFailed,
+		allTestsPass:  !instanceResult.IsTimeout && !instanceResult.IsInterrupted && !isSetupFailed,
+		isSetupFailed: isSetupFailed,
+	}
 
 	for _, result := range instanceResult.Results {
 		if result.Status == resultFail {
-			allTestsPass = false
+			summary.allTestsPass = false
 		}
 
 		if executionTime, err := strconv.ParseFloat(result.ExecutionTime, 64); err == nil {
-			totalExecutionTime += executionTime
+			summary.executionTime += executionTime
 		} else {
-			return nil, err
+			return summary, err
 		}
 
 		for _, metric := range result.Metrics {
-			if metric.MetricUsed == cpuMetric {
-				maxCPU = metric.Value
-				cpuThreshold = metric.Threshold
-			} else if metric.MetricUsed == memMetric {
-				maxMem = metric.Value
-				memThreshold = metric.Threshold
+			if worstValue, ok := summary.worstValues[metric.MetricUsed]; !ok || metrics.IsWorse(metric.Value, worstValue, metric.Direction) {
+				summary.worstValues[metric.MetricUsed] = metric.Value
+				summary.thresholds[metric.MetricUsed] = metric.Threshold
+				summary.directions[metric.MetricUsed] = metric.Direction
+			}
+			if metrics.IsBreached(metric.Value, metric.Threshold, metric.Direction) {
+				summary.success = false
+			}
+			if metric.Rule != "" && !contains(summary.rules, metric.Rule) {
+				summary.rules = append(summary.rules, metric.Rule)
+			}
+		}
+	}
+	if span, ok := executionSpan(instanceResult.Results); ok {
+		summary.executionTime = span
+	}
+
+	return summary, nil
+}
+
+// executionSpan returns the seconds from the earliest start to the latest end of the test files. It isn't ok if there
+// is no test file or one of them has no start or end time.
+func executionSpan(results []resources.Result) (span float64, ok bool) {
+	var earliestStart, latestEnd time.Time
+	for _, result := range results {
+		start, startErr := time.Parse(time.RFC3339, result.StartTime)
+		end, endErr := time.Parse(time.RFC3339, result.EndTime)
+		if startErr != nil || endErr != nil {
+			return 0, false
+		}
+		if earliestStart.IsZero() || start.Before(earliestStart) {
+			earliestStart = start
+		}
+		if end.After(latestEnd) {
+			latestEnd = end
+		}
+	}
+	if earliestStart.IsZero() {
+		return 0, false
+	}
+	return latestEnd.Sub(earliestStart).Seconds(), true
+}
+
+// parseInstanceResultToRow parses the instance result, populates and returns the row data which is used to
+// generate the final output table. Each of the given metrics has a value column and a threshold column, showing
+// the worst value across all test files in the direction of the metric and the threshold of the test file where it
+// was reached.
+func parseInstanceResultToRow(instanceResult resources.Instance, definitions []metrics.Definition) (row []string, err error) {
+	row, _, err = parseInstanceGroupToRows([]resources.Instance{instanceResult}, definitions, 1, 1)
+	return row, err
+}
+
+// parseInstanceGroupToRows parses the results of all replicas of an instance type, populates and returns the row data
+// of the final output table and of the replica table. In the final output table, the value of each metric is its worst
+// across all replicas and the execution time is the mean across replicas, while the replica table shows the spread of
+// the values of the replicas. The instance type succeeds if the fraction of its replicas which stay within the
+// thresholds is at least the required pass rate. Of the replicaCount replicas launched, those which never reported
+// count as failed. Interrupted replicas are not counted, and the instance type is INTERRUPTED if all of them are. The
+// instance type is SETUP_FAILED if the setup of the test suite failed on all other replicas.
+func parseInstanceGroupToRows(group []resources.Instance, definitions []metrics.Definition, requiredPassRate float64, replicaCount int) (row []string, replicaRow []string, err error) {
+	var summaries, interruptedSummaries []instanceSummary
+	for _, instanceResult := range group {
+		summary, err := summarizeInstanceResult(instanceResult)
+		if err != nil {
+			return nil, nil, err
+		}
+		if instanceResult.IsInterrupted {
+			interruptedSummaries = append(interruptedSummaries, summary)
+		} else {
+			summaries = append(summaries, summary)
+		}
+	}
+	missing := replicaCount - len(group)
+	if missing < 0 {
+		missing = 0
+	}
+	isInterrupted := len(summaries) == 0
+	if isInterrupted {
+		// Still report the partial results of the interrupted replicas
+		summaries = interruptedSummaries
+	}
+
+	merged := instanceSummary{
+		worstValues:  make(map[string]float64),
+		thresholds:   make(map[string]float64),
+		allTestsPass: true,
+	}
+	passed, setupFailed := 0, 0
+	var executionTimes []float64
+	minValues := make(map[string]float64)
+	maxValues := make(map[string]float64)
+	for _, summary := range summaries {
+		if summary.success {
+			passed++
+		}
+		if summary.isSetupFailed {
+			setupFailed++
+		}
+		if !summary.allTestsPass {
+			merged.allTestsPass = false
+		}
+		executionTimes = append(executionTimes, summary.executionTime)
+		for metricName, value := range summary.worstValues {
+			if worstValue, ok := merged.worstValues[metricName]; !ok || metrics.IsWorse(value, worstValue, summary.directions[metricName]) {
+				merged.worstValues[metricName] = value
+				merged.thresholds[metricName] = summary.thresholds[metricName]
 			}
-			if metric.Value >= metric.Threshold {
-				success = false
+			if minValue, ok := minValues[metricName]; !ok || value < minValue {
+				minValues[metricName] = value
+			}
+			if maxValue, ok := maxValues[metricName]; !ok || value > maxValue {
+				maxValues[metricName] = value
+			}
+		}
+		for _, rule := range summary.rules {
+			if !contains(merged.rules, rule) {
+				merged.rules = append(merged.rules, rule)
 			}
 		}
 	}
+	meanExecutionTime, stddevExecutionTime := meanAndStddev(executionTimes)
+	counted := len(summaries) + missing
+	if isInterrupted {
+		passed, counted = 0, missing
+	}
 
-	row = append(row, instanceResult.InstanceType)
+	row = append(row, group[0].InstanceType)
+	if counted == 0 {
+		row = append(row, statusInterrupted)
+	} else if !isInterrupted && missing == 0 && setupFailed == len(summaries) {
+		row = append(row, statusSetupFailed)
+	} else if float64(passed) >= requiredPassRate*float64(counted) {
+		row = append(row, statusSuccess)
+	} else {
+		row = append(row, statusFail)
+	}
+	for _, definition := range definitions {
+		row = append(row, fmt.Sprintf("%.2f", merged.worstValues[definition.Name]))
+		row = append(row, fmt.Sprintf("%.2f", merged.thresholds[definition.Name]))
+	}
+	row = append(row, strconv.FormatBool(merged.allTestsPass))
+	row = append(row, fmt.Sprintf("%.2f", meanExecutionTime))
+	if len(merged.rules) > 0 {
+		row = append(row, strings.Join(merged.rules, ","))
+	} else {
+		row = append(row, notApplicable)
+	}
+
+	replicaRow = append(replicaRow, group[0].InstanceType, strconv.Itoa(len(group)+missing))
+	if counted == 0 {
+		replicaRow = append(replicaRow, notApplicable)
+	} else {
+		replicaRow = append(replicaRow, fmt.Sprintf("%d/%d", passed, counted))
+	}
+	replicaRow = append(replicaRow, fmt.Sprintf("%.2f", meanExecutionTime), fmt.Sprintf("%.2f", stddevExecutionTime))
+	for _, definition := range definitions {
+		replicaRow = append(replicaRow, fmt.Sprintf("%.2f", minValues[definition.Name]), fmt.Sprintf("%.2f", maxValues[definition.Name]))
+	}
+
+	return row, replicaRow, nil
+}
+
+// groupByInstanceType groups the instance results by instance type, in the order each instance type first appears.
+func groupByInstanceType(finalResult []resources.Instance) (groups [][]resources.Instance) {
+	groupIdxByInstanceType := make(map[string]int)
+	for _, instanceResult := range finalResult {
+		idx, ok := groupIdxByInstanceType[instanceResult.InstanceType]
+		if !ok {
+			idx = len(groups)
+			groupIdxByInstanceType[instanceResult.InstanceType] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], instanceResult)
+	}
+	return groups
+}
+
+// meanAndStddev returns the mean and the population standard deviation of the values.
+func meanAndStddev(values []float64) (mean float64, stddev float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	for _, value := range values {
+		stddev += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(values)))
+}
+
+// parseTestResultToRow parses the result of one test file executed on an instance type, populates and returns the
+// row data which is used to generate the per-test output table.
+func parseTestResultToRow(instanceType string, result resources.Result, definitions []metrics.Definition) (row []string) {
+	values := make(map[string]string)
+	success := true
+	for _, metric := range result.Metrics {
+		values[metric.MetricUsed] = fmt.Sprintf("%.2f", metric.Value)
+		if metrics.IsBreached(metric.Value, metric.Threshold, metric.Direction) {
+			success = false
+		}
+	}
+
+	row = append(row, instanceType, result.Label)
 	if success {
 		row = append(row, statusSuccess)
 	} else {
 		row = append(row, statusFail)
 	}
-	row = append(row, fmt.Sprintf("%.2f", maxCPU))
-	row = append(row, fmt.Sprintf("%.2f", cpuThreshold))
-	row = append(row, fmt.Sprintf("%.2f", maxMem))
-	row = append(row, fmt.Sprintf("%.2f", memThreshold))
-	if instanceResult.IsTimeout {
-		allTestsPass = false
+	for _, definition := range definitions {
+		if value, ok := values[definition.Name]; ok {
+			row = append(row, value)
+		} else {
+			row = append(row, notApplicable)
+		}
 	}
-	row = append(row, strconv.FormatBool(allTestsPass))
-	row = append(row, fmt.Sprintf("%.2f", totalExecutionTime))
+	row = append(row, strconv.FormatBool(result.Status != resultFail), result.ExecutionTime)
 
-	return row, nil
+	return row
+}
+
+// parseSeriesToRow parses the time series of a metric on an instance type, populates and returns the row data which
+// is used to generate the time series output table.
+func parseSeriesToRow(instanceType string, series resources.MetricSeries) (row []string) {
+	var values []float64
+	for _, dataPoint := range series.DataPoints {
+		values = append(values, dataPoint.Value)
+	}
+	row = append(row, instanceType, series.MetricUsed)
+	if len(values) == 0 {
+		return append(row, notApplicable, notApplicable, notApplicable)
+	}
+
+	min, max := values[0], values[0]
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+	}
+	return append(row, fmt.Sprintf("%.2f", min), fmt.Sprintf("%.2f", max), cmdutil.Sparkline(values, sparklineWidth))
+}
+
+// isSetupFailed checks whether the setup of the test suite failed on the instance.
+func isSetupFailed(instanceResult resources.Instance) bool {
+	return instanceResult.Setup != nil && instanceResult.Setup.Status == resultFail
+}
+
+// contains checks whether the string slice contains the string.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// tableHeader returns the header of the final output table for the given metrics.
+func tableHeader(definitions []metrics.Definition, testFixture config.TestFixture) []string {
+	header := []string{instanceTypeHeader, statusHeader}
+	for _, definition := range definitions {
+		header = append(header, definition.ValueHeader(testFixture.MetricStatistic(definition.Name)), definition.ThresholdHeader())
+	}
+	return append(header, allTestsPassHeader, executionTimeHeader, thresholdRuleHeader)
+}
+
+// replicaTableHeader returns the header of the replica output table for the given metrics.
+func replicaTableHeader(definitions []metrics.Definition) []string {
+	header := []string{instanceTypeHeader, replicasHeader, passRateHeader, meanExecutionTimeHeader, stddevExecutionTimeHeader}
+	for _, definition := range definitions {
+		name := strings.ToUpper(definition.Name)
+		header = append(header, name+minSuffix, name+maxSuffix)
+	}
+	return header
+}
+
+// testTableHeader returns the header of the per-test output table for the given metrics.
+func testTableHeader(definitions []metrics.Definition, testFixture config.TestFixture) []string {
+	header := []string{instanceTypeHeader, testFileHeader, statusHeader}
+	for _, definition := range definitions {
+		header = append(header, definition.ValueHeader(testFixture.MetricStatistic(definition.Name)))
+	}
+	return append(header, testPassHeader, testExecutionTimeHeader)
 }
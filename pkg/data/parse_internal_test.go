@@ -16,7 +16,12 @@ package data
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
@@ -39,12 +44,14 @@ var globalInstanceResult = resources.Instance{
 					MetricUsed: "cpu_usage_active",
 					Value:      35.8,
 					Threshold:  40.0,
+					Rule:       "default",
 					Unit:       "Percent",
 				},
 				{
 					MetricUsed: "mem_used_percent",
 					Value:      1.48,
 					Threshold:  40.0,
+					Rule:       "default",
 					Unit:       "Percent",
 				},
 			},
@@ -58,12 +65,14 @@ var globalInstanceResult = resources.Instance{
 					MetricUsed: "cpu_usage_active",
 					Value:      10.523333333,
 					Threshold:  40.0,
+					Rule:       "default",
 					Unit:       "Percent",
 				},
 				{
 					MetricUsed: "mem_used_percent",
 					Value:      37.77,
 					Threshold:  40.0,
+					Rule:       "default",
 					Unit:       "Percent",
 				},
 			},
@@ -81,13 +90,19 @@ func deepCopy(src resources.Instance, t *testing.T) (dest resources.Instance) {
 	return dest
 }
 
+func defaultDefinitions(t *testing.T) []metrics.Definition {
+	definitions, err := metrics.Select(nil)
+	h.Assert(t, err == nil, "Error selecting default metrics")
+	return definitions
+}
+
 // Tests
 
 func TestParseInstanceResultToRow_StatusSuccess_AllPass(t *testing.T) {
 	instanceResult := deepCopy(globalInstanceResult, t)
-	expected := []string{"m4.large", "SUCCESS", "10.52", "40.00", "37.77", "40.00", "true", "130.75"}
+	expected := []string{"m4.large", "SUCCESS", "35.80", "40.00", "37.77", "40.00", "true", "130.75", "default"}
 
-	actual, err := parseInstanceResultToRow(instanceResult)
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
 	h.Ok(t, err)
 	h.Equals(t, expected, actual)
 }
@@ -95,9 +110,9 @@ func TestParseInstanceResultToRow_StatusSuccess_AllPass(t *testing.T) {
 func TestParseInstanceResultToRow_StatusFail_AllPass(t *testing.T) {
 	instanceResult := deepCopy(globalInstanceResult, t)
 	instanceResult.Results[1].Metrics[0].Value = 41.623
-	expected := []string{"m4.large", "FAIL", "41.62", "40.00", "37.77", "40.00", "true", "130.75"}
+	expected := []string{"m4.large", "FAIL", "41.62", "40.00", "37.77", "40.00", "true", "130.75", "default"}
 
-	actual, err := parseInstanceResultToRow(instanceResult)
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
 	h.Ok(t, err)
 	h.Equals(t, expected, actual)
 }
@@ -105,9 +120,9 @@ func TestParseInstanceResultToRow_StatusFail_AllPass(t *testing.T) {
 func TestParseInstanceResultToRow_StatusSuccess_NotAllPass(t *testing.T) {
 	instanceResult := deepCopy(globalInstanceResult, t)
 	instanceResult.Results[1].Status = "fail"
-	expected := []string{"m4.large", "SUCCESS", "10.52", "40.00", "37.77", "40.00", "false", "130.75"}
+	expected := []string{"m4.large", "SUCCESS", "35.80", "40.00", "37.77", "40.00", "false", "130.75", "default"}
 
-	actual, err := parseInstanceResultToRow(instanceResult)
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
 	h.Ok(t, err)
 	h.Equals(t, expected, actual)
 }
@@ -116,17 +131,245 @@ func TestParseInstanceResultToRow_StatusFail_Timeout(t *testing.T) {
 	instanceResult := deepCopy(globalInstanceResult, t)
 	instanceResult.Results[1].Metrics[1].Value = 45.456
 	instanceResult.IsTimeout = true
-	expected := []string{"m4.large", "FAIL", "10.52", "40.00", "45.46", "40.00", "false", "130.75"}
+	expected := []string{"m4.large", "FAIL", "35.80", "40.00", "45.46", "40.00", "false", "130.75", "default"}
+
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
+	h.Ok(t, err)
+	h.Equals(t, expected, actual)
+}
+
+func TestParseInstanceResultToRow_StatusInterrupted(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.Results = instanceResult.Results[:1]
+	instanceResult.IsInterrupted = true
+	expected := []string{"m4.large", "INTERRUPTED", "35.80", "40.00", "1.48", "40.00", "false", "120.03", "default"}
+
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
+	h.Ok(t, err)
+	h.Equals(t, expected, actual)
+}
 
-	actual, err := parseInstanceResultToRow(instanceResult)
+func TestParseInstanceResultToRow_MultipleRules(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.Results[0].Metrics[0].Rule = "cpu-heavy"
+	instanceResult.Results[0].Metrics[0].Threshold = 20.0
+	expected := []string{"m4.large", "FAIL", "35.80", "20.00", "37.77", "40.00", "true", "130.75", "cpu-heavy,default"}
+
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
 	h.Ok(t, err)
 	h.Equals(t, expected, actual)
 }
 
+func TestParseInstanceResultToRow_DirectionAbove(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	// The worst value of a metric which must stay above its threshold is its min, whichever test file reached it
+	for i, value := range []float64{1000, 3000} {
+		instanceResult.Results[i].Metrics = append(instanceResult.Results[i].Metrics, resources.Metric{
+			MetricUsed: "net_bytes_recv",
+			Value:      value,
+			Threshold:  2000,
+			Rule:       "default",
+			Direction:  "above",
+			Unit:       "Bytes",
+		})
+	}
+	definitions, err := metrics.Select([]string{"cpu_usage_active", "mem_used_percent", "net_bytes_recv"})
+	h.Ok(t, err)
+	expected := []string{"m4.large", "FAIL", "35.80", "40.00", "37.77", "40.00", "1000.00", "2000.00", "true", "130.75", "default"}
+
+	actual, err := parseInstanceResultToRow(instanceResult, definitions)
+	h.Ok(t, err)
+	h.Equals(t, expected, actual)
+	testFixture := config.TestFixture{Statistic: "p95", MetricStatistics: map[string]string{"net_bytes_recv": "Minimum"}}
+	h.Equals(t, []string{"INSTANCE TYPE", "STATUS", "CPU_USAGE_ACTIVE (P95)", "CPU_THRESHOLD", "MEM_USED_PERCENT (P95)", "MEM_THRESHOLD", "NET_BYTES_RECV (MINIMUM)", "NET_RECV_THRESHOLD", "ALL TESTS PASS?", "TOTAL EXECUTION TIME (sec)", "THRESHOLD RULE"}, tableHeader(definitions, testFixture))
+}
+
+func TestParseInstanceGroupToRows_PassRate(t *testing.T) {
+	fastReplica := deepCopy(globalInstanceResult, t)
+	fastReplica.Results[0].ExecutionTime = "100.029"
+	failingReplica := deepCopy(globalInstanceResult, t)
+	failingReplica.Results[1].Metrics[1].Value = 45.456
+	group := []resources.Instance{globalInstanceResult, fastReplica, failingReplica}
+
+	row, replicaRow, err := parseInstanceGroupToRows(group, defaultDefinitions(t), 0.6, 3)
+	h.Ok(t, err)
+	h.Equals(t, []string{"m4.large", "SUCCESS", "35.80", "40.00", "45.46", "40.00", "true", "124.09", "default"}, row)
+	h.Equals(t, []string{"m4.large", "3", "2/3", "124.09", "9.43", "35.80", "35.80", "37.77", "45.46"}, replicaRow)
+
+	row, _, err = parseInstanceGroupToRows(group, defaultDefinitions(t), 1, 3)
+	h.Ok(t, err)
+	h.Equals(t, "FAIL", row[1])
+}
+
+func TestParseInstanceGroupToRows_InterruptedReplicasNotCounted(t *testing.T) {
+	interruptedReplica := deepCopy(globalInstanceResult, t)
+	interruptedReplica.Results[1].Metrics[1].Value = 45.456
+	interruptedReplica.IsInterrupted = true
+
+	row, replicaRow, err := parseInstanceGroupToRows([]resources.Instance{globalInstanceResult, interruptedReplica}, defaultDefinitions(t), 1, 2)
+	h.Ok(t, err)
+	h.Equals(t, []string{"m4.large", "SUCCESS", "35.80", "40.00", "37.77", "40.00", "true", "130.75", "default"}, row)
+	h.Equals(t, "1/1", replicaRow[2])
+
+	row, replicaRow, err = parseInstanceGroupToRows([]resources.Instance{interruptedReplica}, defaultDefinitions(t), 1, 1)
+	h.Ok(t, err)
+	h.Equals(t, "INTERRUPTED", row[1])
+	h.Equals(t, "N/A", replicaRow[2])
+}
+
+func TestParseInstanceGroupToRows_SetupFailed(t *testing.T) {
+	setupFailedReplica := resources.Instance{
+		InstanceId:   "i-0ff4a2f594b270b55",
+		InstanceType: "m4.large",
+		Setup:        &resources.HookResult{Label: "setup.sh", Status: "fail", Attempt: resources.Attempt{ExitCode: 1, ExecutionTime: "2.000"}},
+		Results:      make([]resources.Result, 0),
+	}
+
+	row, replicaRow, err := parseInstanceGroupToRows([]resources.Instance{setupFailedReplica}, defaultDefinitions(t), 1, 1)
+	h.Ok(t, err)
+	h.Equals(t, "SETUP_FAILED", row[1])
+	h.Equals(t, "false", row[6])
+	h.Equals(t, "0/1", replicaRow[2])
+
+	// A replica whose setup failed doesn't pass
+	row, replicaRow, err = parseInstanceGroupToRows([]resources.Instance{globalInstanceResult, setupFailedReplica}, defaultDefinitions(t), 1, 2)
+	h.Ok(t, err)
+	h.Equals(t, "FAIL", row[1])
+	h.Equals(t, "1/2", replicaRow[2])
+}
+
+func TestParseInstanceGroupToRows_MissingReplicasFail(t *testing.T) {
+	// Of 3 replicas, only 1 reported: the missing ones count as failed
+	row, replicaRow, err := parseInstanceGroupToRows([]resources.Instance{globalInstanceResult}, defaultDefinitions(t), 0.6, 3)
+	h.Ok(t, err)
+	h.Equals(t, "FAIL", row[1])
+	h.Equals(t, []string{"m4.large", "3", "1/3"}, replicaRow[:3])
+
+	row, replicaRow, err = parseInstanceGroupToRows([]resources.Instance{globalInstanceResult}, defaultDefinitions(t), 0.3, 3)
+	h.Ok(t, err)
+	h.Equals(t, "SUCCESS", row[1])
+	h.Equals(t, "1/3", replicaRow[2])
+
+	// An interrupted replica isn't counted, unlike the missing ones
+	interruptedReplica := deepCopy(globalInstanceResult, t)
+	interruptedReplica.IsInterrupted = true
+	row, replicaRow, err = parseInstanceGroupToRows([]resources.Instance{interruptedReplica}, defaultDefinitions(t), 1, 2)
+	h.Ok(t, err)
+	h.Equals(t, "FAIL", row[1])
+	h.Equals(t, "0/1", replicaRow[2])
+}
+
+func TestGroupByInstanceType(t *testing.T) {
+	m4Large := resources.Instance{InstanceId: "i-1", InstanceType: "m4.large"}
+	m4Xlarge := resources.Instance{InstanceId: "i-2", InstanceType: "m4.xlarge"}
+	m4LargeReplica := resources.Instance{InstanceId: "i-3", InstanceType: "m4.large"}
+	expected := [][]resources.Instance{{m4Large, m4LargeReplica}, {m4Xlarge}}
+	h.Equals(t, expected, groupByInstanceType([]resources.Instance{m4Large, m4Xlarge, m4LargeReplica}))
+}
+
+func TestParseTestResultToRow(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.Results[0].Metrics[0].Value = 45.0
+	definitions := defaultDefinitions(t)
+
+	h.Equals(t, []string{"m4.large", "cpu-test.sh", "FAIL", "45.00", "1.48", "true", "120.029"}, parseTestResultToRow("m4.large", instanceResult.Results[0], definitions))
+	h.Equals(t, []string{"m4.large", "mem-test.sh", "SUCCESS", "10.52", "37.77", "true", "10.725"}, parseTestResultToRow("m4.large", instanceResult.Results[1], definitions))
+}
+
+func TestParseInstanceResultToRow_ConcurrentTestsWallClockTime(t *testing.T) {
+	// The tests overlap: the total is the span from the first start to the last end, not the sum of 130.75 seconds
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.Results[0].StartTime = "2020-09-02T10:00:00Z"
+	instanceResult.Results[0].EndTime = "2020-09-02T10:02:00Z"
+	instanceResult.Results[1].StartTime = "2020-09-02T10:00:01Z"
+	instanceResult.Results[1].EndTime = "2020-09-02T10:00:12Z"
+
+	actual, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
+	h.Ok(t, err)
+	h.Equals(t, "120.00", actual[7])
+
+	// Results without start and end times fall back to the sum
+	instanceResult.Results[1].EndTime = ""
+	actual, err = parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
+	h.Ok(t, err)
+	h.Equals(t, "130.75", actual[7])
+}
+
 func TestParseInstanceResultToRowInvalidExecutionTimeFailure(t *testing.T) {
 	instanceResult := deepCopy(globalInstanceResult, t)
 	instanceResult.Results[1].ExecutionTime = "EXECUTION_TIME"
 
-	_, err := parseInstanceResultToRow(instanceResult)
+	_, err := parseInstanceResultToRow(instanceResult, defaultDefinitions(t))
 	h.Assert(t, err != nil, "Failed to return error when ExecutionTime is invalid")
 }
+
+func TestUpdateResultsAttachesMetricsToEachTest(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	for i := range instanceResult.Results {
+		instanceResult.Results[i].Metrics = nil
+	}
+	finalResult := []resources.Instance{instanceResult}
+	newResult := func(label string, values ...float64) *cloudwatch.MetricDataResult {
+		var valuePtrs []*float64
+		for _, value := range values {
+			valuePtrs = append(valuePtrs, aws.Float64(value))
+		}
+		return &cloudwatch.MetricDataResult{Label: aws.String(label), Values: valuePtrs}
+	}
+	results := []*cloudwatch.MetricDataResult{
+		newResult("CWAgent i-0ff4a2f594b270b54 m4.large 0 cpu_usage_active", 35.8, 20.0),
+		newResult("CWAgent i-0ff4a2f594b270b54 m4.large 1 cpu_usage_active", 10.5),
+		newResult("CWAgent i-0ff4a2f594b270b54 m4.large 1 mem_used_percent", 45.0),
+		newResult("CWAgent i-0ff4a2f594b270b54 m4.large 0 mem_used_percent"),
+	}
+	testFixture := config.TestFixture{CpuThreshold: 40, MemThreshold: 40, MetricStatistics: map[string]string{"mem_used_percent": "p90"}}
+
+	err := updateResults(results, finalResult, testFixture)
+	h.Ok(t, err)
+	h.Equals(t, []resources.Metric{
+		{MetricUsed: "cpu_usage_active", Value: 35.8, Statistic: "Maximum", Threshold: 40, Rule: "default", Direction: "below", Unit: "Percent"},
+	}, finalResult[0].Results[0].Metrics)
+	h.Equals(t, []resources.Metric{
+		{MetricUsed: "cpu_usage_active", Value: 10.5, Statistic: "Maximum", Threshold: 40, Rule: "default", Direction: "below", Unit: "Percent"},
+		{MetricUsed: "mem_used_percent", Value: 45.0, Statistic: "p90", Threshold: 40, Rule: "default", Direction: "below", Breached: true, Unit: "Percent"},
+	}, finalResult[0].Results[1].Metrics)
+}
+
+func TestUpdateSeries(t *testing.T) {
+	finalResult := []resources.Instance{deepCopy(globalInstanceResult, t)}
+	start := time.Date(2020, 8, 1, 10, 0, 0, 0, time.UTC)
+	results := []*cloudwatch.MetricDataResult{
+		{
+			Label:      aws.String("CWAgent i-0ff4a2f594b270b54 m4.large cpu_usage_active"),
+			Timestamps: []*time.Time{aws.Time(start), aws.Time(start.Add(time.Minute))},
+			Values:     []*float64{aws.Float64(12.5), aws.Float64(97.0)},
+		},
+		{
+			Label: aws.String("CWAgent i-0ff4a2f594b270b54 m4.large mem_used_percent"),
+		},
+	}
+
+	updateSeries(results, finalResult, config.TestFixture{})
+	h.Equals(t, []resources.MetricSeries{
+		{
+			MetricUsed: "cpu_usage_active",
+			Statistic:  "Maximum",
+			Unit:       "Percent",
+			DataPoints: []resources.DataPoint{
+				{Timestamp: "2020-08-01T10:00:00Z", Value: 12.5},
+				{Timestamp: "2020-08-01T10:01:00Z", Value: 97.0},
+			},
+		},
+	}, finalResult[0].Series)
+}
+
+func TestParseSeriesToRow(t *testing.T) {
+	series := resources.MetricSeries{
+		MetricUsed: "cpu_usage_active",
+		DataPoints: []resources.DataPoint{{Value: 10}, {Value: 55}, {Value: 100}},
+	}
+	h.Equals(t, []string{"m4.large", "cpu_usage_active", "10.00", "100.00", "▁▄█"}, parseSeriesToRow("m4.large", series))
+
+	series.DataPoints = nil
+	h.Equals(t, []string{"m4.large", "cpu_usage_active", "N/A", "N/A", "N/A"}, parseSeriesToRow("m4.large", series))
+}
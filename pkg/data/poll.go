@@ -15,14 +15,14 @@ package data
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
@@ -30,149 +30,370 @@ import (
 
 const (
 	resultsDir           = "results"
-	instanceResultSuffix = "-test-results.json"
-	bucketTestsDir       = "Tests"
 	pollingPeriod        = 5 * time.Second
+	maxPollingPeriod     = time.Minute
+	maxConsecutiveErrors = 10
+	// An agent whose heartbeat doesn't change for this long is hung; it is 20 times its period. Since the agent
+	// heartbeats whatever its tests do, only a dead agent is hung: a test hanging without a timeout of its own is still
+	// bounded by the timeout of the run only
+	hungAgentAge     = 20 * resources.HeartbeatPeriod
+	progressTemplate = "Results: %d/%d finished, %d running, %d timed out\n"
 )
 
-// PollForResults polls for all instance results from the bucket in parallel.
-// After each successful polling, the instance result is appended to the final result and the new final result
-// is uploaded to the bucket. Upon returning, final result json file is ready to be parsed.
-func PollForResults(sess *session.Session) error {
-	svc := resources.New(sess)
+// PollForResults collects all instance results from the bucket. Each tick, it lists the results and the heartbeats of
+// the run, and the chunks of the logs only if they are followed, and describes all instances still running at once, so
+// that the number of API calls doesn't grow with the number of instances nor with how long they run. The instance
+// results collected are appended to the final result and the new final result is uploaded to the bucket. The progress
+// is printed to the output stream whenever it changes, along with the logs of the instances if follow is true, in which
+// case the period doesn't back off. Upon returning, final result json file is ready to be parsed.
+func PollForResults(svc *resources.Resources, follow bool, outputStream *os.File) error {
 	testFixture := config.GetTestFixture()
 	instances, err := svc.GetInstancesInCfnStack()
 	if err != nil {
 		return err
 	}
 
-	results := make(chan string, len(instances))
-	errChan := make(chan error, 1)
+	if err := os.MkdirAll(resultsDir, os.ModePerm); err != nil {
+		return err
+	}
+	c := newCollector(svc, testFixture, instances)
+	if err := ioutil.WriteFile(c.localFinalResult, []byte("[]"), 0644); err != nil {
+		return err
+	}
 
-	// This goroutine will receive instance results from the channel. Each time it gets a new instance result, it will append it to the final result, then upload the new final result to the bucket
-	go func() {
-		if err := os.MkdirAll(resultsDir, os.ModePerm); err != nil {
-			errChan <- err
-			return
+	log.Printf("Polling for the results of %d instances...\n", len(instances))
+	b := newBackoff(pollingPeriod, maxPollingPeriod)
+	if follow {
+		c.follower = newFollower(svc.Store, c.bucket, c.layout, instances, outputStream)
+		defer c.follower.flush()
+		b = newBackoff(pollingPeriod, pollingPeriod)
+	}
+	consecutiveErrors := 0
+	lastProgress := ""
+	for len(c.pending) > 0 {
+		time.Sleep(b.next())
+		progressed, err := c.collect()
+		if err != nil {
+			consecutiveErrors++
+			if consecutiveErrors >= maxConsecutiveErrors {
+				return err
+			}
+			// Throttling or any other transient failure is retried once the period backs off
+			log.Println(err)
+		} else {
+			consecutiveErrors = 0
+		}
+		b.update(progressed && err == nil)
+
+		if progress := c.progress(); progress != lastProgress {
+			fmt.Fprint(outputStream, progress)
+			lastProgress = progress
+		}
+	}
+
+	if err := resources.GetFile(svc.Store, testFixture.BucketName, c.localFinalResult, c.remoteFinalResult); err != nil {
+		// Can use the local version
+		log.Println(err)
+	}
+	return nil
+}
+
+// collector collects the results of the instances of a run, and keeps track of the instances still pending.
+type collector struct {
+	// follower prints the logs of the instances as they are uploaded, if set.
+	follower          *follower
+	svc               *resources.Resources
+	bucket            string
+	layout            resources.BucketLayout
+	localFinalResult  string
+	remoteFinalResult string
+	pending           []resources.Instance
+	total             int
+	finished          int
+	timedOut          int
+	// heartbeats are the heartbeats of the agents of the pending instances as last seen, by instance ID.
+	heartbeats map[string]heartbeatSeen
+	now        func() time.Time
+}
+
+// heartbeatSeen is when the heartbeat of an agent was last modified, and when the collector saw it change.
+type heartbeatSeen struct {
+	lastModified time.Time
+	changedAt    time.Time
+}
+
+func newCollector(svc *resources.Resources, testFixture config.TestFixture, instances []resources.Instance) *collector {
+	layout := resources.BucketLayout{RootDir: testFixture.BucketRootDir}
+	return &collector{
+		svc:               svc,
+		bucket:            testFixture.BucketName,
+		layout:            layout,
+		localFinalResult:  resultsDir + "/" + testFixture.FinalResultFilename,
+		remoteFinalResult: layout.FinalResult(testFixture.FinalResultFilename),
+		pending:           instances,
+		total:             len(instances),
+		heartbeats:        make(map[string]heartbeatSeen),
+		now:               time.Now,
+	}
+}
+
+// collect collects the result of every pending instance which uploaded it, or which isn't running anymore or whose
+// agent is hung, in which case its partial result is collected and the instance timed out. It returns whether any
+// instance was collected.
+func (c *collector) collect() (progressed bool, err error) {
+	objects, err := c.svc.Store.List(c.bucket, c.layout.RootDir+"/")
+	if err != nil {
+		return false, err
+	}
+	uploaded := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		uploaded[object.Key] = true
+	}
+	heartbeats, err := c.svc.Store.List(c.bucket, c.layout.Heartbeats())
+	if err != nil {
+		return false, err
+	}
+	modified := make(map[string]time.Time, len(heartbeats))
+	for _, heartbeat := range heartbeats {
+		modified[heartbeat.Key] = heartbeat.LastModified
+	}
+	// The logs are printed before the results, since an agent uploads the whole of its log before its result
+	if c.follower != nil {
+		chunks, err := c.svc.Store.List(c.bucket, c.layout.LogChunks())
+		if err != nil {
+			return false, err
 		}
+		uploadedChunks := make(map[string]bool, len(chunks))
+		for _, chunk := range chunks {
+			uploadedChunks[chunk.Key] = true
+		}
+		c.follower.follow(uploadedChunks)
+	}
 
-		localFinalResult := resultsDir + "/" + testFixture.FinalResultFilename
-		remoteFinalResult := testFixture.BucketRootDir + "/" + testFixture.FinalResultFilename
-		if err := ioutil.WriteFile(localFinalResult, []byte("[]"), 0644); err != nil {
-			errChan <- err
+	var instanceResults []string
+	defer func() {
+		if len(instanceResults) == 0 {
 			return
 		}
+		progressed = true
+		if err := appendResultsAndUpload(c.svc.Store, c.bucket, c.localFinalResult, c.remoteFinalResult, instanceResults); err != nil {
+			// Failing to append instance results should not terminate the whole program
+			log.Println(err)
+		}
+	}()
+
+	var running []resources.Instance
+	var instanceIds []string
+	for _, instance := range c.pending {
+		remotePath := c.layout.Instance(instance.InstanceType, instance.InstanceId).Result()
+		if !uploaded[remotePath] {
+			running = append(running, instance)
+			instanceIds = append(instanceIds, instance.InstanceId)
+			continue
+		}
+		instanceResult, err := c.download(instance, remotePath, nil)
+		if err != nil {
+			// The download is retried once the instance stops, if not at the next tick
+			log.Println(err)
+			running = append(running, instance)
+			instanceIds = append(instanceIds, instance.InstanceId)
+			continue
+		}
+		instanceResults = append(instanceResults, instanceResult)
+		c.finished++
+	}
+	c.pending = running
+	if len(running) == 0 {
+		return progressed, nil
+	}
 
-		for {
-			select {
-			case instanceResult, ok := <-results:
-				if ok {
-					if err := appendResultAndUpload(sess, testFixture.BucketName, localFinalResult, remoteFinalResult, instanceResult); err != nil {
-						// Failing to append one instance result should not terminate the whole program
-						log.Println(err)
-					}
-				} else {
-					if err := svc.DownloadFromBucket(testFixture.BucketName, localFinalResult, remoteFinalResult); err != nil {
-						// Can use the local version
-						log.Println(err)
-					}
-					errChan <- nil
-					return
-				}
+	states, err := c.svc.GetInstanceStates(instanceIds)
+	if err != nil {
+		return progressed, err
+	}
+	c.pending = nil
+	for _, instance := range running {
+		state := states[instance.InstanceId]
+		var mark resultMarker
+		if state.IsRunning {
+			if !c.isHung(instance, modified) {
+				c.pending = append(c.pending, instance)
+				continue
 			}
+			log.Printf("The agent of %s didn't send any heartbeat for %v, so it is considered hung\n", instance.InstanceId, hungAgentAge)
+			mark = markHung
+		} else if state.IsSpotInterrupted {
+			mark = markInterrupted
+		}
+		progressed = true
+		delete(c.heartbeats, instance.InstanceId)
+		if instanceResult, ok := c.collectStopped(instance, mark); ok {
+			instanceResults = append(instanceResults, instanceResult)
+		}
+	}
+	return progressed, nil
+}
+
+// isHung checks whether the agent of a running instance didn't change its heartbeat for hungAgentAge. The age is
+// measured by the clock of the CLI from when it saw the heartbeat change, so that it doesn't depend on the clocks of
+// the instance and of the store. An agent which didn't upload any heartbeat yet, e.g. while its instance boots, or
+// which predates heartbeats, isn't hung. The heartbeat tells whether the agent runs, not whether its tests progress.
+func (c *collector) isHung(instance resources.Instance, modified map[string]time.Time) bool {
+	lastModified, ok := modified[c.layout.Instance(instance.InstanceType, instance.InstanceId).Heartbeat()]
+	if !ok {
+		return false
+	}
+	now := c.now()
+	seen, ok := c.heartbeats[instance.InstanceId]
+	if !ok || !seen.lastModified.Equal(lastModified) {
+		c.heartbeats[instance.InstanceId] = heartbeatSeen{lastModified: lastModified, changedAt: now}
+		return false
+	}
+	return now.Sub(seen.changedAt) >= hungAgentAge
+}
+
+// collectStopped collects the result of an instance which isn't running anymore, or whose agent is hung. If the
+// instance didn't upload its result, it timed out and its partial result is collected instead, marked by mark if any,
+// e.g. as interrupted if the instance was interrupted by a spot interruption.
+func (c *collector) collectStopped(instance resources.Instance, mark resultMarker) (string, bool) {
+	instanceLayout := c.layout.Instance(instance.InstanceType, instance.InstanceId)
+	// The instance may have uploaded its result since the objects were listed
+	if instanceResult, err := c.download(instance, instanceLayout.Result(), nil); err == nil {
+		c.finished++
+		return instanceResult, true
+	}
+	c.timedOut++
+	log.Printf("%s stopped before uploading its result\n", instance.InstanceId)
+	instanceResult, err := c.download(instance, instanceLayout.PartialResult(), mark)
+	if err == nil {
+		return instanceResult, true
+	}
+	log.Println(err)
+	return "", false
+}
+
+// download downloads an instance result, marked by mark if any. The result of a marked instance is written even if the
+// instance stopped before uploading any result.
+func (c *collector) download(instance resources.Instance, remotePath string, mark resultMarker) (string, error) {
+	localPath := resultsDir + "/" + resources.InstanceResultFilename(instance.InstanceId)
+	defer func() {
+		if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+			// Failing to delete the file is acceptable
+			log.Println(err)
 		}
 	}()
 
-	var wg sync.WaitGroup
-	for _, instance := range instances {
-		wg.Add(1)
-		go func(instance resources.Instance) {
-			defer wg.Done()
-
-			instanceId := instance.InstanceId
-			instanceType := instance.InstanceType
-			filename := instanceId + instanceResultSuffix
-			localInstanceResult := resultsDir + "/" + filename
-			remoteInstanceResult := testFixture.BucketRootDir + "/" + instanceType + "/" + instanceId + "/" + filename
-			// If the instance doesn't finish the execution of all test files before timeout, fetch this partial instance result
-			remoteFallbackInstanceResult := testFixture.BucketRootDir + "/" + instanceType + "/" + instanceId + "/" + bucketTestsDir + "/" + filename
-
-			if err := pollForResult(sess, testFixture.BucketName, instanceId, localInstanceResult, remoteInstanceResult, remoteFallbackInstanceResult); err == nil {
-				instanceResult, err := ioutil.ReadFile(localInstanceResult)
-				if err != nil {
-					// Failing to read the instance result from the file should terminate the current goroutine
-					log.Println(err)
-					return
-				}
-				results <- string(instanceResult)
-				if err := os.Remove(localInstanceResult); err != nil {
-					// Failing to delete the file is acceptable
-					log.Println(err)
-				}
-			} else {
-				// Failing to poll for the result of one instance should not terminate the whole program
-				log.Println(err)
-			}
-		}(instance)
+	if err := resources.GetFile(c.svc.Store, c.bucket, localPath, remotePath); err != nil {
+		if mark == nil {
+			return "", err
+		}
+		log.Printf("%s stopped before uploading any result\n", instance.InstanceId)
+	}
+	if mark != nil {
+		if err := mark(instance, localPath); err != nil {
+			return "", err
+		}
 	}
-	wg.Wait()
-	close(results)
+	instanceResult, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	return string(instanceResult), nil
+}
 
-	return <-errChan
+// progress returns the progress line of the instances collected so far.
+func (c *collector) progress() string {
+	return fmt.Sprintf(progressTemplate, c.finished, c.total, len(c.pending), c.timedOut)
 }
 
-// pollForResult polls for one instance result periodically until the instance is not running.
-func pollForResult(sess *session.Session, bucket string, instanceId string, localPath string, remotePath string, fallbackPath string) error {
-	svc := resources.New(sess)
-	ticker := time.NewTicker(pollingPeriod)
-	filename := filepath.Base(localPath)
+// backoff is the period between two ticks of the collector. It doubles up to its max while no instance is collected,
+// including when the APIs fail e.g. because of throttling, and is reset once an instance is collected. Each wait is
+// jittered, so that the CLIs polling the same account don't call the APIs in lockstep.
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	period time.Duration
+}
 
-	log.Printf("Polling for %s...\n", filename)
-	for {
-		select {
-		case <-ticker.C:
-			if err := svc.DownloadFromBucket(bucket, localPath, remotePath); err == nil {
-				ticker.Stop()
-				log.Printf("Polling for %s succeeded\n", filename)
-				return nil
-			}
-			isRunning, err := svc.IsInstanceRunning(instanceId)
-			if err != nil {
-				return err
-			}
-			if !isRunning {
-				ticker.Stop()
-				if err := svc.DownloadFromBucket(bucket, localPath, fallbackPath); err != nil {
-					return err
-				}
-				log.Printf("Polling for %s timeout, downloaded from %s\n", filename, fallbackPath)
-				return nil
-			}
+func newBackoff(base time.Duration, max time.Duration) *backoff {
+	return &backoff{base: base, max: max, period: base}
+}
+
+// next returns the wait before the next tick, between half the period and the period.
+func (b *backoff) next() time.Duration {
+	half := b.period / 2
+	return half + time.Duration(rand.Int63n(int64(b.period-half)+1))
+}
+
+// update resets the period if the tick made progress, and doubles it otherwise.
+func (b *backoff) update(progressed bool) {
+	if progressed {
+		b.period = b.base
+		return
+	}
+	b.period *= 2
+	if b.period > b.max {
+		b.period = b.max
+	}
+}
+
+// resultMarker marks the instance result in the local file with why the instance stopped before uploading it.
+type resultMarker func(instance resources.Instance, localPath string) error
+
+// markInterrupted marks the instance result in the local file as interrupted. If there is no such file, a result
+// without any test result is written.
+func markInterrupted(instance resources.Instance, localPath string) error {
+	return markResult(instance, localPath, func(instanceResult *resources.Instance) {
+		instanceResult.IsInterrupted = true
+	})
+}
+
+// markHung marks the instance result in the local file as timed out, since its agent is hung. If there is no such
+// file, a result without any test result is written.
+func markHung(instance resources.Instance, localPath string) error {
+	return markResult(instance, localPath, func(instanceResult *resources.Instance) {
+		instanceResult.IsTimeout = true
+	})
+}
+
+// markResult applies mark to the instance result in the local file, or to a result without any test result if there
+// is no such file.
+func markResult(instance resources.Instance, localPath string, mark func(*resources.Instance)) error {
+	instanceResult := resources.Instance{
+		InstanceId:   instance.InstanceId,
+		InstanceType: instance.InstanceType,
+		Results:      make([]resources.Result, 0),
+	}
+	if data, err := ioutil.ReadFile(localPath); err == nil {
+		if err := json.Unmarshal(data, &instanceResult); err != nil {
+			return err
 		}
 	}
+	mark(&instanceResult)
+	return cmdutil.MarshalToFile(instanceResult, localPath)
 }
 
-// appendResultAndUpload appends the instance result to the final result and uploads the new final result to
+// appendResultsAndUpload appends the instance results to the final result and uploads the new final result to
 // the bucket.
-func appendResultAndUpload(sess *session.Session, bucket string, localPath string, remotePath string, instanceResult string) error {
-	svc := resources.New(sess)
-
+func appendResultsAndUpload(store resources.ResultStore, bucket string, localPath string, remotePath string, instanceResults []string) error {
 	allResults, err := finalResultToArray(filepath.Base(localPath))
 	if err != nil {
 		return err
 	}
 
-	var newResult resources.Instance
-	if err := json.Unmarshal([]byte(instanceResult), &newResult); err != nil {
-		return err
+	for _, instanceResult := range instanceResults {
+		var newResult resources.Instance
+		if err := json.Unmarshal([]byte(instanceResult), &newResult); err != nil {
+			return err
+		}
+		allResults = append(allResults, newResult)
 	}
 
-	allResults = append(allResults, newResult)
 	if err := cmdutil.MarshalToFile(allResults, localPath); err != nil {
 		return err
 	}
 
-	if err := svc.UploadToBucket(bucket, localPath, remotePath); err != nil {
+	if err := resources.PutFile(store, bucket, localPath, remotePath); err != nil {
 		return err
 	}
 
@@ -0,0 +1,239 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Mocking
+
+// mockedStatesEC2 describes each instance in the state it is mapped to, and counts the calls.
+type mockedStatesEC2 struct {
+	ec2iface.EC2API
+	States map[string]*ec2.Instance
+	Calls  *int
+}
+
+func (m mockedStatesEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	*m.Calls++
+	reservation := &ec2.Reservation{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		reservation.Instances = append(reservation.Instances, m.States[instanceId])
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+// mockedListStore records the prefixes listed.
+type mockedListStore struct {
+	resources.ResultStore
+	Prefixes *[]string
+}
+
+func (m mockedListStore) List(bucket string, prefix string) ([]resources.Object, error) {
+	*m.Prefixes = append(*m.Prefixes, prefix)
+	return m.ResultStore.List(bucket, prefix)
+}
+
+// Helpers
+
+func describedInstance(instanceId string, state string, stateReason string) *ec2.Instance {
+	instance := &ec2.Instance{InstanceId: aws.String(instanceId), State: &ec2.InstanceState{Name: aws.String(state)}}
+	if stateReason != "" {
+		instance.StateReason = &ec2.StateReason{Code: aws.String(stateReason)}
+	}
+	return instance
+}
+
+func putInstanceResult(t *testing.T, store resources.ResultStore, key string, instance resources.Instance) {
+	data, err := json.Marshal(instance)
+	h.Ok(t, err)
+	h.Ok(t, store.Put("qualifier-bucket", key, bytes.NewReader(data)))
+}
+
+func readInstanceResult(t *testing.T, filename string) (instanceResult resources.Instance) {
+	data, err := ioutil.ReadFile(filename)
+	h.Ok(t, err)
+	h.Ok(t, json.Unmarshal(data, &instanceResult))
+	return instanceResult
+}
+
+// Tests
+
+func TestMarkInterruptedPartialResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, resources.InstanceResultFilename("i-0ff4a2f594b270b54"))
+	h.Ok(t, cmdutil.MarshalToFile(globalInstanceResult, filename))
+
+	h.Ok(t, markInterrupted(globalInstanceResult, filename))
+	actual := readInstanceResult(t, filename)
+	h.Assert(t, actual.IsInterrupted, "Failed to mark the instance result as interrupted")
+	h.Equals(t, len(globalInstanceResult.Results), len(actual.Results))
+}
+
+func TestMarkInterruptedNoResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "results")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, resources.InstanceResultFilename("i-0ff4a2f594b270b54"))
+
+	h.Ok(t, markInterrupted(resources.Instance{InstanceId: "i-0ff4a2f594b270b54", InstanceType: "m4.large"}, filename))
+	expected := resources.Instance{
+		InstanceId:    "i-0ff4a2f594b270b54",
+		InstanceType:  "m4.large",
+		IsInterrupted: true,
+		Results:       make([]resources.Result, 0),
+	}
+	h.Equals(t, expected, readInstanceResult(t, filename))
+}
+
+func TestCollectorCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	h.Ok(t, err)
+	h.Ok(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	h.Ok(t, os.MkdirAll(resultsDir, os.ModePerm))
+
+	calls := 0
+	var prefixes []string
+	svc := &resources.Resources{
+		Store: mockedListStore{ResultStore: resources.LocalStore{Dir: filepath.Join(dir, "buckets")}, Prefixes: &prefixes},
+		EC2: mockedStatesEC2{Calls: &calls, States: map[string]*ec2.Instance{
+			"i-finished":    describedInstance("i-finished", "running", ""),
+			"i-running":     describedInstance("i-running", "running", ""),
+			"i-timeout":     describedInstance("i-timeout", "terminated", ""),
+			"i-interrupted": describedInstance("i-interrupted", "terminated", "Server.SpotInstanceTermination"),
+		}},
+	}
+	testFixture := config.TestFixture{BucketName: "qualifier-bucket", BucketRootDir: "Instance-Qualifier-Run-12345", FinalResultFilename: "final-results-12345.json"}
+	var instances []resources.Instance
+	for _, instanceId := range []string{"i-finished", "i-running", "i-timeout", "i-interrupted"} {
+		instances = append(instances, resources.Instance{InstanceId: instanceId, InstanceType: "m4.large"})
+	}
+	c := newCollector(svc, testFixture, instances)
+	h.Ok(t, ioutil.WriteFile(c.localFinalResult, []byte("[]"), 0644))
+	h.Ok(t, svc.Store.CreateBucket("qualifier-bucket"))
+	putInstanceResult(t, svc.Store, c.layout.Instance("m4.large", "i-finished").Result(), instances[0])
+	putInstanceResult(t, svc.Store, c.layout.Instance("m4.large", "i-timeout").PartialResult(), instances[2])
+
+	progressed, err := c.collect()
+	h.Ok(t, err)
+	h.Assert(t, progressed, "Failed to report the instances collected")
+	h.Equals(t, 1, calls)
+	// The chunks of the logs aren't listed unless they are followed
+	h.Equals(t, []string{"Instance-Qualifier-Run-12345/", "Heartbeats/Instance-Qualifier-Run-12345/"}, prefixes)
+	h.Equals(t, []resources.Instance{instances[1]}, c.pending)
+	h.Equals(t, "Results: 1/4 finished, 1 running, 2 timed out\n", c.progress())
+
+	finalResult, err := finalResultToArray(testFixture.FinalResultFilename)
+	h.Ok(t, err)
+	h.Equals(t, 3, len(finalResult))
+	h.Assert(t, finalResult[2].InstanceId == "i-interrupted" && finalResult[2].IsInterrupted, "Failed to mark the interrupted instance")
+	exists, err := svc.Store.Exists("qualifier-bucket", c.remoteFinalResult)
+	h.Ok(t, err)
+	h.Assert(t, exists, "Failed to upload the final result")
+
+	prefixes = nil
+	c.follower = newFollower(svc.Store, "qualifier-bucket", c.layout, c.pending, ioutil.Discard)
+	progressed, err = c.collect()
+	h.Ok(t, err)
+	h.Assert(t, !progressed, "Reported progress while no instance was collected")
+	h.Equals(t, []string{"Instance-Qualifier-Run-12345/", "Heartbeats/Instance-Qualifier-Run-12345/", "Logs/Instance-Qualifier-Run-12345/"}, prefixes)
+}
+
+func TestCollectorHungAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collector")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	h.Ok(t, err)
+	h.Ok(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	h.Ok(t, os.MkdirAll(resultsDir, os.ModePerm))
+
+	calls := 0
+	store := resources.LocalStore{Dir: filepath.Join(dir, "buckets")}
+	svc := &resources.Resources{
+		Store: store,
+		EC2: mockedStatesEC2{Calls: &calls, States: map[string]*ec2.Instance{
+			"i-hung":    describedInstance("i-hung", "running", ""),
+			"i-running": describedInstance("i-running", "running", ""),
+			"i-booting": describedInstance("i-booting", "running", ""),
+		}},
+	}
+	testFixture := config.TestFixture{BucketName: "qualifier-bucket", BucketRootDir: "Instance-Qualifier-Run-12345", FinalResultFilename: "final-results-12345.json"}
+	var instances []resources.Instance
+	for _, instanceId := range []string{"i-hung", "i-running", "i-booting"} {
+		instances = append(instances, resources.Instance{InstanceId: instanceId, InstanceType: "m4.large"})
+	}
+	c := newCollector(svc, testFixture, instances)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	h.Ok(t, ioutil.WriteFile(c.localFinalResult, []byte("[]"), 0644))
+	h.Ok(t, svc.Store.CreateBucket("qualifier-bucket"))
+	putInstanceResult(t, svc.Store, c.layout.Instance("m4.large", "i-hung").PartialResult(), instances[0])
+	for _, instanceId := range []string{"i-hung", "i-running"} {
+		h.Ok(t, svc.Store.Put("qualifier-bucket", c.layout.Instance("m4.large", instanceId).Heartbeat(), bytes.NewReader([]byte("{}"))))
+	}
+
+	progressed, err := c.collect()
+	h.Ok(t, err)
+	h.Assert(t, !progressed, "Reported progress while no instance was collected")
+	h.Equals(t, 3, len(c.pending))
+
+	// Only the heartbeat of i-running changes
+	now = now.Add(hungAgentAge)
+	heartbeat := store.Location("qualifier-bucket", c.layout.Instance("m4.large", "i-running").Heartbeat())
+	h.Ok(t, os.Chtimes(heartbeat, now, now.Add(time.Minute)))
+	progressed, err = c.collect()
+	h.Ok(t, err)
+	h.Assert(t, progressed, "Failed to collect the hung instance")
+	h.Equals(t, []resources.Instance{instances[1], instances[2]}, c.pending)
+	h.Equals(t, "Results: 0/3 finished, 2 running, 1 timed out\n", c.progress())
+
+	finalResult, err := finalResultToArray(testFixture.FinalResultFilename)
+	h.Ok(t, err)
+	h.Equals(t, 1, len(finalResult))
+	h.Assert(t, finalResult[0].InstanceId == "i-hung" && finalResult[0].IsTimeout, "Failed to mark the hung instance as timed out")
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(time.Second, 5*time.Second)
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		wait := b.next()
+		h.Assert(t, wait >= expected/2 && wait <= expected, "Wait %v isn't jittered around %v", wait, expected)
+		b.update(false)
+	}
+	b.update(true)
+	h.Equals(t, time.Second, b.period)
+}
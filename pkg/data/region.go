@@ -0,0 +1,109 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"path"
+	"strings"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+const (
+	regionHeader = "REGION"
+)
+
+// RegionalPrices provides the prices of the instance types of each region of a multi-region run. Prices are looked
+// up by the instance type prefixed by its region, e.g. "us-east-1/m5.large".
+type RegionalPrices map[string]pricing.Source
+
+// GetPrice returns the prices of an instance type in a region and whether they are known.
+func (p RegionalPrices) GetPrice(regionalInstanceType string) (pricing.Price, bool) {
+	source, ok := p[path.Dir(regionalInstanceType)]
+	if !ok || source == nil {
+		return pricing.Price{}, false
+	}
+	return source.GetPrice(path.Base(regionalInstanceType))
+}
+
+// regionalKey returns the instance type prefixed by its region, which tells the same instance type of different
+// regions apart. It is the instance type itself if the region is empty.
+func regionalKey(region string, instanceType string) string {
+	if region == "" {
+		return instanceType
+	}
+	return region + "/" + instanceType
+}
+
+// newMultiRegionReport merges the reports of the regions of a multi-region run into a single report, in which every
+// table starts with a region column. The summary and the costs are those of all regions together.
+func newMultiRegionReport(regionResults []RegionResults, definitions []metrics.Definition, options ReportOptions) (report, error) {
+	var merged report
+	var missingRows [][]string
+	var detailedResultsUrls []string
+	outcomes := make(map[string]string)
+	for _, results := range regionResults {
+		finalResult := make([]resources.Instance, len(results.FinalResult))
+		for i, instanceResult := range results.FinalResult {
+			instanceResult.Region = results.Region
+			finalResult[i] = instanceResult
+		}
+		r, err := newReport(finalResult, results.MissingInstanceTypes, definitions, results.TestFixture, ReportOptions{})
+		if err != nil {
+			return report{}, err
+		}
+
+		if merged.tables == nil {
+			for _, table := range r.tables {
+				merged.tables = append(merged.tables, reportTable{title: table.title, header: append([]string{regionHeader}, table.header...)})
+			}
+		}
+		for i, table := range r.tables {
+			rows := table.rows
+			if i == 0 {
+				// As in the report of a single region, the instance types without results come last
+				rows = table.rows[:len(r.groups)]
+				missingRows = append(missingRows, prependCell(results.Region, table.rows[len(r.groups):])...)
+			}
+			merged.tables[i].rows = append(merged.tables[i].rows, prependCell(results.Region, rows)...)
+		}
+		merged.groups = append(merged.groups, r.groups...)
+		for _, instanceType := range results.MissingInstanceTypes {
+			merged.missingInstanceTypes = append(merged.missingInstanceTypes, regionalKey(results.Region, instanceType))
+		}
+		for instanceType, outcome := range r.summary.Outcomes {
+			outcomes[regionalKey(results.Region, instanceType)] = outcome
+		}
+		detailedResultsUrls = append(detailedResultsUrls, results.DetailedResultsUrl)
+	}
+	merged.tables[0].rows = append(merged.tables[0].rows, missingRows...)
+	merged.detailedResultsUrl = strings.Join(detailedResultsUrls, ", ")
+	merged.summary = newSummary(outcomes, options.Requirement)
+	merged.concurrencyNote = newConcurrencyNote(merged.groups)
+	if options.PriceSource != nil {
+		merged.hasCosts = true
+		merged.recommendation = appendCosts(&merged.tables[0], options.PriceSource, options.PriceType)
+	}
+	return merged, nil
+}
+
+// prependCell returns the rows with the cell prepended to each of them.
+func prependCell(cell string, rows [][]string) (prepended [][]string) {
+	for _, row := range rows {
+		prepended = append(prepended, append([]string{cell}, row...))
+	}
+	return prepended
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+func testRegionResults(t *testing.T) []RegionResults {
+	failingResult := deepCopy(globalInstanceResult, t)
+	failingResult.Results[1].Status = "fail"
+	return []RegionResults{
+		{
+			Region:             "us-east-1",
+			FinalResult:        []resources.Instance{globalInstanceResult},
+			DetailedResultsUrl: "s3://qualifier-bucket-123/Instance-Qualifier-Run-123",
+		},
+		{
+			Region:               "eu-west-1",
+			FinalResult:          []resources.Instance{failingResult},
+			MissingInstanceTypes: []string{"a1.large"},
+			DetailedResultsUrl:   "s3://qualifier-bucket-456/Instance-Qualifier-Run-456",
+		},
+	}
+}
+
+// Tests
+
+func TestNewMultiRegionReport(t *testing.T) {
+	r, err := newMultiRegionReport(testRegionResults(t), defaultDefinitions(t), ReportOptions{})
+	h.Ok(t, err)
+	h.Equals(t, regionHeader, r.tables[0].header[0])
+	h.Equals(t, instanceTypeHeader, r.tables[0].header[1])
+	h.Equals(t, [][]string{
+		{"us-east-1", "m4.large", "SUCCESS", "35.80", "40.00", "37.77", "40.00", "true", "130.75", "default"},
+		{"eu-west-1", "m4.large", "SUCCESS", "35.80", "40.00", "37.77", "40.00", "false", "130.75", "default"},
+		{"eu-west-1", "a1.large", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A"},
+	}, r.tables[0].rows)
+	h.Equals(t, 4, len(r.tables[1].rows))
+	h.Equals(t, "eu-west-1", r.tables[1].rows[2][0])
+	h.Equals(t, map[string]string{
+		"us-east-1/m4.large": OutcomePassed,
+		"eu-west-1/m4.large": OutcomeTestsFailed,
+		"eu-west-1/a1.large": OutcomeNoResults,
+	}, r.summary.Outcomes)
+	h.Equals(t, config.ExitCodeNoResults, r.summary.ExitCode)
+	h.Equals(t, "s3://qualifier-bucket-123/Instance-Qualifier-Run-123, s3://qualifier-bucket-456/Instance-Qualifier-Run-456", r.detailedResultsUrl)
+
+	var buf bytes.Buffer
+	h.Ok(t, r.renderJunitXml(&buf))
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	h.Equals(t, 3, len(actual.TestSuites))
+	h.Equals(t, "us-east-1/m4.large", actual.TestSuites[0].Name)
+	h.Equals(t, "eu-west-1/m4.large", actual.TestSuites[1].Name)
+	h.Equals(t, "eu-west-1/a1.large", actual.TestSuites[2].Name)
+}
+
+func TestNewMultiRegionReportRequirement(t *testing.T) {
+	requirement, err := config.ParseRequirement("at least one of m4.large passes")
+	h.Ok(t, err)
+	r, err := newMultiRegionReport(testRegionResults(t), defaultDefinitions(t), ReportOptions{Requirement: &requirement})
+	h.Ok(t, err)
+	h.Equals(t, StatusRequirementMet, r.summary.Status)
+
+	requirement, err = config.ParseRequirement("all of m4.large pass")
+	h.Ok(t, err)
+	r, err = newMultiRegionReport(testRegionResults(t), defaultDefinitions(t), ReportOptions{Requirement: &requirement})
+	h.Ok(t, err)
+	h.Equals(t, StatusRequirementNotMet, r.summary.Status)
+}
+
+func TestNewMultiRegionReportCosts(t *testing.T) {
+	prices := RegionalPrices{
+		"us-east-1": pricing.StaticSource{"m4.large": {OnDemand: 0.1}},
+		"eu-west-1": pricing.StaticSource{"m4.large": {OnDemand: 0.111}},
+	}
+	r, err := newMultiRegionReport(testRegionResults(t), defaultDefinitions(t), ReportOptions{PriceSource: prices, PriceType: pricing.OnDemand})
+	h.Ok(t, err)
+	h.Equals(t, "0.1000", r.tables[0].rows[0][10])
+	h.Equals(t, "0.1110", r.tables[0].rows[1][10])
+	h.Equals(t, "N/A", r.tables[0].rows[2][10])
+	h.Equals(t, "us-east-1/m4.large", r.recommendation.InstanceType)
+	h.Equals(t, []string{"us-east-1/m4.large"}, r.recommendation.Ranking)
+}
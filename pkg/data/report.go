@@ -0,0 +1,459 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+const (
+	junitSuitesName        = "instance-qualifier"
+	junitThresholdBreach   = "ThresholdBreach"
+	junitTestFailure       = "TestFailure"
+	junitMissingResults    = "MissingResults"
+	junitSpotInterruption  = "SpotInterruption"
+	junitSetupFailure      = "SetupFailure"
+	junitSetupTestCase     = "setup"
+	junitTimeoutTestCase   = "timeout"
+	junitResultsTestCase   = "results"
+	junitInterruptionCase  = "interruption"
+	missingResultsMessage  = "no results were collected from the instance type"
+	timeoutMessage         = "the test suite didn't finish before the timeout"
+	interruptionMessage    = "the spot instance was interrupted before the test suite finished"
+	testFailureMessage     = "the test file exited with an error"
+	setupFailureMessage    = "the setup of the test suite failed, so no test was executed"
+	detailedResultsMessage = "Detailed test results can be found in"
+	concurrencyMessage     = "The metrics of each test file reflect the load of the test files executed concurrently with it on"
+	junitConcurrency       = "concurrency"
+)
+
+// report contains the rows of every table of the final report, so that all output formats agree with each other.
+type report struct {
+	tables []reportTable
+	// groups contains the results of the replicas of each instance type, in the order of the main table
+	groups               [][]resources.Instance
+	missingInstanceTypes []string
+	detailedResultsUrl   string
+	// hasCosts means the main table has cost columns, in which case recommendation is the cheapest passing
+	// instance type, if any
+	hasCosts       bool
+	recommendation *recommendation
+	summary        Summary
+	// concurrencyNote warns about the instance types whose test files were executed concurrently, if any
+	concurrencyNote string
+}
+
+// reportTable is a table of the final report. The first table of a report has no title.
+type reportTable struct {
+	title  string
+	header []string
+	rows   [][]string
+}
+
+// newReport builds the report of the final result. Instance types which have no result get a row of N/A. If the
+// options have a price source, the costs of each instance type are appended to the main table.
+func newReport(finalResult []resources.Instance, missingInstanceTypes []string, definitions []metrics.Definition, testFixture config.TestFixture, options ReportOptions) (report, error) {
+	mainTable := reportTable{header: tableHeader(definitions, testFixture)}
+	testTable := reportTable{title: testTableTitle, header: testTableHeader(definitions, testFixture)}
+	seriesTable := reportTable{title: seriesTableTitle, header: []string{instanceTypeHeader, metricHeader, minHeader, maxHeader, sparklineHeader}}
+	replicaTable := reportTable{title: replicaTableTitle, header: replicaTableHeader(definitions)}
+	groups := groupByInstanceType(finalResult)
+	hasReplicas := testFixture.ReplicaCount() > 1 || len(groups) < len(finalResult)
+	allTestsPassIdx := indexOf(mainTable.header, allTestsPassHeader)
+	outcomes := make(map[string]string)
+	for _, group := range groups {
+		row, replicaRow, err := parseInstanceGroupToRows(group, definitions, testFixture.RequiredPassRate(), testFixture.ReplicaCount())
+		if err != nil {
+			return report{}, err
+		}
+		mainTable.rows = append(mainTable.rows, row)
+		outcomes[group[0].InstanceType] = groupOutcome(group, row[1], row[allTestsPassIdx] == strconv.FormatBool(true))
+		if hasReplicas {
+			replicaTable.rows = append(replicaTable.rows, replicaRow)
+		}
+		for _, instanceResult := range group {
+			for _, result := range instanceResult.Results {
+				testTable.rows = append(testTable.rows, parseTestResultToRow(instanceResult.InstanceType, result, definitions))
+			}
+			for _, series := range instanceResult.Series {
+				seriesTable.rows = append(seriesTable.rows, parseSeriesToRow(instanceResult.InstanceType, series))
+			}
+		}
+	}
+	for _, instanceType := range missingInstanceTypes {
+		row := []string{instanceType}
+		for i := 1; i < len(mainTable.header); i++ {
+			row = append(row, notApplicable)
+		}
+		mainTable.rows = append(mainTable.rows, row)
+		outcomes[instanceType] = OutcomeNoResults
+	}
+
+	r := report{
+		groups:               groups,
+		missingInstanceTypes: missingInstanceTypes,
+		detailedResultsUrl:   fmt.Sprintf("s3://%s/%s", testFixture.BucketName, testFixture.BucketRootDir),
+		summary:              newSummary(outcomes, options.Requirement),
+		concurrencyNote:      newConcurrencyNote(groups),
+	}
+	if options.PriceSource != nil {
+		r.hasCosts = true
+		r.recommendation = appendCosts(&mainTable, options.PriceSource, options.PriceType)
+	}
+	r.tables = []reportTable{mainTable, testTable, seriesTable, replicaTable}
+	return r, nil
+}
+
+// render outputs the report in the given format.
+func (r report) render(outputFormat string, outputStream *os.File) error {
+	switch outputFormat {
+	case config.OutputJson:
+		return r.renderJson(outputStream)
+	case config.OutputCsv:
+		return r.renderCsv(outputStream)
+	case config.OutputMarkdown:
+		r.renderMarkdown(outputStream)
+		return nil
+	case config.OutputJunitXml:
+		return r.renderJunitXml(outputStream)
+	default:
+		r.renderTable(outputStream)
+		return nil
+	}
+}
+
+// renderTable outputs every non-empty table of the report in table format.
+func (r report) renderTable(outputStream *os.File) {
+	for _, table := range r.tables {
+		if table.title != "" {
+			if len(table.rows) == 0 {
+				continue
+			}
+			fmt.Fprintf(outputStream, "\n%s:\n", table.title)
+		}
+		cmdutil.RenderTable(table.rows, table.header, outputStream)
+	}
+	if r.hasCosts {
+		fmt.Fprintf(outputStream, "\n%s\n", r.recommendation)
+	}
+	if r.concurrencyNote != "" {
+		fmt.Fprintf(outputStream, "\n%s\n", r.concurrencyNote)
+	}
+	fmt.Fprintf(outputStream, "\n%s %s\n", detailedResultsMessage, r.detailedResultsUrl)
+	fmt.Fprintf(outputStream, "\n%s\n", r.summary)
+}
+
+// renderJson outputs the report as a JSON object. Each row becomes an object keyed by the column headers.
+func (r report) renderJson(outputStream io.Writer) error {
+	jsonReport := struct {
+		Results         []map[string]string `json:"results"`
+		Tests           []map[string]string `json:"tests"`
+		Series          []map[string]string `json:"series"`
+		Replicas        []map[string]string `json:"replicas"`
+		Recommendation  *recommendation     `json:"recommendation,omitempty"`
+		Note            string              `json:"note,omitempty"`
+		DetailedResults string              `json:"detailed-results"`
+		Summary         Summary             `json:"summary"`
+	}{
+		Results:         r.tables[0].toObjects(),
+		Tests:           r.tables[1].toObjects(),
+		Series:          r.tables[2].toObjects(),
+		Replicas:        r.tables[3].toObjects(),
+		Recommendation:  r.recommendation,
+		Note:            r.concurrencyNote,
+		DetailedResults: r.detailedResultsUrl,
+		Summary:         r.summary,
+	}
+	encoder := json.NewEncoder(outputStream)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(jsonReport)
+}
+
+// renderCsv outputs the main table of the report as CSV.
+func (r report) renderCsv(outputStream io.Writer) error {
+	writer := csv.NewWriter(outputStream)
+	if err := writer.Write(r.tables[0].header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(r.tables[0].rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// renderMarkdown outputs every non-empty table of the report as a Markdown table.
+func (r report) renderMarkdown(outputStream io.Writer) {
+	escape := strings.NewReplacer("|", "\\|")
+	writeRow := func(cells []string) {
+		var escaped []string
+		for _, cell := range cells {
+			escaped = append(escaped, escape.Replace(cell))
+		}
+		fmt.Fprintf(outputStream, "| %s |\n", strings.Join(escaped, " | "))
+	}
+
+	for i, table := range r.tables {
+		if i > 0 {
+			if len(table.rows) == 0 {
+				continue
+			}
+			fmt.Fprintf(outputStream, "\n**%s**\n\n", table.title)
+		}
+		writeRow(table.header)
+		var separators []string
+		for range table.header {
+			separators = append(separators, "---")
+		}
+		writeRow(separators)
+		for _, row := range table.rows {
+			writeRow(row)
+		}
+	}
+	if r.hasCosts {
+		fmt.Fprintf(outputStream, "\n%s\n", r.recommendation)
+	}
+	if r.concurrencyNote != "" {
+		fmt.Fprintf(outputStream, "\n_%s_\n", r.concurrencyNote)
+	}
+	fmt.Fprintf(outputStream, "\n%s `%s`\n", detailedResultsMessage, r.detailedResultsUrl)
+	fmt.Fprintf(outputStream, "\n**%s**\n", r.summary)
+}
+
+// newConcurrencyNote returns the note listing the instance types whose test files were executed concurrently, with
+// the max number of test files executed at once, or an empty note if every test file was executed alone.
+func newConcurrencyNote(groups [][]resources.Instance) string {
+	var instanceTypes []string
+	for _, group := range groups {
+		if concurrency := maxConcurrency(group); concurrency > 1 {
+			instanceTypes = append(instanceTypes, fmt.Sprintf("%s (%d at once)", regionalKey(group[0].Region, group[0].InstanceType), concurrency))
+		}
+	}
+	if len(instanceTypes) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %s.", concurrencyMessage, strings.Join(instanceTypes, ", "))
+}
+
+// maxConcurrency returns the max number of test files executed at once by the replicas of an instance type.
+func maxConcurrency(group []resources.Instance) (concurrency int) {
+	for _, instanceResult := range group {
+		if instanceResult.Concurrency > concurrency {
+			concurrency = instanceResult.Concurrency
+		}
+	}
+	return concurrency
+}
+
+// toObjects converts every row of the table to an object keyed by the column headers.
+func (t reportTable) toObjects() []map[string]string {
+	objects := []map[string]string{}
+	for _, row := range t.rows {
+		object := make(map[string]string)
+		for i, cell := range row {
+			if i < len(t.header) {
+				object[t.header[i]] = cell
+			}
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// JUnit XML report
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// renderJunitXml outputs the report as JUnit XML. Each instance type is a testsuite whose properties are the
+// columns of its row in the main table, and each test file is a testcase which fails if it exits with an error or
+// any of its metrics breaches its threshold.
+func (r report) renderJunitXml(outputStream io.Writer) error {
+	mainTable := r.tables[0]
+	suites := junitTestSuites{Name: junitSuitesName}
+	for i, group := range r.groups {
+		instanceType := regionalKey(group[0].Region, group[0].InstanceType)
+		suite := junitTestSuite{
+			Name:       instanceType,
+			Properties: rowToProperties(mainTable.header, mainTable.rows[i]),
+		}
+		for j, cell := range mainTable.rows[i] {
+			if mainTable.header[j] == executionTimeHeader {
+				suite.Time = cell
+			}
+		}
+		if concurrency := maxConcurrency(group); concurrency > 1 {
+			suite.Properties = append(suite.Properties, junitProperty{Name: junitConcurrency, Value: strconv.Itoa(concurrency)})
+		}
+		for _, instanceResult := range group {
+			// Tell the replicas of the instance type apart by their instance ID
+			className := instanceType
+			if len(group) > 1 {
+				className = instanceType + "." + instanceResult.InstanceId
+			}
+			if isSetupFailed(instanceResult) {
+				suite.TestCases = append(suite.TestCases, junitTestCase{
+					Name:      junitSetupTestCase,
+					ClassName: className,
+					Time:      instanceResult.Setup.ExecutionTime,
+					Error:     &junitFailure{Message: setupFailureMessage, Type: junitSetupFailure},
+				})
+			}
+			for _, result := range instanceResult.Results {
+				suite.TestCases = append(suite.TestCases, resultToTestCase(className, result))
+			}
+			if instanceResult.IsTimeout {
+				suite.TestCases = append(suite.TestCases, junitTestCase{
+					Name:      junitTimeoutTestCase,
+					ClassName: className,
+					Failure:   &junitFailure{Message: timeoutMessage, Type: junitTestFailure},
+				})
+			}
+			if instanceResult.IsInterrupted {
+				suite.TestCases = append(suite.TestCases, junitTestCase{
+					Name:      junitInterruptionCase,
+					ClassName: className,
+					Error:     &junitFailure{Message: interruptionMessage, Type: junitSpotInterruption},
+				})
+			}
+		}
+		suites.TestSuites = append(suites.TestSuites, suite)
+	}
+	for i, instanceType := range r.missingInstanceTypes {
+		suites.TestSuites = append(suites.TestSuites, junitTestSuite{
+			Name:       instanceType,
+			Properties: rowToProperties(mainTable.header, mainTable.rows[len(r.groups)+i]),
+			TestCases: []junitTestCase{
+				{
+					Name:      junitResultsTestCase,
+					ClassName: instanceType,
+					Error:     &junitFailure{Message: missingResultsMessage, Type: junitMissingResults},
+				},
+			},
+		})
+	}
+
+	for i := range suites.TestSuites {
+		suite := &suites.TestSuites[i]
+		for _, testCase := range suite.TestCases {
+			if testCase.Failure != nil {
+				suite.Failures++
+			}
+			if testCase.Error != nil {
+				suite.Errors++
+			}
+		}
+		suite.Tests = len(suite.TestCases)
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+	}
+
+	fmt.Fprint(outputStream, xml.Header)
+	encoder := xml.NewEncoder(outputStream)
+	encoder.Indent("", "    ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	fmt.Fprintln(outputStream)
+	return nil
+}
+
+// resultToTestCase converts the result of a test file to a testcase, listing the reasons of its failure if any.
+func resultToTestCase(className string, result resources.Result) junitTestCase {
+	testCase := junitTestCase{
+		Name:      result.Label,
+		ClassName: className,
+		Time:      result.ExecutionTime,
+	}
+	var reasons []string
+	failureType := junitThresholdBreach
+	if result.Status == resultFail {
+		reasons = append(reasons, testFailureMessage)
+		failureType = junitTestFailure
+	}
+	for _, metric := range result.Metrics {
+		if metrics.IsBreached(metric.Value, metric.Threshold, metric.Direction) {
+			direction := metric.Direction
+			if direction == "" {
+				direction = metrics.Below
+			}
+			reasons = append(reasons, fmt.Sprintf("%s %.2f is not %s the threshold %.2f (rule: %s)", metric.MetricUsed, metric.Value, direction, metric.Threshold, metric.Rule))
+		}
+	}
+	if len(reasons) > 0 {
+		testCase.Failure = &junitFailure{
+			Message: reasons[0],
+			Type:    failureType,
+			Text:    strings.Join(reasons, "\n"),
+		}
+	}
+	return testCase
+}
+
+// rowToProperties converts a row of a table to JUnit properties keyed by the column headers.
+func rowToProperties(header []string, row []string) (properties []junitProperty) {
+	for i, cell := range row {
+		if i < len(header) {
+			properties = append(properties, junitProperty{Name: header[i], Value: cell})
+		}
+	}
+	return properties
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+func testReport(t *testing.T) report {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.Results[1].Status = "fail"
+	instanceResult.Results[1].Metrics[1].Value = 45.0
+	testFixture := config.TestFixture{BucketName: "qualifier-bucket-123", BucketRootDir: "Instance-Qualifier-Run-123"}
+	r, err := newReport([]resources.Instance{instanceResult}, []string{"a1.large"}, defaultDefinitions(t), testFixture, ReportOptions{})
+	h.Ok(t, err)
+	return r
+}
+
+// Tests
+
+func TestNewReport(t *testing.T) {
+	r := testReport(t)
+	h.Equals(t, 4, len(r.tables))
+	h.Equals(t, [][]string{
+		{"m4.large", "FAIL", "35.80", "40.00", "45.00", "40.00", "false", "130.75", "default"},
+		{"a1.large", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A", "N/A"},
+	}, r.tables[0].rows)
+	h.Equals(t, 2, len(r.tables[1].rows))
+	h.Equals(t, 0, len(r.tables[2].rows))
+	h.Equals(t, 0, len(r.tables[3].rows))
+}
+
+func TestNewReportReplicas(t *testing.T) {
+	failingReplica := deepCopy(globalInstanceResult, t)
+	failingReplica.InstanceId = "i-0ff4a2f594b270b55"
+	failingReplica.Results[0].Metrics[0].Value = 42.0
+	testFixture := config.TestFixture{Replicas: 2, PassRate: 0.5}
+	r, err := newReport([]resources.Instance{globalInstanceResult, failingReplica}, nil, defaultDefinitions(t), testFixture, ReportOptions{})
+	h.Ok(t, err)
+	h.Equals(t, [][]string{
+		{"m4.large", "SUCCESS", "42.00", "40.00", "37.77", "40.00", "true", "130.75", "default"},
+	}, r.tables[0].rows)
+	h.Equals(t, 4, len(r.tables[1].rows))
+	h.Equals(t, [][]string{
+		{"m4.large", "2", "1/2", "130.75", "0.00", "35.80", "42.00", "37.77", "37.77"},
+	}, r.tables[3].rows)
+
+	var buf bytes.Buffer
+	h.Ok(t, r.renderJunitXml(&buf))
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	h.Equals(t, 1, len(actual.TestSuites))
+	h.Equals(t, 4, actual.Tests)
+	h.Equals(t, "m4.large.i-0ff4a2f594b270b55", actual.TestSuites[0].TestCases[2].ClassName)
+}
+
+func TestRenderJson(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, testReport(t).renderJson(&buf))
+
+	var actual struct {
+		Results         []map[string]string `json:"results"`
+		Tests           []map[string]string `json:"tests"`
+		DetailedResults string              `json:"detailed-results"`
+	}
+	h.Ok(t, json.Unmarshal(buf.Bytes(), &actual))
+	h.Equals(t, 2, len(actual.Results))
+	h.Equals(t, "FAIL", actual.Results[0]["STATUS"])
+	h.Equals(t, "45.00", actual.Results[0]["MEM_USED_PERCENT (MAXIMUM)"])
+	h.Equals(t, "mem-test.sh", actual.Tests[1]["TEST FILE"])
+	h.Equals(t, "s3://qualifier-bucket-123/Instance-Qualifier-Run-123", actual.DetailedResults)
+}
+
+func TestRenderCsv(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, testReport(t).renderCsv(&buf))
+	h.Equals(t, `INSTANCE TYPE,STATUS,CPU_USAGE_ACTIVE (MAXIMUM),CPU_THRESHOLD,MEM_USED_PERCENT (MAXIMUM),MEM_THRESHOLD,ALL TESTS PASS?,TOTAL EXECUTION TIME (sec),THRESHOLD RULE
+m4.large,FAIL,35.80,40.00,45.00,40.00,false,130.75,default
+a1.large,N/A,N/A,N/A,N/A,N/A,N/A,N/A,N/A
+`, buf.String())
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	testReport(t).renderMarkdown(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	h.Equals(t, "| INSTANCE TYPE | STATUS | CPU_USAGE_ACTIVE (MAXIMUM) | CPU_THRESHOLD | MEM_USED_PERCENT (MAXIMUM) | MEM_THRESHOLD | ALL TESTS PASS? | TOTAL EXECUTION TIME (sec) | THRESHOLD RULE |", lines[0])
+	h.Equals(t, "| --- | --- | --- | --- | --- | --- | --- | --- | --- |", lines[1])
+	h.Equals(t, "| m4.large | FAIL | 35.80 | 40.00 | 45.00 | 40.00 | false | 130.75 | default |", lines[2])
+	h.Assert(t, strings.Contains(buf.String(), "**"+testTableTitle+"**"), "Missing the per-test table")
+	h.Assert(t, !strings.Contains(buf.String(), seriesTableTitle), "Rendered the empty time series table")
+}
+
+func TestRenderJunitXml(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, testReport(t).renderJunitXml(&buf))
+
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	h.Equals(t, 3, actual.Tests)
+	h.Equals(t, 1, actual.Failures)
+	h.Equals(t, 1, actual.Errors)
+	h.Equals(t, 2, len(actual.TestSuites))
+
+	suite := actual.TestSuites[0]
+	h.Equals(t, "m4.large", suite.Name)
+	h.Equals(t, "130.75", suite.Time)
+	h.Equals(t, junitProperty{Name: "STATUS", Value: "FAIL"}, suite.Properties[1])
+	h.Assert(t, suite.TestCases[0].Failure == nil, "Reported a failure for a passing test file")
+	failure := suite.TestCases[1].Failure
+	h.Assert(t, failure != nil, "Failed to report a failure for a failing test file")
+	h.Equals(t, junitTestFailure, failure.Type)
+	h.Equals(t, testFailureMessage+"\nmem_used_percent 45.00 is not below the threshold 40.00 (rule: default)", failure.Text)
+
+	h.Equals(t, "a1.large", actual.TestSuites[1].Name)
+	h.Equals(t, junitMissingResults, actual.TestSuites[1].TestCases[0].Error.Type)
+}
+
+func TestNewReportConcurrency(t *testing.T) {
+	h.Equals(t, "", testReport(t).concurrencyNote)
+
+	concurrentResult := deepCopy(globalInstanceResult, t)
+	concurrentResult.Concurrency = 2
+	r, err := newReport([]resources.Instance{concurrentResult}, nil, defaultDefinitions(t), config.TestFixture{}, ReportOptions{})
+	h.Ok(t, err)
+	h.Equals(t, concurrencyMessage+" m4.large (2 at once).", r.concurrencyNote)
+	var buf bytes.Buffer
+	r.renderMarkdown(&buf)
+	h.Assert(t, strings.Contains(buf.String(), "_"+r.concurrencyNote+"_"), "Missing the concurrency note")
+
+	buf.Reset()
+	h.Ok(t, r.renderJunitXml(&buf))
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	properties := actual.TestSuites[0].Properties
+	h.Equals(t, junitProperty{Name: junitConcurrency, Value: "2"}, properties[len(properties)-1])
+}
+
+func TestRenderJunitXmlInterrupted(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	instanceResult.IsInterrupted = true
+	r, err := newReport([]resources.Instance{instanceResult}, nil, defaultDefinitions(t), config.TestFixture{}, ReportOptions{})
+	h.Ok(t, err)
+	var buf bytes.Buffer
+	h.Ok(t, r.renderJunitXml(&buf))
+
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	suite := actual.TestSuites[0]
+	h.Equals(t, junitProperty{Name: "STATUS", Value: "INTERRUPTED"}, suite.Properties[1])
+	h.Equals(t, 3, len(suite.TestCases))
+	h.Equals(t, junitInterruptionCase, suite.TestCases[2].Name)
+	h.Equals(t, junitSpotInterruption, suite.TestCases[2].Error.Type)
+}
+
+func TestRenderJunitXmlSetupFailed(t *testing.T) {
+	instanceResult := resources.Instance{
+		InstanceId:   "i-0ff4a2f594b270b54",
+		InstanceType: "m4.large",
+		Setup:        &resources.HookResult{Label: "setup.sh", Status: "fail", Attempt: resources.Attempt{ExitCode: 1, ExecutionTime: "2.000"}},
+		Results:      make([]resources.Result, 0),
+	}
+	r, err := newReport([]resources.Instance{instanceResult}, nil, defaultDefinitions(t), config.TestFixture{}, ReportOptions{})
+	h.Ok(t, err)
+	h.Equals(t, map[string]string{"m4.large": OutcomeSetupFailed}, r.summary.Outcomes)
+	h.Equals(t, config.ExitCodeSetupFailed, r.summary.ExitCode)
+	var buf bytes.Buffer
+	h.Ok(t, r.renderJunitXml(&buf))
+
+	var actual junitTestSuites
+	h.Ok(t, xml.Unmarshal(buf.Bytes(), &actual))
+	suite := actual.TestSuites[0]
+	h.Equals(t, junitProperty{Name: "STATUS", Value: "SETUP_FAILED"}, suite.Properties[1])
+	h.Equals(t, 1, len(suite.TestCases))
+	h.Equals(t, junitSetupTestCase, suite.TestCases[0].Name)
+	h.Equals(t, junitSetupFailure, suite.TestCases[0].Error.Type)
+	h.Equals(t, 1, suite.Errors)
+}
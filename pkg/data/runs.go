@@ -0,0 +1,107 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+const (
+	runIdHeader         = "RUN ID"
+	startTimeHeader     = "START TIME"
+	stackStatusHeader   = "STACK STATUS"
+	instanceTypesHeader = "INSTANCE TYPES"
+	finalResultsHeader  = "FINAL RESULTS?"
+	bucketExistsHeader  = "BUCKET EXISTS?"
+	stackExistsHeader   = "STACK EXISTS?"
+	resumeMessage       = "Runs whose bucket exists can be resumed with --bucket=qualifier-bucket-<RUN ID>"
+	noRunsMessage       = "No instance-qualifier run was found"
+)
+
+// OutputRuns outputs the runs in the given format. Only the table, json and csv formats are supported; any other
+// format falls back to a table.
+func OutputRuns(runs []resources.Run, outputFormat string, outputStream *os.File) error {
+	switch outputFormat {
+	case config.OutputJson:
+		return renderRunsJson(runs, outputStream)
+	case config.OutputCsv:
+		return renderRunsCsv(runs, outputStream)
+	default:
+		if len(runs) == 0 {
+			fmt.Fprintln(outputStream, noRunsMessage)
+			return nil
+		}
+		cmdutil.RenderTable(runsToRows(runs), runsTableHeader(), outputStream)
+		fmt.Fprintf(outputStream, "\n%s\n", resumeMessage)
+		return nil
+	}
+}
+
+// renderRunsJson outputs the runs as a JSON array.
+func renderRunsJson(runs []resources.Run, outputStream io.Writer) error {
+	if runs == nil {
+		runs = []resources.Run{}
+	}
+	encoder := json.NewEncoder(outputStream)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(runs)
+}
+
+// renderRunsCsv outputs the runs as CSV.
+func renderRunsCsv(runs []resources.Run, outputStream io.Writer) error {
+	writer := csv.NewWriter(outputStream)
+	if err := writer.Write(runsTableHeader()); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(runsToRows(runs)); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// runsTableHeader returns the header of the runs table.
+func runsTableHeader() []string {
+	return []string{runIdHeader, startTimeHeader, stackStatusHeader, instanceTypesHeader, finalResultsHeader, bucketExistsHeader, stackExistsHeader}
+}
+
+// runsToRows populates and returns the row data of each run, using N/A for unknown details.
+func runsToRows(runs []resources.Run) (rows [][]string) {
+	orNotApplicable := func(s string) string {
+		if s == "" {
+			return notApplicable
+		}
+		return s
+	}
+	for _, run := range runs {
+		rows = append(rows, []string{
+			run.RunId,
+			orNotApplicable(run.StartTime),
+			orNotApplicable(run.StackStatus),
+			orNotApplicable(run.InstanceTypes),
+			strconv.FormatBool(run.HasFinalResults),
+			strconv.FormatBool(run.HasBucket),
+			strconv.FormatBool(run.HasStack),
+		})
+	}
+	return rows
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+var testRuns = []resources.Run{
+	{
+		RunId:         "new",
+		StartTime:     "2020-09-02T10:00:00Z",
+		InstanceTypes: "c5.large",
+		Bucket:        "qualifier-bucket-new",
+		StackName:     "qualifier-stack-new",
+		StackStatus:   "CREATE_COMPLETE",
+		HasBucket:     true,
+		HasStack:      true,
+	},
+	{
+		RunId:       "orphan",
+		StackName:   "qualifier-stack-orphan",
+		StackStatus: "DELETE_FAILED",
+		HasStack:    true,
+	},
+}
+
+func TestRunsToRows(t *testing.T) {
+	expected := [][]string{
+		{"new", "2020-09-02T10:00:00Z", "CREATE_COMPLETE", "c5.large", "false", "true", "true"},
+		{"orphan", "N/A", "DELETE_FAILED", "N/A", "false", "false", "true"},
+	}
+	h.Equals(t, expected, runsToRows(testRuns))
+}
+
+func TestRenderRunsCsv(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, renderRunsCsv(testRuns, &buf))
+	expected := "RUN ID,START TIME,STACK STATUS,INSTANCE TYPES,FINAL RESULTS?,BUCKET EXISTS?,STACK EXISTS?\n" +
+		"new,2020-09-02T10:00:00Z,CREATE_COMPLETE,c5.large,false,true,true\n" +
+		"orphan,N/A,DELETE_FAILED,N/A,false,false,true\n"
+	h.Equals(t, expected, buf.String())
+}
+
+func TestRenderRunsJson(t *testing.T) {
+	var buf bytes.Buffer
+	h.Ok(t, renderRunsJson(testRuns, &buf))
+	var actual []resources.Run
+	h.Ok(t, json.Unmarshal(buf.Bytes(), &actual))
+	h.Equals(t, testRuns, actual)
+
+	buf.Reset()
+	h.Ok(t, renderRunsJson(nil, &buf))
+	h.Equals(t, "[]\n", buf.String())
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+)
+
+// Outcomes of an instance type, and statuses of a run.
+const (
+	OutcomePassed           = "PASSED"
+	OutcomeThresholdsFailed = "THRESHOLDS_FAILED"
+	OutcomeTestsFailed      = "TESTS_FAILED"
+	OutcomeSetupFailed      = "SETUP_FAILED"
+	OutcomeInterrupted      = "INTERRUPTED"
+	OutcomeTimeout          = "TIMEOUT"
+	OutcomeNoResults        = "NO_RESULTS"
+	StatusAllPassed         = "ALL_PASSED"
+	StatusRequirementMet    = "REQUIREMENT_MET"
+	StatusRequirementNotMet = "REQUIREMENT_NOT_MET"
+	summaryTemplate         = "Summary: %s (exit code %d)"
+)
+
+// outcomeExitCodes are the exit codes of the outcomes, in increasing order of severity.
+var outcomeExitCodes = []struct {
+	outcome  string
+	exitCode int
+}{
+	{OutcomePassed, config.ExitCodeAllPassed},
+	{OutcomeThresholdsFailed, config.ExitCodeThresholdsFailed},
+	{OutcomeTestsFailed, config.ExitCodeTestsFailed},
+	{OutcomeSetupFailed, config.ExitCodeSetupFailed},
+	{OutcomeInterrupted, config.ExitCodeTimeout},
+	{OutcomeTimeout, config.ExitCodeTimeout},
+	{OutcomeNoResults, config.ExitCodeNoResults},
+}
+
+// Summary is the overall status of a run and the exit code of the CLI, so that CI pipelines can tell an instance
+// type which doesn't qualify from an infrastructure error.
+type Summary struct {
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit-code"`
+	// Outcomes maps each instance type to its outcome. Instance types are prefixed by their region in a multi-region
+	// run, e.g. "us-east-1/m5.large"
+	Outcomes    map[string]string `json:"outcomes"`
+	Requirement string            `json:"requirement,omitempty"`
+}
+
+// String returns the summary line of the report.
+func (s Summary) String() string {
+	return fmt.Sprintf(summaryTemplate, s.Status, s.ExitCode)
+}
+
+// newSummary summarizes the outcomes of the instance types. Without a requirement, the status of the run is its
+// worst outcome, or ALL_PASSED. With a requirement, the status only tells whether the requirement is met.
+func newSummary(outcomes map[string]string, requirement *config.Requirement) Summary {
+	summary := Summary{Status: StatusAllPassed, ExitCode: config.ExitCodeAllPassed, Outcomes: outcomes}
+	if requirement != nil {
+		summary.Requirement = requirement.Expression
+		if isRequirementMet(*requirement, outcomes) {
+			summary.Status = StatusRequirementMet
+		} else {
+			summary.Status, summary.ExitCode = StatusRequirementNotMet, config.ExitCodeRequirementNotMet
+		}
+		return summary
+	}
+
+	worst := 0
+	for _, outcome := range outcomes {
+		for severity, outcomeExitCode := range outcomeExitCodes {
+			if outcomeExitCode.outcome == outcome && severity > worst {
+				worst = severity
+			}
+		}
+	}
+	if worst > 0 {
+		summary.Status, summary.ExitCode = outcomeExitCodes[worst].outcome, outcomeExitCodes[worst].exitCode
+	}
+	return summary
+}
+
+// groupOutcome returns the outcome of an instance type from the results of its replicas and its row in the main
+// table. It passes if its status is SUCCESS and all its tests pass, as for the recommendation.
+func groupOutcome(group []resources.Instance, status string, allTestsPass bool) string {
+	if status == statusSuccess && allTestsPass {
+		return OutcomePassed
+	}
+	if status == statusInterrupted {
+		return OutcomeInterrupted
+	}
+	if status == statusSetupFailed {
+		return OutcomeSetupFailed
+	}
+	hasResults, isTimeout := false, false
+	for _, instanceResult := range group {
+		if len(instanceResult.Results) > 0 {
+			hasResults = true
+		}
+		if instanceResult.IsTimeout && !instanceResult.IsInterrupted {
+			isTimeout = true
+		}
+	}
+	switch {
+	case !hasResults:
+		return OutcomeNoResults
+	case isTimeout:
+		return OutcomeTimeout
+	case !allTestsPass:
+		return OutcomeTestsFailed
+	default:
+		return OutcomeThresholdsFailed
+	}
+}
+
+// isRequirementMet checks whether enough instance types matching the requirement passed. Each instance type counts
+// once, however many of the patterns it matches. A requirement on all matching instance types also requires each of
+// its patterns to match an instance type of the run. The patterns match the instance type in every region of a
+// multi-region run.
+func isRequirementMet(requirement config.Requirement, outcomes map[string]string) bool {
+	matchedInstanceTypes := make(map[string]bool)
+	for _, pattern := range requirement.Patterns {
+		isPatternMatched := false
+		for instanceType := range outcomes {
+			if ok, err := path.Match(pattern, path.Base(instanceType)); err != nil || !ok {
+				continue
+			}
+			isPatternMatched = true
+			matchedInstanceTypes[instanceType] = true
+		}
+		if requirement.MinPassing == 0 && !isPatternMatched {
+			return false
+		}
+	}
+	passed := 0
+	for instanceType := range matchedInstanceTypes {
+		if outcomes[instanceType] == OutcomePassed {
+			passed++
+		}
+	}
+	if requirement.MinPassing == 0 {
+		return passed == len(matchedInstanceTypes)
+	}
+	return passed >= requirement.MinPassing
+}
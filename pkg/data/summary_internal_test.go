@@ -0,0 +1,95 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestNewReportSummary(t *testing.T) {
+	summary := testReport(t).summary
+	h.Equals(t, map[string]string{"m4.large": OutcomeTestsFailed, "a1.large": OutcomeNoResults}, summary.Outcomes)
+	h.Equals(t, OutcomeNoResults, summary.Status)
+	h.Equals(t, config.ExitCodeNoResults, summary.ExitCode)
+	h.Equals(t, "Summary: NO_RESULTS (exit code 6)", summary.String())
+}
+
+func TestGroupOutcome(t *testing.T) {
+	instanceResult := deepCopy(globalInstanceResult, t)
+	h.Equals(t, OutcomePassed, groupOutcome([]resources.Instance{instanceResult}, statusSuccess, true))
+	h.Equals(t, OutcomeThresholdsFailed, groupOutcome([]resources.Instance{instanceResult}, statusFail, true))
+	h.Equals(t, OutcomeTestsFailed, groupOutcome([]resources.Instance{instanceResult}, statusFail, false))
+	h.Equals(t, OutcomeInterrupted, groupOutcome([]resources.Instance{instanceResult}, statusInterrupted, false))
+	h.Equals(t, OutcomeSetupFailed, groupOutcome([]resources.Instance{instanceResult}, statusSetupFailed, false))
+
+	instanceResult.IsTimeout = true
+	h.Equals(t, OutcomeTimeout, groupOutcome([]resources.Instance{instanceResult}, statusFail, false))
+	instanceResult.Results = nil
+	h.Equals(t, OutcomeNoResults, groupOutcome([]resources.Instance{instanceResult}, statusFail, false))
+}
+
+func TestNewSummaryWorstOutcome(t *testing.T) {
+	summary := newSummary(map[string]string{"m5.large": OutcomePassed, "c5.large": OutcomeThresholdsFailed}, nil)
+	h.Equals(t, OutcomeThresholdsFailed, summary.Status)
+	h.Equals(t, config.ExitCodeThresholdsFailed, summary.ExitCode)
+
+	summary = newSummary(map[string]string{"m5.large": OutcomePassed}, nil)
+	h.Equals(t, StatusAllPassed, summary.Status)
+	h.Equals(t, config.ExitCodeAllPassed, summary.ExitCode)
+}
+
+func TestNewSummaryRequirement(t *testing.T) {
+	outcomes := map[string]string{"m5.large": OutcomePassed, "m5.xlarge": OutcomeTestsFailed, "c5.large": OutcomeTimeout}
+	for expression, isMet := range map[string]bool{
+		"at least one of m5.large,c5.large passes": true,
+		"at least 2 of m5.*,c5.large pass":         false,
+		"all of m5.* pass":                         false,
+		"all of m5.large,r5.large pass":            false,
+		"m5.large passes":                          true,
+		"any of c5.* passes":                       false,
+	} {
+		requirement, err := config.ParseRequirement(expression)
+		h.Ok(t, err)
+		summary := newSummary(outcomes, &requirement)
+		h.Equals(t, expression, summary.Requirement)
+		if isMet {
+			h.Equals(t, StatusRequirementMet, summary.Status)
+			h.Equals(t, config.ExitCodeAllPassed, summary.ExitCode)
+		} else {
+			h.Equals(t, StatusRequirementNotMet, summary.Status)
+			h.Equals(t, config.ExitCodeRequirementNotMet, summary.ExitCode)
+		}
+	}
+}
+
+func TestNewSummaryRequirementOverlappingPatterns(t *testing.T) {
+	outcomes := map[string]string{"m5.large": OutcomePassed, "m5.xlarge": OutcomeTestsFailed}
+	for expression, isMet := range map[string]bool{
+		// m5.large counts once although both patterns match it
+		"at least 2 of m5.*,m5.large pass":     false,
+		"at least one of m5.*,m5.large passes": true,
+		"all of m5.large,m5.l* pass":           true,
+		"all of m5.*,m5.large pass":            false,
+	} {
+		requirement, err := config.ParseRequirement(expression)
+		h.Ok(t, err)
+		h.Equals(t, isMet, isRequirementMet(requirement, outcomes))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+)
+
+const (
+	defaultRuleName = "default"
+)
+
+// resolveThreshold returns the threshold of a metric for a test file executed on an instance type, along with the
+// name of the rule it comes from. Rules are evaluated in order and the first one that matches and overrides the
+// metric wins; if none does, the global threshold is used.
+func resolveThreshold(testFixture config.TestFixture, metricName string, instanceType string, testFile string) (float64, string) {
+	for i, rule := range testFixture.ThresholdRules {
+		if !matchesAny(rule.InstanceTypes, instanceType) || !matchesAny(rule.TestFiles, testFile) {
+			continue
+		}
+		if threshold := pickThreshold(rule.CpuThreshold, rule.MemThreshold, rule.Thresholds[metricName], metricName); threshold > 0 {
+			return threshold, ruleName(rule, i)
+		}
+	}
+	return pickThreshold(testFixture.CpuThreshold, testFixture.MemThreshold, testFixture.MetricThresholds[metricName].Threshold, metricName), defaultRuleName
+}
+
+// resolveDirection returns the direction in which a metric is compared against its threshold.
+func resolveDirection(testFixture config.TestFixture, definition metrics.Definition) string {
+	if direction := testFixture.MetricThresholds[definition.Name].Direction; direction != "" {
+		return direction
+	}
+	return definition.Direction
+}
+
+// pickThreshold picks the threshold corresponding to the metric. cpu_usage_active and mem_used_percent have
+// dedicated thresholds while other metrics use the generic one.
+func pickThreshold(cpuThreshold int, memThreshold int, threshold float64, metricName string) float64 {
+	switch metricName {
+	case metrics.CpuUsageActive:
+		return float64(cpuThreshold)
+	case metrics.MemUsedPercent:
+		return float64(memThreshold)
+	}
+	return threshold
+}
+
+// ruleName returns the name of the rule, or a name derived from its position if the user didn't give one.
+func ruleName(rule config.ThresholdRule, idx int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return fmt.Sprintf("rule-%d", idx)
+}
+
+// matchesAny checks whether the name matches any of the comma-separated patterns. An empty pattern list
+// matches everything.
+func matchesAny(patterns string, name string) bool {
+	if patterns == "" {
+		return true
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if matched, err := path.Match(strings.TrimSpace(pattern), name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
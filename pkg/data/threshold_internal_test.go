@@ -0,0 +1,91 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+var thresholdTestFixture = config.TestFixture{
+	CpuThreshold: 30,
+	MemThreshold: 40,
+	MetricThresholds: map[string]config.MetricThreshold{
+		metrics.NetBytesRecv: {Threshold: 1000, Direction: metrics.Above},
+	},
+	ThresholdRules: []config.ThresholdRule{
+		{
+			Name:          "burstable",
+			InstanceTypes: "t3.*",
+			CpuThreshold:  80,
+			Thresholds:    map[string]float64{metrics.NetBytesRecv: 500},
+		},
+		{
+			InstanceTypes: "m5.large",
+			TestFiles:     "mem-*.sh",
+			CpuThreshold:  50,
+			MemThreshold:  60,
+		},
+	},
+}
+
+// Tests
+
+func TestResolveThresholdDefault(t *testing.T) {
+	threshold, rule := resolveThreshold(thresholdTestFixture, metrics.CpuUsageActive, "m4.large", "cpu-test.sh")
+	h.Equals(t, 30.0, threshold)
+	h.Equals(t, "default", rule)
+}
+
+func TestResolveThresholdInstanceTypePattern(t *testing.T) {
+	threshold, rule := resolveThreshold(thresholdTestFixture, metrics.CpuUsageActive, "t3.micro", "cpu-test.sh")
+	h.Equals(t, 80.0, threshold)
+	h.Equals(t, "burstable", rule)
+}
+
+func TestResolveThresholdFallsThroughUnsetMetric(t *testing.T) {
+	threshold, rule := resolveThreshold(thresholdTestFixture, metrics.MemUsedPercent, "t3.micro", "cpu-test.sh")
+	h.Equals(t, 40.0, threshold)
+	h.Equals(t, "default", rule)
+}
+
+func TestResolveThresholdTestFilePattern(t *testing.T) {
+	threshold, rule := resolveThreshold(thresholdTestFixture, metrics.MemUsedPercent, "m5.large", "mem-test.sh")
+	h.Equals(t, 60.0, threshold)
+	h.Equals(t, "rule-1", rule)
+
+	threshold, rule = resolveThreshold(thresholdTestFixture, metrics.MemUsedPercent, "m5.large", "cpu-test.sh")
+	h.Equals(t, 40.0, threshold)
+	h.Equals(t, "default", rule)
+}
+
+func TestResolveThresholdGenericMetric(t *testing.T) {
+	threshold, rule := resolveThreshold(thresholdTestFixture, metrics.NetBytesRecv, "m4.large", "cpu-test.sh")
+	h.Equals(t, 1000.0, threshold)
+	h.Equals(t, "default", rule)
+
+	threshold, rule = resolveThreshold(thresholdTestFixture, metrics.NetBytesRecv, "t3.micro", "cpu-test.sh")
+	h.Equals(t, 500.0, threshold)
+	h.Equals(t, "burstable", rule)
+}
+
+func TestResolveDirection(t *testing.T) {
+	netBytesRecv, _ := metrics.Get(metrics.NetBytesRecv)
+	h.Equals(t, metrics.Above, resolveDirection(thresholdTestFixture, netBytesRecv))
+	cpuUsageActive, _ := metrics.Get(metrics.CpuUsageActive)
+	h.Equals(t, metrics.Below, resolveDirection(thresholdTestFixture, cpuUsageActive))
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metrics
+
+import (
+	"encoding/json"
+)
+
+const (
+	// CollectionInterval is how often (in seconds) the CloudWatch agent collects metrics.
+	CollectionInterval = 60
+	cwAgentUser        = "cwagent"
+)
+
+// AgentConfig generates the CloudWatch agent config collecting the given metrics.
+func AgentConfig(definitions []Definition) ([]byte, error) {
+	metricsCollected := make(map[string]map[string]interface{})
+	isAggregated := false
+	for _, definition := range definitions {
+		plugin, ok := metricsCollected[definition.Plugin]
+		if !ok {
+			plugin = map[string]interface{}{
+				"measurement":                 []string{},
+				"metrics_collection_interval": CollectionInterval,
+			}
+			for key, value := range definition.PluginOptions {
+				plugin[key] = value
+			}
+			metricsCollected[definition.Plugin] = plugin
+		}
+		plugin["measurement"] = append(plugin["measurement"].([]string), definition.Name)
+		if definition.Aggregated {
+			isAggregated = true
+		}
+	}
+
+	metricsConfig := map[string]interface{}{
+		"append_dimensions": map[string]string{
+			"InstanceId":   "${aws:InstanceId}",
+			"InstanceType": "${aws:InstanceType}",
+		},
+		"metrics_collected": metricsCollected,
+	}
+	if isAggregated {
+		metricsConfig["aggregation_dimensions"] = [][]string{{"InstanceId", "InstanceType"}}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"agent": map[string]interface{}{
+			"metrics_collection_interval": CollectionInterval,
+			"run_as_user":                 cwAgentUser,
+		},
+		"metrics": metricsConfig,
+	}, "", "\t")
+}
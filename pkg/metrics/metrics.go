@@ -0,0 +1,243 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the metrics supported by instance-qualifier, as emitted by the CloudWatch agent.
+const (
+	CpuUsageActive        = "cpu_usage_active"
+	MemUsedPercent        = "mem_used_percent"
+	DiskUsedPercent       = "disk_used_percent"
+	DiskioReadBytes       = "diskio_read_bytes"
+	DiskioWriteBytes      = "diskio_write_bytes"
+	NetBytesSent          = "net_bytes_sent"
+	NetBytesRecv          = "net_bytes_recv"
+	SwapUsedPercent       = "swap_used_percent"
+	ProcessesRunning      = "processes_running"
+	NetstatTcpEstablished = "netstat_tcp_established"
+)
+
+// Directions in which a metric value is compared against its threshold.
+const (
+	// Below means the value must stay below the threshold.
+	Below = "below"
+	// Above means the value must stay above the threshold.
+	Above = "above"
+)
+
+// Statistics used to aggregate the datapoints of a metric over the execution window of a test file.
+const (
+	Average = "Average"
+	Minimum = "Minimum"
+	Maximum = "Maximum"
+	P50     = "p50"
+	P90     = "p90"
+	P95     = "p95"
+	P99     = "p99"
+)
+
+// DefaultStatistic is the statistic used when the user doesn't choose one. A single spike is enough to breach a
+// threshold with it.
+const DefaultStatistic = Maximum
+
+// Statistics are the statistics supported by instance-qualifier.
+var Statistics = []string{Average, Minimum, Maximum, P50, P90, P95, P99}
+
+// LocalMetrics are the metrics supported by the local backend, which samples them from /proc instead of running the
+// CloudWatch agent.
+var LocalMetrics = []string{CpuUsageActive, MemUsedPercent, SwapUsedPercent, ProcessesRunning}
+
+// Definition declaratively describes a metric: how the CloudWatch agent collects it, how it is queried from
+// CloudWatch, and how it is presented in the results.
+type Definition struct {
+	// Name is the metric name emitted by the CloudWatch agent, which is also its measurement name.
+	Name string
+	// Plugin is the section of "metrics_collected" in the CloudWatch agent config collecting the metric.
+	Plugin string
+	// PluginOptions are additional keys of the plugin section.
+	PluginOptions map[string]interface{}
+	// Dimensions are the dimensions besides InstanceId and InstanceType used to query the metric.
+	Dimensions map[string]string
+	// Aggregated means the metric has per-resource dimensions (e.g. disk device or network interface) which are
+	// unknown in advance, so it is queried through the InstanceId/InstanceType aggregation instead.
+	Aggregated bool
+	// ColumnPrefix prefixes the threshold column in the output table.
+	ColumnPrefix string
+	Unit         string
+	Direction    string
+}
+
+var registry = map[string]Definition{
+	CpuUsageActive: {
+		Name:          CpuUsageActive,
+		Plugin:        "cpu",
+		PluginOptions: map[string]interface{}{"resources": []string{"*"}},
+		Dimensions:    map[string]string{"cpu": "cpu-total"},
+		ColumnPrefix:  "CPU",
+		Unit:          "Percent",
+		Direction:     Below,
+	},
+	MemUsedPercent: {
+		Name:         MemUsedPercent,
+		Plugin:       "mem",
+		ColumnPrefix: "MEM",
+		Unit:         "Percent",
+		Direction:    Below,
+	},
+	DiskUsedPercent: {
+		Name:          DiskUsedPercent,
+		Aggregated:    true,
+		Plugin:        "disk",
+		PluginOptions: map[string]interface{}{"resources": []string{"/"}},
+		ColumnPrefix:  "DISK",
+		Unit:          "Percent",
+		Direction:     Below,
+	},
+	DiskioReadBytes: {
+		Name:          DiskioReadBytes,
+		Aggregated:    true,
+		Plugin:        "diskio",
+		PluginOptions: map[string]interface{}{"resources": []string{"*"}},
+		ColumnPrefix:  "DISKIO_READ",
+		Unit:          "Bytes",
+		Direction:     Below,
+	},
+	DiskioWriteBytes: {
+		Name:          DiskioWriteBytes,
+		Aggregated:    true,
+		Plugin:        "diskio",
+		PluginOptions: map[string]interface{}{"resources": []string{"*"}},
+		ColumnPrefix:  "DISKIO_WRITE",
+		Unit:          "Bytes",
+		Direction:     Below,
+	},
+	NetBytesSent: {
+		Name:          NetBytesSent,
+		Aggregated:    true,
+		Plugin:        "net",
+		PluginOptions: map[string]interface{}{"resources": []string{"*"}},
+		ColumnPrefix:  "NET_SENT",
+		Unit:          "Bytes",
+		Direction:     Below,
+	},
+	NetBytesRecv: {
+		Name:          NetBytesRecv,
+		Aggregated:    true,
+		Plugin:        "net",
+		PluginOptions: map[string]interface{}{"resources": []string{"*"}},
+		ColumnPrefix:  "NET_RECV",
+		Unit:          "Bytes",
+		Direction:     Below,
+	},
+	SwapUsedPercent: {
+		Name:         SwapUsedPercent,
+		Plugin:       "swap",
+		ColumnPrefix: "SWAP",
+		Unit:         "Percent",
+		Direction:    Below,
+	},
+	// The CloudWatch agent doesn't expose the load average, so the run queue length is used instead
+	ProcessesRunning: {
+		Name:         ProcessesRunning,
+		Plugin:       "processes",
+		ColumnPrefix: "RUNNING",
+		Unit:         "Count",
+		Direction:    Below,
+	},
+	NetstatTcpEstablished: {
+		Name:         NetstatTcpEstablished,
+		Plugin:       "netstat",
+		ColumnPrefix: "TCP_ESTABLISHED",
+		Unit:         "Count",
+		Direction:    Below,
+	},
+}
+
+// DefaultMetrics are the metrics used when the user doesn't select any.
+var DefaultMetrics = []string{CpuUsageActive, MemUsedPercent}
+
+// Get returns the definition of a metric.
+func Get(name string) (Definition, bool) {
+	definition, ok := registry[name]
+	return definition, ok
+}
+
+// Names returns the names of all supported metrics in alphabetical order.
+func Names() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the definitions of the metrics in the given order. If no metric is given, the definitions of
+// DefaultMetrics are returned.
+func Select(names []string) (definitions []Definition, err error) {
+	if len(names) == 0 {
+		names = DefaultMetrics
+	}
+	for _, name := range names {
+		definition, ok := Get(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("metric %s is not supported; supported metrics are %v", name, Names())
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+// ValidateStatistic checks that a statistic is supported.
+func ValidateStatistic(statistic string) error {
+	for _, supported := range Statistics {
+		if statistic == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("statistic %s is not supported; supported statistics are %v", statistic, Statistics)
+}
+
+// IsBreached checks whether a metric value breaches the threshold in the given direction.
+func IsBreached(value float64, threshold float64, direction string) bool {
+	if direction == Above {
+		return value <= threshold
+	}
+	return value >= threshold
+}
+
+// IsWorse checks whether a metric value is further from staying within its threshold than another value in the given
+// direction: a higher value is worse for a metric which must stay below its threshold, and a lower one for a metric
+// which must stay above it.
+func IsWorse(value float64, other float64, direction string) bool {
+	if direction == Above {
+		return value < other
+	}
+	return value > other
+}
+
+// ValueHeader returns the header of the column containing the metric value aggregated with the given statistic.
+func (d Definition) ValueHeader(statistic string) string {
+	return strings.ToUpper(d.Name) + " (" + strings.ToUpper(statistic) + ")"
+}
+
+// ThresholdHeader returns the header of the column containing the metric threshold.
+func (d Definition) ThresholdHeader() string {
+	return d.ColumnPrefix + "_THRESHOLD"
+}
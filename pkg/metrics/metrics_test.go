@@ -0,0 +1,87 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metrics_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+const (
+	agentConfigFilesPath = "../../test/static/CloudWatchAgentConfig"
+)
+
+// Tests
+
+func TestSelectDefault(t *testing.T) {
+	definitions, err := metrics.Select(nil)
+	h.Ok(t, err)
+	h.Equals(t, 2, len(definitions))
+	h.Equals(t, metrics.CpuUsageActive, definitions[0].Name)
+	h.Equals(t, metrics.MemUsedPercent, definitions[1].Name)
+}
+
+func TestSelectUnsupportedMetricFailure(t *testing.T) {
+	_, err := metrics.Select([]string{metrics.CpuUsageActive, "load_average"})
+	h.Assert(t, err != nil, "Failed to return error when a metric is not supported")
+}
+
+func TestIsBreached(t *testing.T) {
+	h.Equals(t, true, metrics.IsBreached(50, 40, metrics.Below))
+	h.Equals(t, false, metrics.IsBreached(30, 40, metrics.Below))
+	h.Equals(t, true, metrics.IsBreached(30, 40, metrics.Above))
+	h.Equals(t, false, metrics.IsBreached(50, 40, metrics.Above))
+}
+
+func TestIsWorse(t *testing.T) {
+	h.Equals(t, true, metrics.IsWorse(50, 40, metrics.Below))
+	h.Equals(t, false, metrics.IsWorse(30, 40, metrics.Below))
+	h.Equals(t, true, metrics.IsWorse(30, 40, metrics.Above))
+	h.Equals(t, false, metrics.IsWorse(50, 40, metrics.Above))
+}
+
+func TestAgentConfigDefault(t *testing.T) {
+	definitions, err := metrics.Select(nil)
+	h.Ok(t, err)
+
+	actual, err := metrics.AgentConfig(definitions)
+	h.Ok(t, err)
+	expected, err := ioutil.ReadFile(agentConfigFilesPath + "/default.json")
+	h.Assert(t, err == nil, "Error reading expected CloudWatch agent config")
+	h.Equals(t, string(expected), string(actual))
+}
+
+func TestAgentConfigMergesPluginsAndAggregates(t *testing.T) {
+	definitions, err := metrics.Select([]string{metrics.NetBytesSent, metrics.NetBytesRecv, metrics.MemUsedPercent})
+	h.Ok(t, err)
+
+	agentConfig, err := metrics.AgentConfig(definitions)
+	h.Ok(t, err)
+	var actual struct {
+		Metrics struct {
+			AggregationDimensions [][]string `json:"aggregation_dimensions"`
+			MetricsCollected      map[string]struct {
+				Measurement []string `json:"measurement"`
+			} `json:"metrics_collected"`
+		} `json:"metrics"`
+	}
+	h.Ok(t, json.Unmarshal(agentConfig, &actual))
+	h.Equals(t, [][]string{{"InstanceId", "InstanceType"}}, actual.Metrics.AggregationDimensions)
+	h.Equals(t, []string{metrics.NetBytesSent, metrics.NetBytesRecv}, actual.Metrics.MetricsCollected["net"].Measurement)
+	h.Equals(t, []string{metrics.MemUsedPercent}, actual.Metrics.MetricsCollected["mem"].Measurement)
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// Types of prices an instance type can be ranked by.
+const (
+	OnDemand = "on-demand"
+	Spot     = "spot"
+)
+
+// Price contains the hourly prices of an instance type in USD. A price of 0 means it is unknown.
+type Price struct {
+	OnDemand float64 `json:"on-demand"`
+	Spot     float64 `json:"spot"`
+}
+
+// Source provides the prices of instance types.
+type Source interface {
+	// GetPrice returns the prices of an instance type and whether they are known.
+	GetPrice(instanceType string) (Price, bool)
+}
+
+// StaticSource is a Source backed by a map of instance types to prices.
+type StaticSource map[string]Price
+
+// GetPrice returns the prices of an instance type and whether they are known.
+func (s StaticSource) GetPrice(instanceType string) (Price, bool) {
+	price, ok := s[instanceType]
+	return price, ok
+}
+
+// ByType returns the hourly price of the given type, or 0 if it is unknown.
+func (p Price) ByType(priceType string) float64 {
+	if priceType == Spot {
+		return p.Spot
+	}
+	return p.OnDemand
+}
+
+// pricingApiExport is the output of "aws pricing get-products --service-code AmazonEC2", where each item of
+// PriceList is a JSON document describing a product and its terms.
+type pricingApiExport struct {
+	PriceList []string `json:"PriceList"`
+}
+
+type pricingApiProduct struct {
+	Product struct {
+		Attributes map[string]string `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// NewFileSource loads prices from a local price file, which is either a JSON object mapping instance types to
+// their prices, e.g. {"m5.large": {"on-demand": 0.096, "spot": 0.035}}, or a cached Pricing API export. Only the
+// shared-tenancy Linux on-demand prices of the given region are taken from a Pricing API export.
+func NewFileSource(filename string, region string) (StaticSource, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var export pricingApiExport
+	if err := json.Unmarshal(data, &export); err == nil && export.PriceList != nil {
+		return parsePricingApiExport(export, region)
+	}
+	var source StaticSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return nil, fmt.Errorf("error while processing price file %s: %v", filename, err)
+	}
+	return source, nil
+}
+
+// parsePricingApiExport extracts the on-demand prices of the region from a Pricing API export.
+func parsePricingApiExport(export pricingApiExport, region string) (StaticSource, error) {
+	source := make(StaticSource)
+	for _, item := range export.PriceList {
+		var product pricingApiProduct
+		if err := json.Unmarshal([]byte(item), &product); err != nil {
+			return nil, fmt.Errorf("error while processing Pricing API export: %v", err)
+		}
+		attributes := product.Product.Attributes
+		if attributes["instanceType"] == "" ||
+			(region != "" && attributes["regionCode"] != "" && attributes["regionCode"] != region) ||
+			!matchesAttribute(attributes, "operatingSystem", "Linux") ||
+			!matchesAttribute(attributes, "tenancy", "Shared") ||
+			!matchesAttribute(attributes, "preInstalledSw", "NA") ||
+			!matchesAttribute(attributes, "capacitystatus", "Used") {
+			continue
+		}
+		for _, term := range product.Terms.OnDemand {
+			for _, dimension := range term.PriceDimensions {
+				if dimension.Unit != "Hrs" {
+					continue
+				}
+				usd, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+				if err != nil || usd <= 0 {
+					continue
+				}
+				price := source[attributes["instanceType"]]
+				price.OnDemand = usd
+				source[attributes["instanceType"]] = price
+			}
+		}
+	}
+	return source, nil
+}
+
+// matchesAttribute checks that the attribute is either absent or has the value.
+func matchesAttribute(attributes map[string]string, name string, value string) bool {
+	actual, ok := attributes[name]
+	return !ok || actual == value
+}
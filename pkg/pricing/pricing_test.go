@@ -0,0 +1,58 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package pricing_test
+
+import (
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+const (
+	priceFilesPath = "../../test/static/Pricing"
+)
+
+// Tests
+
+func TestNewFileSourcePriceFile(t *testing.T) {
+	source, err := pricing.NewFileSource(priceFilesPath+"/prices.json", "us-east-2")
+	h.Ok(t, err)
+
+	price, ok := source.GetPrice("m4.large")
+	h.Assert(t, ok, "Failed to find the price of m4.large")
+	h.Equals(t, pricing.Price{OnDemand: 0.1, Spot: 0.0312}, price)
+	h.Equals(t, 0.0312, price.ByType(pricing.Spot))
+
+	price, ok = source.GetPrice("m4.xlarge")
+	h.Assert(t, ok, "Failed to find the price of m4.xlarge")
+	h.Equals(t, 0.0, price.ByType(pricing.Spot))
+
+	_, ok = source.GetPrice("c5.large")
+	h.Assert(t, !ok, "Found the price of an instance type missing from the price file")
+}
+
+func TestNewFileSourcePricingApiExport(t *testing.T) {
+	source, err := pricing.NewFileSource(priceFilesPath+"/pricing-api-export.json", "us-east-2")
+	h.Ok(t, err)
+	h.Equals(t, pricing.StaticSource{
+		"m5.large": {OnDemand: 0.096},
+		"c5.large": {OnDemand: 0.085},
+	}, source)
+}
+
+func TestNewFileSourceNonExistentFileFailure(t *testing.T) {
+	_, err := pricing.NewFileSource(priceFilesPath+"/non-existent.json", "us-east-2")
+	h.Assert(t, err != nil, "Failed to return error when the price file doesn't exist")
+}
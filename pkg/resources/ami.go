@@ -22,7 +22,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 )
 
 const (
@@ -41,7 +41,7 @@ func (itf Resources) GetAmiId(amiId string, inputStream *os.File, outputStream *
 				switch aerr.Code() {
 				case "InvalidAMIID.NotFound", "InvalidAMIID.Malformed", "InvalidAMIID.Unavailable":
 					prompt := fmt.Sprintf("The specified AMI %s doesn't exist/is no longer available. Use the default Amazon Linux 2 image?", amiId)
-					answer, err := cmdutil.BoolPrompt(prompt, inputStream, outputStream)
+					answer, err := config.Decide(config.DecisionInvalidAmi, prompt, inputStream, outputStream)
 					if err != nil {
 						return "", err
 					}
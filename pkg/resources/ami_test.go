@@ -33,6 +33,7 @@ const (
 	describeAvailabilityZones     = "DescribeAvailabilityZones"
 	describeInstanceTypeOfferings = "DescribeInstanceTypeOfferings"
 	describeInstanceTypes         = "DescribeInstanceTypes"
+	describeInstances             = "DescribeInstances"
 	describeSubnets               = "DescribeSubnets"
 	describeVpcs                  = "DescribeVpcs"
 	mockFilesPath                 = "../../test/static"
@@ -75,6 +76,8 @@ type mockedEC2 struct {
 	DescribeInstanceTypesRespA1Large          ec2.DescribeInstanceTypesOutput
 	DescribeInstanceTypesRespC5a12xlarge      ec2.DescribeInstanceTypesOutput
 	DescribeInstanceTypesErr                  error
+	DescribeInstancesResp                     ec2.DescribeInstancesOutput
+	DescribeInstancesErr                      error
 	DescribeSubnetsResp                       ec2.DescribeSubnetsOutput
 	DescribeSubnetsErr                        error
 	DescribeVpcsResp                          ec2.DescribeVpcsOutput
@@ -126,6 +129,15 @@ func (m mockedEC2) DescribeInstanceTypeOfferings(input *ec2.DescribeInstanceType
 	return &ec2.DescribeInstanceTypeOfferingsOutput{}, nil
 }
 
+func (m mockedEC2) DescribeInstanceTypeOfferingsPages(input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool) error {
+	output, err := m.DescribeInstanceTypeOfferings(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
 func (m mockedEC2) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
 	switch *input.InstanceTypes[0] {
 	case "m4.large":
@@ -140,6 +152,10 @@ func (m mockedEC2) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput)
 	return &ec2.DescribeInstanceTypesOutput{}, nil
 }
 
+func (m mockedEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return &m.DescribeInstancesResp, m.DescribeInstancesErr
+}
+
 func (m mockedEC2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
 	if input.SubnetIds != nil && *input.SubnetIds[0] == "INVALID_SUBNET_ID" {
 		return &ec2.DescribeSubnetsOutput{}, awserr.New("InvalidSubnetID.NotFound", "INVALID SUBNET ID", nil)
@@ -213,6 +229,13 @@ func setupMockedEC2(t *testing.T, api string, file string) mockedEC2 {
 				DescribeInstanceTypesRespC5a12xlarge: dito,
 			}
 		}
+	case describeInstances:
+		dio := ec2.DescribeInstancesOutput{}
+		err = json.Unmarshal(mockFile, &dio)
+		h.Assert(t, err == nil, "Error parsing mock json file contents "+mockFilename)
+		return mockedEC2{
+			DescribeInstancesResp: dio,
+		}
 	case describeSubnets:
 		dso := ec2.DescribeSubnetsOutput{}
 		err = json.Unmarshal(mockFile, &dso)
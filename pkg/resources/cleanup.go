@@ -0,0 +1,287 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Types of the resources deleted by the cleanup subcommand, in the order in which they are deleted.
+const (
+	ResourceTypeInstance       = "instance"
+	ResourceTypeStack          = "stack"
+	ResourceTypeLaunchTemplate = "launch-template"
+	ResourceTypeBucket         = "bucket"
+)
+
+// OrphanedResource is an instance-qualifier resource which is left behind by a run.
+type OrphanedResource struct {
+	Type         string    `json:"type"`
+	Id           string    `json:"id"`
+	RunId        string    `json:"run-id"`
+	State        string    `json:"state"`
+	CreationTime time.Time `json:"creation-time"`
+}
+
+// FindOrphanedResources returns the instance-qualifier resources of the region, found by tag or bucket name prefix,
+// which were created at least olderThan before now or belong to a run whose stack failed. They are returned in the
+// order in which they should be deleted.
+func (itf Resources) FindOrphanedResources(region string, olderThan time.Duration, now time.Time) ([]OrphanedResource, error) {
+	var found []OrphanedResource
+
+	instances, err := itf.findTaggedInstances()
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, instances...)
+
+	stacks, err := itf.findTaggedStacks()
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, stacks...)
+
+	launchTemplates, err := itf.findTaggedLaunchTemplates()
+	if err != nil {
+		return nil, err
+	}
+	found = append(found, launchTemplates...)
+
+	buckets, err := itf.listRunBuckets(region)
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range buckets {
+		found = append(found, OrphanedResource{
+			Type:         ResourceTypeBucket,
+			Id:           aws.StringValue(bucket.Name),
+			RunId:        RemoveBucketNamePrefix(aws.StringValue(bucket.Name)),
+			CreationTime: aws.TimeValue(bucket.CreationDate),
+		})
+	}
+
+	failedRunIds := make(map[string]bool)
+	for _, stack := range stacks {
+		if isStackFailed(stack.State) {
+			failedRunIds[stack.RunId] = true
+		}
+	}
+	var orphans []OrphanedResource
+	for _, resource := range found {
+		if failedRunIds[resource.RunId] || now.Sub(resource.CreationTime) >= olderThan {
+			orphans = append(orphans, resource)
+		}
+	}
+
+	return orphans, nil
+}
+
+// DeleteOrphanedResources deletes the given resources. If archiveBucket is provided, the final results of each run are
+// copied to it before its bucket is deleted, and a bucket whose final results cannot be archived is kept. Deletion
+// carries on after a failure so that as many resources as possible are deleted.
+func (itf Resources) DeleteOrphanedResources(orphans []OrphanedResource, archiveBucket string) error {
+	var failures int
+
+	var instanceIds []*string
+	for _, resource := range orphans {
+		if resource.Type == ResourceTypeInstance {
+			instanceIds = append(instanceIds, aws.String(resource.Id))
+		}
+	}
+	if len(instanceIds) > 0 {
+		if _, err := itf.EC2.TerminateInstances(&ec2.TerminateInstancesInput{
+			InstanceIds: instanceIds,
+		}); err != nil {
+			log.Printf("Failed to terminate instances %v: %v\n", aws.StringValueSlice(instanceIds), err)
+			failures += len(instanceIds)
+		} else {
+			log.Printf("Started the process of terminating instances %v\n", aws.StringValueSlice(instanceIds))
+		}
+	}
+
+	for _, resource := range orphans {
+		var err error
+		switch resource.Type {
+		case ResourceTypeStack:
+			_, err = itf.CloudFormation.DeleteStack(&cloudformation.DeleteStackInput{
+				StackName: aws.String(resource.Id),
+			})
+		case ResourceTypeLaunchTemplate:
+			_, err = itf.EC2.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{
+				LaunchTemplateId: aws.String(resource.Id),
+			})
+		case ResourceTypeBucket:
+			if archiveBucket != "" {
+				err = itf.archiveFinalResults(resource.Id, archiveBucket)
+			}
+			if err == nil {
+				err = itf.Store.DeleteBucket(resource.Id)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to delete %s %s: %v\n", resource.Type, resource.Id, err)
+			failures++
+			continue
+		}
+		log.Printf("Started the process of deleting %s %s\n", resource.Type, resource.Id)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d resources failed to be deleted", failures, len(orphans))
+	}
+	return nil
+}
+
+// findTaggedInstances returns the instances tagged with a run ID which are not terminated yet.
+func (itf Resources) findTaggedInstances() (instances []OrphanedResource, err error) {
+	err = itf.EC2.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(tagKey)},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}),
+			},
+		},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				var state string
+				if instance.State != nil {
+					state = aws.StringValue(instance.State.Name)
+				}
+				instances = append(instances, OrphanedResource{
+					Type:         ResourceTypeInstance,
+					Id:           aws.StringValue(instance.InstanceId),
+					RunId:        getEc2RunIdTag(instance.Tags),
+					State:        state,
+					CreationTime: aws.TimeValue(instance.LaunchTime),
+				})
+			}
+		}
+		return true
+	})
+	return instances, err
+}
+
+// findTaggedStacks returns the stacks tagged with a run ID which are not being deleted already.
+func (itf Resources) findTaggedStacks() (stacks []OrphanedResource, err error) {
+	err = itf.CloudFormation.DescribeStacksPages(&cloudformation.DescribeStacksInput{}, func(page *cloudformation.DescribeStacksOutput, lastPage bool) bool {
+		for _, stack := range page.Stacks {
+			runId := getRunIdTag(stack.Tags)
+			status := aws.StringValue(stack.StackStatus)
+			if runId == "" || status == cloudformation.StackStatusDeleteInProgress || status == cloudformation.StackStatusDeleteComplete {
+				continue
+			}
+			stacks = append(stacks, OrphanedResource{
+				Type:         ResourceTypeStack,
+				Id:           aws.StringValue(stack.StackName),
+				RunId:        runId,
+				State:        status,
+				CreationTime: aws.TimeValue(stack.CreationTime),
+			})
+		}
+		return true
+	})
+	return stacks, err
+}
+
+// findTaggedLaunchTemplates returns the launch templates tagged with a run ID.
+func (itf Resources) findTaggedLaunchTemplates() (launchTemplates []OrphanedResource, err error) {
+	err = itf.EC2.DescribeLaunchTemplatesPages(&ec2.DescribeLaunchTemplatesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(tagKey)},
+			},
+		},
+	}, func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+		for _, launchTemplate := range page.LaunchTemplates {
+			launchTemplates = append(launchTemplates, OrphanedResource{
+				Type:         ResourceTypeLaunchTemplate,
+				Id:           aws.StringValue(launchTemplate.LaunchTemplateId),
+				RunId:        getEc2RunIdTag(launchTemplate.Tags),
+				CreationTime: aws.TimeValue(launchTemplate.CreateTime),
+			})
+		}
+		return true
+	})
+	return launchTemplates, err
+}
+
+// archiveFinalResults copies the final results of a run, if any, from its bucket to <run ID>/ in the archive bucket.
+// It only returns nil without copying anything once the bucket is known to have no final results, so that a bucket
+// which cannot be checked is kept.
+func (itf Resources) archiveFinalResults(bucket string, archiveBucket string) error {
+	hasTestFixture, err := itf.Store.Exists(bucket, TestFixtureKey)
+	if err != nil {
+		return err
+	}
+	if !hasTestFixture {
+		// Without a test fixture, the run never got to upload its final results
+		log.Printf("Nothing to archive from bucket %s since it has no test fixture\n", bucket)
+		return nil
+	}
+	testFixture, err := itf.downloadTestFixture(bucket)
+	if err != nil {
+		return err
+	}
+	key := BucketLayout{RootDir: testFixture.BucketRootDir}.FinalResult(testFixture.FinalResultFilename)
+	hasFinalResults, err := itf.Store.Exists(bucket, key)
+	if err != nil {
+		return err
+	}
+	if !hasFinalResults {
+		log.Printf("Nothing to archive from bucket %s since it has no final results\n", bucket)
+		return nil
+	}
+
+	archiveKey := testFixture.RunId + "/" + testFixture.FinalResultFilename
+	if _, err := itf.S3.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(archiveBucket),
+		Key:        aws.String(archiveKey),
+		CopySource: aws.String(bucket + "/" + key),
+	}); err != nil {
+		return err
+	}
+	log.Printf("Final results of run %s archived to s3://%s/%s\n", testFixture.RunId, archiveBucket, archiveKey)
+	return nil
+}
+
+// isStackFailed returns whether a stack failed to be created, updated or deleted.
+func isStackFailed(status string) bool {
+	return strings.HasSuffix(status, "_FAILED") || status == cloudformation.StackStatusRollbackComplete
+}
+
+// getEc2RunIdTag returns the run ID the EC2 resource is tagged with.
+func getEc2RunIdTag(tags []*ec2.Tag) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == tagKey {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Mocking helpers
+
+type mockedCleanupEC2 struct {
+	ec2iface.EC2API
+	Instances              []*ec2.Instance
+	LaunchTemplates        []*ec2.LaunchTemplate
+	TerminatedInstances    *[]string
+	DeletedLaunchTemplates *[]string
+}
+
+func (m mockedCleanupEC2) DescribeInstancesPages(input *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: m.Instances}}}, true)
+	return nil
+}
+
+func (m mockedCleanupEC2) DescribeLaunchTemplatesPages(input *ec2.DescribeLaunchTemplatesInput, fn func(*ec2.DescribeLaunchTemplatesOutput, bool) bool) error {
+	fn(&ec2.DescribeLaunchTemplatesOutput{LaunchTemplates: m.LaunchTemplates}, true)
+	return nil
+}
+
+func (m mockedCleanupEC2) TerminateInstances(input *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	*m.TerminatedInstances = append(*m.TerminatedInstances, aws.StringValueSlice(input.InstanceIds)...)
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (m mockedCleanupEC2) DeleteLaunchTemplate(input *ec2.DeleteLaunchTemplateInput) (*ec2.DeleteLaunchTemplateOutput, error) {
+	*m.DeletedLaunchTemplates = append(*m.DeletedLaunchTemplates, *input.LaunchTemplateId)
+	return &ec2.DeleteLaunchTemplateOutput{}, nil
+}
+
+// mockedArchiveS3 fails to copy objects, as if the archive bucket didn't exist.
+type mockedArchiveS3 struct {
+	mockedS3
+}
+
+func (m mockedArchiveS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+}
+
+// failingStore fails to check whether objects exist, and records the buckets it deletes.
+type failingStore struct {
+	resources.ResultStore
+	DeletedBuckets *[]string
+}
+
+func (m failingStore) Exists(bucket string, key string) (bool, error) {
+	return false, awserr.New("InternalError", "We encountered an internal error", nil)
+}
+
+func (m failingStore) DeleteBucket(bucket string) error {
+	*m.DeletedBuckets = append(*m.DeletedBuckets, bucket)
+	return nil
+}
+
+func ec2RunTag(runId string) []*ec2.Tag {
+	return []*ec2.Tag{{Key: aws.String("instance-qualifier:id"), Value: aws.String(runId)}}
+}
+
+// Tests
+
+func TestFindOrphanedResources(t *testing.T) {
+	now := time.Date(2020, 9, 10, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+	itf := resources.Resources{
+		EC2: mockedCleanupEC2{
+			Instances: []*ec2.Instance{
+				{InstanceId: aws.String("i-old"), LaunchTime: aws.Time(old), State: &ec2.InstanceState{Name: aws.String("running")}, Tags: ec2RunTag("old")},
+				{InstanceId: aws.String("i-inflight"), LaunchTime: aws.Time(recent), State: &ec2.InstanceState{Name: aws.String("running")}, Tags: ec2RunTag("inflight")},
+			},
+			LaunchTemplates: []*ec2.LaunchTemplate{
+				{LaunchTemplateId: aws.String("lt-failed"), CreateTime: aws.Time(recent), Tags: ec2RunTag("failed")},
+			},
+		},
+		CloudFormation: mockedCloudFormation{
+			Stacks: []*cloudformation.Stack{
+				{StackName: aws.String("qualifier-stack-failed"), StackStatus: aws.String("ROLLBACK_COMPLETE"), Tags: runTag("failed"), CreationTime: aws.Time(recent)},
+				{StackName: aws.String("qualifier-stack-inflight"), StackStatus: aws.String("CREATE_COMPLETE"), Tags: runTag("inflight"), CreationTime: aws.Time(recent)},
+				{StackName: aws.String("qualifier-stack-deleting"), StackStatus: aws.String("DELETE_IN_PROGRESS"), Tags: runTag("deleting"), CreationTime: aws.Time(old)},
+				{StackName: aws.String("unrelated-stack"), StackStatus: aws.String("CREATE_COMPLETE"), CreationTime: aws.Time(old)},
+			},
+		},
+		S3: mockedS3{
+			Buckets: map[string]string{
+				"qualifier-bucket-old":      "us-east-2",
+				"qualifier-bucket-inflight": "us-east-2",
+				"qualifier-bucket-other":    "us-west-2",
+			},
+		},
+	}
+
+	// The mocked buckets have no creation date, so they are as old as can be
+	orphans, err := itf.FindOrphanedResources("us-east-2", 24*time.Hour, now)
+	h.Ok(t, err)
+	var ids []string
+	for _, orphan := range orphans {
+		ids = append(ids, orphan.Type+":"+orphan.Id)
+	}
+	h.Assert(t, len(ids) == 5, "Expected 5 orphaned resources, got %v", ids)
+	h.Equals(t, []string{"instance:i-old", "stack:qualifier-stack-failed", "launch-template:lt-failed"}, ids[:3])
+	h.Equals(t, resources.OrphanedResource{Type: resources.ResourceTypeInstance, Id: "i-old", RunId: "old", State: "running", CreationTime: old}, orphans[0])
+
+	// Every resource is found when there is no minimum age
+	orphans, err = itf.FindOrphanedResources("us-east-2", 0, now)
+	h.Ok(t, err)
+	h.Equals(t, 7, len(orphans))
+}
+
+func TestDeleteOrphanedResources(t *testing.T) {
+	var terminated, deletedLaunchTemplates, deletedStacks []string
+	objects := map[string]string{
+		"qualifier-bucket-old/test-fixture.json":                                 `{"runId": "old", "final-results": "final-results-old.json", "bucket-root-dir": "Instance-Qualifier-Run-old"}`,
+		"qualifier-bucket-old/Instance-Qualifier-Run-old/final-results-old.json": `[]`,
+	}
+	itf := resources.Resources{
+		EC2: mockedCleanupEC2{
+			TerminatedInstances:    &terminated,
+			DeletedLaunchTemplates: &deletedLaunchTemplates,
+		},
+		CloudFormation: mockedCloudFormation{DeletedStacks: &deletedStacks},
+		S3:             mockedArchiveS3{mockedS3{Objects: objects}},
+		Store:          resources.S3Store{S3: mockedArchiveS3{mockedS3{Objects: objects}}, Downloader: mockedS3Downloader{Objects: objects}},
+	}
+	orphans := []resources.OrphanedResource{
+		{Type: resources.ResourceTypeInstance, Id: "i-1", RunId: "old"},
+		{Type: resources.ResourceTypeInstance, Id: "i-2", RunId: "old"},
+		{Type: resources.ResourceTypeStack, Id: "qualifier-stack-old", RunId: "old"},
+		{Type: resources.ResourceTypeLaunchTemplate, Id: "lt-1", RunId: "old"},
+		{Type: resources.ResourceTypeBucket, Id: "qualifier-bucket-old", RunId: "old"},
+	}
+
+	// The bucket is kept since its final results cannot be archived
+	err := itf.DeleteOrphanedResources(orphans, "archive-bucket")
+	h.Assert(t, err != nil, "Failed to return error when the final results cannot be archived")
+	h.Equals(t, []string{"i-1", "i-2"}, terminated)
+	h.Equals(t, []string{"qualifier-stack-old"}, deletedStacks)
+	h.Equals(t, []string{"lt-1"}, deletedLaunchTemplates)
+}
+
+func TestDeleteOrphanedResourcesStoreFailure(t *testing.T) {
+	var deletedBuckets []string
+	itf := resources.Resources{
+		Store: failingStore{DeletedBuckets: &deletedBuckets},
+	}
+	orphans := []resources.OrphanedResource{
+		{Type: resources.ResourceTypeBucket, Id: "qualifier-bucket-old", RunId: "old"},
+	}
+
+	// The bucket is kept since it cannot be checked for final results
+	err := itf.DeleteOrphanedResources(orphans, "archive-bucket")
+	h.Assert(t, err != nil, "Failed to return error when the bucket cannot be checked for final results")
+	h.Equals(t, 0, len(deletedBuckets))
+
+	// The bucket is deleted when it isn't archived
+	h.Ok(t, itf.DeleteOrphanedResources(orphans, ""))
+	h.Equals(t, []string{"qualifier-bucket-old"}, deletedBuckets)
+}
@@ -15,115 +15,269 @@ package resources
 
 import (
 	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 )
 
 const (
-	retryAttempts = 3
-	retryPeriod   = 1 //minutes
+	retryAttempts    = 3
+	retryPeriod      = 1 //minutes
+	cwAgentNamespace = "CWAgent"
+	// maxMetricDataQueries is the number of queries GetMetricData accepts at most.
+	maxMetricDataQueries = 500
 )
 
-// GetCloudWatchData retrieves instance metric data from CloudWatch
-func (itf Resources) GetCloudWatchData(instances []Instance, testFixture config.TestFixture) (resp *cloudwatch.GetMetricDataOutput, err error) {
-	startTime, _ := time.Parse(time.RFC3339, testFixture.StartTime)
-	duration := time.Duration(testFixture.Timeout) * time.Second
-	endTime := startTime.Add(duration)
+// GetCloudWatchData retrieves instance metric data from CloudWatch, fetching the series of all instances at once and
+// deriving both the per-test results and the time series from them. Each test file gets its own metrics, aggregated
+// with the statistic of the metric over its execution window: the datapoints of the collection intervals of the window,
+// which hold a single sample each, are aggregated. A window without any datapoint gets a result without any value.
+// The label of each MetricDataResult of results is "<namespace> <instance ID> <instance type> <result index> <metric
+// name>". seriesResults are the time series of the metrics of each instance, one datapoint per CloudWatch agent
+// collection interval, over the execution of all its tests, labeled "<namespace> <instance ID> <instance type> <metric
+// name>".
+func (itf Resources) GetCloudWatchData(instances []Instance, testFixture config.TestFixture) (results []*cloudwatch.MetricDataResult, seriesResults []*cloudwatch.MetricDataResult, err error) {
+	definitions, err := metrics.Select(testFixture.Metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+	series, err := itf.getSeries(instances, definitions, testFixture)
+	if err != nil {
+		return nil, nil, err
+	}
+	return testResults(instances, definitions, series, testFixture), instanceSeries(instances, definitions, series, testFixture), nil
+}
 
-	var queries []*cloudwatch.MetricDataQuery
+// testResults aggregates the series of each instance over the execution window of each of its test files.
+func testResults(instances []Instance, definitions []metrics.Definition, series [][]*cloudwatch.MetricDataResult, testFixture config.TestFixture) (results []*cloudwatch.MetricDataResult) {
 	for i, instance := range instances {
-		metricId := "cpu_metric" + strconv.Itoa(i)
-		queries = append(queries, createCpuActiveQuery(instance, metricId, testFixture.Timeout))
+		for resultIdx, result := range instance.Results {
+			startTime, endTime := testWindow(result, testFixture)
+			for j, definition := range definitions {
+				values := valuesInWindow(series[i][j], startTime, endTime)
+				metricData := &cloudwatch.MetricDataResult{
+					Id:     aws.String(definition.Name + "_" + strconv.Itoa(resultIdx)),
+					Label:  aws.String(cwAgentNamespace + " " + instance.InstanceId + " " + instance.InstanceType + " " + strconv.Itoa(resultIdx) + " " + definition.Name),
+					Values: []*float64{},
+				}
+				if len(values) > 0 {
+					metricData.Values = append(metricData.Values, aws.Float64(aggregate(values, testFixture.MetricStatistic(definition.Name))))
+				}
+				results = append(results, metricData)
+			}
+		}
 	}
+	return results
+}
+
+// instanceSeries trims the series of each instance to the execution of all its tests.
+func instanceSeries(instances []Instance, definitions []metrics.Definition, series [][]*cloudwatch.MetricDataResult, testFixture config.TestFixture) (results []*cloudwatch.MetricDataResult) {
 	for i, instance := range instances {
-		metricId := "mem_metric" + strconv.Itoa(i)
-		queries = append(queries, createMemUsageQuery(instance, metricId, testFixture.Timeout))
+		startTime, endTime := runWindow(instance, testFixture)
+		for j, definition := range definitions {
+			metricData := &cloudwatch.MetricDataResult{
+				Id:    aws.String(definition.Name + "_series"),
+				Label: aws.String(cwAgentNamespace + " " + instance.InstanceId + " " + instance.InstanceType + " " + definition.Name),
+			}
+			for k, timestamp := range series[i][j].Timestamps {
+				if k < len(series[i][j].Values) && !timestamp.Before(startTime) && timestamp.Before(endTime) {
+					metricData.Timestamps = append(metricData.Timestamps, timestamp)
+					metricData.Values = append(metricData.Values, series[i][j].Values[k])
+				}
+			}
+			results = append(results, metricData)
+		}
+	}
+	return results
+}
+
+// getSeries retrieves the datapoints of the metrics of all instances, one per CloudWatch agent collection interval,
+// over the window spanning the execution of all their tests. The series of the metric at index j of the definitions
+// on the instance at index i is series[i][j].
+func (itf Resources) getSeries(instances []Instance, definitions []metrics.Definition, testFixture config.TestFixture) (series [][]*cloudwatch.MetricDataResult, err error) {
+	var startTime, endTime time.Time
+	var queries []*cloudwatch.MetricDataQuery
+	for i, instance := range instances {
+		start, end := runWindow(instance, testFixture)
+		if i == 0 || start.Before(startTime) {
+			startTime = start
+		}
+		if i == 0 || end.After(endTime) {
+			endTime = end
+		}
+		for j, definition := range definitions {
+			metricId := "m" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+			label := cwAgentNamespace + " " + instance.InstanceId + " " + instance.InstanceType + " " + definition.Name
+			queries = append(queries, createMetricQuery(instance, definition, testFixture.MetricStatistic(definition.Name), metricId, label, metrics.CollectionInterval))
+		}
 	}
 
-	input := &cloudwatch.GetMetricDataInput{
-		EndTime:           &endTime,
-		StartTime:         &startTime,
-		MetricDataQueries: queries,
+	resultsById, err := itf.getMetricData(queries, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	series = make([][]*cloudwatch.MetricDataResult, len(instances))
+	for i := range instances {
+		for j := range definitions {
+			result, ok := resultsById["m"+strconv.Itoa(i)+"_"+strconv.Itoa(j)]
+			if !ok {
+				result = &cloudwatch.MetricDataResult{}
+			}
+			series[i] = append(series[i], result)
+		}
 	}
+	return series, nil
+}
 
-	log.Printf("Requesting metrics with GetMetricDataInput: %v\n", input)
-	// CloudWatch data is not always immediately available for retrieval; therefore, best-effort retry
-	for i := 0; i < retryAttempts; i++ {
-		log.Printf("GetMetricData attempt: %s\n", strconv.Itoa(i))
-		resp, err = itf.CloudWatch.GetMetricData(input)
-		if err != nil {
-			log.Println("error getting metric data ", err)
-			log.Printf("Resp: %v\n", resp)
-			return nil, err
+// getMetricData retrieves the results of the queries by ID, in as many GetMetricData calls as the number of queries
+// and the pages of their datapoints take. CloudWatch data is not always immediately available for retrieval;
+// therefore, the queries whose results don't have any value yet are retried, best-effort, the retries of all of them
+// sharing the same attempts.
+func (itf Resources) getMetricData(queries []*cloudwatch.MetricDataQuery, startTime time.Time, endTime time.Time) (map[string]*cloudwatch.MetricDataResult, error) {
+	resultsById := make(map[string]*cloudwatch.MetricDataResult)
+	pending := queries
+	for i := 0; i < retryAttempts && len(pending) > 0; i++ {
+		if i > 0 {
+			time.Sleep(retryPeriod * time.Minute)
 		}
-		for _, result := range resp.MetricDataResults {
-			if result.Values == nil {
-				time.Sleep(retryPeriod * time.Minute)
-				continue
+		log.Printf("GetMetricData attempt %d for %d queries from %s to %s\n", i, len(pending), startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+		attemptResults := make(map[string]*cloudwatch.MetricDataResult)
+		for start := 0; start < len(pending); start += maxMetricDataQueries {
+			end := start + maxMetricDataQueries
+			if end > len(pending) {
+				end = len(pending)
+			}
+			input := &cloudwatch.GetMetricDataInput{
+				EndTime:           aws.Time(endTime),
+				StartTime:         aws.Time(startTime),
+				MetricDataQueries: pending[start:end],
+				ScanBy:            aws.String(cloudwatch.ScanByTimestampAscending),
+			}
+			for {
+				resp, err := itf.CloudWatch.GetMetricData(input)
+				if err != nil {
+					log.Println("error getting metric data ", err)
+					return nil, err
+				}
+				for _, result := range resp.MetricDataResults {
+					id := aws.StringValue(result.Id)
+					if previous, ok := attemptResults[id]; ok {
+						previous.Timestamps = append(previous.Timestamps, result.Timestamps...)
+						previous.Values = append(previous.Values, result.Values...)
+					} else {
+						attemptResults[id] = result
+					}
+				}
+				if resp.NextToken == nil {
+					break
+				}
+				input.NextToken = resp.NextToken
 			}
 		}
+
+		var incomplete []*cloudwatch.MetricDataQuery
+		for _, query := range pending {
+			result, ok := attemptResults[aws.StringValue(query.Id)]
+			if ok {
+				resultsById[aws.StringValue(query.Id)] = result
+			}
+			if !ok || result.Values == nil {
+				incomplete = append(incomplete, query)
+			}
+		}
+		pending = incomplete
 	}
-	return resp, nil
+	return resultsById, nil
 }
 
-func createCpuActiveQuery(instance Instance, metricId string, metricPeriod int) *cloudwatch.MetricDataQuery {
-	namespace := "CWAgent"
-	metricname := "cpu_usage_active"
-	metricid := metricId
-	returndata := true
-	metricDim1Name := "InstanceId"
-	metricDim1Value := instance.InstanceId
-	metricDim2Name := "InstanceType"
-	metricDim2Value := instance.InstanceType
-	metricDim3Name := "cpu"
-	metricDim3Value := "cpu-total"
-	label := namespace + " " + instance.InstanceId + " " + instance.InstanceType + " " + metricname
-	period := int64(convertToMultiple(metricPeriod, 60)) // MetricStat.Period must be a multiple of 60
-	stat := "Maximum"                                    //p100
-	return &cloudwatch.MetricDataQuery{
-		Id:         &metricid,
-		Label:      &label,
-		ReturnData: &returndata,
-		MetricStat: &cloudwatch.MetricStat{
-			Metric: &cloudwatch.Metric{
-				Namespace:  &namespace,
-				MetricName: &metricname,
-				Dimensions: []*cloudwatch.Dimension{
-					{
-						Name:  &metricDim1Name,
-						Value: &metricDim1Value,
-					},
-					{
-						Name:  &metricDim2Name,
-						Value: &metricDim2Value,
-					},
-					{
-						Name:  &metricDim3Name,
-						Value: &metricDim3Value,
-					},
-				},
-			},
-			Period: &period,
-			Stat:   &stat,
-		},
+// valuesInWindow returns the values of the datapoints of the result within the window.
+func valuesInWindow(result *cloudwatch.MetricDataResult, startTime time.Time, endTime time.Time) (values []float64) {
+	for i, timestamp := range result.Timestamps {
+		if i < len(result.Values) && !timestamp.Before(startTime) && timestamp.Before(endTime) {
+			values = append(values, aws.Float64Value(result.Values[i]))
+		}
 	}
+	return values
 }
 
-func createMemUsageQuery(instance Instance, metricId string, metricPeriod int) *cloudwatch.MetricDataQuery {
-	namespace := "CWAgent"
-	metricname := "mem_used_percent"
+// testWindow returns the window over which the metrics of a test file are queried. The window is aligned to the
+// CloudWatch agent collection interval. If the test result doesn't record when it was executed, the window of the
+// whole run is returned.
+func testWindow(result Result, testFixture config.TestFixture) (startTime time.Time, endTime time.Time) {
+	interval := time.Duration(metrics.CollectionInterval) * time.Second
+	start, startErr := time.Parse(time.RFC3339, result.StartTime)
+	end, endErr := time.Parse(time.RFC3339, result.EndTime)
+	if startErr != nil || endErr != nil {
+		startTime, _ = time.Parse(time.RFC3339, testFixture.StartTime)
+		duration := time.Duration(convertToMultiple(testFixture.Timeout, metrics.CollectionInterval)) * time.Second
+		return startTime, startTime.Add(duration)
+	}
+
+	startTime = start.Truncate(interval)
+	endTime = end.Truncate(interval)
+	if endTime.Before(end) || !endTime.After(startTime) {
+		endTime = endTime.Add(interval)
+	}
+	return startTime, endTime
+}
+
+// runWindow returns the window spanning the execution windows of all tests executed on an instance.
+func runWindow(instance Instance, testFixture config.TestFixture) (startTime time.Time, endTime time.Time) {
+	if len(instance.Results) == 0 {
+		return testWindow(Result{}, testFixture)
+	}
+	for i, result := range instance.Results {
+		start, end := testWindow(result, testFixture)
+		if i == 0 || start.Before(startTime) {
+			startTime = start
+		}
+		if i == 0 || end.After(endTime) {
+			endTime = end
+		}
+	}
+	return startTime, endTime
+}
+
+// createMetricQuery creates the query of a metric emitted by the CloudWatch agent on an instance. The metric is
+// aggregated with the statistic over each period.
+func createMetricQuery(instance Instance, definition metrics.Definition, statistic string, metricId string, label string, metricPeriod int) *cloudwatch.MetricDataQuery {
+	namespace := cwAgentNamespace
+	metricname := definition.Name
 	metricid := metricId
 	returnData := true
-	metricDim1Name := "InstanceId"
-	metricDim1Value := instance.InstanceId
-	metricDim2Name := "InstanceType"
-	metricDim2Value := instance.InstanceType
-	label := namespace + " " + instance.InstanceId + " " + instance.InstanceType + " " + metricname
-	period := int64(convertToMultiple(metricPeriod, 60))
-	stat := "Maximum"
+	period := int64(convertToMultiple(metricPeriod, 60)) // MetricStat.Period must be a multiple of 60
+	stat := statistic
+
+	dimensions := []*cloudwatch.Dimension{
+		{
+			Name:  aws.String("InstanceId"),
+			Value: aws.String(instance.InstanceId),
+		},
+		{
+			Name:  aws.String("InstanceType"),
+			Value: aws.String(instance.InstanceType),
+		},
+	}
+	// Sort the extra dimensions to keep the query deterministic
+	var dimensionNames []string
+	for name := range definition.Dimensions {
+		dimensionNames = append(dimensionNames, name)
+	}
+	sort.Strings(dimensionNames)
+	for _, name := range dimensionNames {
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(name),
+			Value: aws.String(definition.Dimensions[name]),
+		})
+	}
+
 	return &cloudwatch.MetricDataQuery{
 		Id:         &metricid,
 		Label:      &label,
@@ -132,16 +286,7 @@ func createMemUsageQuery(instance Instance, metricId string, metricPeriod int) *
 			Metric: &cloudwatch.Metric{
 				Namespace:  &namespace,
 				MetricName: &metricname,
-				Dimensions: []*cloudwatch.Dimension{
-					{
-						Name:  &metricDim1Name,
-						Value: &metricDim1Value,
-					},
-					{
-						Name:  &metricDim2Name,
-						Value: &metricDim2Value,
-					},
-				},
+				Dimensions: dimensions,
 			},
 			Period: &period,
 			Stat:   &stat,
@@ -149,6 +294,31 @@ func createMemUsageQuery(instance Instance, metricId string, metricPeriod int) *
 	}
 }
 
+// aggregate returns the statistic of the values, a percentile being the nearest rank.
+func aggregate(values []float64, statistic string) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	switch statistic {
+	case metrics.Minimum:
+		return sorted[0]
+	case metrics.Average:
+		sum := 0.0
+		for _, value := range sorted {
+			sum += value
+		}
+		return sum / float64(len(sorted))
+	case metrics.P50, metrics.P90, metrics.P95, metrics.P99:
+		percentile, _ := strconv.ParseFloat(strings.TrimPrefix(statistic, "p"), 64)
+		rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	default:
+		return sorted[len(sorted)-1]
+	}
+}
+
 // convertToMultiple takes a value and returns the nearest multiple, rounding up
 func convertToMultiple(value, multiple int) int {
 	if value <= multiple {
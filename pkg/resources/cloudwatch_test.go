@@ -0,0 +1,184 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Mocking Helpers
+
+// mockedCloudWatch returns a datapoint per minute of the window for each query, whose value is the number of minutes
+// from the start of the window plus one, in pages of PageSize datapoints per query if set.
+type mockedCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	GetMetricDataInputs *[]*cloudwatch.GetMetricDataInput
+	PageSize            int
+}
+
+func (m mockedCloudWatch) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	*m.GetMetricDataInputs = append(*m.GetMetricDataInputs, input)
+	var timestamps []*time.Time
+	var values []*float64
+	for timestamp := *input.StartTime; timestamp.Before(*input.EndTime); timestamp = timestamp.Add(time.Minute) {
+		timestamps = append(timestamps, aws.Time(timestamp))
+		values = append(values, aws.Float64(float64(len(values)+1)))
+	}
+	output := &cloudwatch.GetMetricDataOutput{}
+	offset, _ := strconv.Atoi(aws.StringValue(input.NextToken))
+	end := len(values)
+	if m.PageSize > 0 && offset+m.PageSize < end {
+		end = offset + m.PageSize
+		output.NextToken = aws.String(strconv.Itoa(end))
+	}
+	for _, query := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			Label:      query.Label,
+			Timestamps: timestamps[offset:end],
+			Values:     values[offset:end],
+		})
+	}
+	return output, nil
+}
+
+// Tests
+
+func TestGetCloudWatchDataAggregatesEachTestWindow(t *testing.T) {
+	var inputs []*cloudwatch.GetMetricDataInput
+	itf := resources.Resources{
+		CloudWatch: mockedCloudWatch{GetMetricDataInputs: &inputs},
+	}
+	instances := []resources.Instance{
+		{
+			InstanceId:   "i-0ff4a2f594b270b54",
+			InstanceType: "m4.large",
+			Results: []resources.Result{
+				{Label: "cpu-test.sh", StartTime: "2020-08-01T10:00:30Z", EndTime: "2020-08-01T10:02:30Z"},
+				{Label: "mem-test.sh", StartTime: "2020-08-01T10:02:30Z", EndTime: "2020-08-01T10:02:40Z"},
+				{Label: "old-agent.sh"},
+			},
+		},
+	}
+	testFixture := config.TestFixture{
+		StartTime:        "2020-08-01T09:59:00Z",
+		Timeout:          100,
+		Statistic:        "p95",
+		MetricStatistics: map[string]string{"mem_used_percent": "Average"},
+	}
+
+	results, _, err := itf.GetCloudWatchData(instances, testFixture)
+	h.Ok(t, err)
+	// The datapoints of every test are retrieved at once, over the window spanning all of them
+	h.Equals(t, 1, len(inputs))
+	h.Equals(t, "2020-08-01T09:59:00Z", inputs[0].StartTime.Format(time.RFC3339))
+	h.Equals(t, "2020-08-01T10:03:00Z", inputs[0].EndTime.Format(time.RFC3339))
+	for _, query := range inputs[0].MetricDataQueries {
+		h.Equals(t, int64(60), *query.MetricStat.Period)
+	}
+	h.Equals(t, "p95", *inputs[0].MetricDataQueries[0].MetricStat.Stat)
+	h.Equals(t, "Average", *inputs[0].MetricDataQueries[1].MetricStat.Stat)
+
+	h.Equals(t, 6, len(results))
+	h.Equals(t, "CWAgent i-0ff4a2f594b270b54 m4.large 1 cpu_usage_active", *results[2].Label)
+	// From 10:00 to 10:03
+	h.Equals(t, []*float64{aws.Float64(4)}, results[0].Values)
+	h.Equals(t, []*float64{aws.Float64(3)}, results[1].Values)
+	// From 10:02 to 10:03
+	h.Equals(t, []*float64{aws.Float64(4)}, results[2].Values)
+	h.Equals(t, []*float64{aws.Float64(4)}, results[3].Values)
+	// Results without timestamps fall back to the window of the whole run, from 09:59 to 10:01
+	h.Equals(t, []*float64{aws.Float64(2)}, results[4].Values)
+	h.Equals(t, []*float64{aws.Float64(1.5)}, results[5].Values)
+}
+
+func TestGetCloudWatchDataBatchesQueries(t *testing.T) {
+	var inputs []*cloudwatch.GetMetricDataInput
+	itf := resources.Resources{
+		CloudWatch: mockedCloudWatch{GetMetricDataInputs: &inputs, PageSize: 2},
+	}
+	var instances []resources.Instance
+	for i := 0; i < 300; i++ {
+		instances = append(instances, resources.Instance{
+			InstanceId:   fmt.Sprintf("i-%017d", i),
+			InstanceType: "m4.large",
+			Results: []resources.Result{
+				{Label: "cpu-test.sh", StartTime: "2020-08-01T10:00:30Z", EndTime: "2020-08-01T10:02:30Z"},
+				{Label: "mem-test.sh", StartTime: "2020-08-01T10:02:30Z", EndTime: "2020-08-01T10:03:40Z"},
+			},
+		})
+	}
+
+	results, _, err := itf.GetCloudWatchData(instances, config.TestFixture{})
+	h.Ok(t, err)
+	// 600 queries, in 2 pages of datapoints each
+	h.Equals(t, 4, len(inputs))
+	h.Equals(t, 500, len(inputs[0].MetricDataQueries))
+	h.Equals(t, 500, len(inputs[1].MetricDataQueries))
+	h.Equals(t, "2", *inputs[1].NextToken)
+	h.Equals(t, 100, len(inputs[2].MetricDataQueries))
+	h.Equals(t, 1200, len(results))
+	h.Equals(t, []*float64{aws.Float64(4)}, results[len(results)-1].Values)
+}
+
+func TestGetCloudWatchDataSeriesSpanRunWindow(t *testing.T) {
+	var inputs []*cloudwatch.GetMetricDataInput
+	itf := resources.Resources{
+		CloudWatch: mockedCloudWatch{GetMetricDataInputs: &inputs},
+	}
+	instances := []resources.Instance{
+		{
+			InstanceId:   "i-0ff4a2f594b270b54",
+			InstanceType: "m4.large",
+			Results: []resources.Result{
+				{Label: "cpu-test.sh", StartTime: "2020-08-01T10:00:30Z", EndTime: "2020-08-01T10:02:30Z"},
+				{Label: "mem-test.sh", StartTime: "2020-08-01T10:02:30Z", EndTime: "2020-08-01T10:05:10Z"},
+			},
+		},
+		{
+			InstanceId:   "i-0ff4a2f594b270b55",
+			InstanceType: "c5.large",
+			Results: []resources.Result{
+				{Label: "cpu-test.sh", StartTime: "2020-08-01T10:05:30Z", EndTime: "2020-08-01T10:06:30Z"},
+			},
+		},
+	}
+
+	// The series are derived from the same retrieval as the results of the tests
+	_, results, err := itf.GetCloudWatchData(instances, config.TestFixture{})
+	h.Ok(t, err)
+	h.Equals(t, 1, len(inputs))
+	h.Equals(t, 4, len(results))
+	h.Equals(t, "2020-08-01T10:00:00Z", inputs[0].StartTime.Format(time.RFC3339))
+	h.Equals(t, "2020-08-01T10:07:00Z", inputs[0].EndTime.Format(time.RFC3339))
+	h.Equals(t, cloudwatch.ScanByTimestampAscending, *inputs[0].ScanBy)
+	for _, query := range inputs[0].MetricDataQueries {
+		h.Equals(t, int64(60), *query.MetricStat.Period)
+	}
+	h.Equals(t, "CWAgent i-0ff4a2f594b270b54 m4.large mem_used_percent", *results[1].Label)
+	// Each series only spans the execution of the tests of its instance
+	h.Equals(t, 6, len(results[1].Values))
+	h.Equals(t, "2020-08-01T10:05:00Z", results[1].Timestamps[5].Format(time.RFC3339))
+	h.Equals(t, []*float64{aws.Float64(6), aws.Float64(7)}, results[3].Values)
+}
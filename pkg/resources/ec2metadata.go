@@ -13,6 +13,10 @@
 
 package resources
 
+const (
+	spotInstanceActionPath = "spot/instance-action"
+)
+
 // GetRegion returns the AWS region.
 func (itf Resources) GetRegion() (region string, err error) {
 	identityDoc, err := itf.EC2Metadata.GetInstanceIdentityDocument()
@@ -22,6 +26,13 @@ func (itf Resources) GetRegion() (region string, err error) {
 	return identityDoc.Region, nil
 }
 
+// IsSpotInterruptionNoticed returns true if the spot instance received an interruption notice. The notice is only
+// available in instance metadata once the instance is marked to be interrupted.
+func (itf Resources) IsSpotInterruptionNoticed() bool {
+	action, err := itf.EC2Metadata.GetMetadata(spotInstanceActionPath)
+	return err == nil && action != ""
+}
+
 // CreateInstance populates the Instance struct with metadata.
 func (itf Resources) CreateInstance(instanceType string, vCpus string, memory string, osVersion string, architecture string) (instance Instance, err error) {
 	instance.InstanceType = instanceType
@@ -19,6 +19,7 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
@@ -34,12 +35,20 @@ type mockedEC2Metadata struct {
 	resources.EC2MetadataAPI
 	GetInstanceIdentityDocumentResp ec2metadata.EC2InstanceIdentityDocument
 	GetInstanceIdentityDocumentErr  error
+	GetMetadataResp                 map[string]string
 }
 
 func (m mockedEC2Metadata) GetInstanceIdentityDocument() (ec2metadata.EC2InstanceIdentityDocument, error) {
 	return m.GetInstanceIdentityDocumentResp, m.GetInstanceIdentityDocumentErr
 }
 
+func (m mockedEC2Metadata) GetMetadata(p string) (string, error) {
+	if value, ok := m.GetMetadataResp[p]; ok {
+		return value, nil
+	}
+	return "", awserr.New("EC2MetadataError", "failed to make EC2Metadata request", nil)
+}
+
 func setupMockedEC2Metadata(t *testing.T, api string, file string) mockedEC2Metadata {
 	mockFilename := fmt.Sprintf("%s/%s/%s", mockFilesPath, api, file)
 	mockFile, err := ioutil.ReadFile(mockFilename)
@@ -78,3 +87,21 @@ func TestCreateInstanceSuccess(t *testing.T) {
 	h.Ok(t, err)
 	h.Equals(t, expected, actual)
 }
+
+func TestIsSpotInterruptionNoticed(t *testing.T) {
+	itf := resources.Resources{
+		EC2Metadata: mockedEC2Metadata{
+			GetMetadataResp: map[string]string{
+				"spot/instance-action": `{"action": "terminate", "time": "2020-09-18T08:22:00Z"}`,
+			},
+		},
+	}
+	h.Assert(t, itf.IsSpotInterruptionNoticed(), "Failed to notice the spot interruption")
+}
+
+func TestIsSpotInterruptionNoticedNoNotice(t *testing.T) {
+	itf := resources.Resources{
+		EC2Metadata: mockedEC2Metadata{},
+	}
+	h.Assert(t, !itf.IsSpotInterruptionNoticed(), "Spot interruption noticed without a notice in instance metadata")
+}
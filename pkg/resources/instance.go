@@ -16,18 +16,25 @@ package resources
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
 )
 
 const (
-	runningState = "16"
+	runningState                = "16"
+	runningStateName            = "running"
+	pendingStateName            = "pending"
+	spotInstanceTerminationCode = "Server.SpotInstanceTermination"
 )
 
+// The OS and architecture of the AMI are described once per AMI, which differs across the regions of a run
+var imageId string
 var osVersion string
 var architecture string
 
@@ -52,6 +59,55 @@ func (itf Resources) IsInstanceRunning(instanceId string) (bool, error) {
 	return false, nil
 }
 
+// IsInstanceSpotInterrupted returns true if an instance was terminated because of a spot interruption; false otherwise.
+func (itf Resources) IsInstanceSpotInterrupted(instanceId string) (bool, error) {
+	output, err := itf.EC2.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceId)},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, reservation := range output.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.StateReason != nil && aws.StringValue(instance.StateReason.Code) == spotInstanceTerminationCode {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// GetInstanceStates returns the state of each instance with a single paginated DescribeInstances call for all of them.
+// An instance which isn't described anymore is not running.
+func (itf Resources) GetInstanceStates(instanceIds []string) (map[string]InstanceState, error) {
+	states := make(map[string]InstanceState, len(instanceIds))
+	input := &ec2.DescribeInstancesInput{
+		InstanceIds: aws.StringSlice(instanceIds),
+	}
+	for {
+		output, err := itf.EC2.DescribeInstances(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				var state InstanceState
+				if instance.State != nil {
+					name := aws.StringValue(instance.State.Name)
+					state.IsRunning = name == runningStateName || name == pendingStateName
+				}
+				state.IsSpotInterrupted = instance.StateReason != nil && aws.StringValue(instance.StateReason.Code) == spotInstanceTerminationCode
+				states[aws.StringValue(instance.InstanceId)] = state
+			}
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			return states, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
 // GetInstancesInCfnStack populates InstanceId and InstanceType fields of the Instance struct for all instances in the
 // CloudFormation stack, and returns them.
 func (itf Resources) GetInstancesInCfnStack() (instances []Instance, err error) {
@@ -79,25 +135,127 @@ func (itf Resources) GetInstancesInCfnStack() (instances []Instance, err error)
 	return instances, nil
 }
 
-// FindBestAvailabilityZone finds the Availability Zone that can support the most instance types provided by the
-// user. It returns the name of the best Availability Zone, and user-specified instance types that are available
-// in that Availability Zone.
-func (itf Resources) FindBestAvailabilityZone(instanceTypes string, subnetId string) (bestAvailabilityZone string, supportedInstanceTypes []string, err error) {
+// ResolveInstanceTypes expands the instance type filters into the sorted list of instance types that match them.
+// If instanceTypes isn't empty, the filters only narrow down that list. Prices are only needed to filter on the max
+// price.
+func (itf Resources) ResolveInstanceTypes(instanceTypes string, filters config.InstanceTypeFilters, prices pricing.Source, priceType string) (resolvedInstanceTypes []string, err error) {
+	input := &ec2.DescribeInstanceTypesInput{}
+	if instanceTypes != "" {
+		input.InstanceTypes = aws.StringSlice(strings.Split(instanceTypes, ","))
+	}
+	err = itf.EC2.DescribeInstanceTypesPages(input, func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+		for _, instanceTypeInfo := range page.InstanceTypes {
+			if matchesFilters(instanceTypeInfo, filters, prices, priceType) {
+				resolvedInstanceTypes = append(resolvedInstanceTypes, aws.StringValue(instanceTypeInfo.InstanceType))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resolvedInstanceTypes) == 0 {
+		return nil, fmt.Errorf("no instance type matches the instance type filters")
+	}
+	sort.Strings(resolvedInstanceTypes)
+	return resolvedInstanceTypes, nil
+}
+
+// matchesFilters checks whether an instance type matches all instance type filters.
+func matchesFilters(instanceTypeInfo *ec2.InstanceTypeInfo, filters config.InstanceTypeFilters, prices pricing.Source, priceType string) bool {
+	instanceType := aws.StringValue(instanceTypeInfo.InstanceType)
+	if !filters.MatchesFamily(instanceType) {
+		return false
+	}
+	if filters.CurrentGeneration && !aws.BoolValue(instanceTypeInfo.CurrentGeneration) {
+		return false
+	}
+	if !filters.MatchesBurstable(aws.BoolValue(instanceTypeInfo.BurstablePerformanceSupported)) {
+		return false
+	}
+
+	if instanceTypeInfo.VCpuInfo != nil {
+		vCpus := int(aws.Int64Value(instanceTypeInfo.VCpuInfo.DefaultVCpus))
+		if vCpus < filters.VCpusMin || (filters.VCpusMax > 0 && vCpus > filters.VCpusMax) {
+			return false
+		}
+	}
+	if instanceTypeInfo.MemoryInfo != nil {
+		memory := float64(aws.Int64Value(instanceTypeInfo.MemoryInfo.SizeInMiB)) / 1024
+		if memory < filters.MemoryMin || (filters.MemoryMax > 0 && memory > filters.MemoryMax) {
+			return false
+		}
+	}
+	if filters.Architecture != "" {
+		if instanceTypeInfo.ProcessorInfo == nil || !containsString(instanceTypeInfo.ProcessorInfo.SupportedArchitectures, filters.Architecture) {
+			return false
+		}
+	}
+	var networkPerformance string
+	if instanceTypeInfo.NetworkInfo != nil {
+		networkPerformance = aws.StringValue(instanceTypeInfo.NetworkInfo.NetworkPerformance)
+	}
+	if !filters.MatchesNetworkPerformance(networkPerformance) {
+		return false
+	}
+
+	if filters.MaxPrice > 0 {
+		if prices == nil {
+			return false
+		}
+		price, ok := prices.GetPrice(instanceType)
+		if !ok || price.ByType(priceType) <= 0 || price.ByType(priceType) > filters.MaxPrice {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString checks whether a list of string pointers contains a value.
+func containsString(values []*string, value string) bool {
+	for _, v := range values {
+		if aws.StringValue(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Placement assigns instance types to the Availability Zones of the subnets where their instances are launched.
+type Placement map[string]string
+
+// AvailabilityZones returns the distinct Availability Zones of the placement, sorted.
+func (p Placement) AvailabilityZones() (availabilityZones []string) {
+	for _, availabilityZone := range p {
+		if !containsAvailabilityZone(availabilityZones, availabilityZone) {
+			availabilityZones = append(availabilityZones, availabilityZone)
+		}
+	}
+	sort.Strings(availabilityZones)
+	return availabilityZones
+}
+
+// PlaceInstanceTypes places the instance types provided by the user in the fewest Availability Zones that together
+// offer all of them, so that a subnet is created in each of these Availability Zones only. It returns the placement,
+// and the user-specified instance types that are offered somewhere in the region. If the user provides a subnet, the
+// CLI must use it, so no instance type is placed.
+func (itf Resources) PlaceInstanceTypes(instanceTypes string, subnetId string) (placement Placement, placedInstanceTypes []string, err error) {
 	instanceTypesList := strings.Split(instanceTypes, ",")
 	if subnetId != none {
-		// If the user provides a subnet, the CLI must use it
-		return "", instanceTypesList, nil
+		return Placement{}, instanceTypesList, nil
 	}
 
-	azMap := make(map[string][]string)
-
 	availabilityZones, err := itf.getAvailabilityZones()
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
+	sort.Strings(availabilityZones)
 
+	offerings := make(map[string]map[string]bool)
 	for _, availabilityZone := range availabilityZones {
-		output, err := itf.EC2.DescribeInstanceTypeOfferings(&ec2.DescribeInstanceTypeOfferingsInput{
+		offerings[availabilityZone] = make(map[string]bool)
+		err := itf.EC2.DescribeInstanceTypeOfferingsPages(&ec2.DescribeInstanceTypeOfferingsInput{
 			LocationType: aws.String("availability-zone"),
 			Filters: []*ec2.Filter{
 				{
@@ -105,38 +263,96 @@ func (itf Resources) FindBestAvailabilityZone(instanceTypes string, subnetId str
 					Values: []*string{aws.String(availabilityZone)},
 				},
 			},
+		}, func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+			for _, instanceTypeOffering := range page.InstanceTypeOfferings {
+				offerings[availabilityZone][*instanceTypeOffering.InstanceType] = true
+			}
+			return true
 		})
 		if err != nil {
-			return "", nil, err
+			return nil, nil, err
+		}
+	}
+
+	for _, instanceType := range instanceTypesList {
+		for _, availabilityZone := range availabilityZones {
+			if offerings[availabilityZone][instanceType] {
+				placedInstanceTypes = append(placedInstanceTypes, instanceType)
+				break
+			}
+		}
+	}
+
+	// Each instance type goes to the first Availability Zone of the cover that offers it
+	placement = make(Placement)
+	cover := minimalCover(availabilityZones, offerings, placedInstanceTypes)
+	for _, instanceType := range placedInstanceTypes {
+		for _, availabilityZone := range cover {
+			if offerings[availabilityZone][instanceType] {
+				placement[instanceType] = availabilityZone
+				break
+			}
+		}
+	}
+	for _, availabilityZone := range cover {
+		var placedInAvailabilityZone []string
+		for _, instanceType := range placedInstanceTypes {
+			if placement[instanceType] == availabilityZone {
+				placedInAvailabilityZone = append(placedInAvailabilityZone, instanceType)
+			}
+		}
+		log.Printf("Use %s to create subnet which supports %v\n", availabilityZone, placedInAvailabilityZone)
+	}
+
+	return placement, placedInstanceTypes, nil
+}
+
+// minimalCover returns the smallest set of Availability Zones that together offer all instance types. Sets of the
+// same size are tried in the order of the Availability Zones, which regions only have a handful of.
+func minimalCover(availabilityZones []string, offerings map[string]map[string]bool, instanceTypes []string) []string {
+	for size := 1; size <= len(availabilityZones); size++ {
+		if cover := coverOfSize(availabilityZones, offerings, instanceTypes, size, nil); cover != nil {
+			return cover
 		}
+	}
+	return nil
+}
 
-		azMap[availabilityZone] = make([]string, 0)
-		for _, instanceType := range instanceTypesList {
-			isFound := false
-			for _, instanceTypeOffering := range output.InstanceTypeOfferings {
-				if instanceType == *instanceTypeOffering.InstanceType {
-					isFound = true
+// coverOfSize extends the Availability Zones chosen so far with the remaining ones until the set has the given size,
+// and returns the first set that offers all instance types, or nil.
+func coverOfSize(availabilityZones []string, offerings map[string]map[string]bool, instanceTypes []string, size int, chosen []string) []string {
+	if len(chosen) == size {
+		for _, instanceType := range instanceTypes {
+			isOffered := false
+			for _, availabilityZone := range chosen {
+				if offerings[availabilityZone][instanceType] {
+					isOffered = true
 					break
 				}
 			}
-
-			if isFound {
-				azMap[availabilityZone] = append(azMap[availabilityZone], instanceType)
+			if !isOffered {
+				return nil
 			}
 		}
+		return chosen
 	}
-
-	maxSupportedInstancesNum := 0
-	for availabilityZone, supportedInstanceTypesList := range azMap {
-		if len(supportedInstanceTypesList) > maxSupportedInstancesNum {
-			maxSupportedInstancesNum = len(supportedInstanceTypesList)
-			bestAvailabilityZone = availabilityZone
+	for i, availabilityZone := range availabilityZones {
+		extended := append(append([]string{}, chosen...), availabilityZone)
+		if cover := coverOfSize(availabilityZones[i+1:], offerings, instanceTypes, size, extended); cover != nil {
+			return cover
 		}
 	}
-	supportedInstanceTypes = azMap[bestAvailabilityZone]
-	log.Printf("Use %s to create subnet which supports %v\n", bestAvailabilityZone, supportedInstanceTypes)
+	return nil
+}
 
-	return bestAvailabilityZone, supportedInstanceTypes, nil
+// containsAvailabilityZone checks whether a list of Availability Zones contains one.
+func containsAvailabilityZone(availabilityZones []string, availabilityZone string) bool {
+	for _, az := range availabilityZones {
+		if az == availabilityZone {
+			return true
+		}
+	}
+	return false
 }
 
 // GetSupportedInstances returns instances that are supported to be launched. It checks 2 things:
@@ -179,7 +395,8 @@ func (itf Resources) isInstanceTypeAvailableInSubnet(instanceType string, subnet
 		return false, err
 	}
 
-	output, err := itf.EC2.DescribeInstanceTypeOfferings(&ec2.DescribeInstanceTypeOfferingsInput{
+	isOffered := false
+	err = itf.EC2.DescribeInstanceTypeOfferingsPages(&ec2.DescribeInstanceTypeOfferingsInput{
 		LocationType: aws.String("availability-zone"),
 		Filters: []*ec2.Filter{
 			{
@@ -191,12 +408,15 @@ func (itf Resources) isInstanceTypeAvailableInSubnet(instanceType string, subnet
 				Values: []*string{aws.String(instanceType)},
 			},
 		},
+	}, func(page *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+		isOffered = len(page.InstanceTypeOfferings) > 0
+		return !isOffered
 	})
 	if err != nil {
 		return false, err
 	}
 
-	if len(output.InstanceTypeOfferings) == 0 {
+	if !isOffered {
 		log.Printf("%s is not available in %s\n", instanceType, availabilityZone)
 		return false, nil
 	}
@@ -220,7 +440,7 @@ func (itf Resources) populateMetadata(instanceType string, amiId string) (instan
 	instance.VCpus = strconv.Itoa(int(*instanceTypeInfo.VCpuInfo.DefaultVCpus))
 	instance.Memory = strconv.Itoa(int(*instanceTypeInfo.MemoryInfo.SizeInMiB))
 
-	if osVersion == "" {
+	if osVersion == "" || imageId != amiId {
 		imagesOutput, err := itf.EC2.DescribeImages(&ec2.DescribeImagesInput{
 			ImageIds: []*string{aws.String(amiId)},
 		})
@@ -228,6 +448,7 @@ func (itf Resources) populateMetadata(instanceType string, amiId string) (instan
 			return instance, err
 		}
 		imageInfo := imagesOutput.Images[0]
+		imageId = amiId
 		osVersion = *imageInfo.PlatformDetails
 		architecture = *imageInfo.Architecture
 	}
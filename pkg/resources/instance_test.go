@@ -14,21 +14,127 @@
 package resources_test
 
 import (
+	"strconv"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/pricing"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
 
+// Mocking
+
+type mockedInstanceTypesEC2 struct {
+	ec2iface.EC2API
+	InstanceTypes []*ec2.InstanceTypeInfo
+}
+
+// DescribeInstanceTypesPages returns one instance type per page, restricted to the instance types of the input if any.
+func (m mockedInstanceTypesEC2) DescribeInstanceTypesPages(input *ec2.DescribeInstanceTypesInput, fn func(*ec2.DescribeInstanceTypesOutput, bool) bool) error {
+	for i, instanceTypeInfo := range m.InstanceTypes {
+		if len(input.InstanceTypes) > 0 && !containsInstanceType(input.InstanceTypes, *instanceTypeInfo.InstanceType) {
+			continue
+		}
+		page := &ec2.DescribeInstanceTypesOutput{InstanceTypes: []*ec2.InstanceTypeInfo{instanceTypeInfo}}
+		if !fn(page, i == len(m.InstanceTypes)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+type mockedPlacementEC2 struct {
+	ec2iface.EC2API
+	// Offerings maps Availability Zones to the instance types they offer.
+	Offerings map[string][]string
+}
+
+func (m mockedPlacementEC2) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	output := &ec2.DescribeAvailabilityZonesOutput{}
+	for availabilityZone := range m.Offerings {
+		output.AvailabilityZones = append(output.AvailabilityZones, &ec2.AvailabilityZone{ZoneName: aws.String(availabilityZone)})
+	}
+	return output, nil
+}
+
+// DescribeInstanceTypeOfferingsPages describes one offering per page.
+func (m mockedPlacementEC2) DescribeInstanceTypeOfferingsPages(input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool) error {
+	instanceTypes := m.Offerings[*input.Filters[0].Values[0]]
+	for i, instanceType := range instanceTypes {
+		page := &ec2.DescribeInstanceTypeOfferingsOutput{InstanceTypeOfferings: []*ec2.InstanceTypeOffering{{InstanceType: aws.String(instanceType)}}}
+		if !fn(page, i == len(instanceTypes)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// mockedStatesEC2 describes one instance per page.
+type mockedStatesEC2 struct {
+	ec2iface.EC2API
+	Instances []*ec2.Instance
+}
+
+func (m mockedStatesEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	i := 0
+	if input.NextToken != nil {
+		i, _ = strconv.Atoi(*input.NextToken)
+	}
+	output := &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{m.Instances[i]}}}}
+	if i < len(m.Instances)-1 {
+		output.NextToken = aws.String(strconv.Itoa(i + 1))
+	}
+	return output, nil
+}
+
 // Helpers
 
+func containsInstanceType(instanceTypes []*string, instanceType string) bool {
+	for _, t := range instanceTypes {
+		if *t == instanceType {
+			return true
+		}
+	}
+	return false
+}
+
+func instanceTypeInfo(instanceType string, vCpus int64, memoryMiB int64, architecture string, burstable bool, currentGeneration bool, networkPerformance string) *ec2.InstanceTypeInfo {
+	return &ec2.InstanceTypeInfo{
+		InstanceType:                  aws.String(instanceType),
+		VCpuInfo:                      &ec2.VCpuInfo{DefaultVCpus: aws.Int64(vCpus)},
+		MemoryInfo:                    &ec2.MemoryInfo{SizeInMiB: aws.Int64(memoryMiB)},
+		ProcessorInfo:                 &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{architecture})},
+		BurstablePerformanceSupported: aws.Bool(burstable),
+		CurrentGeneration:             aws.Bool(currentGeneration),
+		NetworkInfo:                   &ec2.NetworkInfo{NetworkPerformance: aws.String(networkPerformance)},
+	}
+}
+
+func newInstanceTypesResources() resources.Resources {
+	return resources.Resources{
+		EC2: mockedInstanceTypesEC2{InstanceTypes: []*ec2.InstanceTypeInfo{
+			instanceTypeInfo("m5.xlarge", 4, 16384, "x86_64", false, true, "Up to 10 Gigabit"),
+			instanceTypeInfo("m5.2xlarge", 8, 32768, "x86_64", false, true, "Up to 10 Gigabit"),
+			instanceTypeInfo("m5.4xlarge", 16, 65536, "x86_64", false, true, "Up to 10 Gigabit"),
+			instanceTypeInfo("m4.xlarge", 4, 16384, "x86_64", false, false, "High"),
+			instanceTypeInfo("c5.2xlarge", 8, 16384, "x86_64", false, true, "Up to 10 Gigabit"),
+			instanceTypeInfo("t3.xlarge", 4, 16384, "x86_64", true, true, "Up to 5 Gigabit"),
+			instanceTypeInfo("m6g.xlarge", 4, 16384, "arm64", false, true, "Up to 10 Gigabit"),
+		}},
+	}
+}
+
 func isInstanceTypeAvailable(instanceType string) bool {
 	return instanceType == "m4.large" || instanceType == "m4.xlarge" || instanceType == "c4.large" || instanceType == "a1.large"
 }
 
 // Tests
 
-func TestFindBestAvailabilityZoneNewSubnet(t *testing.T) {
+func TestPlaceInstanceTypesNewSubnet(t *testing.T) {
 	ec2Mock := mockedEC2{
 		DescribeAvailabilityZonesResp:             setupMockedEC2(t, describeAvailabilityZones, "availability_zones.json").DescribeAvailabilityZonesResp,
 		DescribeInstanceTypeOfferingsRespUsEast2a: setupMockedEC2(t, describeInstanceTypeOfferings, "us_east_2a.json").DescribeInstanceTypeOfferingsRespUsEast2a,
@@ -39,19 +145,41 @@ func TestFindBestAvailabilityZoneNewSubnet(t *testing.T) {
 		EC2: ec2Mock,
 	}
 	// c5a.12xlarge is not available in us-east-2a, a1.4xlarge is not available in us-east-2c
-	availabilityZone, instanceTypes, err := itf.FindBestAvailabilityZone("m4.large,m4.xlarge,c4.large,c5a.12xlarge,a1.4xlarge", "NONE")
+	placement, instanceTypes, err := itf.PlaceInstanceTypes("m4.large,m4.xlarge,c4.large,c5a.12xlarge,a1.4xlarge", "NONE")
 	h.Ok(t, err)
-	h.Equals(t, "us-east-2b", availabilityZone)
+	h.Equals(t, []string{"us-east-2b"}, placement.AvailabilityZones())
 	h.Equals(t, []string{"m4.large", "m4.xlarge", "c4.large", "c5a.12xlarge", "a1.4xlarge"}, instanceTypes)
 }
 
-func TestFindBestAvailabilityZoneExistingSubnet(t *testing.T) {
+func TestPlaceInstanceTypesMultipleAvailabilityZones(t *testing.T) {
+	itf := resources.Resources{
+		EC2: mockedPlacementEC2{Offerings: map[string][]string{
+			"us-east-2a": {"m4.large", "c5.large", "p3.2xlarge"},
+			"us-east-2b": {"m4.large", "m5.large"},
+			"us-east-2c": {"m5.large", "c5.large", "a1.large"},
+		}},
+	}
+	// us-east-2a and us-east-2c offer all instance types but x1.large, which isn't offered anywhere
+	placement, instanceTypes, err := itf.PlaceInstanceTypes("m4.large,m5.large,c5.large,a1.large,p3.2xlarge,x1.large", "NONE")
+	h.Ok(t, err)
+	h.Equals(t, []string{"m4.large", "m5.large", "c5.large", "a1.large", "p3.2xlarge"}, instanceTypes)
+	h.Equals(t, []string{"us-east-2a", "us-east-2c"}, placement.AvailabilityZones())
+	h.Equals(t, resources.Placement{
+		"m4.large":   "us-east-2a",
+		"m5.large":   "us-east-2c",
+		"c5.large":   "us-east-2a",
+		"a1.large":   "us-east-2c",
+		"p3.2xlarge": "us-east-2a",
+	}, placement)
+}
+
+func TestPlaceInstanceTypesExistingSubnet(t *testing.T) {
 	itf := resources.Resources{
 		EC2: mockedEC2{},
 	}
-	availabilityZone, instanceTypes, err := itf.FindBestAvailabilityZone("m4.large,m4.xlarge,c4.large,c5a.12xlarge,a1.4xlarge", "subnet-12345")
+	placement, instanceTypes, err := itf.PlaceInstanceTypes("m4.large,m4.xlarge,c4.large,c5a.12xlarge,a1.4xlarge", "subnet-12345")
 	h.Ok(t, err)
-	h.Equals(t, "", availabilityZone)
+	h.Equals(t, 0, len(placement.AvailabilityZones()))
 	h.Equals(t, []string{"m4.large", "m4.xlarge", "c4.large", "c5a.12xlarge", "a1.4xlarge"}, instanceTypes)
 }
 
@@ -121,3 +249,90 @@ func TestGetSupportedInstancesNoSupportedInstanceTypeFailure(t *testing.T) {
 	_, err := itf.GetSupportedInstances([]string{"a1.large", "c5a.12xlarge"}, "VALID_AMI_ID", "subnet-123456")
 	h.Assert(t, err != nil, "Failed to return error when there is no supported instance type")
 }
+
+func TestIsInstanceSpotInterrupted(t *testing.T) {
+	itf := resources.Resources{
+		EC2: setupMockedEC2(t, describeInstances, "spot_interrupted.json"),
+	}
+	isInterrupted, err := itf.IsInstanceSpotInterrupted("i-0df3ef636ba12ee2a")
+	h.Ok(t, err)
+	h.Assert(t, isInterrupted, "Failed to detect the spot interruption of the instance")
+}
+
+func TestIsInstanceSpotInterruptedUserTerminated(t *testing.T) {
+	itf := resources.Resources{
+		EC2: setupMockedEC2(t, describeInstances, "user_terminated.json"),
+	}
+	isInterrupted, err := itf.IsInstanceSpotInterrupted("i-0df3ef636ba12ee2a")
+	h.Ok(t, err)
+	h.Assert(t, !isInterrupted, "Instance terminated by the user should not be reported as interrupted")
+}
+
+func TestGetInstanceStates(t *testing.T) {
+	itf := resources.Resources{
+		EC2: mockedStatesEC2{Instances: []*ec2.Instance{
+			{InstanceId: aws.String("i-running"), State: &ec2.InstanceState{Name: aws.String("running")}},
+			{InstanceId: aws.String("i-terminated"), State: &ec2.InstanceState{Name: aws.String("terminated")}},
+			{
+				InstanceId:  aws.String("i-interrupted"),
+				State:       &ec2.InstanceState{Name: aws.String("terminated")},
+				StateReason: &ec2.StateReason{Code: aws.String("Server.SpotInstanceTermination")},
+			},
+		}},
+	}
+	states, err := itf.GetInstanceStates([]string{"i-running", "i-terminated", "i-interrupted"})
+	h.Ok(t, err)
+	h.Equals(t, map[string]resources.InstanceState{
+		"i-running":     {IsRunning: true},
+		"i-terminated":  {},
+		"i-interrupted": {IsSpotInterrupted: true},
+	}, states)
+}
+
+func TestResolveInstanceTypesSuccess(t *testing.T) {
+	itf := newInstanceTypesResources()
+	filters := config.InstanceTypeFilters{VCpusMin: 4, VCpusMax: 8, MemoryMin: 16, MemoryMax: 32, Architecture: "x86_64", Burstable: "false", CurrentGeneration: true}
+	instanceTypes, err := itf.ResolveInstanceTypes("", filters, nil, pricing.OnDemand)
+	h.Ok(t, err)
+	h.Equals(t, []string{"c5.2xlarge", "m5.2xlarge", "m5.xlarge"}, instanceTypes)
+
+	filters = config.InstanceTypeFilters{Families: "m*", ExcludeFamilies: "m4", NetworkPerformance: "up to 10 gigabit", VCpusMax: 4}
+	instanceTypes, err = itf.ResolveInstanceTypes("", filters, nil, pricing.OnDemand)
+	h.Ok(t, err)
+	h.Equals(t, []string{"m5.xlarge", "m6g.xlarge"}, instanceTypes)
+
+	filters = config.InstanceTypeFilters{Burstable: "true"}
+	instanceTypes, err = itf.ResolveInstanceTypes("", filters, nil, pricing.OnDemand)
+	h.Ok(t, err)
+	h.Equals(t, []string{"t3.xlarge"}, instanceTypes)
+}
+
+func TestResolveInstanceTypesNarrowDownListSuccess(t *testing.T) {
+	itf := newInstanceTypesResources()
+	instanceTypes, err := itf.ResolveInstanceTypes("m5.xlarge,m4.xlarge,t3.xlarge", config.InstanceTypeFilters{CurrentGeneration: true}, nil, pricing.OnDemand)
+	h.Ok(t, err)
+	h.Equals(t, []string{"m5.xlarge", "t3.xlarge"}, instanceTypes)
+}
+
+func TestResolveInstanceTypesMaxPriceSuccess(t *testing.T) {
+	itf := newInstanceTypesResources()
+	prices := pricing.StaticSource{
+		"m5.xlarge":  {OnDemand: 0.192, Spot: 0.07},
+		"m5.2xlarge": {OnDemand: 0.384, Spot: 0.14},
+		"c5.2xlarge": {OnDemand: 0.34, Spot: 0.12},
+	}
+	// Instance types without a price are excluded
+	instanceTypes, err := itf.ResolveInstanceTypes("", config.InstanceTypeFilters{MaxPrice: 0.3}, prices, pricing.OnDemand)
+	h.Ok(t, err)
+	h.Equals(t, []string{"m5.xlarge"}, instanceTypes)
+
+	instanceTypes, err = itf.ResolveInstanceTypes("", config.InstanceTypeFilters{MaxPrice: 0.3}, prices, pricing.Spot)
+	h.Ok(t, err)
+	h.Equals(t, []string{"c5.2xlarge", "m5.2xlarge", "m5.xlarge"}, instanceTypes)
+}
+
+func TestResolveInstanceTypesNoMatchFailure(t *testing.T) {
+	itf := newInstanceTypesResources()
+	_, err := itf.ResolveInstanceTypes("", config.InstanceTypeFilters{VCpusMin: 64}, nil, pricing.OnDemand)
+	h.Assert(t, err != nil, "Failed to return error when no instance type matches the filters")
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// TestFixtureKey is the key of the test fixture of a run, from which the run is resumed.
+	TestFixtureKey = "test-fixture.json"
+	// RunStatusKey is the key of the status of a run, from which the run is resumed and cleaned up.
+	RunStatusKey         = "run-state.json"
+	bucketTestsDir       = "Tests"
+	instanceResultSuffix = "-test-results.json"
+	testOutputSuffix     = ".log"
+	bucketLogsDir        = "Logs"
+	bucketHeartbeatsDir  = "Heartbeats"
+	heartbeatSuffix      = ".json"
+)
+
+// BucketLayout lays out the keys of the objects of a run in its bucket:
+//
+//	test-fixture.json
+//	run-state.json
+//	<user config>
+//	<CloudFormation template>
+//	<compressed test suite>
+//	<root dir>/<final result>
+//	<root dir>/<instance type>/<instance ID>/<instance ID>-test-results.json
+//	<root dir>/<instance type>/<instance ID>/<instance type>.log
+//	<root dir>/<instance type>/<instance ID>/Tests/<instance ID>-test-results.json, updated after each test file
+//	<root dir>/<instance type>/<instance ID>/Tests/<test file>-result.json
+//	<root dir>/<instance type>/<instance ID>/Tests/<test file>-stdout.log and <test file>-stderr.log
+//	Heartbeats/<root dir>/<instance type>/<instance ID>.json, updated periodically while the agent runs
+//	Logs/<root dir>/<instance type>/<instance ID>/<sequence number>.log, each chunk of the log uploaded while the agent runs
+//
+// The heartbeats and the chunks of the logs, which keep growing while the agents run, are kept out of the root dir
+// so that the results are listed without them.
+type BucketLayout struct {
+	RootDir string
+}
+
+// InstanceLayout lays out the keys of the objects of an instance of a run.
+type InstanceLayout struct {
+	Dir        string
+	InstanceId string
+}
+
+// TestSuiteKey returns the key of the compressed test suite.
+func TestSuiteKey(compressedTestSuiteName string) string {
+	return filepath.Base(compressedTestSuiteName)
+}
+
+// InstanceResultFilename returns the name of the file of the result of an instance.
+func InstanceResultFilename(instanceId string) string {
+	return instanceId + instanceResultSuffix
+}
+
+// TestOutputFilename returns the name of the file capturing the output of a test file on a stream.
+func TestOutputFilename(testFile string, stream string) string {
+	return filepath.Base(testFile) + "-" + stream + testOutputSuffix
+}
+
+// FinalResult returns the key of the final result, which merges the results of all instances.
+func (l BucketLayout) FinalResult(finalResultFilename string) string {
+	return path.Join(l.RootDir, finalResultFilename)
+}
+
+// Heartbeats returns the prefix of the keys of the heartbeats of all instances.
+func (l BucketLayout) Heartbeats() string {
+	return path.Join(bucketHeartbeatsDir, l.RootDir) + "/"
+}
+
+// LogChunks returns the prefix of the keys of the chunks of the logs of all instances.
+func (l BucketLayout) LogChunks() string {
+	return path.Join(bucketLogsDir, l.RootDir) + "/"
+}
+
+// Instance returns the layout of the objects of an instance.
+func (l BucketLayout) Instance(instanceType string, instanceId string) InstanceLayout {
+	return InstanceLayout{Dir: path.Join(l.RootDir, instanceType, instanceId), InstanceId: instanceId}
+}
+
+// Object returns the key of a file uploaded by the instance, such as its log.
+func (l InstanceLayout) Object(filename string) string {
+	return path.Join(l.Dir, filename)
+}
+
+// TestsObject returns the key of a file uploaded by the instance while executing the tests.
+func (l InstanceLayout) TestsObject(filename string) string {
+	return path.Join(l.Dir, bucketTestsDir, filename)
+}
+
+// TestOutput returns the key of the output of a test file on a stream.
+func (l InstanceLayout) TestOutput(testFile string, stream string) string {
+	return l.TestsObject(TestOutputFilename(testFile, stream))
+}
+
+// Heartbeat returns the key of the heartbeat of the agent of the instance.
+func (l InstanceLayout) Heartbeat() string {
+	return path.Join(bucketHeartbeatsDir, l.Dir) + heartbeatSuffix
+}
+
+// LogChunk returns the key of a chunk of the log of the instance. The sequence number is zero-padded so that chunks
+// are listed in order.
+func (l InstanceLayout) LogChunk(sequenceNumber int) string {
+	return path.Join(bucketLogsDir, l.Dir, fmt.Sprintf("%06d.log", sequenceNumber))
+}
+
+// Result returns the key of the result of the instance, uploaded once it executed all test files.
+func (l InstanceLayout) Result() string {
+	return l.Object(InstanceResultFilename(l.InstanceId))
+}
+
+// PartialResult returns the key of the result of the instance updated after each test file, which is the result of
+// an instance which didn't execute all test files before timeout.
+func (l InstanceLayout) PartialResult() string {
+	return l.TestsObject(InstanceResultFilename(l.InstanceId))
+}
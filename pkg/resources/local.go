@@ -0,0 +1,606 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+)
+
+const (
+	localBucketsDir      = "buckets"
+	localStacksDir       = "stacks"
+	localMetricsDir      = "metrics"
+	localAgentBin        = "agent"
+	localWorkDirPrefix   = "instance-qualifier-"
+	meminfoPath          = "/proc/meminfo"
+	stateRunning         = "running"
+	stateTerminated      = "terminated"
+	errCodeNotFound      = "NotFound"
+	errCodeStackNotFound = "ValidationError"
+	// agentStopTimeout is how long the deletion of a stack waits for an agent to kill its tests and exit.
+	agentStopTimeout = 10 * time.Second
+	procDir          = "/proc"
+)
+
+// localInstance is an instance of a local stack, which is the process of its agent. The start time of the process
+// tells the agent apart from a process which reuses its PID once it exits; it is empty without /proc.
+type localInstance struct {
+	InstanceId       string `json:"instance-id"`
+	InstanceType     string `json:"instance-type"`
+	Pid              int    `json:"pid"`
+	ProcessStartTime string `json:"process-start-time,omitempty"`
+	WorkDir          string `json:"work-dir"`
+}
+
+// localDatapoint is a value of a metric published by a local agent.
+type localDatapoint struct {
+	MetricName string    `json:"metric"`
+	Timestamp  time.Time `json:"timestamp"`
+	Value      float64   `json:"value"`
+}
+
+// NewLocal creates an instance of Resources backed by the local machine for the local backend. Buckets are
+// directories of dir, the instances of a stack are the agent processes started by CreateLocalStack, and metrics
+// are the datapoints published by the agents. instanceId is the instance described by the metadata, which only
+// the agents know. The calls which the local machine doesn't back fail with an error.
+func NewLocal(dir string, instanceId string) *Resources {
+	sess := unsupportedSession()
+	return &Resources{
+		EC2:            localEC2{EC2API: ec2.New(sess), dir: dir},
+		CloudFormation: localCloudFormation{CloudFormationAPI: cloudformation.New(sess), dir: dir},
+		CloudWatch:     localCloudWatch{CloudWatchAPI: cloudwatch.New(sess), dir: dir},
+		S3:             s3.New(sess),
+		AutoScaling:    autoscaling.New(sess),
+		EC2Metadata:    localEC2Metadata{instanceId: instanceId},
+		Store:          LocalStore{Dir: filepath.Join(dir, localBucketsDir)},
+		LocalDir:       dir,
+	}
+}
+
+// unsupportedSession returns a session whose requests fail before they are sent, for the clients of the local backend
+// to reject the calls which the local machine doesn't back. Its waiters don't sleep between their attempts, so they
+// give up right away.
+func unsupportedSession() *session.Session {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:      aws.String(defaultRegion),
+			Credentials: credentials.AnonymousCredentials,
+			SleepDelay:  func(time.Duration) {},
+		},
+		SharedConfigState: session.SharedConfigDisable,
+	}))
+	sess.Handlers.Validate.Clear()
+	sess.Handlers.Validate.PushBack(func(r *request.Request) {
+		// The waiters match the status code of the response, which no request receives
+		r.HTTPResponse = &http.Response{Header: http.Header{}}
+		r.Error = fmt.Errorf("%s %s is not supported by the %s backend", r.ClientInfo.ServiceName, r.Operation.Name, config.BackendLocal)
+	})
+	sess.Handlers.Send.Clear()
+	return sess
+}
+
+// LocalInstances returns the instances of a local run: the replicas of each instance type, all of them with the
+// metadata of the local machine.
+func LocalInstances(instanceTypes []string, replicas int) (instances []Instance) {
+	memory := ""
+	if meminfo, err := ReadMeminfo(); err == nil {
+		memory = strconv.Itoa(int(meminfo["MemTotal"] / 1024))
+	}
+	osVersion := runtime.GOOS
+	if osVersion == "linux" {
+		osVersion = "Linux/UNIX"
+	}
+	architecture := runtime.GOARCH
+	if architecture == "amd64" {
+		architecture = "x86_64"
+	}
+	for _, instanceType := range instanceTypes {
+		for i := 0; i < replicas; i++ {
+			instances = append(instances, Instance{
+				InstanceType: instanceType,
+				VCpus:        strconv.Itoa(runtime.NumCPU()),
+				Memory:       memory,
+				Os:           osVersion,
+				Architecture: architecture,
+			})
+		}
+	}
+	return instances
+}
+
+// ReadMeminfo returns the fields of /proc/meminfo, in kB.
+func ReadMeminfo() (map[string]float64, error) {
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseMeminfo(file)
+}
+
+// parseMeminfo parses the fields of the content of /proc/meminfo.
+func parseMeminfo(reader io.Reader) (map[string]float64, error) {
+	meminfo := make(map[string]float64)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if value, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			meminfo[strings.TrimSuffix(fields[0], ":")] = value
+		}
+	}
+	return meminfo, scanner.Err()
+}
+
+// CreateLocalStack starts the agent of each instance as a child process, as the user data of an instance does: the
+// compressed test suite is downloaded from the bucket and unpacked into a temporary directory, then the agent runs
+// the test suite in it and logs next to the test files. The agents ignore interrupts, so that the CLI can quit and
+// resume the run as with EC2. The custom script is not executed since it provisions instances.
+func (itf Resources) CreateLocalStack(instances []Instance, outputStream *os.File) error {
+	testFixture := config.GetTestFixture()
+	testSuiteKey := TestSuiteKey(testFixture.CompressedTestSuiteName)
+
+	var stack []localInstance
+	for _, instance := range instances {
+		instanceId := fmt.Sprintf("i-%017x", rand.Int63())
+		workDir, err := ioutil.TempDir("", localWorkDirPrefix+instanceId)
+		if err != nil {
+			return err
+		}
+		compressedTestSuite := filepath.Join(workDir, testSuiteKey)
+		if err := GetFile(itf.Store, testFixture.BucketName, compressedTestSuite, testSuiteKey); err != nil {
+			return err
+		}
+		if err := cmdutil.Decompress(compressedTestSuite, workDir); err != nil {
+			return err
+		}
+
+		instance.InstanceId = instanceId
+		pid, err := itf.startLocalAgent(filepath.Join(workDir, filepath.Base(testFixture.TestSuiteName)), instance, testFixture)
+		if err != nil {
+			return err
+		}
+		_, startTime, _ := readProcessStat(pid)
+		stack = append(stack, localInstance{InstanceId: instanceId, InstanceType: instance.InstanceType, Pid: pid, ProcessStartTime: startTime, WorkDir: workDir})
+		// Persist the stack as it grows, so that deleting it stops every agent started so far
+		if err := itf.writeLocalStack(testFixture.CfnStackName, stack); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(outputStream, "Stack Created: %s (local)\n", testFixture.CfnStackName)
+
+	return nil
+}
+
+// startLocalAgent makes the files of the test suite executable and starts the agent of the instance in it. The agent
+// is reaped once it exits so that it isn't reported as running.
+func (itf Resources) startLocalAgent(testSuiteDir string, instance Instance, testFixture config.TestFixture) (pid int, err error) {
+	files, err := ioutil.ReadDir(testSuiteDir)
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			if err := os.Chmod(filepath.Join(testSuiteDir, file.Name()), file.Mode()|0100); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	logFile, err := os.Create(filepath.Join(testSuiteDir, instance.InstanceType+".log"))
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(filepath.Join(testSuiteDir, localAgentBin), instance.InstanceType, instance.VCpus, instance.Memory,
+		instance.Os, instance.Architecture, testFixture.BucketName, strconv.Itoa(testFixture.Timeout), testFixture.BucketRootDir,
+		"", testFixture.PurchaseOption, "--backend="+config.BackendLocal, "--local-dir="+itf.LocalDir, "--instance-id="+instance.InstanceId,
+		"--store-endpoint="+testFixture.StoreEndpoint, "--concurrency="+strconv.Itoa(testFixture.Concurrency))
+	cmd.Dir = testSuiteDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return 0, err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("The agent of %s exited: %v\n", instance.InstanceId, err)
+		}
+		logFile.Close()
+	}()
+	log.Printf("Started the agent of %s (%s) in %s\n", instance.InstanceId, instance.InstanceType, testSuiteDir)
+
+	return cmd.Process.Pid, nil
+}
+
+// writeLocalStack persists the instances of a local stack.
+func (itf Resources) writeLocalStack(stackName string, stack []localInstance) error {
+	if err := os.MkdirAll(filepath.Join(itf.LocalDir, localStacksDir), 0755); err != nil {
+		return err
+	}
+	return cmdutil.MarshalToFile(stack, localStackPath(itf.LocalDir, stackName))
+}
+
+// readLocalStack returns the instances of a local stack.
+func readLocalStack(dir string, stackName string) (stack []localInstance, err error) {
+	data, err := ioutil.ReadFile(localStackPath(dir, stackName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, awserr.New(errCodeStackNotFound, fmt.Sprintf("Stack with id %s does not exist", stackName), err)
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &stack)
+	return stack, err
+}
+
+// findLocalInstance returns the instance of any local stack with the instance ID.
+func findLocalInstance(dir string, instanceId string) (localInstance, bool) {
+	stackFiles, err := filepath.Glob(filepath.Join(dir, localStacksDir, "*.json"))
+	if err != nil {
+		return localInstance{}, false
+	}
+	for _, stackFile := range stackFiles {
+		stack, err := readLocalStack(dir, strings.TrimSuffix(filepath.Base(stackFile), ".json"))
+		if err != nil {
+			continue
+		}
+		for _, instance := range stack {
+			if instance.InstanceId == instanceId {
+				return instance, true
+			}
+		}
+	}
+	return localInstance{}, false
+}
+
+func localStackPath(dir string, stackName string) string {
+	return filepath.Join(dir, localStacksDir, stackName+".json")
+}
+
+func localMetricsPath(dir string, instanceId string) string {
+	return filepath.Join(dir, localMetricsDir, instanceId+".jsonl")
+}
+
+// isAgentRunning checks whether the agent of the instance is running, and not a zombie it left nor another process
+// which reuses its PID.
+func isAgentRunning(instance localInstance) bool {
+	if !isProcessRunning(instance.Pid) {
+		return false
+	}
+	state, startTime, ok := readProcessStat(instance.Pid)
+	if !ok {
+		return instance.ProcessStartTime == ""
+	}
+	return state != "Z" && (instance.ProcessStartTime == "" || startTime == instance.ProcessStartTime)
+}
+
+// readProcessStat returns the state of the process and the time it started after the boot, in clock ticks, as in
+// /proc/<pid>/stat. It isn't ok if the process doesn't exist or /proc isn't available.
+func readProcessStat(pid int) (state string, startTime string, ok bool) {
+	data, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", "", false
+	}
+	// The fields after the command, which may contain spaces, start with the state (field 3); starttime is field 22
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return "", "", false
+	}
+	return fields[0], fields[19], true
+}
+
+// PutLocalMetricData publishes the values of the metrics of a local instance at the timestamp, as the CloudWatch agent
+// does on an EC2 instance.
+func (itf Resources) PutLocalMetricData(instance Instance, values map[string]float64, timestamp time.Time) error {
+	var metricNames []string
+	for name := range values {
+		metricNames = append(metricNames, name)
+	}
+	sort.Strings(metricNames)
+
+	var metricData []*cloudwatch.MetricDatum
+	for _, name := range metricNames {
+		metricData = append(metricData, &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: []*cloudwatch.Dimension{
+				{
+					Name:  aws.String("InstanceId"),
+					Value: aws.String(instance.InstanceId),
+				},
+				{
+					Name:  aws.String("InstanceType"),
+					Value: aws.String(instance.InstanceType),
+				},
+			},
+			Timestamp: aws.Time(timestamp),
+			Value:     aws.Float64(values[name]),
+		})
+	}
+	_, err := itf.CloudWatch.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(cwAgentNamespace),
+		MetricData: metricData,
+	})
+	return err
+}
+
+// Local EC2
+
+type localEC2 struct {
+	ec2iface.EC2API
+	dir string
+}
+
+func (l localEC2) DescribeInstanceStatus(input *ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+	output := &ec2.DescribeInstanceStatusOutput{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		if instance, ok := findLocalInstance(l.dir, instanceId); ok && isAgentRunning(instance) {
+			output.InstanceStatuses = append(output.InstanceStatuses, &ec2.InstanceStatus{
+				InstanceId:    aws.String(instanceId),
+				InstanceState: &ec2.InstanceState{Code: aws.Int64(16), Name: aws.String(stateRunning)},
+			})
+		}
+	}
+	return output, nil
+}
+
+func (l localEC2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	reservation := &ec2.Reservation{}
+	for _, instanceId := range aws.StringValueSlice(input.InstanceIds) {
+		instance, ok := findLocalInstance(l.dir, instanceId)
+		if !ok {
+			continue
+		}
+		state := stateTerminated
+		if isAgentRunning(instance) {
+			state = stateRunning
+		}
+		reservation.Instances = append(reservation.Instances, &ec2.Instance{
+			InstanceId:   aws.String(instance.InstanceId),
+			InstanceType: aws.String(instance.InstanceType),
+			State:        &ec2.InstanceState{Name: aws.String(state)},
+		})
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+type localEC2Metadata struct {
+	instanceId string
+}
+
+func (l localEC2Metadata) GetInstanceIdentityDocument() (ec2metadata.EC2InstanceIdentityDocument, error) {
+	return ec2metadata.EC2InstanceIdentityDocument{InstanceID: l.instanceId}, nil
+}
+
+func (l localEC2Metadata) GetMetadata(p string) (string, error) {
+	return "", fmt.Errorf("%s is not available on the local machine", p)
+}
+
+// Local CloudFormation
+
+type localCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	dir string
+}
+
+func (l localCloudFormation) DescribeStackResources(input *cloudformation.DescribeStackResourcesInput) (*cloudformation.DescribeStackResourcesOutput, error) {
+	stack, err := readLocalStack(l.dir, aws.StringValue(input.StackName))
+	if err != nil {
+		return nil, err
+	}
+	output := &cloudformation.DescribeStackResourcesOutput{}
+	for i, instance := range stack {
+		output.StackResources = append(output.StackResources, &cloudformation.StackResource{
+			LogicalResourceId:  aws.String("instance" + strconv.Itoa(i)),
+			PhysicalResourceId: aws.String(instance.InstanceId),
+			ResourceType:       aws.String("AWS::EC2::Instance"),
+		})
+	}
+	return output, nil
+}
+
+// DeleteStack stops the agents which are still running, which kill their tests as they exit, and removes their
+// temporary directories. The directory of an agent which doesn't stop in time is left behind, not to remove it from
+// under its tests.
+func (l localCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	stackName := aws.StringValue(input.StackName)
+	stack, err := readLocalStack(l.dir, stackName)
+	if err != nil {
+		return nil, err
+	}
+	for _, instance := range stack {
+		if isAgentRunning(instance) {
+			if err := terminateProcess(instance.Pid); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	for _, instance := range stack {
+		deadline := time.Now().Add(agentStopTimeout)
+		for isAgentRunning(instance) && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if isAgentRunning(instance) {
+			log.Printf("The agent of %s is still running; leaving %s behind\n", instance.InstanceId, instance.WorkDir)
+			continue
+		}
+		if err := os.RemoveAll(instance.WorkDir); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := os.Remove(localStackPath(l.dir, stackName)); err != nil {
+		return nil, err
+	}
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+// WaitUntilStackCreateComplete only checks that the stack exists, since CreateLocalStack returns once it is complete.
+func (l localCloudFormation) WaitUntilStackCreateComplete(input *cloudformation.DescribeStacksInput) error {
+	_, err := readLocalStack(l.dir, aws.StringValue(input.StackName))
+	return err
+}
+
+func (l localCloudFormation) WaitUntilStackDeleteComplete(input *cloudformation.DescribeStacksInput) error {
+	return nil
+}
+
+// Local CloudWatch
+
+type localCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	dir string
+}
+
+// PutMetricData appends the datapoints of each instance to its own file.
+func (l localCloudWatch) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	if err := os.MkdirAll(filepath.Join(l.dir, localMetricsDir), 0755); err != nil {
+		return nil, err
+	}
+	for _, datum := range input.MetricData {
+		instanceId := dimensionValue(datum.Dimensions, "InstanceId")
+		line, err := json.Marshal(localDatapoint{
+			MetricName: aws.StringValue(datum.MetricName),
+			Timestamp:  aws.TimeValue(datum.Timestamp),
+			Value:      aws.Float64Value(datum.Value),
+		})
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(localMetricsPath(l.dir, instanceId), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = file.Write(append(line, '\n'))
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// GetMetricData aggregates the datapoints of each query with its statistic over each of its periods within the time
+// range, as CloudWatch does. Periods without any datapoint are omitted.
+func (l localCloudWatch) GetMetricData(input *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	startTime, endTime := aws.TimeValue(input.StartTime), aws.TimeValue(input.EndTime)
+	datapointsByInstance := make(map[string][]localDatapoint)
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, query := range input.MetricDataQueries {
+		metricStat := query.MetricStat
+		instanceId := dimensionValue(metricStat.Metric.Dimensions, "InstanceId")
+		if _, ok := datapointsByInstance[instanceId]; !ok {
+			datapoints, err := readLocalDatapoints(l.dir, instanceId)
+			if err != nil {
+				return nil, err
+			}
+			datapointsByInstance[instanceId] = datapoints
+		}
+
+		period := time.Duration(aws.Int64Value(metricStat.Period)) * time.Second
+		valuesByPeriod := make(map[int64][]float64)
+		for _, datapoint := range datapointsByInstance[instanceId] {
+			if datapoint.MetricName != aws.StringValue(metricStat.Metric.MetricName) || datapoint.Timestamp.Before(startTime) || !datapoint.Timestamp.Before(endTime) {
+				continue
+			}
+			periodIdx := int64(datapoint.Timestamp.Sub(startTime) / period)
+			valuesByPeriod[periodIdx] = append(valuesByPeriod[periodIdx], datapoint.Value)
+		}
+		var periodIdxs []int64
+		for periodIdx := range valuesByPeriod {
+			periodIdxs = append(periodIdxs, periodIdx)
+		}
+		sort.Slice(periodIdxs, func(i, j int) bool { return periodIdxs[i] < periodIdxs[j] })
+
+		// Values is never nil so that the results are complete
+		result := &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			Label:      query.Label,
+			StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+			Values:     []*float64{},
+		}
+		for _, periodIdx := range periodIdxs {
+			result.Timestamps = append(result.Timestamps, aws.Time(startTime.Add(time.Duration(periodIdx)*period)))
+			result.Values = append(result.Values, aws.Float64(aggregate(valuesByPeriod[periodIdx], aws.StringValue(metricStat.Stat))))
+		}
+		output.MetricDataResults = append(output.MetricDataResults, result)
+	}
+	return output, nil
+}
+
+// readLocalDatapoints returns the datapoints published by the agent of an instance, which has none before it
+// publishes anything.
+func readLocalDatapoints(dir string, instanceId string) (datapoints []localDatapoint, err error) {
+	file, err := os.Open(localMetricsPath(dir, instanceId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var datapoint localDatapoint
+		// Skip a line being written
+		if err := json.Unmarshal(scanner.Bytes(), &datapoint); err == nil {
+			datapoints = append(datapoints, datapoint)
+		}
+	}
+	return datapoints, scanner.Err()
+}
+
+func dimensionValue(dimensions []*cloudwatch.Dimension, name string) string {
+	for _, dimension := range dimensions {
+		if aws.StringValue(dimension.Name) == name {
+			return aws.StringValue(dimension.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Helpers
+
+func newLocalResources(t *testing.T) (*resources.Resources, func()) {
+	dir, err := ioutil.TempDir("", "local-backend")
+	h.Ok(t, err)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	h.Ok(t, err)
+	svc := resources.NewLocal(dir, "")
+	h.Ok(t, svc.CreateBucket("123456789abcdef", devNull))
+	return svc, func() {
+		devNull.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Tests
+
+func TestLocalBucketSuccess(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+	bucket := config.GetTestFixture().BucketName
+
+	h.Ok(t, svc.Store.Put(bucket, "dir/object.json", bytes.NewReader([]byte("content"))))
+	_, err := os.Stat(svc.Store.Location(bucket, "dir/object.json"))
+	h.Ok(t, err)
+	data, err := svc.Store.Get(bucket, "dir/object.json")
+	h.Ok(t, err)
+	h.Equals(t, "content", string(data))
+
+	h.Ok(t, svc.DeleteBucket())
+	_, err = os.Stat(svc.Store.Location(bucket, ""))
+	h.Assert(t, os.IsNotExist(err), "Failed to delete the bucket directory")
+}
+
+func TestLocalBucketNonExistentObjectFailure(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+
+	_, err := svc.Store.Get(config.GetTestFixture().BucketName, "non-existent-object.json")
+	h.Assert(t, err != nil, "Failed to return error when the object doesn't exist")
+}
+
+func TestLocalCloudWatchDataSuccess(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+	instance := resources.Instance{
+		InstanceId:   "i-0ff4a2f594b270b54",
+		InstanceType: "m4.large",
+		Results: []resources.Result{
+			{Label: "cpu-test.sh", StartTime: "2020-08-01T10:00:30Z", EndTime: "2020-08-01T10:00:50Z"},
+		},
+	}
+	start := time.Date(2020, 8, 1, 10, 0, 0, 0, time.UTC)
+	for i, value := range []float64{10, 40, 20} {
+		h.Ok(t, svc.PutLocalMetricData(instance, map[string]float64{metrics.CpuUsageActive: value}, start.Add(time.Duration(10*(i+1))*time.Second)))
+	}
+	// Outside of the execution window
+	h.Ok(t, svc.PutLocalMetricData(instance, map[string]float64{metrics.CpuUsageActive: 90}, start.Add(2*time.Minute)))
+
+	testFixture := config.TestFixture{Metrics: []string{metrics.CpuUsageActive}}
+	results, _, err := svc.GetCloudWatchData([]resources.Instance{instance}, testFixture)
+	h.Ok(t, err)
+	h.Equals(t, 1, len(results))
+	h.Equals(t, []*float64{aws.Float64(40)}, results[0].Values)
+
+	testFixture.Statistic = metrics.Average
+	results, _, err = svc.GetCloudWatchData([]resources.Instance{instance}, testFixture)
+	h.Ok(t, err)
+	h.Equals(t, []*float64{aws.Float64(float64(70) / 3)}, results[0].Values)
+}
+
+func TestLocalCloudWatchDataWithoutDatapointSuccess(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+	instance := resources.Instance{
+		InstanceId:   "i-0ff4a2f594b270b54",
+		InstanceType: "m4.large",
+		Results: []resources.Result{
+			{Label: "cpu-test.sh", StartTime: "2020-08-01T10:00:30Z", EndTime: "2020-08-01T10:00:50Z"},
+		},
+	}
+
+	results, _, err := svc.GetCloudWatchData([]resources.Instance{instance}, config.TestFixture{Metrics: []string{metrics.CpuUsageActive}})
+	h.Ok(t, err)
+	h.Equals(t, []*float64{}, results[0].Values)
+}
+
+func TestLocalStackSuccess(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+
+	// The agent of the test suite only waits to be stopped
+	testSuite, err := ioutil.TempDir("", "local-test-suite")
+	h.Ok(t, err)
+	defer os.RemoveAll(testSuite)
+	h.Ok(t, ioutil.WriteFile(filepath.Join(testSuite, "agent"), []byte("#!/bin/sh\nsleep 60\n"), 0644))
+	h.Ok(t, config.PopulateTestFixture(config.UserConfig{TestSuiteName: testSuite, Timeout: 60}, "123456789abcdef", ""))
+	testFixture := config.GetTestFixture()
+	h.Ok(t, cmdutil.Compress(testFixture.TestSuiteName, testFixture.CompressedTestSuiteName))
+	defer os.Remove(testFixture.CompressedTestSuiteName)
+	h.Ok(t, resources.PutFile(svc.Store, testFixture.BucketName, testFixture.CompressedTestSuiteName, resources.TestSuiteKey(testFixture.CompressedTestSuiteName)))
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	h.Ok(t, err)
+	defer devNull.Close()
+	h.Ok(t, svc.CreateLocalStack(resources.LocalInstances([]string{"m4.large", "c5.large"}, 1), devNull))
+	h.Ok(t, svc.CloudFormation.WaitUntilStackCreateComplete(&cloudformation.DescribeStacksInput{StackName: aws.String(testFixture.CfnStackName)}))
+
+	instances, err := svc.GetInstancesInCfnStack()
+	h.Ok(t, err)
+	h.Equals(t, 2, len(instances))
+	h.Equals(t, "m4.large", instances[0].InstanceType)
+	h.Equals(t, "c5.large", instances[1].InstanceType)
+	for _, instance := range instances {
+		isRunning, err := svc.IsInstanceRunning(instance.InstanceId)
+		h.Ok(t, err)
+		h.Assert(t, isRunning, "The agent of "+instance.InstanceId+" is not running")
+	}
+
+	h.Ok(t, svc.DeleteCfnStack())
+	h.Ok(t, svc.WaitUntilCfnStackDeleteComplete())
+	_, err = svc.GetInstancesInCfnStack()
+	h.Assert(t, err != nil, "Failed to return error when the stack is deleted")
+	for _, instance := range instances {
+		isRunning, err := svc.IsInstanceRunning(instance.InstanceId)
+		h.Ok(t, err)
+		h.Assert(t, !isRunning, "The agent of "+instance.InstanceId+" is still running")
+	}
+}
+
+func TestLocalStackNonExistentTestSuiteFailure(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+	h.Ok(t, config.PopulateTestFixture(config.UserConfig{TestSuiteName: "non-existent-folder"}, "123456789abcdef", ""))
+
+	err := svc.CreateLocalStack(resources.LocalInstances([]string{"m4.large"}, 1), os.Stdout)
+	h.Assert(t, err != nil, "Failed to return error when the test suite isn't in the bucket")
+}
+
+func TestLocalUnsupportedCallFailure(t *testing.T) {
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+
+	_, err := svc.EC2.DescribeVpcs(&ec2.DescribeVpcsInput{})
+	h.Assert(t, err != nil && strings.Contains(err.Error(), "not supported by the local backend"), "Failed to reject an unsupported EC2 call")
+	_, err = svc.CloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{StackName: aws.String("stack")})
+	h.Assert(t, err != nil && strings.Contains(err.Error(), "not supported by the local backend"), "Failed to reject an unsupported CloudFormation call")
+	err = svc.CloudFormation.WaitUntilStackExists(&cloudformation.DescribeStacksInput{StackName: aws.String("stack")})
+	h.Assert(t, err != nil, "Failed to reject an unsupported CloudFormation waiter")
+	_, err = svc.S3.ListBuckets(&s3.ListBucketsInput{})
+	h.Assert(t, err != nil && strings.Contains(err.Error(), "not supported by the local backend"), "Failed to reject an unsupported S3 call")
+}
+
+func TestLocalStackReusedPidSuccess(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("/proc is not available")
+	}
+	svc, cleanup := newLocalResources(t)
+	defer cleanup()
+	workDir, err := ioutil.TempDir("", "local-work-dir")
+	h.Ok(t, err)
+	defer os.RemoveAll(workDir)
+
+	// The agent exited, and the test process reuses its PID
+	h.Ok(t, os.MkdirAll(filepath.Join(svc.LocalDir, "stacks"), 0755))
+	stack := fmt.Sprintf(`[{"instance-id": "i-0ff4a2f594b270b54", "instance-type": "m4.large", "pid": %d, "process-start-time": "1", "work-dir": %q}]`, os.Getpid(), workDir)
+	h.Ok(t, ioutil.WriteFile(filepath.Join(svc.LocalDir, "stacks", "qualifier-stack-123.json"), []byte(stack), 0644))
+
+	isRunning, err := svc.IsInstanceRunning("i-0ff4a2f594b270b54")
+	h.Ok(t, err)
+	h.Assert(t, !isRunning, "The process which reuses the PID of the agent is reported as the agent")
+	// The test process isn't signalled
+	_, err = svc.CloudFormation.DeleteStack(&cloudformation.DeleteStackInput{StackName: aws.String("qualifier-stack-123")})
+	h.Ok(t, err)
+	_, err = os.Stat(workDir)
+	h.Assert(t, os.IsNotExist(err), "Failed to remove the work directory of the agent")
+}
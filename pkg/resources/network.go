@@ -23,6 +23,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 )
 
 const (
@@ -62,7 +63,7 @@ func (itf Resources) onlyVpcProvided(vpcId string, inputStream *os.File, outputS
 	}
 	if !isValid {
 		prompt := fmt.Sprintf("The specified VPC %s doesn't exist/we were unable to locate that VPC. Create a new VPC and proceed?", vpcId)
-		answer, err := cmdutil.BoolPrompt(prompt, inputStream, outputStream)
+		answer, err := config.Decide(config.DecisionInvalidNetwork, prompt, inputStream, outputStream)
 		if err != nil {
 			return "", "", err
 		}
@@ -81,6 +82,7 @@ func (itf Resources) onlyVpcProvided(vpcId string, inputStream *os.File, outputS
 	}
 
 	var tableData [][]string
+	var subnetIds []string
 	for i, subnet := range subnets {
 		var row []string
 		row = append(row, strconv.Itoa(i))
@@ -88,11 +90,12 @@ func (itf Resources) onlyVpcProvided(vpcId string, inputStream *os.File, outputS
 		row = append(row, *subnet.AvailabilityZone)
 		row = append(row, *subnet.CidrBlock)
 		tableData = append(tableData, row)
+		subnetIds = append(subnetIds, *subnet.SubnetId)
 	}
 	cmdutil.RenderTable(tableData, strings.Split(subnetsTableHeader, ","), outputStream)
 
 	prompt := fmt.Sprintf("Please select a subnet. Your option:")
-	option, err := cmdutil.OptionPrompt(prompt, len(subnets), inputStream, outputStream)
+	option, err := config.Choose(config.DecisionSubnet, "subnet", prompt, subnetIds, inputStream, outputStream)
 	if err != nil {
 		return "", "", err
 	}
@@ -107,7 +110,7 @@ func (itf Resources) onlySubnetProvided(subnetId string, inputStream *os.File, o
 	}
 	if !isValid {
 		prompt := fmt.Sprintf("The specified subnet %s doesn't exist/we were unable to locate that subnet. Create a new VPC and proceed?", subnetId)
-		answer, err := cmdutil.BoolPrompt(prompt, inputStream, outputStream)
+		answer, err := config.Decide(config.DecisionInvalidNetwork, prompt, inputStream, outputStream)
 		if err != nil {
 			return "", "", err
 		}
@@ -0,0 +1,62 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// TestOutput is the output of a test file on a stream, as uploaded by the agent of an instance.
+type TestOutput struct {
+	InstanceId string
+	Key        string
+	Content    []byte
+}
+
+// GetTestOutputs returns the output of a test file on a stream for every instance of an instance type which uploaded
+// it in the bucket of a run, in the order of the instance IDs. If instanceId isn't empty, only its output is returned.
+func (itf Resources) GetTestOutputs(bucket string, instanceType string, testFile string, stream string, instanceId string) (outputs []TestOutput, err error) {
+	testFixture, err := itf.downloadTestFixture(bucket)
+	if err != nil {
+		return nil, err
+	}
+	instanceTypeDir := path.Join(testFixture.BucketRootDir, instanceType)
+	objects, err := itf.Store.List(bucket, instanceTypeDir+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	outputSuffix := "/" + path.Join(bucketTestsDir, TestOutputFilename(testFile, stream))
+	for _, object := range objects {
+		key := object.Key
+		if !strings.HasSuffix(key, outputSuffix) {
+			continue
+		}
+		keyInstanceId := path.Base(path.Dir(path.Dir(key)))
+		if instanceId != "" && keyInstanceId != instanceId {
+			continue
+		}
+		content, err := itf.Store.Get(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, TestOutput{InstanceId: keyInstanceId, Key: key, Content: content})
+	}
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("no %s of test %s on %s found in bucket %s", stream, testFile, instanceType, bucket)
+	}
+	return outputs, nil
+}
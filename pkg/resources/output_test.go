@@ -0,0 +1,65 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestGetTestOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	svc := resources.Resources{Store: resources.LocalStore{Dir: dir}}
+	h.Ok(t, svc.Store.CreateBucket("qualifier-bucket"))
+	testFixture, err := json.Marshal(config.TestFixture{BucketRootDir: "Instance-Qualifier-Run-123"})
+	h.Ok(t, err)
+	h.Ok(t, svc.Store.Put("qualifier-bucket", resources.TestFixtureKey, bytes.NewReader(testFixture)))
+
+	layout := resources.BucketLayout{RootDir: "Instance-Qualifier-Run-123"}
+	for key, content := range map[string]string{
+		layout.Instance("m4.large", "i-2").TestOutput("cpu-test.sh", config.StreamStdout):  "second",
+		layout.Instance("m4.large", "i-1").TestOutput("cpu-test.sh", config.StreamStdout):  "first",
+		layout.Instance("m4.large", "i-1").TestOutput("cpu-test.sh", config.StreamStderr):  "error",
+		layout.Instance("m4.large", "i-1").TestOutput("mem-test.sh", config.StreamStdout):  "other test",
+		layout.Instance("m4.xlarge", "i-3").TestOutput("cpu-test.sh", config.StreamStdout): "other instance type",
+	} {
+		h.Ok(t, svc.Store.Put("qualifier-bucket", key, bytes.NewReader([]byte(content))))
+	}
+
+	outputs, err := svc.GetTestOutputs("qualifier-bucket", "m4.large", "cpu-test.sh", config.StreamStdout, "")
+	h.Ok(t, err)
+	h.Equals(t, 2, len(outputs))
+	h.Equals(t, "i-1", outputs[0].InstanceId)
+	h.Equals(t, "first", string(outputs[0].Content))
+	h.Equals(t, "i-2", outputs[1].InstanceId)
+	h.Equals(t, "second", string(outputs[1].Content))
+
+	outputs, err = svc.GetTestOutputs("qualifier-bucket", "m4.large", "cpu-test.sh", config.StreamStderr, "i-1")
+	h.Ok(t, err)
+	h.Equals(t, []resources.TestOutput{{InstanceId: "i-1", Key: layout.Instance("m4.large", "i-1").TestOutput("cpu-test.sh", config.StreamStderr), Content: []byte("error")}}, outputs)
+
+	_, err = svc.GetTestOutputs("qualifier-bucket", "m4.large", "missing-test.sh", config.StreamStdout, "")
+	h.Assert(t, err != nil, "Failed to return error when the test has no output")
+}
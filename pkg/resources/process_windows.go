@@ -0,0 +1,30 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+)
+
+// isProcessRunning is never true on Windows, which the local backend doesn't support.
+func isProcessRunning(pid int) bool {
+	return false
+}
+
+// terminateProcess fails on Windows, which the local backend doesn't support.
+func terminateProcess(pid int) error {
+	return fmt.Errorf("the %s backend is not supported on windows", config.BackendLocal)
+}
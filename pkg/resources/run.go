@@ -0,0 +1,183 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+)
+
+// Run summarizes an instance-qualifier run found in the account, from its bucket and its CloudFormation stack.
+type Run struct {
+	RunId           string `json:"run-id"`
+	StartTime       string `json:"start-time"`
+	InstanceTypes   string `json:"instance-types"`
+	Bucket          string `json:"bucket"`
+	StackName       string `json:"stack-name"`
+	StackStatus     string `json:"stack-status"`
+	HasFinalResults bool   `json:"has-final-results"`
+	HasBucket       bool   `json:"has-bucket"`
+	HasStack        bool   `json:"has-stack"`
+}
+
+// ListRuns returns every instance-qualifier run in the region, from the most recent to the oldest. A run is found
+// either by its bucket, named after the run ID, or by its CloudFormation stack, tagged with the run ID. Details
+// which cannot be read from the bucket are left empty.
+func (itf Resources) ListRuns(region string) (runs []Run, err error) {
+	runsById := make(map[string]*Run)
+	var runIds []string
+
+	buckets, err := itf.listRunBuckets(region)
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range buckets {
+		bucketName := aws.StringValue(bucket.Name)
+		run := &Run{
+			RunId:     RemoveBucketNamePrefix(bucketName),
+			Bucket:    bucketName,
+			HasBucket: true,
+		}
+		if err := itf.describeRunFromBucket(run); err != nil {
+			log.Printf("Could not read the details of run %s from bucket %s: %v\n", run.RunId, bucketName, err)
+		}
+		runsById[run.RunId] = run
+		runIds = append(runIds, run.RunId)
+	}
+
+	err = itf.CloudFormation.DescribeStacksPages(&cloudformation.DescribeStacksInput{}, func(page *cloudformation.DescribeStacksOutput, lastPage bool) bool {
+		for _, stack := range page.Stacks {
+			runId := getRunIdTag(stack.Tags)
+			if runId == "" {
+				continue
+			}
+			run, ok := runsById[runId]
+			if !ok {
+				run = &Run{RunId: runId}
+				runsById[runId] = run
+				runIds = append(runIds, runId)
+			}
+			run.StackName = aws.StringValue(stack.StackName)
+			run.StackStatus = aws.StringValue(stack.StackStatus)
+			run.HasStack = true
+			if run.StartTime == "" && stack.CreationTime != nil {
+				run.StartTime = stack.CreationTime.Format(time.RFC3339)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, runId := range runIds {
+		runs = append(runs, *runsById[runId])
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return parseStartTime(runs[i].StartTime).After(parseStartTime(runs[j].StartTime))
+	})
+
+	return runs, nil
+}
+
+// listRunBuckets returns the instance-qualifier buckets located in the region.
+func (itf Resources) listRunBuckets(region string) (buckets []*s3.Bucket, err error) {
+	output, err := itf.S3.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range output.Buckets {
+		bucketName := aws.StringValue(bucket.Name)
+		if !strings.HasPrefix(bucketName, bucketNamePrefix) {
+			continue
+		}
+		locationOutput, err := itf.S3.GetBucketLocation(&s3.GetBucketLocationInput{
+			Bucket: aws.String(bucketName),
+		})
+		if err != nil {
+			log.Printf("Skipping bucket %s since its region cannot be determined: %v\n", bucketName, err)
+			continue
+		}
+		if s3.NormalizeBucketLocation(aws.StringValue(locationOutput.LocationConstraint)) != region {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+// describeRunFromBucket populates the start time, instance types and whether final results exist from the test
+// fixture and user config persisted in the bucket of the run.
+func (itf Resources) describeRunFromBucket(run *Run) error {
+	testFixture, err := itf.downloadTestFixture(run.Bucket)
+	if err != nil {
+		return err
+	}
+	run.StartTime = testFixture.StartTime
+	run.StackName = testFixture.CfnStackName
+
+	finalResultKey := BucketLayout{RootDir: testFixture.BucketRootDir}.FinalResult(testFixture.FinalResultFilename)
+	if run.HasFinalResults, err = itf.Store.Exists(run.Bucket, finalResultKey); err != nil {
+		return err
+	}
+
+	userConfigByte, err := itf.Store.Get(run.Bucket, testFixture.UserConfigFilename)
+	if err != nil {
+		return err
+	}
+	var userConfig config.UserConfig
+	if err := json.Unmarshal(userConfigByte, &userConfig); err != nil {
+		return err
+	}
+	run.InstanceTypes = userConfig.InstanceTypes
+
+	return nil
+}
+
+// downloadTestFixture returns the test fixture persisted in the bucket of a run.
+func (itf Resources) downloadTestFixture(bucket string) (testFixture config.TestFixture, err error) {
+	tfByte, err := itf.Store.Get(bucket, TestFixtureKey)
+	if err != nil {
+		return testFixture, err
+	}
+	err = json.Unmarshal(tfByte, &testFixture)
+	return testFixture, err
+}
+
+// getRunIdTag returns the run ID the stack is tagged with, or an empty string if it isn't an instance-qualifier stack.
+func getRunIdTag(tags []*cloudformation.Tag) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == tagKey {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// parseStartTime parses the start time of a run, which is the zero time if it is unknown.
+func parseStartTime(startTime string) time.Time {
+	t, err := time.Parse(time.RFC3339, startTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
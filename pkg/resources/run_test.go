@@ -0,0 +1,156 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Mocking helpers
+
+// mockedS3 serves buckets keyed by name with their location, and objects keyed by "<bucket>/<key>".
+type mockedS3 struct {
+	s3iface.S3API
+	Buckets map[string]string
+	Objects map[string]string
+}
+
+func (m mockedS3) ListBuckets(input *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	output := &s3.ListBucketsOutput{}
+	for name := range m.Buckets {
+		output.Buckets = append(output.Buckets, &s3.Bucket{Name: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (m mockedS3) GetBucketLocation(input *s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error) {
+	return &s3.GetBucketLocationOutput{LocationConstraint: aws.String(m.Buckets[*input.Bucket])}, nil
+}
+
+func (m mockedS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if _, ok := m.Objects[*input.Bucket+"/"+*input.Key]; !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+type mockedS3Downloader struct {
+	s3manageriface.DownloaderAPI
+	Objects map[string]string
+}
+
+func (m mockedS3Downloader) Download(w io.WriterAt, input *s3.GetObjectInput, options ...func(*s3manager.Downloader)) (int64, error) {
+	content, ok := m.Objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return 0, awserr.New("NoSuchKey", "The specified key does not exist.", nil)
+	}
+	n, err := w.WriteAt([]byte(content), 0)
+	return int64(n), err
+}
+
+type mockedCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	Stacks        []*cloudformation.Stack
+	DeletedStacks *[]string
+}
+
+func (m mockedCloudFormation) DescribeStacksPages(input *cloudformation.DescribeStacksInput, fn func(*cloudformation.DescribeStacksOutput, bool) bool) error {
+	fn(&cloudformation.DescribeStacksOutput{Stacks: m.Stacks}, true)
+	return nil
+}
+
+func (m mockedCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	*m.DeletedStacks = append(*m.DeletedStacks, *input.StackName)
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func runTag(runId string) []*cloudformation.Tag {
+	return []*cloudformation.Tag{{Key: aws.String("instance-qualifier:id"), Value: aws.String(runId)}}
+}
+
+// Tests
+
+func TestListRuns(t *testing.T) {
+	objects := map[string]string{
+		"qualifier-bucket-old/test-fixture.json":                                 `{"runId": "old", "start-time": "2020-09-01T10:00:00Z", "stack-name": "qualifier-stack-old", "final-results": "final-results-old.json", "bucket-root-dir": "Instance-Qualifier-Run-old", "user-config": "instance-qualifier-old.config"}`,
+		"qualifier-bucket-old/instance-qualifier-old.config":                     `{"instance-types": "m4.large,m4.xlarge"}`,
+		"qualifier-bucket-old/Instance-Qualifier-Run-old/final-results-old.json": `[]`,
+		"qualifier-bucket-new/test-fixture.json":                                 `{"runId": "new", "start-time": "2020-09-02T10:00:00Z", "stack-name": "qualifier-stack-new", "final-results": "final-results-new.json", "bucket-root-dir": "Instance-Qualifier-Run-new", "user-config": "instance-qualifier-new.config"}`,
+		"qualifier-bucket-new/instance-qualifier-new.config":                     `{"instance-types": "c5.large"}`,
+	}
+	itf := resources.Resources{
+		S3: mockedS3{
+			Buckets: map[string]string{
+				"qualifier-bucket-old":   "us-east-2",
+				"qualifier-bucket-new":   "us-east-2",
+				"qualifier-bucket-other": "us-west-2",
+				"unrelated-bucket":       "us-east-2",
+			},
+			Objects: objects,
+		},
+		Store: resources.S3Store{S3: mockedS3{Objects: objects}, Downloader: mockedS3Downloader{Objects: objects}},
+		CloudFormation: mockedCloudFormation{
+			Stacks: []*cloudformation.Stack{
+				{StackName: aws.String("qualifier-stack-new"), StackStatus: aws.String("CREATE_COMPLETE"), Tags: runTag("new")},
+				{StackName: aws.String("qualifier-stack-orphan"), StackStatus: aws.String("DELETE_FAILED"), Tags: runTag("orphan"), CreationTime: aws.Time(time.Date(2020, 8, 1, 10, 0, 0, 0, time.UTC))},
+				{StackName: aws.String("unrelated-stack"), StackStatus: aws.String("CREATE_COMPLETE")},
+			},
+		},
+	}
+
+	runs, err := itf.ListRuns("us-east-2")
+	h.Ok(t, err)
+	expected := []resources.Run{
+		{
+			RunId:         "new",
+			StartTime:     "2020-09-02T10:00:00Z",
+			InstanceTypes: "c5.large",
+			Bucket:        "qualifier-bucket-new",
+			StackName:     "qualifier-stack-new",
+			StackStatus:   "CREATE_COMPLETE",
+			HasBucket:     true,
+			HasStack:      true,
+		},
+		{
+			RunId:           "old",
+			StartTime:       "2020-09-01T10:00:00Z",
+			InstanceTypes:   "m4.large,m4.xlarge",
+			Bucket:          "qualifier-bucket-old",
+			StackName:       "qualifier-stack-old",
+			HasFinalResults: true,
+			HasBucket:       true,
+		},
+		{
+			RunId:       "orphan",
+			StartTime:   "2020-08-01T10:00:00Z",
+			StackName:   "qualifier-stack-orphan",
+			StackStatus: "DELETE_FAILED",
+			HasStack:    true,
+		},
+	}
+	h.Equals(t, expected, runs)
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+)
+
+// RunState is the phase a run has reached. A run goes through the states in the order they are declared.
+type RunState string
+
+// States of a run. The state of a run started before states were persisted is empty, and handled as
+// RunStateTestsRunning when the run is resumed.
+const (
+	RunStateCreatedBucket     RunState = "created-bucket"
+	RunStateUploadedSuite     RunState = "uploaded-suite"
+	RunStateStackCreating     RunState = "stack-creating"
+	RunStateTestsRunning      RunState = "tests-running"
+	RunStateCollectingMetrics RunState = "collecting-metrics"
+	RunStateReported          RunState = "reported"
+	// RunStateCleanedUp means the deletion of the stack has started; only the bucket is left.
+	RunStateCleanedUp RunState = "cleaned-up"
+)
+
+// RunStatus is the state of a run persisted next to its test fixture, from which the CLI decides what to resume and
+// what to delete.
+type RunStatus struct {
+	State     RunState `json:"state"`
+	UpdatedAt string   `json:"updated-at,omitempty"`
+	// MissingInstanceTypes are the instance types of the stack without results, recorded once the run is reported
+	// since the stack may be deleted by the time the run is resumed.
+	MissingInstanceTypes []string `json:"missing-instance-types,omitempty"`
+}
+
+// Cleanup returns which resources of a run in the state are deleted when the CLI terminates. Until the tests begin,
+// the run is deleted as a whole; once they begin, the run is kept so that it can be resumed; once it is reported, only
+// the stack is deleted and the bucket is kept for any deep dive. Resources which don't exist yet or are already
+// deleted are never deleted.
+func (s RunState) Cleanup() (deleteBucket bool, deleteStack bool) {
+	switch s {
+	case RunStateCreatedBucket, RunStateUploadedSuite:
+		return true, false
+	case RunStateStackCreating:
+		return true, true
+	case RunStateReported:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// IsResumable checks whether a run in the state can be resumed, which is the case once its tests begin.
+func (s RunState) IsResumable() bool {
+	switch s {
+	case RunStateCreatedBucket, RunStateUploadedSuite, RunStateStackCreating:
+		return false
+	default:
+		return true
+	}
+}
+
+// IsPolled checks whether the results of all instances of a run in the state were polled, in which case the final
+// result in the bucket is complete.
+func (s RunState) IsPolled() bool {
+	return s == RunStateCollectingMetrics || s == RunStateReported || s == RunStateCleanedUp
+}
+
+// IsReported checks whether the final result of a run in the state already contains the CloudWatch data.
+func (s RunState) IsReported() bool {
+	return s == RunStateReported || s == RunStateCleanedUp
+}
+
+// PutRunStatus persists the status of the run in its bucket.
+func (itf Resources) PutRunStatus(status RunStatus) error {
+	status.UpdatedAt = time.Now().Format(time.RFC3339)
+	statusByte, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	bucket := config.GetTestFixture().BucketName
+	if err := itf.Store.Put(bucket, RunStatusKey, bytes.NewReader(statusByte)); err != nil {
+		return err
+	}
+	log.Printf("Run state of bucket %s: %s\n", bucket, status.State)
+	return nil
+}
+
+// GetRunStatus returns the status of the run of the bucket. The status of a run started before states were persisted
+// is empty.
+func (itf Resources) GetRunStatus(bucket string) (status RunStatus, err error) {
+	exists, err := itf.Store.Exists(bucket, RunStatusKey)
+	if err != nil || !exists {
+		return status, err
+	}
+	statusByte, err := itf.Store.Get(bucket, RunStatusKey)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(statusByte, &status)
+	return status, err
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// Tests
+
+func TestRunStateCleanup(t *testing.T) {
+	for _, testCase := range []struct {
+		state        resources.RunState
+		deleteBucket bool
+		deleteStack  bool
+	}{
+		{"", false, false},
+		{resources.RunStateCreatedBucket, true, false},
+		{resources.RunStateUploadedSuite, true, false},
+		{resources.RunStateStackCreating, true, true},
+		{resources.RunStateTestsRunning, false, false},
+		{resources.RunStateCollectingMetrics, false, false},
+		{resources.RunStateReported, false, true},
+		{resources.RunStateCleanedUp, false, false},
+	} {
+		deleteBucket, deleteStack := testCase.state.Cleanup()
+		h.Assert(t, deleteBucket == testCase.deleteBucket, "Wrong bucket deletion in state %q", testCase.state)
+		h.Assert(t, deleteStack == testCase.deleteStack, "Wrong stack deletion in state %q", testCase.state)
+	}
+}
+
+func TestRunStateIsResumable(t *testing.T) {
+	h.Assert(t, !resources.RunStateUploadedSuite.IsResumable(), "A run whose tests didn't begin is resumable")
+	h.Assert(t, !resources.RunStateStackCreating.IsResumable(), "A run whose tests didn't begin is resumable")
+	h.Assert(t, resources.RunState("").IsResumable(), "A run started before states were persisted isn't resumable")
+	h.Assert(t, resources.RunStateTestsRunning.IsResumable(), "A run whose tests began isn't resumable")
+	h.Assert(t, !resources.RunStateTestsRunning.IsPolled(), "A running run is polled")
+	h.Assert(t, resources.RunStateCollectingMetrics.IsPolled() && !resources.RunStateCollectingMetrics.IsReported(), "A run collecting metrics isn't polled only")
+	h.Assert(t, resources.RunStateCleanedUp.IsReported(), "A cleaned up run isn't reported")
+}
+
+func TestPutAndGetRunStatusSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	svc := resources.Resources{Store: resources.LocalStore{Dir: dir}}
+	h.Ok(t, svc.Store.CreateBucket("qualifier-bucket"))
+	config.SetTestFixtureBucketName("qualifier-bucket")
+
+	// A run started before states were persisted has no state
+	status, err := svc.GetRunStatus("qualifier-bucket")
+	h.Ok(t, err)
+	h.Equals(t, resources.RunState(""), status.State)
+
+	h.Ok(t, svc.PutRunStatus(resources.RunStatus{State: resources.RunStateReported, MissingInstanceTypes: []string{"a1.large"}}))
+	status, err = svc.GetRunStatus("qualifier-bucket")
+	h.Ok(t, err)
+	h.Equals(t, resources.RunStateReported, status.State)
+	h.Equals(t, []string{"a1.large"}, status.MissingInstanceTypes)
+	h.Assert(t, status.UpdatedAt != "", "Failed to record when the state was updated")
+}
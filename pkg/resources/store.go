@@ -0,0 +1,363 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+)
+
+// defaultRegion is where S3 creates the buckets without a location constraint, which it rejects for it, and the region
+// signing the requests to an endpoint when none is provided.
+const defaultRegion = "us-east-1"
+
+// ResultStore stores the objects of runs in buckets: configurations, test suites, logs and results. The keys of the
+// objects of a run are laid out by BucketLayout.
+type ResultStore interface {
+	// CreateBucket creates a bucket, which is not an error if it already exists.
+	CreateBucket(bucket string) error
+	// Put stores an object, replacing the object with the same key if any.
+	Put(bucket string, key string, body io.Reader) error
+	// Get returns the content of an object.
+	Get(bucket string, key string) ([]byte, error)
+	// Exists checks whether an object exists.
+	Exists(bucket string, key string) (bool, error)
+	// List returns all objects whose key begins with the prefix, in the order of their keys.
+	List(bucket string, prefix string) ([]Object, error)
+	// DeleteBucket deletes a bucket and all its objects.
+	DeleteBucket(bucket string) error
+	// Location returns where the user can find an object, or a directory of objects.
+	Location(bucket string, key string) string
+}
+
+// Object is an object listed in a bucket.
+type Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// PutFile stores a local file as an object.
+func PutFile(store ResultStore, bucket string, localPath string, key string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := store.Put(bucket, key, file); err != nil {
+		return err
+	}
+	log.Printf("%s successfully uploaded to %s\n", filepath.Base(localPath), store.Location(bucket, key))
+
+	return nil
+}
+
+// GetFile writes an object to a local file. The local file is left untouched if the object can't be read.
+func GetFile(store ResultStore, bucket string, localPath string, key string) error {
+	data, err := store.Get(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(localPath, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("%s successfully downloaded from %s\n", filepath.Base(localPath), store.Location(bucket, key))
+
+	return nil
+}
+
+// S3Store stores the buckets in S3, or in an S3-compatible service.
+type S3Store struct {
+	S3         s3iface.S3API
+	Uploader   s3manageriface.UploaderAPI
+	Downloader s3manageriface.DownloaderAPI
+	// BlockPublicAccess blocks all public access to the buckets created, which S3-compatible services may not support.
+	BlockPublicAccess bool
+	// Region is where the buckets are created, which S3 requires unless it is us-east-1. The buckets are created in
+	// the default location of the service if it is empty.
+	Region string
+}
+
+// NewS3Store creates a store of buckets in S3 provided an AWS session.
+func NewS3Store(sess *session.Session) S3Store {
+	return S3Store{
+		S3:                s3.New(sess),
+		Uploader:          s3manager.NewUploader(sess),
+		Downloader:        s3manager.NewDownloader(sess),
+		BlockPublicAccess: true,
+		Region:            aws.StringValue(sess.Config.Region),
+	}
+}
+
+// NewEndpointStore creates a store of buckets in the S3-compatible service at the endpoint, such as a local stand-in
+// of S3 for testing. The credentials are those of the default chain; the region only matters to sign the requests.
+func NewEndpointStore(endpoint string, region string) S3Store {
+	if region == "" {
+		region = defaultRegion
+	}
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	return S3Store{
+		S3:         s3.New(sess),
+		Uploader:   s3manager.NewUploader(sess),
+		Downloader: s3manager.NewDownloader(sess),
+	}
+}
+
+// CreateBucket creates a bucket and blocks all public access.
+func (store S3Store) CreateBucket(bucket string) error {
+	// Create
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	if store.Region != "" && store.Region != defaultRegion {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(store.Region)}
+	}
+	_, err := store.S3.CreateBucket(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			// proceed if bucket already exists or owned
+			case s3.ErrCodeBucketAlreadyExists:
+				fmt.Println(s3.ErrCodeBucketAlreadyExists, aerr.Error())
+			case s3.ErrCodeBucketAlreadyOwnedByYou:
+				fmt.Println(s3.ErrCodeBucketAlreadyOwnedByYou, aerr.Error())
+			default:
+				fmt.Println(aerr.Error())
+				return err
+			}
+		}
+	}
+
+	// Wait until exists
+	log.Printf("Waiting for bucket %s to be created...\n", bucket)
+	if err := store.S3.WaitUntilBucketExists(&s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	}); err != nil {
+		return err
+	}
+	log.Printf("Bucket %s successfully created\n", bucket)
+
+	if !store.BlockPublicAccess {
+		return nil
+	}
+	// Block all public access
+	_, err = store.S3.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Bucket %s has blocked all public access\n", bucket)
+
+	return nil
+}
+
+// Put uploads an object.
+func (store S3Store) Put(bucket string, key string, body io.Reader) error {
+	_, err := store.Uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return err
+}
+
+// Get downloads an object.
+func (store S3Store) Get(bucket string, key string) ([]byte, error) {
+	// Should first check whether the object exists, since the download doesn't fail fast otherwise
+	_, err := store.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := aws.NewWriteAtBuffer([]byte{})
+	_, err = store.Downloader.Download(buf,
+		&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Exists checks whether an object exists.
+func (store S3Store) Exists(bucket string, key string) (bool, error) {
+	_, err := store.S3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == errCodeNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// List lists the objects with the prefix, one page of up to 1000 keys per request.
+func (store S3Store) List(bucket string, prefix string) (objects []Object, err error) {
+	err = store.S3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, Object{Key: aws.StringValue(object.Key), LastModified: aws.TimeValue(object.LastModified)})
+		}
+		return true
+	})
+	return objects, err
+}
+
+// DeleteBucket empties and deletes a bucket.
+func (store S3Store) DeleteBucket(bucket string) error {
+	// First delete all objects
+	iter := s3manager.NewDeleteListIterator(store.S3, &s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+	})
+	if err := s3manager.NewBatchDeleteWithClient(store.S3).Delete(aws.BackgroundContext(), iter); err != nil {
+		return err
+	}
+
+	// Delete the bucket
+	_, err := store.S3.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Bucket %s successfully deleted\n", bucket)
+
+	return nil
+}
+
+// Location returns the S3 URI of an object.
+func (store S3Store) Location(bucket string, key string) string {
+	return fmt.Sprintf("s3://%s/%s", bucket, key)
+}
+
+// LocalStore stores each bucket as a directory of Dir, and each object as a file of its bucket directory.
+type LocalStore struct {
+	Dir string
+}
+
+// CreateBucket creates the directory of a bucket.
+func (store LocalStore) CreateBucket(bucket string) error {
+	if err := os.MkdirAll(store.Location(bucket, ""), 0755); err != nil {
+		return err
+	}
+	log.Printf("Bucket %s successfully created\n", bucket)
+	return nil
+}
+
+// Put writes an object to a temporary file first, so that the object is never read partially written.
+func (store LocalStore) Put(bucket string, key string, body io.Reader) error {
+	path := store.Location(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := io.Copy(tempFile, body); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+// Get reads an object.
+func (store LocalStore) Get(bucket string, key string) ([]byte, error) {
+	return ioutil.ReadFile(store.Location(bucket, key))
+}
+
+// Exists checks whether the file of an object exists.
+func (store LocalStore) Exists(bucket string, key string) (bool, error) {
+	_, err := os.Stat(store.Location(bucket, key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List walks the directory of a bucket for the files of the objects with the prefix. Temporary files of objects being
+// written are skipped.
+func (store LocalStore) List(bucket string, prefix string) (objects []Object, err error) {
+	root := store.Location(bucket, "")
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(relPath); strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// DeleteBucket removes the directory of a bucket.
+func (store LocalStore) DeleteBucket(bucket string) error {
+	if err := os.RemoveAll(store.Location(bucket, "")); err != nil {
+		return err
+	}
+	log.Printf("Bucket %s successfully deleted\n", bucket)
+	return nil
+}
+
+// Location returns the path of an object.
+func (store LocalStore) Location(bucket string, key string) string {
+	return filepath.Join(store.Dir, bucket, filepath.FromSlash(key))
+}
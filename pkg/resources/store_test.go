@@ -0,0 +1,124 @@
+// Copyright 2020 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//     http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package resources_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
+	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
+)
+
+// mockedCreateBucketS3 records the buckets created.
+type mockedCreateBucketS3 struct {
+	s3iface.S3API
+	Inputs *[]*s3.CreateBucketInput
+}
+
+func (m mockedCreateBucketS3) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	*m.Inputs = append(*m.Inputs, input)
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (m mockedCreateBucketS3) WaitUntilBucketExists(input *s3.HeadBucketInput) error {
+	return nil
+}
+
+// Tests
+
+func TestLocalStoreSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	store := resources.LocalStore{Dir: dir}
+
+	h.Ok(t, store.CreateBucket("qualifier-bucket"))
+	exists, err := store.Exists("qualifier-bucket", "dir/object.json")
+	h.Ok(t, err)
+	h.Assert(t, !exists, "The object exists before being put")
+
+	h.Ok(t, store.Put("qualifier-bucket", "dir/object.json", bytes.NewReader([]byte("first"))))
+	h.Ok(t, store.Put("qualifier-bucket", "dir/object.json", bytes.NewReader([]byte("second"))))
+	exists, err = store.Exists("qualifier-bucket", "dir/object.json")
+	h.Ok(t, err)
+	h.Assert(t, exists, "The object doesn't exist after being put")
+	data, err := store.Get("qualifier-bucket", "dir/object.json")
+	h.Ok(t, err)
+	h.Equals(t, "second", string(data))
+	h.Equals(t, filepath.Join(dir, "qualifier-bucket", "dir", "object.json"), store.Location("qualifier-bucket", "dir/object.json"))
+
+	// No temporary file is left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "qualifier-bucket", "dir"))
+	h.Ok(t, err)
+	h.Equals(t, 1, len(files))
+
+	h.Ok(t, store.Put("qualifier-bucket", "other/object.json", bytes.NewReader([]byte("other"))))
+	objects, err := store.List("qualifier-bucket", "dir/")
+	h.Ok(t, err)
+	h.Equals(t, 1, len(objects))
+	h.Equals(t, "dir/object.json", objects[0].Key)
+	h.Assert(t, !objects[0].LastModified.IsZero(), "Failed to return when the object was last modified")
+
+	h.Ok(t, store.DeleteBucket("qualifier-bucket"))
+	_, err = store.Get("qualifier-bucket", "dir/object.json")
+	h.Assert(t, err != nil, "Failed to delete the bucket")
+}
+
+func TestS3StoreCreateBucketRegion(t *testing.T) {
+	var inputs []*s3.CreateBucketInput
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		store := resources.S3Store{S3: mockedCreateBucketS3{Inputs: &inputs}, Region: region}
+		h.Ok(t, store.CreateBucket("qualifier-bucket"))
+	}
+	h.Assert(t, inputs[0].CreateBucketConfiguration == nil, "Constrained the location of a bucket in us-east-1")
+	h.Equals(t, "eu-west-1", aws.StringValue(inputs[1].CreateBucketConfiguration.LocationConstraint))
+}
+
+func TestPutAndGetFileSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-store")
+	h.Ok(t, err)
+	defer os.RemoveAll(dir)
+	store := resources.LocalStore{Dir: filepath.Join(dir, "buckets")}
+	localPath := filepath.Join(dir, "result.json")
+	h.Ok(t, ioutil.WriteFile(localPath, []byte("[]"), 0644))
+
+	h.Ok(t, resources.PutFile(store, "qualifier-bucket", localPath, "Instance-Qualifier-Run-12345/result.json"))
+	downloadedPath := filepath.Join(dir, "downloaded.json")
+	h.Ok(t, resources.GetFile(store, "qualifier-bucket", downloadedPath, "Instance-Qualifier-Run-12345/result.json"))
+	data, err := ioutil.ReadFile(downloadedPath)
+	h.Ok(t, err)
+	h.Equals(t, "[]", string(data))
+
+	err = resources.GetFile(store, "qualifier-bucket", downloadedPath, "non-existent-object.json")
+	h.Assert(t, err != nil, "Failed to return error when the object doesn't exist")
+}
+
+func TestBucketLayout(t *testing.T) {
+	layout := resources.BucketLayout{RootDir: "Instance-Qualifier-Run-12345"}
+	h.Equals(t, "Instance-Qualifier-Run-12345/final-results-12345.json", layout.FinalResult("final-results-12345.json"))
+
+	instance := layout.Instance("m4.large", "i-0df3ef636ba12ee2a")
+	h.Equals(t, "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a/m4.large.log", instance.Object("m4.large.log"))
+	h.Equals(t, "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a/i-0df3ef636ba12ee2a-test-results.json", instance.Result())
+	h.Equals(t, "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a/Tests/i-0df3ef636ba12ee2a-test-results.json", instance.PartialResult())
+	h.Equals(t, "Instance-Qualifier-Run-12345/m4.large/i-0df3ef636ba12ee2a/Tests/cpu-test.sh-result.json", instance.TestsObject("cpu-test.sh-result.json"))
+	h.Equals(t, "test-suite.tar.gz", resources.TestSuiteKey("/tmp/test-suite.tar.gz"))
+}
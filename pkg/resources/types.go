@@ -14,6 +14,8 @@
 package resources
 
 import (
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -26,65 +28,152 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
 )
 
 // EC2MetadataAPI provides an interface to enable mocking the ec2metadata.EC2Metadata service client's APIs.
 type EC2MetadataAPI interface {
 	GetInstanceIdentityDocument() (ec2metadata.EC2InstanceIdentityDocument, error)
+	GetMetadata(p string) (string, error)
 }
 
 // Resources is used to store clients for AWS services.
 type Resources struct {
-	EC2                 ec2iface.EC2API
-	AutoScaling         autoscalingiface.AutoScalingAPI
-	S3                  s3iface.S3API
-	S3ManagerUploader   s3manageriface.UploaderAPI
-	S3ManagerDownloader s3manageriface.DownloaderAPI
-	CloudFormation      cloudformationiface.CloudFormationAPI
-	CloudWatch          cloudwatchiface.CloudWatchAPI
-	EC2Metadata         EC2MetadataAPI
+	EC2            ec2iface.EC2API
+	AutoScaling    autoscalingiface.AutoScalingAPI
+	S3             s3iface.S3API
+	CloudFormation cloudformationiface.CloudFormationAPI
+	CloudWatch     cloudwatchiface.CloudWatchAPI
+	EC2Metadata    EC2MetadataAPI
+	// Store stores the buckets of the runs, which are in S3 unless replaced.
+	Store ResultStore
+	// LocalDir is the directory backing the clients created by NewLocal; it is empty for AWS.
+	LocalDir string
 }
 
 // Metric represents the metric data.
 type Metric struct {
 	MetricUsed string  `json:"metric"`
 	Value      float64 `json:"value"`
+	Statistic  string  `json:"statistic,omitempty"`
 	Threshold  float64 `json:"threshold"`
+	Rule       string  `json:"rule,omitempty"`
+	Direction  string  `json:"direction,omitempty"`
+	Breached   bool    `json:"breached"`
 	Unit       string  `json:"unit"`
 }
 
+// DataPoint represents one value of a metric time series.
+type DataPoint struct {
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// MetricSeries represents the time series of a metric on an instance over the execution of all its tests.
+type MetricSeries struct {
+	MetricUsed string      `json:"metric"`
+	Statistic  string      `json:"statistic"`
+	Unit       string      `json:"unit"`
+	DataPoints []DataPoint `json:"datapoints"`
+}
+
 // Result represents the result of one test file.
 type Result struct {
 	Label         string   `json:"label"`
 	Status        string   `json:"status"`
 	ExecutionTime string   `json:"execution-time"`
+	StartTime     string   `json:"start-time,omitempty"`
+	EndTime       string   `json:"end-time,omitempty"`
 	Metrics       []Metric `json:"Metrics"`
+	Tags          []string `json:"tags,omitempty"`
+	// Attempts are the executions of the test file, more than one if it was retried after failing.
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// StdoutKey and StderrKey are the keys of the output of the test file in the bucket.
+	StdoutKey string `json:"stdout-key,omitempty"`
+	StderrKey string `json:"stderr-key,omitempty"`
+	// Before and After are the results of the last execution of the hooks of the test file, if any.
+	Before *HookResult `json:"before,omitempty"`
+	After  *HookResult `json:"after,omitempty"`
+}
+
+// HeartbeatPeriod is the period at which the agent uploads the new chunk of its log, if any, and its heartbeat.
+const HeartbeatPeriod = 15 * time.Second
+
+// Heartbeat is periodically uploaded by the agent while it runs, so that a hung agent can be told apart from a long test.
+type Heartbeat struct {
+	UpdatedAt string `json:"updated-at"`
+	// ElapsedTime is the seconds since the agent started.
+	ElapsedTime  string        `json:"elapsed-time"`
+	RunningTests []RunningTest `json:"running-tests,omitempty"`
+	// LogChunks is the number of chunks of the log uploaded so far.
+	LogChunks int `json:"log-chunks"`
+}
+
+// RunningTest is a test file which the agent is executing.
+type RunningTest struct {
+	Label string `json:"label"`
+	// ElapsedTime is the seconds since the test file started, across all its attempts.
+	ElapsedTime string `json:"elapsed-time"`
+}
+
+// HookResult represents the execution of a script of the test suite executed around the tests, i.e. a setup,
+// teardown, before or after script.
+type HookResult struct {
+	Label  string `json:"label"`
+	Status string `json:"status"`
+	Attempt
+}
+
+// Attempt represents one execution of a test file.
+type Attempt struct {
+	StartTime     string `json:"start-time"`
+	ExecutionTime string `json:"execution-time"`
+	// ExitCode is -1 if the test file was killed or couldn't be executed.
+	ExitCode  int  `json:"exit-code"`
+	IsTimeout bool `json:"isTimeout,omitempty"`
 }
 
 // Instance contains the data of an instance.
 type Instance struct {
-	InstanceId   string   `json:"instance-id"`
-	InstanceType string   `json:"instance-type"`
-	VCpus        string   `json:"vCPUs"`
-	Memory       string   `json:"memory"`
-	Os           string   `json:"OS"`
-	Architecture string   `json:"Architecture"`
-	IsTimeout    bool     `json:"isTimeout"`
-	Results      []Result `json:"results"`
+	InstanceId   string `json:"instance-id"`
+	InstanceType string `json:"instance-type"`
+	VCpus        string `json:"vCPUs"`
+	Memory       string `json:"memory"`
+	Os           string `json:"OS"`
+	Architecture string `json:"Architecture"`
+	// AvailabilityZone is the Availability Zone of the subnet created for the instance; it is empty if the user
+	// provides a subnet.
+	AvailabilityZone string `json:"availability-zone,omitempty"`
+	// Region is the region of the instance in the report of a multi-region run.
+	Region    string `json:"region,omitempty"`
+	IsTimeout bool   `json:"isTimeout"`
+	// IsInterrupted is true if the spot instance was interrupted before finishing the tests.
+	IsInterrupted bool `json:"isInterrupted,omitempty"`
+	// Concurrency is the max number of test files executed at once, if more than one, in which case the metrics of
+	// each test file reflect the load of the test files executed along with it.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Setup and Teardown are the results of the setup and teardown scripts of the test suite, if any. No test is
+	// executed if the setup failed.
+	Setup    *HookResult    `json:"setup,omitempty"`
+	Teardown *HookResult    `json:"teardown,omitempty"`
+	Results  []Result       `json:"results"`
+	Series   []MetricSeries `json:"series,omitempty"`
+}
+
+// InstanceState represents the state of an instance, as seen while polling for its result.
+type InstanceState struct {
+	IsRunning         bool
+	IsSpotInterrupted bool
 }
 
 // New creates an instance of Resources provided an AWS session.
 func New(sess *session.Session) *Resources {
 	return &Resources{
-		EC2:                 ec2.New(sess),
-		AutoScaling:         autoscaling.New(sess),
-		S3:                  s3.New(sess),
-		S3ManagerUploader:   s3manager.NewUploader(sess),
-		S3ManagerDownloader: s3manager.NewDownloader(sess),
-		CloudFormation:      cloudformation.New(sess),
-		CloudWatch:          cloudwatch.New(sess),
-		EC2Metadata:         ec2metadata.New(sess),
+		EC2:            ec2.New(sess),
+		AutoScaling:    autoscaling.New(sess),
+		S3:             s3.New(sess),
+		CloudFormation: cloudformation.New(sess),
+		CloudWatch:     cloudwatch.New(sess),
+		EC2Metadata:    ec2metadata.New(sess),
+		Store:          NewS3Store(sess),
 	}
 }
@@ -21,6 +21,7 @@ import (
 
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/cmdutil"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 )
 
 const (
@@ -28,16 +29,11 @@ const (
 	cloudWatchAgentConfigName = "cwagent-config.json"
 )
 
-// DO NOT EDIT: these values are populated by the Makefile
-var (
-	encodedCloudWatchAgentConfig string
-)
-
 // SetTestSuite copies agent scripts to test suite, compresses test suite into a tarball, then removes agent
 // scripts from test suite.
 func SetTestSuite() error {
 	testFixture := config.GetTestFixture()
-	if err := copyAgentScriptsToTestSuite(testFixture.TestSuiteName); err != nil {
+	if err := copyAgentScriptsToTestSuite(testFixture.TestSuiteName, testFixture.Metrics); err != nil {
 		return err
 	}
 	if err := cmdutil.Compress(testFixture.TestSuiteName, testFixture.CompressedTestSuiteName); err != nil {
@@ -59,12 +55,18 @@ func IsInstanceQualifierScript(filename string) bool {
 	return false
 }
 
-func copyAgentScriptsToTestSuite(testSuiteName string) error {
-	cloudWatchAgentConfig, err := cmdutil.DecodeBase64(encodedCloudWatchAgentConfig)
+// copyAgentScriptsToTestSuite copies the agent bin and the CloudWatch agent config collecting the selected
+// metrics to the test suite.
+func copyAgentScriptsToTestSuite(testSuiteName string, metricNames []string) error {
+	definitions, err := metrics.Select(metricNames)
+	if err != nil {
+		return err
+	}
+	cloudWatchAgentConfig, err := metrics.AgentConfig(definitions)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(testSuiteName+"/"+cloudWatchAgentConfigName, []byte(cloudWatchAgentConfig), 0644); err != nil {
+	if err := ioutil.WriteFile(testSuiteName+"/"+cloudWatchAgentConfigName, cloudWatchAgentConfig, 0644); err != nil {
 		return err
 	}
 
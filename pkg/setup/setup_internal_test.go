@@ -18,6 +18,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/metrics"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
 
@@ -40,7 +41,7 @@ func TestCopyAgentScriptsToTestSuiteSuccess(t *testing.T) {
 	defer os.Remove("agent")
 	h.Assert(t, err == nil, "Error writing agent file")
 
-	err = copyAgentScriptsToTestSuite(testFolder)
+	err = copyAgentScriptsToTestSuite(testFolder, nil)
 	defer cleanup()
 	h.Ok(t, err)
 
@@ -51,7 +52,11 @@ func TestCopyAgentScriptsToTestSuiteSuccess(t *testing.T) {
 		h.Equals(t, expectedContent, string(data))
 	}
 	assertScriptFileInTestSuite("agent", "AGENT")
-	assertScriptFileInTestSuite("cwagent-config.json", "")
+	definitions, err := metrics.Select(nil)
+	h.Assert(t, err == nil, "Error selecting default metrics")
+	cloudWatchAgentConfig, err := metrics.AgentConfig(definitions)
+	h.Assert(t, err == nil, "Error generating CloudWatch agent config")
+	assertScriptFileInTestSuite("cwagent-config.json", string(cloudWatchAgentConfig))
 }
 
 func TestCopyAgentScriptsToTestSuiteNonExistentTestSuiteFailure(t *testing.T) {
@@ -60,17 +65,28 @@ func TestCopyAgentScriptsToTestSuiteNonExistentTestSuiteFailure(t *testing.T) {
 	defer os.Remove("agent")
 	h.Assert(t, err == nil, "Error writing agent file")
 
-	err = copyAgentScriptsToTestSuite("non-existent-folder")
+	err = copyAgentScriptsToTestSuite("non-existent-folder", nil)
 	defer cleanup()
 	h.Assert(t, err != nil, "Failed to return error when test suite doesn't exist")
 }
 
 func TestCopyAgentScriptsToTestSuiteNonExistentAgentBinFailure(t *testing.T) {
-	err := copyAgentScriptsToTestSuite(testFolder)
+	err := copyAgentScriptsToTestSuite(testFolder, nil)
 	defer cleanup()
 	h.Assert(t, err != nil, "Failed to return error when agent bin doesn't exist")
 }
 
+func TestCopyAgentScriptsToTestSuiteUnsupportedMetricFailure(t *testing.T) {
+	// Mock agent bin
+	err := ioutil.WriteFile("agent", []byte("AGENT"), 0644)
+	defer os.Remove("agent")
+	h.Assert(t, err == nil, "Error writing agent file")
+
+	err = copyAgentScriptsToTestSuite(testFolder, []string{"unsupported_metric"})
+	defer cleanup()
+	h.Assert(t, err != nil, "Failed to return error when a metric is not supported")
+}
+
 func TestRemoveAgentScriptsFromTestSuiteSuccess(t *testing.T) {
 	oldFiles, err := ioutil.ReadDir(testFolder)
 	h.Assert(t, err == nil, "Error reading the directory "+testFolder)
@@ -33,11 +33,36 @@ import (
 const (
 	timeBuffer   = 600 // 10 min
 	resourcesKey = "Resources"
+	// Removes InstanceMarketOptions from the launch template, so that on-demand instances are launched
+	onDemandMarketOptions = `{
+            "Ref": "AWS::NoValue"
+          }`
+	// Spot instances are interrupted by termination, like the instances of a run are after it
+	spotMarketOptions = `{
+            "MarketType": "spot",
+            "SpotOptions": {
+              "SpotInstanceType": "one-time",
+              "InstanceInterruptionBehavior": "terminate"
+            }
+          }`
+	// Each subnet of the auto scaling group is the created one or the one provided by the user
+	subnetIdentifier = `{
+            "Fn::If": [
+              "createNewVpcInfrastructure",
+              {
+                "Ref": "subnet$idx"
+              },
+              {
+                "Ref": "providedSubnet"
+              }
+            ]
+          }`
 )
 
 // DO NOT EDIT: these values are populated by the Makefile
 var (
 	encodedMasterTemplate           string
+	encodedSubnetTemplate           string
 	encodedLaunchTemplateTemplate   string
 	encodedAutoScalingGroupTemplate string
 	encodedInstanceTemplate         string
@@ -46,30 +71,39 @@ var (
 
 // UserScript encapsulates the data required for creating user script that will be deployed to the instance(s)
 type UserScript struct {
-	InstanceType, VCpus, Memory, Os, Architecture, BucketName, Timeout, BucketRootDir, CompressedTestSuiteName, TestSuiteName, CustomScript, Region string
+	InstanceType, VCpus, Memory, Os, Architecture, BucketName, Timeout, BucketRootDir, CompressedTestSuiteName, TestSuiteName, CustomScript, Region, PurchaseOption, StoreEndpoint, Concurrency string
 }
 
-// GenerateCfnTemplate returns the CloudFormation template used to create resources for instance-qualifier.
-func GenerateCfnTemplate(instances []resources.Instance, allInstanceTypes string, availabilityZone string, inputStream *os.File, outputStream *os.File) (template string, err error) {
+// GenerateCfnTemplate returns the CloudFormation template used to create resources for instance-qualifier. A subnet
+// is created in the Availability Zone of each instance, unless the user provides one.
+func GenerateCfnTemplate(instances []resources.Instance, allInstanceTypes string, inputStream *os.File, outputStream *os.File) (template string, err error) {
 	testFixture := config.GetTestFixture()
-	template, err = populateMasterTemplate(availabilityZone)
+	template, err = populateMasterTemplate()
 	if err != nil {
 		return "", err
 	}
 
-	launchTemplateTemplate, err := populateLaunchTemplateTemplate(instances, allInstanceTypes, testFixture.AmiId, inputStream, outputStream)
+	availabilityZones, subnetIdxs := assignSubnets(instances)
+	subnetTemplate, err := populateSubnetTemplate(availabilityZones)
+	if err != nil {
+		return "", err
+	}
+	template = appendTemplate(template, subnetTemplate)
+
+	launchTemplateTemplate, err := populateLaunchTemplateTemplate(instances, allInstanceTypes, testFixture.AmiId, testFixture.PurchaseOption, inputStream, outputStream)
 	if err != nil {
 		return "", err
 	}
 	template = appendTemplate(template, launchTemplateTemplate)
 
-	autoScalingGroupTemplate, err := populateAutoScalingGroupTemplate(len(instances), testFixture.Timeout)
+	replicas := testFixture.ReplicaCount()
+	autoScalingGroupTemplate, err := populateAutoScalingGroupTemplate(len(instances)*replicas, len(availabilityZones), testFixture.Timeout)
 	if err != nil {
 		return "", err
 	}
 	template = appendTemplate(template, autoScalingGroupTemplate)
 
-	instanceTemplate, err := populateInstanceTemplate(len(instances))
+	instanceTemplate, err := populateInstanceTemplate(subnetIdxs, replicas)
 	if err != nil {
 		return "", err
 	}
@@ -144,36 +178,75 @@ func processRawUserData(rawUserData string) string {
 	return userData.String()
 }
 
-// populateMasterTemplate populates the Master template with the correct value, and returns it.
-func populateMasterTemplate(availabilityZone string) (template string, err error) {
-	rawTemplate, err := cmdutil.DecodeBase64(encodedMasterTemplate)
+// populateMasterTemplate populates the Master template, and returns it.
+func populateMasterTemplate() (template string, err error) {
+	template, err = cmdutil.DecodeBase64(encodedMasterTemplate)
 	if err != nil {
 		return "", err
 	}
+	log.Println("Successfully populated the Master template")
 
-	if availabilityZone != "" {
-		template = strings.ReplaceAll(rawTemplate, "$availabilityZone", availabilityZone)
-	} else {
-		// An existent VPC infrastructure will be used, so no need to populate the availabilityZone value
-		template = rawTemplate
+	return template, nil
+}
+
+// assignSubnets returns the Availability Zones of the subnets in the order of the instances, and the index of the
+// subnet of each instance. Instances without an Availability Zone share a single subnet, which is the one provided by
+// the user.
+func assignSubnets(instances []resources.Instance) (availabilityZones []string, subnetIdxs []int) {
+	for _, instance := range instances {
+		subnetIdx := -1
+		for i, availabilityZone := range availabilityZones {
+			if availabilityZone == instance.AvailabilityZone {
+				subnetIdx = i
+				break
+			}
+		}
+		if subnetIdx == -1 {
+			subnetIdx = len(availabilityZones)
+			availabilityZones = append(availabilityZones, instance.AvailabilityZone)
+		}
+		subnetIdxs = append(subnetIdxs, subnetIdx)
 	}
-	log.Println("Successfully populated the Master template")
+
+	return availabilityZones, subnetIdxs
+}
+
+// populateSubnetTemplate populates the CloudFormation template of subnets with the correct values, merges all, and
+// returns the generated template. The subnets are only created if the user doesn't provide one.
+func populateSubnetTemplate(availabilityZones []string) (template string, err error) {
+	rawTemplate, err := cmdutil.DecodeBase64(encodedSubnetTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	for idx, availabilityZone := range availabilityZones {
+		processedTemplate := strings.ReplaceAll(rawTemplate, "$idx", strconv.Itoa(idx))
+		processedTemplate = strings.ReplaceAll(processedTemplate, "$availabilityZone", availabilityZone)
+		template = appendTemplate(template, processedTemplate)
+	}
+
+	log.Println("Successfully generated the CloudFormation template of subnets")
 
 	return template, nil
 }
 
 // populateLaunchTemplateTemplate populates the CloudFormation template of launch templates with the correct
 // values, merges all, and returns the generated template.
-func populateLaunchTemplateTemplate(instances []resources.Instance, allInstanceTypes string, amiId string, inputStream *os.File, outputStream *os.File) (template string, err error) {
+func populateLaunchTemplateTemplate(instances []resources.Instance, allInstanceTypes string, amiId string, purchaseOption string, inputStream *os.File, outputStream *os.File) (template string, err error) {
 	rawTemplate, err := cmdutil.DecodeBase64(encodedLaunchTemplateTemplate)
 	if err != nil {
 		return "", err
 	}
+	instanceMarketOptions := onDemandMarketOptions
+	if purchaseOption == config.PurchaseOptionSpot {
+		instanceMarketOptions = spotMarketOptions
+	}
 
 	for i, instance := range instances {
 		processedTemplate := strings.ReplaceAll(rawTemplate, "$idx", strconv.Itoa(i))
 		processedTemplate = strings.ReplaceAll(processedTemplate, "$amiId", amiId)
 		processedTemplate = strings.ReplaceAll(processedTemplate, "$instanceType", instance.InstanceType)
+		processedTemplate = strings.ReplaceAll(processedTemplate, "$instanceMarketOptions", instanceMarketOptions)
 		processedTemplate = strings.ReplaceAll(processedTemplate, "$userData", processRawUserData(populateUserData(instance)))
 
 		template = appendTemplate(template, processedTemplate)
@@ -181,8 +254,8 @@ func populateLaunchTemplateTemplate(instances []resources.Instance, allInstanceT
 
 	supportedInstanceTypes, unsupportedInstanceTypes := classifyInstanceTypes(instances, allInstanceTypes)
 	if len(unsupportedInstanceTypes) > 0 {
-		prompt := fmt.Sprintf("Instance types %v are not supported due to AMI or Availability Zone. Do you want to proceed with the rest instance types %v ?", unsupportedInstanceTypes, supportedInstanceTypes)
-		answer, err := cmdutil.BoolPrompt(prompt, inputStream, outputStream)
+		prompt := fmt.Sprintf("Instance types %v are not supported by the AMI or not offered in the region or subnet. Do you want to proceed with the rest instance types %v ?", unsupportedInstanceTypes, supportedInstanceTypes)
+		answer, err := config.Decide(config.DecisionUnsupportedInstanceTypes, prompt, inputStream, outputStream)
 		if err != nil {
 			return "", err
 		}
@@ -220,14 +293,19 @@ func classifyInstanceTypes(supportedInstances []resources.Instance, allInstanceT
 }
 
 // populateAutoScalingGroupTemplate populates the CloudFormation template of the auto scaling group with the
-// correct values, and returns it.
-func populateAutoScalingGroupTemplate(instanceNum int, timeout int) (string, error) {
+// correct values, and returns it. The auto scaling group spans all subnets, so that all instances can be attached.
+func populateAutoScalingGroupTemplate(instanceNum int, subnetNum int, timeout int) (string, error) {
 	rawTemplate, err := cmdutil.DecodeBase64(encodedAutoScalingGroupTemplate)
 	if err != nil {
 		return "", err
 	}
 
-	processedTemplate := strings.ReplaceAll(rawTemplate, "$instanceNum", strconv.Itoa(instanceNum))
+	var subnets []string
+	for idx := 0; idx < subnetNum; idx++ {
+		subnets = append(subnets, strings.ReplaceAll(subnetIdentifier, "$idx", strconv.Itoa(idx)))
+	}
+	processedTemplate := strings.ReplaceAll(rawTemplate, "$subnets", "[\n          "+strings.Join(subnets, ",\n          ")+"\n        ]")
+	processedTemplate = strings.ReplaceAll(processedTemplate, "$instanceNum", strconv.Itoa(instanceNum))
 	startTime := time.Now().UTC().Add(time.Second * time.Duration(timeout+timeBuffer))
 	processedTemplate = strings.ReplaceAll(processedTemplate, "$startTime", startTime.Format(time.RFC3339))
 
@@ -237,15 +315,18 @@ func populateAutoScalingGroupTemplate(instanceNum int, timeout int) (string, err
 }
 
 // populateInstanceTemplate populates the CloudFormation template of instances with the correct values, merges
-// all, and returns the generated template.
-func populateInstanceTemplate(instanceNum int) (template string, err error) {
+// all, and returns the generated template. Each launch template launches the given number of replicas in the subnet
+// of the same index.
+func populateInstanceTemplate(subnetIdxs []int, replicas int) (template string, err error) {
 	rawTemplate, err := cmdutil.DecodeBase64(encodedInstanceTemplate)
 	if err != nil {
 		return "", err
 	}
 
-	for idx := 0; idx < instanceNum; idx++ {
-		processedTemplate := strings.ReplaceAll(rawTemplate, "$idx", strconv.Itoa(idx))
+	for idx := 0; idx < len(subnetIdxs)*replicas; idx++ {
+		processedTemplate := strings.ReplaceAll(rawTemplate, "$launchTemplateIdx", strconv.Itoa(idx/replicas))
+		processedTemplate = strings.ReplaceAll(processedTemplate, "$subnetIdx", strconv.Itoa(subnetIdxs[idx/replicas]))
+		processedTemplate = strings.ReplaceAll(processedTemplate, "$idx", strconv.Itoa(idx))
 		template = appendTemplate(template, processedTemplate)
 	}
 
@@ -291,7 +372,12 @@ func populateUserData(instance resources.Instance) string {
 		CompressedTestSuiteName: compressedTestSuiteName,
 		TestSuiteName:           testSuiteName,
 		CustomScript:            string(customScript),
-		Region:                  userConfig.Region,
+		Region:                  testFixture.Region,
+		PurchaseOption:          testFixture.PurchaseOption,
+		StoreEndpoint:           testFixture.StoreEndpoint,
+	}
+	if testFixture.Concurrency > 0 {
+		userScript.Concurrency = strconv.Itoa(testFixture.Concurrency)
 	}
 	var byteBuffer bytes.Buffer
 	err = t.Execute(&byteBuffer, userScript)
@@ -23,6 +23,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/config"
 	"github.com/awslabs/amazon-ec2-instance-qualifier/pkg/resources"
 	h "github.com/awslabs/amazon-ec2-instance-qualifier/pkg/test"
 )
@@ -63,6 +64,7 @@ func encodeTemplate(filename string, t *testing.T) string {
 
 func setEncodedTemplates(t *testing.T) {
 	encodedMasterTemplate = encodeTemplate("master.template", t)
+	encodedSubnetTemplate = encodeTemplate("subnet.template", t)
 	encodedLaunchTemplateTemplate = encodeTemplate("launch-template.template", t)
 	encodedInstanceTemplate = encodeTemplate("instance.template", t)
 	encodedAutoScalingGroupTemplate = encodeTemplate("auto-scaling-group.template", t)
@@ -106,33 +108,96 @@ func TestPopulateInstanceTemplate(t *testing.T) {
 	setEncodedTemplates(t)
 	numInstances := 2
 	expectedVals := []string{"instance0", "instance1", "launchTemplate0", "launchTemplate1"}
-	actual, err := populateInstanceTemplate(numInstances)
+	actual, err := populateInstanceTemplate(make([]int, numInstances), 1)
 	h.Assert(t, err == nil, "Error calling populateInstanceTemplate")
 	for _, ev := range expectedVals {
 		h.Assert(t, strings.Contains(actual, ev), "Error: could not find "+ev+" in instance template")
 	}
 }
 
+func TestPopulateInstanceTemplateReplicas(t *testing.T) {
+	setEncodedTemplates(t)
+	actual, err := populateInstanceTemplate([]int{0, 1}, 3)
+	h.Assert(t, err == nil, "Error calling populateInstanceTemplate")
+	for _, ev := range []string{"instance0", "instance2", "instance3", "instance5"} {
+		h.Assert(t, strings.Contains(actual, ev), "Error: could not find "+ev+" in instance template")
+	}
+	h.Assert(t, !strings.Contains(actual, "instance6"), "Error: too many instances in instance template")
+	h.Equals(t, 3*2, strings.Count(actual, `"Ref": "launchTemplate0"`)+strings.Count(actual, `"launchTemplate0",`))
+	h.Equals(t, 3*2, strings.Count(actual, `"Ref": "launchTemplate1"`)+strings.Count(actual, `"launchTemplate1",`))
+}
+
+func TestPopulateInstanceTemplateSubnets(t *testing.T) {
+	setEncodedTemplates(t)
+	actual, err := populateInstanceTemplate([]int{1, 0}, 2)
+	h.Ok(t, err)
+	h.Equals(t, 2, strings.Count(actual, `"Ref": "subnet1"`))
+	h.Equals(t, 2, strings.Count(actual, `"Ref": "subnet0"`))
+	h.Assert(t, strings.Index(actual, `"Ref": "subnet1"`) < strings.Index(actual, `"Ref": "subnet0"`), "Error: the instances of the first launch template should be in subnet1")
+}
+
+func TestAssignSubnets(t *testing.T) {
+	availabilityZones, subnetIdxs := assignSubnets([]resources.Instance{
+		{InstanceType: "m4.large", AvailabilityZone: "us-east-2b"},
+		{InstanceType: "m5.large", AvailabilityZone: "us-east-2a"},
+		{InstanceType: "c5.large", AvailabilityZone: "us-east-2b"},
+	})
+	h.Equals(t, []string{"us-east-2b", "us-east-2a"}, availabilityZones)
+	h.Equals(t, []int{0, 1, 0}, subnetIdxs)
+
+	// Instances in the subnet provided by the user share it
+	availabilityZones, subnetIdxs = assignSubnets(instances)
+	h.Equals(t, []string{""}, availabilityZones)
+	h.Equals(t, []int{0, 0}, subnetIdxs)
+}
+
+func TestPopulateSubnetTemplate(t *testing.T) {
+	setEncodedTemplates(t)
+	actual, err := populateSubnetTemplate([]string{"us-east-2b", "us-east-2a"})
+	h.Ok(t, err)
+	for _, ev := range []string{`"subnet0"`, `"subnet1"`, `"subnetRouteTableAssociation1"`, `"CidrBlock": "10.0.1.0/24"`, `"AvailabilityZone": "us-east-2a"`} {
+		h.Assert(t, strings.Contains(actual, ev), "Error: could not find "+ev+" in subnet template")
+	}
+	h.Assert(t, !strings.Contains(actual, "subnet2"), "Error: too many subnets in subnet template")
+}
+
+func TestPopulateASGTemplateSubnets(t *testing.T) {
+	setEncodedTemplates(t)
+	actual, err := populateAutoScalingGroupTemplate(3, 2, 60)
+	h.Ok(t, err)
+	h.Assert(t, strings.Contains(actual, `"Ref": "subnet0"`) && strings.Contains(actual, `"Ref": "subnet1"`), "Error: the auto scaling group should span all subnets")
+	h.Equals(t, 2, strings.Count(actual, `"Ref": "providedSubnet"`))
+}
+
 func TestPopulateLaunchTemplate(t *testing.T) {
 	setEncodedTemplates(t)
 	allInstanceTypes := "m4.large,m4.xlarge"
 	userDataScript, _ := ioutil.ReadFile(userDataScriptSampleTemplate)
 	userScriptForTemplate := processRawUserData(string(userDataScript))
 	expectedVals := []string{"launchTemplate0", "launchTemplate1", "m4.large", "m4.xlarge", userScriptForTemplate}
-	actual, err := populateLaunchTemplateTemplate(instances, allInstanceTypes, "", inputStream, outputStream)
+	actual, err := populateLaunchTemplateTemplate(instances, allInstanceTypes, "", "", inputStream, outputStream)
 	h.Assert(t, err == nil, "Error calling populateLaunchTemplateTemplate")
 	for _, ev := range expectedVals {
 		h.Assert(t, strings.Contains(actual, ev), "Error: could not find "+ev+" in launch template")
 	}
 }
 
+func TestPopulateLaunchTemplateSpot(t *testing.T) {
+	setEncodedTemplates(t)
+	allInstanceTypes := "m4.large,m4.xlarge"
+	actual, err := populateLaunchTemplateTemplate(instances, allInstanceTypes, "", config.PurchaseOptionSpot, inputStream, outputStream)
+	h.Assert(t, err == nil, "Error calling populateLaunchTemplateTemplate")
+	h.Assert(t, strings.Count(actual, `"MarketType": "spot"`) == 2, "Error: spot market options should be set in each launch template")
+	h.Assert(t, !strings.Contains(actual, "AWS::NoValue"), "Error: spot launch templates should not remove the market options")
+}
+
 func TestPopulateASGTemplate(t *testing.T) {
 	setEncodedTemplates(t)
 	numberInstances := 2
 	timeout := 3
 	testBuffer := 5 //StartTime should be within 5sec
 	expectedStartTimes := getTimesWithBuffer(testBuffer, timeout)
-	actual, err := populateAutoScalingGroupTemplate(numberInstances, timeout)
+	actual, err := populateAutoScalingGroupTemplate(numberInstances, 1, timeout)
 	h.Assert(t, err == nil, "Error calling populateAutoScalingGroupTemplate")
 	h.Assert(t, strings.Contains(actual, fmt.Sprint(numberInstances)), "Error: could not find instanceNum in ASG template")
 	found := false
@@ -157,7 +222,11 @@ func TestGenerateCfnTemplateUnsupportedInstanceTypes_Proceed(t *testing.T) {
 	expected, err := ioutil.ReadFile(masterSampleTemplate)
 	h.Assert(t, err == nil, "Error reading "+masterSampleTemplate)
 
-	actual, err := GenerateCfnTemplate(instances, allInstanceTypes, "us-east-2a", inputStream, outputStream)
+	// The instance types are placed in different Availability Zones, so two subnets are created
+	placedInstances := append([]resources.Instance{}, instances...)
+	placedInstances[0].AvailabilityZone = "us-east-2a"
+	placedInstances[1].AvailabilityZone = "us-east-2b"
+	actual, err := GenerateCfnTemplate(placedInstances, allInstanceTypes, inputStream, outputStream)
 	h.Ok(t, err)
 	h.Equals(t, string(expected), removeStartTimeFromTemplate(actual))
 }
@@ -171,7 +240,7 @@ func TestGenerateCfnTemplateUnsupportedInstanceTypes_NotProceedFailure(t *testin
 	setEncodedTemplates(t)
 	allInstanceTypes := "m4.large,m4.xlarge,a1.large"
 
-	_, err = GenerateCfnTemplate(instances, allInstanceTypes, "", inputStream, outputStream)
+	_, err = GenerateCfnTemplate(instances, allInstanceTypes, inputStream, outputStream)
 	h.Assert(t, err != nil, "Failed to return error when answering no to proceeding with the rest instance types")
 }
 
@@ -180,9 +249,9 @@ func TestAppendTemplate(t *testing.T) {
 	numInstances := 2
 	timeout := 3
 
-	template, err := populateAutoScalingGroupTemplate(numInstances, timeout)
+	template, err := populateAutoScalingGroupTemplate(numInstances, 1, timeout)
 	h.Assert(t, err == nil, "Error calling populateAutoScalingGroupTemplate")
-	instanceTemplate, err := populateInstanceTemplate(numInstances)
+	instanceTemplate, err := populateInstanceTemplate(make([]int, numInstances), 1)
 	h.Assert(t, err == nil, "Error calling populateInstanceTemplate")
 	template = appendTemplate(template, instanceTemplate)
 
@@ -208,7 +277,7 @@ func TestAppendTemplateToEmptyTemplate(t *testing.T) {
 	numInstances := 2
 	timeout := 3
 
-	template, err := populateAutoScalingGroupTemplate(numInstances, timeout)
+	template, err := populateAutoScalingGroupTemplate(numInstances, 1, timeout)
 	h.Assert(t, err == nil, "Error calling populateAutoScalingGroupTemplate")
 
 	actual := appendTemplate("", template)
@@ -217,7 +286,7 @@ func TestAppendTemplateToEmptyTemplate(t *testing.T) {
 
 func TestExtractResourcesFromTemplate(t *testing.T) {
 	numInstances := 2
-	instanceTemplate, err := populateInstanceTemplate(numInstances)
+	instanceTemplate, err := populateInstanceTemplate(make([]int, numInstances), 1)
 	h.Assert(t, err == nil, "Error calling populateInstanceTemplate")
 	expectedVals := []string{"instance0", "instance1", "launchTemplate0", "launchTemplate1"}
 	actual := extractResourcesFromTemplate(instanceTemplate)
@@ -242,3 +311,28 @@ func TestPopulateUserData(t *testing.T) {
 	})
 	h.Equals(t, string(expected), actual)
 }
+
+func TestPopulateUserDataRegions(t *testing.T) {
+	setEncodedTemplates(t)
+	defer config.SetTestFixture(config.TestFixture{})
+
+	// Each region of a multi-region run has a test fixture of its own, from which its user data is generated
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		config.SetTestFixture(config.TestFixture{Region: region})
+		actual := populateUserData(instances[0])
+		h.Assert(t, strings.Contains(actual, "\nREGION="+region+"\n"), "Failed to generate the user data of region %s", region)
+	}
+}
+
+func TestPopulateUserDataOptions(t *testing.T) {
+	setEncodedTemplates(t)
+	defer config.SetTestFixture(config.TestFixture{})
+
+	config.SetTestFixture(config.TestFixture{StoreEndpoint: "http://localhost:9000", Concurrency: 4})
+	actual := populateUserData(instances[0])
+	h.Assert(t, strings.Contains(actual, `"$PURCHASE_OPTION" --store-endpoint="http://localhost:9000" --concurrency=4 >`), "Failed to pass the options to the agent as flags")
+
+	config.SetTestFixture(config.TestFixture{Concurrency: 4})
+	actual = populateUserData(instances[0])
+	h.Assert(t, strings.Contains(actual, `"$PURCHASE_OPTION" --concurrency=4 >`), "Failed to pass the concurrency to the agent without a store endpoint")
+}